@@ -347,6 +347,7 @@ func setGRPCHandlers(cli pb.NodeCommuunicationsClient, nsqProducer *nsq.Producer
 				log.Errorf("initGrpcClient: saveMultyTransaction: %s", err)
 			}
 			updateWalletAndAddressDate(tx, networtkID)
+			go extendXpubWallets(tx, networtkID, wa)
 			if !gTx.Resync {
 				sendNotifyToClients(tx, nsqProducer, networtkID)
 			}
@@ -431,6 +432,7 @@ func setGRPCHandlers(cli pb.NodeCommuunicationsClient, nsqProducer *nsq.Producer
 					log.Errorf("initGrpcClient: saveMultyTransaction: %s", err)
 				}
 				updateWalletAndAddressDate(tx, networtkID)
+				go extendXpubWallets(tx, networtkID, wa)
 			}
 
 			// sp outs
@@ -506,6 +508,7 @@ func setGRPCHandlers(cli pb.NodeCommuunicationsClient, nsqProducer *nsq.Producer
 			}
 			if len(rTxs.Txs) > 0 {
 				resync.Delete(rTxs.Txs[0].TxAddress[0])
+				sendResyncCompleted(rTxs.Txs[0].UserID, rTxs.Txs[0].TxAddress[0], networtkID, nsqProducer)
 			}
 
 		}
@@ -0,0 +1,370 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package btc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+)
+
+const (
+	opCheckMultisig = 0xae
+	opData33        = 0x21
+
+	// MaxMultisigOwners is a limit of keys in standard P2SH redeem script
+	MaxMultisigOwners = 15
+
+	psbtGlobalUnsignedTx = 0x00
+	psbtInWitnessUtxo    = 0x01
+	psbtInPartialSig     = 0x02
+	psbtInRedeemScript   = 0x04
+	psbtInWitnessScript  = 0x05
+	psbtMaxField         = 1 << 20
+
+	sigHashAll = 0x01
+)
+
+var psbtMagic = []byte{'p', 's', 'b', 't', 0xff}
+
+// NetParams returns chain parameters of the bitcoin network
+func NetParams(networkID int) (*chaincfg.Params, error) {
+	switch networkID {
+	case currencies.Main:
+		return &chaincfg.MainNetParams, nil
+	case currencies.Test:
+		return &chaincfg.TestNet3Params, nil
+	default:
+		return nil, errors.New("NetParams: wrong networkID")
+	}
+}
+
+// CheckExtendedKey checks xpub is a public extended key of the network
+func CheckExtendedKey(xpub string, networkID int) error {
+	params, err := NetParams(networkID)
+	if err != nil {
+		return err
+	}
+	key, err := hdkeychain.NewKeyFromString(xpub)
+	if err != nil {
+		return fmt.Errorf("CheckExtendedKey: %s", err.Error())
+	}
+	if key.IsPrivate() {
+		return errors.New("CheckExtendedKey: private key is not allowed")
+	}
+	if !key.IsForNet(params) {
+		return errors.New("CheckExtendedKey: key is for another network")
+	}
+	return nil
+}
+
+// MultisigAddress derives m-of-n address of the owners' extended public keys on the external chain at the index.
+// Public keys are sorted as BIP67 says so owners get the same script no matter the order they joined.
+// Script is the P2SH redeem script or the P2WSH witness script.
+func MultisigAddress(xpubs []string, required, index int, addressType string, networkID int) (address string, script string, err error) {
+	params, err := NetParams(networkID)
+	if err != nil {
+		return "", "", err
+	}
+
+	keys := make([][]byte, 0, len(xpubs))
+	for _, xpub := range xpubs {
+		pub, err := multisigOwnerKey(xpub, index)
+		if err != nil {
+			return "", "", err
+		}
+		keys = append(keys, pub.SerializeCompressed())
+	}
+
+	redeem, err := multisigScript(required, keys)
+	if err != nil {
+		return "", "", err
+	}
+
+	var addr btcutil.Address
+	switch addressType {
+	case store.MultisigAddressP2SH:
+		addr, err = btcutil.NewAddressScriptHash(redeem, params)
+	case store.MultisigAddressP2WSH:
+		hash := sha256.Sum256(redeem)
+		addr, err = btcutil.NewAddressWitnessScriptHash(hash[:], params)
+	default:
+		return "", "", errors.New("MultisigAddress: wrong address type " + addressType)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("MultisigAddress: %s", err.Error())
+	}
+
+	return addr.EncodeAddress(), hex.EncodeToString(redeem), nil
+}
+
+// multisigOwnerKey derives the owner's public key of the multisig address at the index
+func multisigOwnerKey(xpub string, index int) (*btcec.PublicKey, error) {
+	key, err := hdkeychain.NewKeyFromString(xpub)
+	if err != nil {
+		return nil, fmt.Errorf("multisigOwnerKey: %s", err.Error())
+	}
+	external, err := key.Child(0)
+	if err != nil {
+		return nil, fmt.Errorf("multisigOwnerKey: %s", err.Error())
+	}
+	child, err := external.Child(uint32(index))
+	if err != nil {
+		return nil, fmt.Errorf("multisigOwnerKey: %s", err.Error())
+	}
+	pub, err := child.ECPubKey()
+	if err != nil {
+		return nil, fmt.Errorf("multisigOwnerKey: %s", err.Error())
+	}
+	return pub, nil
+}
+
+// multisigScript builds OP_m <sorted pubkeys> OP_n OP_CHECKMULTISIG
+func multisigScript(required int, keys [][]byte) ([]byte, error) {
+	if len(keys) == 0 || len(keys) > MaxMultisigOwners {
+		return nil, fmt.Errorf("multisigScript: wrong number of keys %d", len(keys))
+	}
+	if required < 1 || required > len(keys) {
+		return nil, fmt.Errorf("multisigScript: wrong number of required signatures %d", required)
+	}
+
+	sorted := make([][]byte, len(keys))
+	copy(sorted, keys)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+
+	script := []byte{smallInt(required)}
+	for _, key := range sorted {
+		if len(key) != 33 {
+			return nil, errors.New("multisigScript: key is not compressed")
+		}
+		script = append(script, opData33)
+		script = append(script, key...)
+	}
+	script = append(script, smallInt(len(keys)), opCheckMultisig)
+	return script, nil
+}
+
+// smallInt is OP_1 .. OP_16
+func smallInt(n int) byte {
+	return byte(0x50 + n)
+}
+
+// IsPSBT checks decoded data starts with the partially signed bitcoin transaction magic
+func IsPSBT(data []byte) bool {
+	return bytes.HasPrefix(data, psbtMagic)
+}
+
+// PSBTUnsignedTx reads the unsigned transaction from the global map of the partially signed
+// bitcoin transaction (BIP174). Signatures go to the input maps so the transaction is the same
+// in every copy signed by the owners.
+func PSBTUnsignedTx(data []byte) (*wire.MsgTx, error) {
+	if !IsPSBT(data) {
+		return nil, errors.New("PSBTUnsignedTx: no psbt magic")
+	}
+	return psbtUnsignedTx(bytes.NewReader(data[len(psbtMagic):]))
+}
+
+func psbtUnsignedTx(r *bytes.Reader) (*wire.MsgTx, error) {
+	var tx *wire.MsgTx
+	for {
+		key, value, err := readPSBTPair(r)
+		if err != nil {
+			return nil, fmt.Errorf("PSBTUnsignedTx: %s", err.Error())
+		}
+		// separator ends the global map
+		if len(key) == 0 {
+			if tx == nil {
+				return nil, errors.New("PSBTUnsignedTx: no unsigned transaction")
+			}
+			return tx, nil
+		}
+		if key[0] != psbtGlobalUnsignedTx {
+			continue
+		}
+		if len(key) != 1 {
+			return nil, errors.New("PSBTUnsignedTx: wrong unsigned transaction key")
+		}
+		tx = wire.NewMsgTx(wire.TxVersion)
+		err = tx.DeserializeNoWitness(bytes.NewReader(value))
+		if err != nil {
+			return nil, fmt.Errorf("PSBTUnsignedTx: %s", err.Error())
+		}
+		if len(tx.TxIn) == 0 || len(tx.TxOut) == 0 {
+			return nil, errors.New("PSBTUnsignedTx: transaction has no inputs or outputs")
+		}
+	}
+}
+
+// readPSBTPair reads a key-value pair of a psbt map, empty key is the separator with no value
+func readPSBTPair(r *bytes.Reader) (key, value []byte, err error) {
+	key, err = wire.ReadVarBytes(r, 0, psbtMaxField, "psbt key")
+	if err != nil || len(key) == 0 {
+		return key, nil, err
+	}
+	value, err = wire.ReadVarBytes(r, 0, psbtMaxField, "psbt value")
+	return key, value, err
+}
+
+// psbtInput is the part of a psbt input map needed to check signatures
+type psbtInput struct {
+	witnessUtxo   *wire.TxOut
+	partialSigs   map[string][]byte // hex of the compressed public key to the signature with sighash type
+	redeemScript  []byte
+	witnessScript []byte
+}
+
+// parsePSBT reads the unsigned transaction and the input maps
+func parsePSBT(data []byte) (*wire.MsgTx, []psbtInput, error) {
+	if !IsPSBT(data) {
+		return nil, nil, errors.New("parsePSBT: no psbt magic")
+	}
+	r := bytes.NewReader(data[len(psbtMagic):])
+	tx, err := psbtUnsignedTx(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	inputs := make([]psbtInput, len(tx.TxIn))
+	for i := range inputs {
+		in := &inputs[i]
+		in.partialSigs = map[string][]byte{}
+		for {
+			key, value, err := readPSBTPair(r)
+			if err != nil {
+				return nil, nil, fmt.Errorf("parsePSBT: %s", err.Error())
+			}
+			if len(key) == 0 {
+				break
+			}
+			switch key[0] {
+			case psbtInWitnessUtxo:
+				vr := bytes.NewReader(value)
+				out := &wire.TxOut{}
+				err = binary.Read(vr, binary.LittleEndian, &out.Value)
+				if err == nil {
+					out.PkScript, err = wire.ReadVarBytes(vr, 0, psbtMaxField, "psbt witness utxo")
+				}
+				if err != nil {
+					return nil, nil, fmt.Errorf("parsePSBT: witness utxo: %s", err.Error())
+				}
+				in.witnessUtxo = out
+			case psbtInPartialSig:
+				in.partialSigs[hex.EncodeToString(key[1:])] = value
+			case psbtInRedeemScript:
+				in.redeemScript = value
+			case psbtInWitnessScript:
+				in.witnessScript = value
+			}
+		}
+	}
+	return tx, inputs, nil
+}
+
+// CheckPSBTSigned checks every input of the psbt spends one of the multisig scripts (script to address index)
+// and holds a valid SIGHASH_ALL signature of the owner's key derived at the index of the script
+func CheckPSBTSigned(data []byte, xpub string, scripts map[string]int, addressType string) error {
+	tx, inputs, err := parsePSBT(data)
+	if err != nil {
+		return err
+	}
+	for i, in := range inputs {
+		script := in.redeemScript
+		if addressType == store.MultisigAddressP2WSH {
+			script = in.witnessScript
+		}
+		index, ok := scripts[hex.EncodeToString(script)]
+		if !ok || len(script) == 0 {
+			return fmt.Errorf("CheckPSBTSigned: input %d doesn't spend the multisig", i)
+		}
+		pub, err := multisigOwnerKey(xpub, index)
+		if err != nil {
+			return err
+		}
+		sig, ok := in.partialSigs[hex.EncodeToString(pub.SerializeCompressed())]
+		if !ok || len(sig) == 0 {
+			return fmt.Errorf("CheckPSBTSigned: input %d isn't signed by the key", i)
+		}
+		if sig[len(sig)-1] != sigHashAll {
+			return fmt.Errorf("CheckPSBTSigned: input %d isn't signed with SIGHASH_ALL", i)
+		}
+		signature, err := btcec.ParseDERSignature(sig[:len(sig)-1], btcec.S256())
+		if err != nil {
+			return fmt.Errorf("CheckPSBTSigned: input %d: %s", i, err.Error())
+		}
+
+		var hash []byte
+		if addressType == store.MultisigAddressP2WSH {
+			if in.witnessUtxo == nil {
+				return fmt.Errorf("CheckPSBTSigned: input %d has no witness utxo", i)
+			}
+			hash = witnessSigHashAll(tx, i, script, in.witnessUtxo.Value)
+		} else {
+			hash = legacySigHashAll(tx, i, script)
+		}
+		if !signature.Verify(hash, pub) {
+			return fmt.Errorf("CheckPSBTSigned: input %d has a wrong signature", i)
+		}
+	}
+	return nil
+}
+
+// legacySigHashAll is the SIGHASH_ALL digest of the P2SH input, redeem script is the script code
+func legacySigHashAll(tx *wire.MsgTx, idx int, script []byte) []byte {
+	txCopy := tx.Copy()
+	for i := range txCopy.TxIn {
+		txCopy.TxIn[i].SignatureScript = nil
+		txCopy.TxIn[i].Witness = nil
+	}
+	txCopy.TxIn[idx].SignatureScript = script
+
+	var b bytes.Buffer
+	txCopy.SerializeNoWitness(&b)
+	binary.Write(&b, binary.LittleEndian, uint32(sigHashAll))
+	return chainhash.DoubleHashB(b.Bytes())
+}
+
+// witnessSigHashAll is the SIGHASH_ALL digest of the segwit input (BIP143), witness script is the script code
+func witnessSigHashAll(tx *wire.MsgTx, idx int, script []byte, amount int64) []byte {
+	var prevouts, sequences, outputs bytes.Buffer
+	for _, in := range tx.TxIn {
+		prevouts.Write(in.PreviousOutPoint.Hash[:])
+		binary.Write(&prevouts, binary.LittleEndian, in.PreviousOutPoint.Index)
+		binary.Write(&sequences, binary.LittleEndian, in.Sequence)
+	}
+	for _, out := range tx.TxOut {
+		wire.WriteTxOut(&outputs, 0, 0, out)
+	}
+
+	in := tx.TxIn[idx]
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, tx.Version)
+	b.Write(chainhash.DoubleHashB(prevouts.Bytes()))
+	b.Write(chainhash.DoubleHashB(sequences.Bytes()))
+	b.Write(in.PreviousOutPoint.Hash[:])
+	binary.Write(&b, binary.LittleEndian, in.PreviousOutPoint.Index)
+	wire.WriteVarBytes(&b, 0, script)
+	binary.Write(&b, binary.LittleEndian, amount)
+	binary.Write(&b, binary.LittleEndian, in.Sequence)
+	b.Write(chainhash.DoubleHashB(outputs.Bytes()))
+	binary.Write(&b, binary.LittleEndian, tx.LockTime)
+	binary.Write(&b, binary.LittleEndian, uint32(sigHashAll))
+	return chainhash.DoubleHashB(b.Bytes())
+}
@@ -0,0 +1,269 @@
+package btc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+)
+
+// BIP67 test vector
+func TestMultisigScriptSorted(t *testing.T) {
+	keys := [][]byte{}
+	for _, k := range []string{
+		"02ff12471208c14bd580709cb2358d98975247d8765f92bc25eab3b2763ed605f8",
+		"02fe6f0a5a297eb38c391581c4413e084773ea23954d93f7753db7dc0adc188b2f",
+	} {
+		key, _ := hex.DecodeString(k)
+		keys = append(keys, key)
+	}
+
+	script, err := multisigScript(2, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "522102fe6f0a5a297eb38c391581c4413e084773ea23954d93f7753db7dc0adc188b2f2102ff12471208c14bd580709cb2358d98975247d8765f92bc25eab3b2763ed605f852ae"
+	if hex.EncodeToString(script) != want {
+		t.Fatalf("script = %x", script)
+	}
+	addr, err := btcutil.NewAddressScriptHash(script, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.EncodeAddress() != "39bgKC7RFbpoCRbtD5KEdkYKtNyhpsNa3Z" {
+		t.Errorf("address = %s", addr.EncodeAddress())
+	}
+}
+
+func TestMultisigAddress(t *testing.T) {
+	xpubs := []string{}
+	for i := byte(1); i <= 3; i++ {
+		master, err := hdkeychain.NewMaster(bytes.Repeat([]byte{i}, 32), &chaincfg.TestNet3Params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub, err := master.Neuter()
+		if err != nil {
+			t.Fatal(err)
+		}
+		xpubs = append(xpubs, pub.String())
+	}
+
+	if err := CheckExtendedKey(xpubs[0], currencies.Test); err != nil {
+		t.Error(err)
+	}
+	if err := CheckExtendedKey(xpubs[0], currencies.Main); err == nil {
+		t.Error("testnet key accepted for main net")
+	}
+
+	p2sh, script, err := MultisigAddress(xpubs, 2, 0, store.MultisigAddressP2SH, currencies.Test)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(p2sh, "2") || !strings.HasPrefix(script, "52") || !strings.HasSuffix(script, "53ae") {
+		t.Errorf("unexpected p2sh %s %s", p2sh, script)
+	}
+
+	reversed := []string{xpubs[2], xpubs[1], xpubs[0]}
+	again, _, err := MultisigAddress(reversed, 2, 0, store.MultisigAddressP2SH, currencies.Test)
+	if err != nil || again != p2sh {
+		t.Errorf("address depends on owners order: %s %s", p2sh, again)
+	}
+
+	next, _, _ := MultisigAddress(xpubs, 2, 1, store.MultisigAddressP2SH, currencies.Test)
+	if next == p2sh {
+		t.Error("same address at the next index")
+	}
+
+	p2wsh, _, err := MultisigAddress(xpubs, 2, 0, store.MultisigAddressP2WSH, currencies.Test)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(p2wsh, "tb1q") || len(p2wsh) != 62 {
+		t.Errorf("unexpected p2wsh %s", p2wsh)
+	}
+}
+
+// testPSBT serializes the unsigned transaction into the global map, the input map holds a partial signature if any
+func testPSBT(tx *wire.MsgTx, sig []byte) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{'p', 's', 'b', 't', 0xff})
+	unsigned := bytes.Buffer{}
+	tx.SerializeNoWitness(&unsigned)
+	wire.WriteVarBytes(&b, 0, []byte{0x00})
+	wire.WriteVarBytes(&b, 0, unsigned.Bytes())
+	b.WriteByte(0x00)
+	for range tx.TxIn {
+		if sig != nil {
+			wire.WriteVarBytes(&b, 0, append([]byte{0x02}, bytes.Repeat([]byte{0x03}, 33)...))
+			wire.WriteVarBytes(&b, 0, sig)
+		}
+		b.WriteByte(0x00)
+	}
+	for range tx.TxOut {
+		b.WriteByte(0x00)
+	}
+	return b.Bytes()
+}
+
+func TestPSBTUnsignedTx(t *testing.T) {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(100000, []byte{0x00, 0x14}))
+
+	proposed, err := PSBTUnsignedTx(testPSBT(tx, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := PSBTUnsignedTx(testPSBT(tx, []byte{0x30, 0x01}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proposed.TxHash() != tx.TxHash() || signed.TxHash() != tx.TxHash() {
+		t.Errorf("wrong unsigned transaction %s %s", proposed.TxHash(), signed.TxHash())
+	}
+
+	tx.TxOut[0].Value = 200000
+	other, _ := PSBTUnsignedTx(testPSBT(tx, nil))
+	if other == nil || other.TxHash() == proposed.TxHash() {
+		t.Error("other transaction has the same hash")
+	}
+
+	for _, data := range [][]byte{
+		[]byte("psbt"),
+		{'p', 's', 'b', 't', 0xff, 0x00},
+		testPSBT(tx, nil)[:20],
+	} {
+		if _, err := PSBTUnsignedTx(data); err == nil {
+			t.Errorf("wrong psbt accepted %x", data)
+		}
+	}
+}
+
+// writePSBT serializes the unsigned transaction and the input maps
+func writePSBT(tx *wire.MsgTx, inputs []psbtInput) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{'p', 's', 'b', 't', 0xff})
+	unsigned := bytes.Buffer{}
+	tx.SerializeNoWitness(&unsigned)
+	wire.WriteVarBytes(&b, 0, []byte{psbtGlobalUnsignedTx})
+	wire.WriteVarBytes(&b, 0, unsigned.Bytes())
+	b.WriteByte(0x00)
+	for _, in := range inputs {
+		if in.witnessUtxo != nil {
+			utxo := bytes.Buffer{}
+			wire.WriteTxOut(&utxo, 0, 0, in.witnessUtxo)
+			wire.WriteVarBytes(&b, 0, []byte{psbtInWitnessUtxo})
+			wire.WriteVarBytes(&b, 0, utxo.Bytes())
+		}
+		for pub, sig := range in.partialSigs {
+			key, _ := hex.DecodeString(pub)
+			wire.WriteVarBytes(&b, 0, append([]byte{psbtInPartialSig}, key...))
+			wire.WriteVarBytes(&b, 0, sig)
+		}
+		if in.redeemScript != nil {
+			wire.WriteVarBytes(&b, 0, []byte{psbtInRedeemScript})
+			wire.WriteVarBytes(&b, 0, in.redeemScript)
+		}
+		if in.witnessScript != nil {
+			wire.WriteVarBytes(&b, 0, []byte{psbtInWitnessScript})
+			wire.WriteVarBytes(&b, 0, in.witnessScript)
+		}
+		b.WriteByte(0x00)
+	}
+	for range tx.TxOut {
+		b.WriteByte(0x00)
+	}
+	return b.Bytes()
+}
+
+func TestCheckPSBTSigned(t *testing.T) {
+	masters := []*hdkeychain.ExtendedKey{}
+	xpubs := []string{}
+	for i := byte(1); i <= 2; i++ {
+		master, _ := hdkeychain.NewMaster(bytes.Repeat([]byte{i}, 32), &chaincfg.TestNet3Params)
+		pub, _ := master.Neuter()
+		masters = append(masters, master)
+		xpubs = append(xpubs, pub.String())
+	}
+	ownerKey := func(master *hdkeychain.ExtendedKey, index int) *btcec.PrivateKey {
+		external, _ := master.Child(0)
+		child, _ := external.Child(uint32(index))
+		priv, _ := child.ECPrivKey()
+		return priv
+	}
+
+	for _, addressType := range []string{store.MultisigAddressP2SH, store.MultisigAddressP2WSH} {
+		_, script, err := MultisigAddress(xpubs, 2, 1, addressType, currencies.Test)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scripts := map[string]int{script: 1}
+		rawScript, _ := hex.DecodeString(script)
+
+		tx := wire.NewMsgTx(wire.TxVersion)
+		tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), nil, nil))
+		tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{2}, 1), nil, nil))
+		tx.AddTxOut(wire.NewTxOut(100000, []byte{0x00, 0x14}))
+
+		sign := func(master *hdkeychain.ExtendedKey, tx *wire.MsgTx) []psbtInput {
+			priv := ownerKey(master, 1)
+			inputs := []psbtInput{}
+			for i := range tx.TxIn {
+				in := psbtInput{partialSigs: map[string][]byte{}}
+				var hash []byte
+				if addressType == store.MultisigAddressP2WSH {
+					in.witnessScript = rawScript
+					in.witnessUtxo = wire.NewTxOut(60000, []byte{0x00, 0x20})
+					hash = witnessSigHashAll(tx, i, rawScript, in.witnessUtxo.Value)
+				} else {
+					in.redeemScript = rawScript
+					hash = legacySigHashAll(tx, i, rawScript)
+				}
+				sig, _ := priv.Sign(hash)
+				in.partialSigs[hex.EncodeToString(priv.PubKey().SerializeCompressed())] = append(sig.Serialize(), sigHashAll)
+				inputs = append(inputs, in)
+			}
+			return inputs
+		}
+
+		signed := writePSBT(tx, sign(masters[0], tx))
+		if err := CheckPSBTSigned(signed, xpubs[0], scripts, addressType); err != nil {
+			t.Errorf("%s: signed psbt: %s", addressType, err)
+		}
+		if err := CheckPSBTSigned(signed, xpubs[1], scripts, addressType); err == nil {
+			t.Errorf("%s: psbt accepted for the owner who didn't sign", addressType)
+		}
+		if err := CheckPSBTSigned(signed, xpubs[0], map[string]int{script: 0}, addressType); err == nil {
+			t.Errorf("%s: psbt accepted for the key of another index", addressType)
+		}
+		if err := CheckPSBTSigned(signed, xpubs[0], map[string]int{}, addressType); err == nil {
+			t.Errorf("%s: psbt accepted for inputs out of the multisig", addressType)
+		}
+
+		// signature of another transaction is put to the proposed one
+		other := tx.Copy()
+		other.TxOut[0].Value = 90000
+		forged := writePSBT(tx, sign(masters[0], other))
+		if err := CheckPSBTSigned(forged, xpubs[0], scripts, addressType); err == nil {
+			t.Errorf("%s: psbt accepted with signatures of another transaction", addressType)
+		}
+
+		unsigned := sign(masters[0], tx)
+		for i := range unsigned {
+			unsigned[i].partialSigs = map[string][]byte{}
+		}
+		if err := CheckPSBTSigned(writePSBT(tx, unsigned), xpubs[0], scripts, addressType); err == nil {
+			t.Errorf("%s: unsigned psbt accepted", addressType)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package btc
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/btcsuite/btcutil"
+)
+
+// AddressType decodes legacy, P2SH or bech32 address of the network and returns it's type.
+// Nested segwit address can't be told from any other P2SH one, so it is p2sh as well.
+// Errors are store.AddressError.
+func AddressType(address string, networkID int) (string, error) {
+	params, err := NetParams(networkID)
+	if err != nil {
+		return "", store.ErrAddressNetwork
+	}
+	addr, err := btcutil.DecodeAddress(address, params)
+	if err != nil {
+		if err == btcutil.ErrChecksumMismatch || bech32ChecksumMismatch(address, params.Bech32HRPSegwit) {
+			return "", store.ErrAddressChecksum
+		}
+		return "", store.ErrAddressFormat
+	}
+	if !addr.IsForNet(params) {
+		return "", store.ErrAddressNetwork
+	}
+
+	switch addr.(type) {
+	case *btcutil.AddressPubKeyHash:
+		return store.AddressP2PKH, nil
+	case *btcutil.AddressScriptHash:
+		return store.AddressP2SH, nil
+	case *btcutil.AddressWitnessPubKeyHash:
+		return store.AddressP2WPKH, nil
+	case *btcutil.AddressWitnessScriptHash:
+		return store.AddressP2WSH, nil
+	}
+	return "", store.ErrAddressUnsupported
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// bech32ChecksumMismatch checks BIP173 checksum of the segwit address of the network,
+// bech32 package tells a wrong checksum from other errors by message only
+func bech32ChecksumMismatch(address, hrp string) bool {
+	lower := strings.ToLower(address)
+	if !strings.HasPrefix(lower, hrp+"1") {
+		return false
+	}
+	values := []int{}
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, int(hrp[i])>>5)
+	}
+	values = append(values, 0)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, int(hrp[i])&31)
+	}
+	for _, c := range lower[len(hrp)+1:] {
+		value := strings.IndexRune(bech32Charset, c)
+		if value < 0 {
+			return false
+		}
+		values = append(values, value)
+	}
+
+	generator := []int{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := 1
+	for _, value := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ value
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk != 1
+}
+
+// ValidateAddress checks base58check or bech32 checksum and network of the address
+func ValidateAddress(address string, networkID int) error {
+	_, err := AddressType(address, networkID)
+	return err
+}
+
+// NormalizeAddress returns address the way node streamer encodes it, bech32 addresses are case insensitive
+func NormalizeAddress(address string, networkID int) (string, error) {
+	params, err := NetParams(networkID)
+	if err != nil {
+		return "", err
+	}
+	addr, err := btcutil.DecodeAddress(address, params)
+	if err != nil {
+		return "", fmt.Errorf("NormalizeAddress: %s", err.Error())
+	}
+	return addr.EncodeAddress(), nil
+}
+
+// MatchAddressType checks address could be derived by wallet of the address type
+func MatchAddressType(addressType, walletAddressType string) bool {
+	switch walletAddressType {
+	case "":
+		return true
+	case store.AddressP2SHP2WPKH:
+		return addressType == store.AddressP2SH
+	}
+	return addressType == walletAddressType
+}
+
+// ScriptType returns type of the hex encoded output script
+func ScriptType(script string) string {
+	s, err := hex.DecodeString(script)
+	if err != nil {
+		return ""
+	}
+	switch {
+	// OP_DUP OP_HASH160 <20> OP_EQUALVERIFY OP_CHECKSIG
+	case len(s) == 25 && s[0] == 0x76 && s[1] == 0xa9 && s[2] == 0x14 && s[23] == 0x88 && s[24] == 0xac:
+		return store.AddressP2PKH
+	// OP_HASH160 <20> OP_EQUAL
+	case len(s) == 23 && s[0] == 0xa9 && s[1] == 0x14 && s[22] == 0x87:
+		return store.AddressP2SH
+	// OP_0 <20>
+	case len(s) == 22 && s[0] == 0x00 && s[1] == 0x14:
+		return store.AddressP2WPKH
+	// OP_0 <32>
+	case len(s) == 34 && s[0] == 0x00 && s[1] == 0x20:
+		return store.AddressP2WSH
+	}
+	return ""
+}
+
+// Weight units of inputs and outputs by type. Signatures are counted as 72 bytes long,
+// multisig inputs are counted as 2-of-3.
+var (
+	inputWeight = map[string]int{
+		store.AddressP2PKH:      148 * 4,
+		store.AddressP2SHP2WPKH: 64*4 + 108,
+		store.AddressP2WPKH:     41*4 + 108,
+		store.AddressP2SH:       297 * 4,
+		store.AddressP2WSH:      41*4 + 254,
+	}
+	outputWeight = map[string]int{
+		store.AddressP2PKH:  34 * 4,
+		store.AddressP2SH:   32 * 4,
+		store.AddressP2WPKH: 31 * 4,
+		store.AddressP2WSH:  43 * 4,
+	}
+	// recipient and change outputs of BIP84 wallet, used when outputs are unknown
+	defaultOutputs = []string{store.AddressP2WPKH, store.AddressP2WPKH}
+)
+
+const (
+	// version, locktime and counters
+	txOverheadWeight = 10 * 4
+	// segwit marker and flag
+	witnessOverheadWeight = 2
+	// empty witness of non-segwit input in segwit transaction
+	emptyWitnessWeight = 1
+)
+
+// EstimateVsize returns virtual size of the transaction spending inputs of the types to outputs of the types,
+// fee is vsize multiplied by the fee rate per byte. Without outputs payment and change to P2WPKH are counted
+func EstimateVsize(inputs, outputs []string) (int, error) {
+	if len(outputs) == 0 {
+		outputs = defaultOutputs
+	}
+	weight := txOverheadWeight
+	witness := false
+	legacy := 0
+	for _, input := range inputs {
+		w, ok := inputWeight[input]
+		if !ok {
+			return 0, errors.New("EstimateVsize: unknown input type " + input)
+		}
+		weight += w
+		if input != store.AddressP2PKH && input != store.AddressP2SH {
+			witness = true
+		} else {
+			legacy++
+		}
+	}
+	for _, output := range outputs {
+		if output == store.AddressP2SHP2WPKH {
+			output = store.AddressP2SH
+		}
+		w, ok := outputWeight[output]
+		if !ok {
+			return 0, errors.New("EstimateVsize: unknown output type " + output)
+		}
+		weight += w
+	}
+	if witness {
+		weight += witnessOverheadWeight + legacy*emptyWitnessWeight
+	}
+	return (weight + 3) / 4, nil
+}
@@ -0,0 +1,84 @@
+package btc
+
+import (
+	"testing"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+)
+
+func TestAddressType(t *testing.T) {
+	for address, want := range map[string]string{
+		"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2":                             store.AddressP2PKH,
+		"3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy":                             store.AddressP2SH,
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4":                     store.AddressP2WPKH,
+		"bc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qccfmv3": store.AddressP2WSH,
+		"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4":                     store.AddressP2WPKH,
+	} {
+		addressType, err := AddressType(address, currencies.Main)
+		if err != nil {
+			t.Errorf("%s: %s", address, err)
+			continue
+		}
+		if addressType != want {
+			t.Errorf("%s: type = %s, want %s", address, addressType, want)
+		}
+	}
+
+	for address, want := range map[string]error{
+		"tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx": store.ErrAddressNetwork,
+		"mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn":         store.ErrAddressNetwork,
+		"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN3":         store.ErrAddressChecksum,
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5": store.ErrAddressChecksum,
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3tb": store.ErrAddressFormat,
+		"BC1SW50QA3JX3S": store.ErrAddressFormat,
+		"not an address": store.ErrAddressFormat,
+	} {
+		if err := ValidateAddress(address, currencies.Main); err != want {
+			t.Errorf("%s: err = %v, want %v", address, err, want)
+		}
+	}
+	if address, _ := NormalizeAddress("BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", currencies.Main); address != "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4" {
+		t.Errorf("normalized = %s", address)
+	}
+}
+
+func TestScriptType(t *testing.T) {
+	for script, want := range map[string]string{
+		"76a914751e76e8199196d454941c45d1b3a323f1433bd688ac": store.AddressP2PKH,
+		"a914b472a266d0bd89c13706a4132ccfb16f7c3b9fcb87":     store.AddressP2SH,
+		"0014751e76e8199196d454941c45d1b3a323f1433bd6":       store.AddressP2WPKH,
+		"6a": "",
+	} {
+		if scriptType := ScriptType(script); scriptType != want {
+			t.Errorf("%s: type = %s, want %s", script, scriptType, want)
+		}
+	}
+}
+
+func TestEstimateVsize(t *testing.T) {
+	for _, tc := range []struct {
+		inputs, outputs []string
+		vsize           int
+	}{
+		{[]string{store.AddressP2PKH}, []string{store.AddressP2PKH, store.AddressP2PKH}, 226},
+		{[]string{store.AddressP2WPKH}, []string{store.AddressP2WPKH, store.AddressP2WPKH}, 141},
+		{[]string{store.AddressP2SHP2WPKH}, []string{store.AddressP2SH, store.AddressP2SH}, 166},
+		// payment and change of BIP84 wallet
+		{[]string{store.AddressP2WPKH}, nil, 141},
+		// legacy input has empty witness
+		{[]string{store.AddressP2WPKH, store.AddressP2PKH}, []string{store.AddressP2WPKH}, 258},
+	} {
+		vsize, err := EstimateVsize(tc.inputs, tc.outputs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vsize != tc.vsize {
+			t.Errorf("%v -> %v: vsize = %d, want %d", tc.inputs, tc.outputs, vsize, tc.vsize)
+		}
+	}
+
+	if _, err := EstimateVsize([]string{"p2tr"}, nil); err == nil {
+		t.Error("unknown input type accepted")
+	}
+}
@@ -112,28 +112,24 @@ func GetReSyncExchangeRate(time int64) ([]store.ExchangeRatesRecord, error) {
 	if err != nil {
 		return nil, err
 	}
+	// records might be older than pairs
+	stocksCCCAGG.Exchanges.Normalize()
 	return []store.ExchangeRatesRecord{stocksCCCAGG}, nil
 }
 
+// GetLatestExchangeRate returns the latest canonical rate aggregated from all rate providers
 func GetLatestExchangeRate() ([]store.ExchangeRatesRecord, error) {
-	selGdax := bson.M{
-		"stockexchange": "Gdax",
+	selCanonical := bson.M{
+		"stockexchange": store.StockExchangeCanonical,
 	}
-	selPoloniex := bson.M{
-		"stockexchange": "Poloniex",
-	}
-	stocksGdax := store.ExchangeRatesRecord{}
-	err := exRate.Find(selGdax).Sort("-timestamp").One(&stocksGdax)
-	if err != nil {
-		return nil, err
-	}
-
-	stocksPoloniex := store.ExchangeRatesRecord{}
-	err = exRate.Find(selPoloniex).Sort("-timestamp").One(&stocksPoloniex)
+	stocksCanonical := store.ExchangeRatesRecord{}
+	err := exRate.Find(selCanonical).Sort("-timestamp").One(&stocksCanonical)
 	if err != nil {
 		return nil, err
 	}
-	return []store.ExchangeRatesRecord{stocksPoloniex, stocksGdax}, nil
+	// sources stay in the rates collection, transactions keep rates only
+	stocksCanonical.Rates = nil
+	return []store.ExchangeRatesRecord{stocksCanonical}, nil
 }
 
 func setExchangeRates(tx *store.MultyTX, isReSync bool, TxTime int64) {
@@ -227,6 +223,37 @@ func sendNotifyToClients(tx store.MultyTX, nsqProducer *nsq.Producer, netid int)
 	}
 }
 
+// sendResyncCompleted notifies the user that history of the address is synced
+func sendResyncCompleted(userID, address string, networkID int, nsqProducer *nsq.Producer) {
+	user := store.User{}
+	err := usersData.Find(bson.M{"userID": userID}).One(&user)
+	if err != nil {
+		log.Errorf("sendResyncCompleted: usersData.Find: %s", err.Error())
+		return
+	}
+	for _, wallet := range user.Wallets {
+		if wallet.CurrencyID != currencies.Bitcoin || wallet.NetworkID != networkID {
+			continue
+		}
+		for _, walletAddress := range wallet.Adresses {
+			if walletAddress.Address != address {
+				continue
+			}
+			sendNotify(&store.TransactionWithUserID{
+				UserID: userID,
+				NotificationMsg: &store.WsTxNotify{
+					CurrencyID:      currencies.Bitcoin,
+					NetworkID:       networkID,
+					Address:         address,
+					TransactionType: store.ResyncCompleted,
+					WalletIndex:     wallet.WalletIndex,
+				},
+			}, nsqProducer)
+			return
+		}
+	}
+}
+
 func sendNotify(txMsq *store.TransactionWithUserID, nsqProducer *nsq.Producer) {
 	newTxJSON, err := json.Marshal(txMsq)
 	if err != nil {
@@ -311,6 +338,7 @@ func generatedSpOutsToStore(gSpOut *btcpb.AddSpOut) store.SpendableOutputs {
 		TxOutID:      int(gSpOut.TxOutID),
 		TxOutAmount:  gSpOut.TxOutAmount,
 		TxOutScript:  gSpOut.TxOutScript,
+		ScriptType:   ScriptType(gSpOut.TxOutScript),
 		Address:      gSpOut.Address,
 		UserID:       gSpOut.UserID,
 		TxStatus:     int(gSpOut.TxStatus),
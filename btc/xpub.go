@@ -0,0 +1,246 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package btc
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	pb "github.com/Multy-io/Multy-back/node-streamer/btc"
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type xpubVersion struct {
+	networkID   int
+	addressType string
+}
+
+// xpubVersions maps serialized key version to it's network and address type as SLIP-0132 registers them
+var xpubVersions = map[[4]byte]xpubVersion{
+	{0x04, 0x88, 0xb2, 0x1e}: {currencies.Main, store.AddressP2PKH},      // xpub
+	{0x04, 0x9d, 0x7c, 0xb2}: {currencies.Main, store.AddressP2SHP2WPKH}, // ypub
+	{0x04, 0xb2, 0x47, 0x46}: {currencies.Main, store.AddressP2WPKH},     // zpub
+	{0x04, 0x35, 0x87, 0xcf}: {currencies.Test, store.AddressP2PKH},      // tpub
+	{0x04, 0x4a, 0x52, 0x62}: {currencies.Test, store.AddressP2SHP2WPKH}, // upub
+	{0x04, 0x5f, 0x1c, 0xf6}: {currencies.Test, store.AddressP2WPKH},     // vpub
+}
+
+// XpubAddressType returns type of addresses the account extended public key stands for:
+// xpub gives legacy, ypub nested segwit and zpub native segwit addresses
+func XpubAddressType(xpub string, networkID int) (string, error) {
+	key, err := hdkeychain.NewKeyFromString(xpub)
+	if err != nil {
+		return "", fmt.Errorf("XpubAddressType: %s", err.Error())
+	}
+	if key.IsPrivate() {
+		return "", errors.New("XpubAddressType: private key is not allowed")
+	}
+
+	var version [4]byte
+	copy(version[:], base58.Decode(xpub))
+	v, ok := xpubVersions[version]
+	if !ok {
+		return "", errors.New("XpubAddressType: unknown key version")
+	}
+	if v.networkID != networkID {
+		return "", errors.New("XpubAddressType: key is for another network")
+	}
+	return v.addressType, nil
+}
+
+// XpubAddresses derives addresses [from, to) of the receive or change chain of the account key
+func XpubAddresses(xpub, addressType string, change bool, from, to, networkID int) ([]store.Address, error) {
+	params, err := NetParams(networkID)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hdkeychain.NewKeyFromString(xpub)
+	if err != nil {
+		return nil, fmt.Errorf("XpubAddresses: %s", err.Error())
+	}
+	chain := uint32(0)
+	if change {
+		chain = 1
+	}
+	branch, err := key.Child(chain)
+	if err != nil {
+		return nil, fmt.Errorf("XpubAddresses: %s", err.Error())
+	}
+
+	addresses := []store.Address{}
+	for i := from; i < to; i++ {
+		child, err := branch.Child(uint32(i))
+		if err != nil {
+			return nil, fmt.Errorf("XpubAddresses: %s", err.Error())
+		}
+		pub, err := child.ECPubKey()
+		if err != nil {
+			return nil, fmt.Errorf("XpubAddresses: %s", err.Error())
+		}
+		hash := btcutil.Hash160(pub.SerializeCompressed())
+
+		var addr btcutil.Address
+		switch addressType {
+		case store.AddressP2PKH:
+			addr, err = btcutil.NewAddressPubKeyHash(hash, params)
+		case store.AddressP2SHP2WPKH:
+			addr, err = btcutil.NewAddressScriptHash(append([]byte{0x00, 0x14}, hash...), params)
+		case store.AddressP2WPKH:
+			addr, err = btcutil.NewAddressWitnessPubKeyHash(hash, params)
+		default:
+			return nil, errors.New("XpubAddresses: wrong address type " + addressType)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("XpubAddresses: %s", err.Error())
+		}
+
+		addresses = append(addresses, store.Address{
+			Address:      addr.EncodeAddress(),
+			AddressIndex: i,
+			IsChange:     change,
+		})
+	}
+	return addresses, nil
+}
+
+// XpubWindow returns addresses the wallet lacks to have gap limit unused addresses
+// after the last used one on both receive and change chains
+func XpubWindow(wallet store.Wallet) ([]store.Address, error) {
+	gap := wallet.GapLimit
+	if gap == 0 {
+		gap = store.DefaultGapLimit
+	}
+
+	window := []store.Address{}
+	for _, change := range []bool{false, true} {
+		derived, used := 0, -1
+		for _, address := range wallet.Adresses {
+			if address.IsChange != change {
+				continue
+			}
+			if address.AddressIndex >= derived {
+				derived = address.AddressIndex + 1
+			}
+			if address.LastActionTime != 0 && address.AddressIndex > used {
+				used = address.AddressIndex
+			}
+		}
+		if used+1+gap <= derived {
+			continue
+		}
+		addresses, err := XpubAddresses(wallet.Xpub, wallet.AddressType, change, derived, used+1+gap, wallet.NetworkID)
+		if err != nil {
+			return nil, err
+		}
+		window = append(window, addresses...)
+	}
+	return window, nil
+}
+
+// xpubExtendAttempts limits how many times the window is computed again when
+// transactions of the wallet move it concurrently
+const xpubExtendAttempts = 3
+
+// extendXpubWallets moves gap limit window of watch-only wallets the transaction touched
+func extendXpubWallets(tx store.MultyTX, networkID int, wa chan pb.WatchAddress) {
+	touched := map[string]map[int]bool{} // userid -> wallet indexes
+	for _, wallets := range [][]store.WalletForTx{tx.WalletsInput, tx.WalletsOutput} {
+		for _, wallet := range wallets {
+			if touched[wallet.UserId] == nil {
+				touched[wallet.UserId] = map[int]bool{}
+			}
+			touched[wallet.UserId][wallet.WalletIndex] = true
+		}
+	}
+
+	for userID, walletIndexes := range touched {
+		for walletIndex := range walletIndexes {
+			addresses, err := extendXpubWallet(userID, networkID, walletIndex)
+			if err != nil {
+				log.Errorf("extendXpubWallets: %s", err.Error())
+				continue
+			}
+			for _, address := range addresses {
+				wa <- pb.WatchAddress{
+					Address:      address.Address,
+					UserID:       userID,
+					WalletIndex:  int32(walletIndex),
+					AddressIndex: int32(address.AddressIndex),
+				}
+			}
+		}
+	}
+}
+
+// extendXpubWallet pushes addresses the watch-only wallet lacks and returns them. Transactions of the wallet
+// are processed concurrently, so the window is pushed only if none of its addresses were pushed meanwhile,
+// otherwise it's computed again from the stored addresses.
+func extendXpubWallet(userID string, networkID, walletIndex int) ([]store.Address, error) {
+	for attempt := 0; attempt < xpubExtendAttempts; attempt++ {
+		user := store.User{}
+		err := usersData.Find(bson.M{"userID": userID}).One(&user)
+		if err != nil {
+			return nil, nil
+		}
+		position := -1
+		for i, wallet := range user.Wallets {
+			if wallet.Xpub != "" && wallet.CurrencyID == currencies.Bitcoin && wallet.NetworkID == networkID && wallet.WalletIndex == walletIndex {
+				position = i
+			}
+		}
+		if position < 0 {
+			return nil, nil
+		}
+		wallet := user.Wallets[position]
+
+		addresses, err := XpubWindow(wallet)
+		if err != nil {
+			return nil, fmt.Errorf("extendXpubWallet: XpubWindow: %s", err.Error())
+		}
+		if len(addresses) == 0 {
+			return nil, nil
+		}
+
+		update := bson.M{
+			"$push": bson.M{"wallets." + strconv.Itoa(position) + ".addresses": bson.M{"$each": addresses}},
+			"$set":  bson.M{"wallets." + strconv.Itoa(position) + ".lastActionTime": time.Now().Unix()},
+		}
+		err = usersData.Update(xpubWindowSelector(userID, position, wallet, addresses), update)
+		if err == mgo.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("extendXpubWallet: usersData.Update: %s", err.Error())
+		}
+		return addresses, nil
+	}
+	return nil, fmt.Errorf("extendXpubWallet: window of wallet %d of %s is moved concurrently", walletIndex, userID)
+}
+
+// xpubWindowSelector matches the user while the wallet is at the position and has none of the window addresses.
+// Windows start after the last derived address of each chain, so a window computed before
+// another one is pushed overlaps it and doesn't match.
+func xpubWindowSelector(userID string, position int, wallet store.Wallet, window []store.Address) bson.M {
+	addresses := make([]string, 0, len(window))
+	for _, address := range window {
+		addresses = append(addresses, address.Address)
+	}
+	prefix := "wallets." + strconv.Itoa(position)
+	return bson.M{
+		"userID":                      userID,
+		prefix + ".walletIndex":       wallet.WalletIndex,
+		prefix + ".xpub":              wallet.Xpub,
+		prefix + ".addresses.address": bson.M{"$nin": addresses},
+	}
+}
@@ -0,0 +1,116 @@
+package btc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strings"
+	"testing"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// withVersion reencodes serialized extended key with another version bytes
+func withVersion(key string, version []byte) string {
+	payload := append([]byte{}, base58.Decode(key)[:78]...)
+	copy(payload, version)
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	return base58.Encode(append(payload, second[:4]...))
+}
+
+func TestXpubAddresses(t *testing.T) {
+	master, err := hdkeychain.NewMaster(bytes.Repeat([]byte{7}, 32), &chaincfg.TestNet3Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := master.Neuter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpub := pub.String()
+
+	for key, want := range map[string]struct {
+		addressType string
+		prefix      string
+	}{
+		tpub: {store.AddressP2PKH, ""},
+		withVersion(tpub, []byte{0x04, 0x4a, 0x52, 0x62}): {store.AddressP2SHP2WPKH, "2"},
+		withVersion(tpub, []byte{0x04, 0x5f, 0x1c, 0xf6}): {store.AddressP2WPKH, "tb1q"},
+	} {
+		addressType, err := XpubAddressType(key, currencies.Test)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addressType != want.addressType {
+			t.Errorf("address type = %s, want %s", addressType, want.addressType)
+		}
+		addresses, err := XpubAddresses(key, addressType, false, 0, 2, currencies.Test)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(addresses) != 2 || addresses[0].Address == addresses[1].Address || !strings.HasPrefix(addresses[0].Address, want.prefix) {
+			t.Errorf("unexpected %s addresses %v", addressType, addresses)
+		}
+	}
+
+	if _, err := XpubAddressType(tpub, currencies.Main); err == nil {
+		t.Error("testnet key accepted for main net")
+	}
+	if _, err := XpubAddressType(master.String(), currencies.Test); err == nil {
+		t.Error("private key accepted")
+	}
+}
+
+func TestXpubWindow(t *testing.T) {
+	master, _ := hdkeychain.NewMaster(bytes.Repeat([]byte{7}, 32), &chaincfg.TestNet3Params)
+	pub, _ := master.Neuter()
+	wallet := store.Wallet{
+		NetworkID:   currencies.Test,
+		Xpub:        pub.String(),
+		AddressType: store.AddressP2PKH,
+		GapLimit:    3,
+	}
+
+	window, err := XpubWindow(wallet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(window) != 6 {
+		t.Fatalf("initial window = %d addresses", len(window))
+	}
+	wallet.Adresses = window
+
+	if window, _ = XpubWindow(wallet); len(window) != 0 {
+		t.Errorf("window moved without usage: %v", window)
+	}
+
+	// receive address 1 got a transaction
+	wallet.Adresses[1].LastActionTime = 1
+	window, _ = XpubWindow(wallet)
+	if len(window) != 2 || window[0].AddressIndex != 3 || window[1].AddressIndex != 4 || window[0].IsChange {
+		t.Errorf("unexpected window %v", window)
+	}
+}
+
+func TestXpubWindowSelector(t *testing.T) {
+	master, _ := hdkeychain.NewMaster(bytes.Repeat([]byte{7}, 32), &chaincfg.TestNet3Params)
+	pub, _ := master.Neuter()
+	wallet := store.Wallet{NetworkID: currencies.Test, WalletIndex: 2, Xpub: pub.String(), AddressType: store.AddressP2PKH, GapLimit: 2}
+	window, _ := XpubWindow(wallet)
+
+	// change addresses have indexes of their own chain
+	if len(window) != 4 || window[2].AddressIndex != 0 || !window[2].IsChange {
+		t.Fatalf("unexpected window %v", window)
+	}
+
+	sel := xpubWindowSelector("alice", 1, wallet, window)
+	nin, _ := sel["wallets.1.addresses.address"].(bson.M)["$nin"].([]string)
+	if sel["userID"] != "alice" || sel["wallets.1.walletIndex"] != 2 || sel["wallets.1.xpub"] != wallet.Xpub || len(nin) != 4 || nin[2] != window[2].Address {
+		t.Errorf("unexpected selector %v", sel)
+	}
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"errors"
+
+	"github.com/Multy-io/Multy-back/btc"
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/eth"
+	"github.com/Multy-io/Multy-back/store"
+)
+
+// checkAddress validates address of the wallet currency and network and returns it the way node streamers encode it,
+// errors are store.AddressError or msgErrAddressType. Addresses of currencies without validation are taken as they are.
+func checkAddress(address string, currencyID, networkID int, walletAddressType string) (string, error) {
+	switch currencyID {
+	case currencies.Bitcoin:
+		return checkBTCAddress(address, walletAddressType, networkID)
+	case currencies.Ether:
+		return address, eth.ValidateAddress(address, networkID)
+	}
+	return address, nil
+}
+
+// checkBTCAddress validates address of the network fits the wallet address type and returns it normalized
+func checkBTCAddress(address, walletAddressType string, networkID int) (string, error) {
+	switch walletAddressType {
+	case "", store.AddressP2PKH, store.AddressP2SHP2WPKH, store.AddressP2WPKH:
+	default:
+		return "", errors.New(msgErrAddressType)
+	}
+	addressType, err := btc.AddressType(address, networkID)
+	if err != nil {
+		return "", err
+	}
+	if !btc.MatchAddressType(addressType, walletAddressType) {
+		return "", errors.New(msgErrAddressType)
+	}
+	return btc.NormalizeAddress(address, networkID)
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"testing"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+)
+
+func TestCheckAddress(t *testing.T) {
+	tests := []struct {
+		address     string
+		currencyID  int
+		networkID   int
+		addressType string
+		normalized  string
+		err         error
+	}{
+		{"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", currencies.Bitcoin, currencies.Main, store.AddressP2WPKH, "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", nil},
+		{"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5", currencies.Bitcoin, currencies.Main, "", "", store.ErrAddressChecksum},
+		{"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", currencies.Bitcoin, currencies.Test, "", "", store.ErrAddressNetwork},
+		{"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", currencies.Ether, currencies.ETHMain, "", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", nil},
+		{"0x5aaeb6053F3E94C9b9A09f33669435E7Ef1BeAed", currencies.Ether, currencies.ETHMain, "", "0x5aaeb6053F3E94C9b9A09f33669435E7Ef1BeAed", store.ErrAddressChecksum},
+		// currencies without validation
+		{"ltc1qaddress", 2, 1, "", "ltc1qaddress", nil},
+	}
+	for _, test := range tests {
+		normalized, err := checkAddress(test.address, test.currencyID, test.networkID, test.addressType)
+		if err != test.err || (err == nil && normalized != test.normalized) {
+			t.Errorf("%s: %s %v, want %s %v", test.address, normalized, err, test.normalized, test.err)
+		}
+	}
+
+	if _, err := checkAddress("1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", currencies.Bitcoin, currencies.Main, store.AddressP2WPKH); err == nil || err.Error() != msgErrAddressType {
+		t.Errorf("legacy address added to segwit wallet %v", err)
+	}
+}
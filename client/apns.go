@@ -0,0 +1,167 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const (
+	apnsProductionHost = "https://api.push.apple.com"
+	apnsSandboxHost    = "https://api.sandbox.push.apple.com"
+
+	// APNs rejects provider tokens older than an hour
+	apnsTokenLifetime = 50 * time.Minute
+)
+
+// APNsConf is token based authentication of Apple Push Notification service
+type APNsConf struct {
+	// KeyFile is .p8 signing key from Apple developer account
+	KeyFile string
+	KeyID   string
+	TeamID  string
+	// Topic is bundle id of the app
+	Topic      string
+	Production bool
+}
+
+// APNsNotifier sends pushes to iOS devices directly through APNs HTTP/2 API
+type APNsNotifier struct {
+	conf   APNsConf
+	host   string
+	key    *ecdsa.PrivateKey
+	client *http.Client
+
+	m         sync.Mutex
+	token     string
+	tokenTime time.Time
+}
+
+func NewAPNsNotifier(conf APNsConf) (*APNsNotifier, error) {
+	if conf.KeyID == "" || conf.TeamID == "" || conf.Topic == "" {
+		return nil, errors.New("NewAPNsNotifier: KeyID, TeamID and Topic are required")
+	}
+	raw, err := ioutil.ReadFile(conf.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("NewAPNsNotifier: ioutil.ReadFile: %s", err.Error())
+	}
+	key, err := parseAPNsKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("NewAPNsNotifier: parseAPNsKey: %s", err.Error())
+	}
+
+	host := apnsSandboxHost
+	if conf.Production {
+		host = apnsProductionHost
+	}
+	return &APNsNotifier{
+		conf: conf,
+		host: host,
+		key:  key,
+		// net/http negotiates HTTP/2 over TLS itself
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// parseAPNsKey parses PKCS8 .p8 key of Apple, SEC1 keys are accepted as well
+func parseAPNsKey(raw []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		ecKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("not an ECDSA key")
+		}
+		return ecKey, nil
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+func (an *APNsNotifier) Name() string { return NotifierAPNs }
+
+func (an *APNsNotifier) Supports(deviceType int) bool { return deviceType == DeviceTypeIOS }
+
+// providerToken returns cached ES256 provider token, refreshing it before APNs expires it
+func (an *APNsNotifier) providerToken() (string, error) {
+	an.m.Lock()
+	defer an.m.Unlock()
+	if an.token != "" && time.Since(an.tokenTime) < apnsTokenLifetime {
+		return an.token, nil
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": an.conf.TeamID,
+		"iat": now.Unix(),
+	})
+	token.Header["kid"] = an.conf.KeyID
+	signed, err := token.SignedString(an.key)
+	if err != nil {
+		return "", err
+	}
+	an.token, an.tokenTime = signed, now
+	return signed, nil
+}
+
+func (an *APNsNotifier) Notify(push Push) error {
+	payload := map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{
+				"title": push.Title,
+				"body":  push.Body,
+			},
+		},
+	}
+	for key, value := range push.Data {
+		payload[key] = value
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	token, err := an.providerToken()
+	if err != nil {
+		return fmt.Errorf("providerToken: %s", err.Error())
+	}
+
+	req, err := http.NewRequest(http.MethodPost, an.host+"/3/device/"+push.Token, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("authorization", "bearer "+token)
+	req.Header.Set("apns-topic", an.conf.Topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("content-type", "application/json")
+
+	resp, err := an.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		reason := struct {
+			Reason string `json:"reason"`
+		}{}
+		json.NewDecoder(resp.Body).Decode(&reason)
+		return fmt.Errorf("apns: %d %s", resp.StatusCode, reason.Reason)
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	authChallengeTimeout = 5 * time.Minute
+	// authChallengesLimit is how many challenges of a user can wait for login. Challenges are issued
+	// without authentication, so new ones replace the oldest instead of being refused.
+	authChallengesLimit = 5
+	// authChallengePrefix separates login challenges from other messages signed by the key
+	authChallengePrefix = "Multy login"
+
+	msgErrAuthChallenge = "can't create challenge"
+	msgErrLoginKey      = "wrong public key"
+	msgErrLoginSigned   = "signed challenge required"
+	msgErrLoginKeySet   = "login key is already registered"
+)
+
+// loginChallenge is the message the user signs, sha256 of it is signed by the key
+func loginChallenge(userID, nonce string) string {
+	return fmt.Sprintf("%s %s %s", authChallengePrefix, userID, nonce)
+}
+
+// parseLoginKey parses hex compressed or uncompressed secp256k1 public key
+func parseLoginKey(publicKey string) (*btcec.PublicKey, error) {
+	keyBytes, err := hex.DecodeString(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("parseLoginKey: %s", err.Error())
+	}
+	key, err := btcec.ParsePubKey(keyBytes, btcec.S256())
+	if err != nil {
+		return nil, fmt.Errorf("parseLoginKey: %s", err.Error())
+	}
+	return key, nil
+}
+
+// verifyLoginSignature checks hex DER signature of the challenge by the key
+func verifyLoginSignature(publicKey, challenge, signature string) error {
+	key, err := parseLoginKey(publicKey)
+	if err != nil {
+		return err
+	}
+	sigBytes, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("verifyLoginSignature: %s", err.Error())
+	}
+	sig, err := btcec.ParseDERSignature(sigBytes, btcec.S256())
+	if err != nil {
+		return fmt.Errorf("verifyLoginSignature: %s", err.Error())
+	}
+	hash := sha256.Sum256([]byte(challenge))
+	if !sig.Verify(hash[:], key) {
+		return errors.New("verifyLoginSignature: wrong signature")
+	}
+	return nil
+}
+
+// verifyLogin consumes the challenge of the user and checks it's signed by the key.
+// The challenge is used once even if the signature is wrong.
+func (restClient *RestClient) verifyLogin(userID, publicKey string, loginVals Login) error {
+	if loginVals.Nonce == "" || loginVals.Signature == "" {
+		return errors.New("verifyLogin: no signed challenge")
+	}
+	err := restClient.userStore.ConsumeAuthChallenge(userID, loginVals.Nonce, time.Now())
+	if err != nil {
+		return fmt.Errorf("verifyLogin: ConsumeAuthChallenge: %s", err.Error())
+	}
+	return verifyLoginSignature(publicKey, loginChallenge(userID, loginVals.Nonce), loginVals.Signature)
+}
+
+type ChallengeParams struct {
+	UserID string `json:"userID"`
+}
+
+// authChallenge issues the nonce to sign for login. The client signs sha256 of the challenge
+// with the key derived from the seed and sends nonce and hex DER signature to /auth.
+func (restClient *RestClient) authChallenge() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var cp ChallengeParams
+		err := decodeBody(c, &cp)
+		if err != nil || cp.UserID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrRequestBodyError,
+			})
+			return
+		}
+
+		nonce, err := randomID()
+		if err != nil {
+			restClient.log.Errorf("authChallenge: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": msgErrAuthChallenge,
+			})
+			return
+		}
+		expire := time.Now().Add(authChallengeTimeout)
+		err = restClient.userStore.InsertAuthChallenge(store.AuthChallenge{
+			UserID:   cp.UserID,
+			Nonce:    nonce,
+			ExpireAt: expire,
+		})
+		if err != nil {
+			restClient.log.Errorf("authChallenge: restClient.userStore.InsertAuthChallenge: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": msgErrAuthChallenge,
+			})
+			return
+		}
+		err = restClient.userStore.TrimAuthChallenges(cp.UserID, authChallengesLimit)
+		if err != nil {
+			restClient.log.Errorf("authChallenge: restClient.userStore.TrimAuthChallenges: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"code":      http.StatusOK,
+			"message":   http.StatusText(http.StatusOK),
+			"nonce":     nonce,
+			"challenge": loginChallenge(cp.UserID, nonce),
+			"expire":    expire.Format(time.RFC3339),
+		})
+	}
+}
+
+type LoginKeyParams struct {
+	PublicKey string `json:"publicKey"`
+	Nonce     string `json:"nonce"`
+	Signature string `json:"signature"`
+}
+
+// addLoginKey registers the login key of the account without one. It's done from a logged in device
+// and the key is proved by the signed challenge, signed login is required then.
+func (restClient *RestClient) addLoginKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+		token, _ := getToken(c)
+
+		var kp LoginKeyParams
+		err = decodeBody(c, &kp)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrRequestBodyError,
+			})
+			return
+		}
+		if _, err := parseLoginKey(kp.PublicKey); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrLoginKey,
+			})
+			return
+		}
+		if user.PublicKey != "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrLoginKeySet,
+			})
+			return
+		}
+
+		err = restClient.verifyLogin(user.UserID, kp.PublicKey, Login{Nonce: kp.Nonce, Signature: kp.Signature})
+		if err != nil {
+			restClient.log.Errorf("addLoginKey: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"code":    http.StatusUnauthorized,
+				"message": msgErrLoginSigned,
+			})
+			return
+		}
+
+		// the key registered meanwhile isn't replaced
+		err = restClient.userStore.SetUserPublicKey(user.UserID, kp.PublicKey)
+		if err != nil {
+			restClient.log.Errorf("addLoginKey: restClient.userStore.SetUserPublicKey: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrLoginKeySet,
+			})
+			return
+		}
+		restClient.securityEvent(user.UserID, store.SecurityLoginKeyAdded, tokenDevice(user, token), c.ClientIP(), nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": http.StatusText(http.StatusOK),
+		})
+	}
+}
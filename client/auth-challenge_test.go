@@ -0,0 +1,304 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Multy-io/Multy-back/btc"
+	"github.com/Multy-io/Multy-back/store"
+	nsq "github.com/bitly/go-nsq"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/gin-gonic/gin"
+	"github.com/jekabolt/slf"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// loginStore keeps users and challenges in memory
+type loginStore struct {
+	store.UserStore
+	users      map[string]store.User
+	challenges map[string]time.Time // userID/nonce -> expire
+	issued     []string             // userID/nonce in the order of issue
+}
+
+func (ls *loginStore) FindUser(query bson.M, user *store.User) error {
+	if hash, ok := query["devices.JWT"]; ok {
+		for _, found := range ls.users {
+			for _, device := range found.Devices {
+				if device.JWT == hash {
+					*user = found
+					return nil
+				}
+			}
+		}
+		return errors.New("not found")
+	}
+	found, ok := ls.users[query["userID"].(string)]
+	if !ok {
+		return errors.New("not found")
+	}
+	*user = found
+	return nil
+}
+
+func (ls *loginStore) SetUserPublicKey(userID, publicKey string) error {
+	user := ls.users[userID]
+	if user.PublicKey != "" {
+		return errors.New("not found")
+	}
+	user.PublicKey = publicKey
+	ls.users[userID] = user
+	return nil
+}
+
+func (ls *loginStore) TrimAuthChallenges(userID string, keep int) error {
+	for i := len(ls.issued) - 1; i >= 0; i-- {
+		key := ls.issued[i]
+		if !strings.HasPrefix(key, userID+"/") {
+			continue
+		}
+		if keep > 0 {
+			keep--
+			continue
+		}
+		delete(ls.challenges, key)
+	}
+	return nil
+}
+
+func (ls *loginStore) IsTokenRevoked(hash string) (bool, error) {
+	return false, nil
+}
+
+func (ls *loginStore) InsertSecurityEvent(event store.SecurityEvent) error {
+	return nil
+}
+
+func (ls *loginStore) Insert(user store.User) error {
+	ls.users[user.UserID] = user
+	return nil
+}
+
+func (ls *loginStore) Update(sel, update bson.M) error {
+	return nil
+}
+
+func (ls *loginStore) InsertAuthChallenge(challenge store.AuthChallenge) error {
+	ls.challenges[challenge.UserID+"/"+challenge.Nonce] = challenge.ExpireAt
+	ls.issued = append(ls.issued, challenge.UserID+"/"+challenge.Nonce)
+	return nil
+}
+
+func (ls *loginStore) ConsumeAuthChallenge(userID, nonce string, now time.Time) error {
+	expire, ok := ls.challenges[userID+"/"+nonce]
+	delete(ls.challenges, userID+"/"+nonce)
+	if !ok || !expire.After(now) {
+		return errors.New("not found")
+	}
+	return nil
+}
+
+func (ls *loginStore) RevokeTokens(tokens []store.RevokedToken) error {
+	return nil
+}
+
+func postJSON(r *gin.Engine, path string, body interface{}) (int, map[string]interface{}) {
+	raw, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", path, bytes.NewReader(raw)))
+	resp := map[string]interface{}{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp
+}
+
+func signChallenge(key *btcec.PrivateKey, challenge string) string {
+	hash := sha256.Sum256([]byte(challenge))
+	sig, _ := key.Sign(hash[:])
+	return hex.EncodeToString(sig.Serialize())
+}
+
+func TestChallengeLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := &loginStore{users: map[string]store.User{}, challenges: map[string]time.Time{}}
+	restClient := &RestClient{userStore: db, Secretkey: "secret", log: slf.WithContext("test")}
+	initMiddlewareJWT(restClient)
+	r := gin.New()
+	r.POST("/auth", restClient.LoginHandler())
+	r.POST("/auth/challenge", restClient.authChallenge())
+
+	key, _ := btcec.NewPrivateKey(btcec.S256())
+	publicKey := hex.EncodeToString(key.PubKey().SerializeCompressed())
+	login := Login{UserID: "alice", DeviceID: "phone", PushToken: "push", AppVersion: "1.0", DeviceType: DeviceTypeIOS}
+
+	challenge := func() (string, string) {
+		code, resp := postJSON(r, "/auth/challenge", ChallengeParams{UserID: "alice"})
+		if code != http.StatusOK {
+			t.Fatalf("challenge %d %v", code, resp)
+		}
+		return resp["nonce"].(string), resp["challenge"].(string)
+	}
+
+	// the key is registered with the new account
+	nonce, message := challenge()
+	signed := login
+	signed.PublicKey, signed.Nonce, signed.Signature = publicKey, nonce, signChallenge(key, message)
+	if code, resp := postJSON(r, "/auth", signed); code != http.StatusOK || resp["token"] == "" {
+		t.Fatalf("signed sign up %d %v", code, resp)
+	}
+	if db.users["alice"].PublicKey != publicKey {
+		t.Errorf("key isn't registered %+v", db.users["alice"])
+	}
+
+	// challenges are used once
+	if code, _ := postJSON(r, "/auth", signed); code != http.StatusUnauthorized {
+		t.Errorf("replayed challenge %d", code)
+	}
+	if code, _ := postJSON(r, "/auth", login); code != http.StatusUnauthorized {
+		t.Errorf("plain login of account with key %d", code)
+	}
+
+	other, _ := btcec.NewPrivateKey(btcec.S256())
+	nonce, message = challenge()
+	forged := login
+	forged.PublicKey, forged.Nonce, forged.Signature = hex.EncodeToString(other.PubKey().SerializeCompressed()), nonce, signChallenge(other, message)
+	if code, _ := postJSON(r, "/auth", forged); code != http.StatusUnauthorized {
+		t.Errorf("login signed by other key %d", code)
+	}
+
+	nonce, message = challenge()
+	signed.Nonce, signed.Signature = nonce, signChallenge(key, message)
+	if code, resp := postJSON(r, "/auth", signed); code != http.StatusOK || resp["token"] == "" {
+		t.Errorf("signed login %d %v", code, resp)
+	}
+
+	// accounts without keys log in as before
+	plain := login
+	plain.UserID = "bob"
+	if code, _ := postJSON(r, "/auth", plain); code != http.StatusOK {
+		t.Errorf("plain sign up %d", code)
+	}
+
+	// the key isn't registered to the existing account at login, it would lock the owner out
+	code, resp := postJSON(r, "/auth/challenge", ChallengeParams{UserID: "bob"})
+	if code != http.StatusOK {
+		t.Fatalf("challenge %d %v", code, resp)
+	}
+	takeover := plain
+	takeover.PublicKey, takeover.Nonce = hex.EncodeToString(other.PubKey().SerializeCompressed()), resp["nonce"].(string)
+	takeover.Signature = signChallenge(other, resp["challenge"].(string))
+	postJSON(r, "/auth", takeover)
+	if db.users["bob"].PublicKey != "" {
+		t.Errorf("key registered to the existing account")
+	}
+	if code, _ := postJSON(r, "/auth", plain); code != http.StatusOK {
+		t.Errorf("owner is locked out %d", code)
+	}
+	bad := plain
+	bad.UserID, bad.PublicKey = "carol", "00"
+	if code, _ := postJSON(r, "/auth", bad); code != http.StatusBadRequest {
+		t.Errorf("wrong key %d", code)
+	}
+}
+
+func TestVerifyLoginSignature(t *testing.T) {
+	key, _ := btcec.NewPrivateKey(btcec.S256())
+	uncompressed := hex.EncodeToString(key.PubKey().SerializeUncompressed())
+	challenge := loginChallenge("alice", "nonce")
+	if err := verifyLoginSignature(uncompressed, challenge, signChallenge(key, challenge)); err != nil {
+		t.Errorf("uncompressed key: %s", err.Error())
+	}
+	if err := verifyLoginSignature(uncompressed, loginChallenge("bob", "nonce"), signChallenge(key, challenge)); err == nil {
+		t.Errorf("signature of other user challenge accepted")
+	}
+	if err := verifyLoginSignature(uncompressed, challenge, "zz"); err == nil {
+		t.Errorf("garbage signature accepted")
+	}
+}
+
+func TestAddLoginKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	producer, _ := nsq.NewProducer("127.0.0.1:1", nsq.NewConfig())
+	producer.SetLogger(nil, nsq.LogLevelError)
+	db := &loginStore{users: map[string]store.User{}, challenges: map[string]time.Time{}}
+	restClient := &RestClient{userStore: db, Secretkey: "secret", log: slf.WithContext("test"), BTC: &btc.BTCConn{NsqProducer: producer}}
+	initMiddlewareJWT(restClient)
+	r := gin.New()
+	r.POST("/auth", restClient.LoginHandler())
+	r.POST("/auth/challenge", restClient.authChallenge())
+	r.POST("/api/v1/auth/key", restClient.middlewareJWT.MiddlewareFunc(), restClient.addLoginKey())
+
+	login := Login{UserID: "bob", DeviceID: "phone", PushToken: "push", AppVersion: "1.0", DeviceType: DeviceTypeIOS}
+	code, resp := postJSON(r, "/auth", login)
+	if code != http.StatusOK {
+		t.Fatalf("sign up %d %v", code, resp)
+	}
+	token := resp["token"].(string)
+	addKey := func(token string, params LoginKeyParams) int {
+		raw, _ := json.Marshal(params)
+		req := httptest.NewRequest("POST", "/api/v1/auth/key", bytes.NewReader(raw))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	key, _ := btcec.NewPrivateKey(btcec.S256())
+	publicKey := hex.EncodeToString(key.PubKey().SerializeCompressed())
+	_, resp = postJSON(r, "/auth/challenge", ChallengeParams{UserID: "bob"})
+	params := LoginKeyParams{PublicKey: publicKey, Nonce: resp["nonce"].(string), Signature: signChallenge(key, resp["challenge"].(string))}
+	if code := addKey("wrong", params); code != http.StatusUnauthorized {
+		t.Errorf("key added without login %d", code)
+	}
+	if code := addKey(token, params); code != http.StatusOK || db.users["bob"].PublicKey != publicKey {
+		t.Errorf("wrong key addition %d %+v", code, db.users["bob"])
+	}
+	if code, _ := postJSON(r, "/auth", login); code != http.StatusUnauthorized {
+		t.Errorf("plain login of account with key %d", code)
+	}
+	if code := addKey(token, params); code != http.StatusBadRequest {
+		t.Errorf("key replaced %d", code)
+	}
+}
+
+func TestAuthChallengesLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := &loginStore{users: map[string]store.User{}, challenges: map[string]time.Time{}}
+	restClient := &RestClient{userStore: db, log: slf.WithContext("test")}
+	r := gin.New()
+	r.POST("/auth/challenge", restClient.authChallenge())
+
+	nonces := []string{}
+	for i := 0; i <= authChallengesLimit; i++ {
+		code, resp := postJSON(r, "/auth/challenge", ChallengeParams{UserID: "alice"})
+		if code != http.StatusOK {
+			t.Fatalf("challenge %d: %d", i, code)
+		}
+		nonces = append(nonces, resp["nonce"].(string))
+	}
+	// requests of others don't lock the user out, the oldest challenge is replaced
+	if len(db.challenges) != authChallengesLimit {
+		t.Errorf("%d challenges kept", len(db.challenges))
+	}
+	if _, ok := db.challenges["alice/"+nonces[0]]; ok {
+		t.Errorf("oldest challenge kept")
+	}
+	if _, ok := db.challenges["alice/"+nonces[authChallengesLimit]]; !ok {
+		t.Errorf("latest challenge dropped")
+	}
+	if code, _ := postJSON(r, "/auth/challenge", ChallengeParams{UserID: "bob"}); code != http.StatusOK || len(db.challenges) != authChallengesLimit+1 {
+		t.Errorf("challenges of other user limited %d", code)
+	}
+}
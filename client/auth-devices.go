@@ -0,0 +1,322 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// jwtMaxRefresh is how long tokens are refreshed after login, signing in again is required then
+	jwtMaxRefresh = 30 * 24 * time.Hour
+	// revocationCacheTimeout is how long a token found not revoked isn't looked up again.
+	// Tokens revoked on other instances are accepted there for that long at most.
+	revocationCacheTimeout = 10 * time.Second
+	revocationCacheSize    = 10000
+
+	msgErrRefreshToken = "can't refresh token"
+	msgErrLogout       = "can't log out"
+	msgErrNoDevice     = "no such device"
+)
+
+// revocationCache keeps hashes of tokens recently found not revoked, requests of the same device
+// don't look up the revocation list each time
+type revocationCache struct {
+	m       sync.Mutex
+	checked map[string]time.Time
+}
+
+func (rc *revocationCache) notRevoked(hash string) bool {
+	rc.m.Lock()
+	defer rc.m.Unlock()
+	checked, ok := rc.checked[hash]
+	return ok && time.Since(checked) < revocationCacheTimeout
+}
+
+func (rc *revocationCache) add(hash string) {
+	rc.m.Lock()
+	defer rc.m.Unlock()
+	if rc.checked == nil {
+		rc.checked = map[string]time.Time{}
+	}
+	if len(rc.checked) >= revocationCacheSize {
+		for h, checked := range rc.checked {
+			if time.Since(checked) >= revocationCacheTimeout {
+				delete(rc.checked, h)
+			}
+		}
+	}
+	rc.checked[hash] = time.Now()
+}
+
+func (rc *revocationCache) forget(hashes ...string) {
+	rc.m.Lock()
+	defer rc.m.Unlock()
+	for _, hash := range hashes {
+		delete(rc.checked, hash)
+	}
+}
+
+// tokenRevoked checks the token is in the revocation list, tokens aren't trusted if it can't be read
+func (restClient *RestClient) tokenRevoked(token string) bool {
+	hash := store.TokenHash(token)
+	if restClient.revocations.notRevoked(hash) {
+		return false
+	}
+	revoked, err := restClient.userStore.IsTokenRevoked(hash)
+	if err != nil {
+		restClient.log.Errorf("tokenRevoked: restClient.userStore.IsTokenRevoked: %s", err.Error())
+		return true
+	}
+	if !revoked {
+		restClient.revocations.add(hash)
+	}
+	return revoked
+}
+
+// revokeTokens adds token hashes of the user to the revocation list until any token issued by now expires
+func (restClient *RestClient) revokeTokens(userID string, hashes ...string) error {
+	expire := time.Now().Add(restClient.middlewareJWT.Timeout)
+	tokens := []store.RevokedToken{}
+	for _, hash := range hashes {
+		if hash != "" {
+			tokens = append(tokens, store.RevokedToken{Hash: hash, UserID: userID, ExpireAt: expire})
+		}
+	}
+	if len(tokens) == 0 {
+		return nil
+	}
+	restClient.revocations.forget(hashes...)
+	return restClient.userStore.RevokeTokens(tokens)
+}
+
+// logoutDevices revokes tokens of the devices and stops their push notifications,
+// the devices are kept with their preferences until they log in again
+func (restClient *RestClient) logoutDevices(userID string, devices []store.Device) error {
+	hashes := []string{}
+	for _, device := range devices {
+		hashes = append(hashes, device.JWT)
+	}
+	err := restClient.revokeTokens(userID, hashes...)
+	if err != nil {
+		return err
+	}
+	for _, device := range devices {
+		sel := bson.M{"userID": userID, "devices.deviceID": device.DeviceID}
+		err := restClient.userStore.Update(sel, bson.M{"$set": bson.M{"devices.$.JWT": "", "devices.$.pushToken": ""}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshHandler reissues the token of the device until jwtMaxRefresh since login, the old token is revoked
+func (restClient *RestClient) refreshHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+		token, _ := getToken(c)
+
+		tokenString, expire, err := restClient.middlewareJWT.RefreshToken(ExtractClaims(c))
+		if err != nil {
+			restClient.middlewareJWT.unauthorized(c, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		// concurrent refresh of the same token fails here
+		sel := bson.M{"userID": user.UserID, "devices.JWT": token}
+		set := bson.M{"devices.$.JWT": store.TokenHash(tokenString), "devices.$.lastActionTime": time.Now().Unix(), "devices.$.lastActionIP": c.ClientIP()}
+		err = restClient.userStore.Update(sel, bson.M{"$set": set})
+		if err != nil {
+			restClient.log.Errorf("refreshHandler: restClient.userStore.Update: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			restClient.middlewareJWT.unauthorized(c, http.StatusUnauthorized, msgErrRefreshToken)
+			return
+		}
+		err = restClient.revokeTokens(user.UserID, token)
+		if err != nil {
+			restClient.log.Errorf("refreshHandler: revokeTokens: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"token":  tokenString,
+			"expire": expire.Format(time.RFC3339),
+		})
+	}
+}
+
+// logout logs out the device of the request
+func (restClient *RestClient) logout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+		token, _ := getToken(c)
+
+		// the token is of no device when it's logged out already
+		device := tokenDevice(user, token)
+		if device.DeviceID == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"code":    http.StatusUnauthorized,
+				"message": msgErrNoDevice,
+			})
+			return
+		}
+
+		err = restClient.logoutDevices(user.UserID, []store.Device{device})
+		if err != nil {
+			restClient.log.Errorf("logout: logoutDevices: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": msgErrLogout,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": http.StatusText(http.StatusOK),
+		})
+	}
+}
+
+// logoutOthers logs out all user devices but the one of the request
+func (restClient *RestClient) logoutOthers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+		token, _ := getToken(c)
+		current := tokenDevice(user, token)
+
+		others := []store.Device{}
+		for _, device := range user.Devices {
+			if device.DeviceID != current.DeviceID && (device.JWT != "" || device.PushToken != "") {
+				others = append(others, device)
+			}
+		}
+		err = restClient.logoutDevices(user.UserID, others)
+		if err != nil {
+			restClient.log.Errorf("logoutOthers: logoutDevices: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": msgErrLogout,
+			})
+			return
+		}
+		if len(others) > 0 {
+			restClient.securityEvent(user.UserID, store.SecurityDevicesLoggedOut, current, c.ClientIP(), map[string]string{
+				"devices": strconv.Itoa(len(others)),
+			})
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": http.StatusText(http.StatusOK),
+			"devices": len(others),
+		})
+	}
+}
+
+// DeviceInfo is the user device as listed to the user
+type DeviceInfo struct {
+	DeviceID       string `json:"deviceid"`
+	DeviceType     int    `json:"devicetype"`
+	AppVersion     string `json:"appversion"`
+	LastActionTime int64  `json:"lastactiontime"`
+	LastActionIP   string `json:"lastactionip"`
+	LoggedIn       bool   `json:"loggedin"`
+	// Current is the device of the request
+	Current bool `json:"current"`
+}
+
+func (restClient *RestClient) getDevices() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+		token, _ := getToken(c)
+
+		devices := []DeviceInfo{}
+		for _, device := range user.Devices {
+			devices = append(devices, DeviceInfo{
+				DeviceID:       device.DeviceID,
+				DeviceType:     device.DeviceType,
+				AppVersion:     device.AppVersion,
+				LastActionTime: device.LastActionTime,
+				LastActionIP:   device.LastActionIP,
+				LoggedIn:       device.JWT != "",
+				Current:        device.JWT == token,
+			})
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": http.StatusText(http.StatusOK),
+			"devices": devices,
+		})
+	}
+}
+
+// deleteDevice cuts off the device, e.g. a lost phone. Its token is revoked and the device is removed,
+// logging in from it again is a new device then.
+func (restClient *RestClient) deleteDevice() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+		token, _ := getToken(c)
+
+		deviceID := c.Param("deviceid")
+		device, found := store.Device{}, false
+		for _, d := range user.Devices {
+			if d.DeviceID == deviceID {
+				device, found = d, true
+				break
+			}
+		}
+		if !found {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrNoDevice,
+			})
+			return
+		}
+
+		err = restClient.revokeTokens(user.UserID, device.JWT)
+		if err == nil {
+			err = restClient.userStore.Update(bson.M{"userID": user.UserID}, bson.M{"$pull": bson.M{"devices": bson.M{"deviceID": deviceID}}})
+		}
+		if err != nil {
+			restClient.log.Errorf("deleteDevice: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": msgErrLogout,
+			})
+			return
+		}
+		if device.JWT != token {
+			restClient.securityEvent(user.UserID, store.SecurityDevicesLoggedOut, tokenDevice(user, token), c.ClientIP(), map[string]string{
+				"devices":  "1",
+				"deviceid": deviceID,
+			})
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": http.StatusText(http.StatusOK),
+		})
+	}
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Multy-io/Multy-back/btc"
+	"github.com/Multy-io/Multy-back/store"
+	nsq "github.com/bitly/go-nsq"
+	"github.com/gin-gonic/gin"
+	"github.com/jekabolt/slf"
+	"gopkg.in/dgrijalva/jwt-go.v3"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// sessionsStore keeps a single user with devices and the revocation list in memory
+type sessionsStore struct {
+	store.UserStore
+	user    store.User
+	revoked map[string]time.Time
+	events  []store.SecurityEvent
+}
+
+func (ss *sessionsStore) FindUser(query bson.M, user *store.User) error {
+	if hash, ok := query["devices.JWT"]; ok && ss.device(hash.(string)) < 0 {
+		return errors.New("not found")
+	}
+	*user = ss.user
+	user.Devices = append([]store.Device{}, ss.user.Devices...)
+	return nil
+}
+
+func (ss *sessionsStore) device(hash string) int {
+	for i, device := range ss.user.Devices {
+		if device.JWT == hash {
+			return i
+		}
+	}
+	return -1
+}
+
+func (ss *sessionsStore) Update(sel, update bson.M) error {
+	if pull, ok := update["$pull"]; ok {
+		deviceID := pull.(bson.M)["devices"].(bson.M)["deviceID"]
+		devices := []store.Device{}
+		for _, device := range ss.user.Devices {
+			if device.DeviceID != deviceID {
+				devices = append(devices, device)
+			}
+		}
+		ss.user.Devices = devices
+		return nil
+	}
+
+	i := -1
+	if hash, ok := sel["devices.JWT"]; ok {
+		i = ss.device(hash.(string))
+	}
+	for j, device := range ss.user.Devices {
+		if device.DeviceID == sel["devices.deviceID"] {
+			i = j
+		}
+	}
+	if i < 0 {
+		return errors.New("not found")
+	}
+	set := update["$set"].(bson.M)
+	ss.user.Devices[i].JWT = set["devices.$.JWT"].(string)
+	if pushToken, ok := set["devices.$.pushToken"]; ok {
+		ss.user.Devices[i].PushToken = pushToken.(string)
+	}
+	return nil
+}
+
+func (ss *sessionsStore) RevokeTokens(tokens []store.RevokedToken) error {
+	for _, token := range tokens {
+		ss.revoked[token.Hash] = token.ExpireAt
+	}
+	return nil
+}
+
+func (ss *sessionsStore) IsTokenRevoked(hash string) (bool, error) {
+	_, ok := ss.revoked[hash]
+	return ok, nil
+}
+
+func (ss *sessionsStore) InsertSecurityEvent(event store.SecurityEvent) error {
+	ss.events = append(ss.events, event)
+	return nil
+}
+
+func TestDeviceSessions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := &sessionsStore{revoked: map[string]time.Time{}}
+	// security events are published to nowhere
+	producer, _ := nsq.NewProducer("127.0.0.1:1", nsq.NewConfig())
+	producer.SetLogger(nil, nsq.LogLevelError)
+	restClient := &RestClient{userStore: db, Secretkey: "secret", log: slf.WithContext("test"), BTC: &btc.BTCConn{NsqProducer: producer}}
+	initMiddlewareJWT(restClient)
+	mw := restClient.middlewareJWT
+	mw.MiddlewareInit()
+
+	r := gin.New()
+	r.POST("/auth/refresh", mw.MiddlewareFunc(), restClient.refreshHandler())
+	v1 := r.Group("/api/v1")
+	v1.Use(mw.MiddlewareFunc())
+	v1.POST("/logout", restClient.logout())
+	v1.POST("/logout/others", restClient.logoutOthers())
+	v1.GET("/devices", restClient.getDevices())
+	v1.DELETE("/devices/:deviceid", restClient.deleteDevice())
+
+	request := func(method, path, token string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		resp := map[string]interface{}{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	issue := func() string {
+		token, _, err := mw.RefreshToken(jwt.MapClaims{"id": "alice", "orig_iat": float64(time.Now().Unix())})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return token
+	}
+
+	phone, tablet, laptop := issue(), issue(), issue()
+	// logged in too long ago to be refreshed
+	stale, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":       "alice",
+		"exp":      time.Now().Add(time.Hour).Unix(),
+		"orig_iat": time.Now().Add(-jwtMaxRefresh - time.Minute).Unix(),
+	}).SignedString(mw.Key)
+	db.user = store.User{UserID: "alice", Devices: []store.Device{
+		{DeviceID: "phone", JWT: store.TokenHash(phone), PushToken: "p1"},
+		{DeviceID: "tablet", JWT: store.TokenHash(tablet), PushToken: "p2"},
+		{DeviceID: "laptop", JWT: store.TokenHash(laptop), PushToken: "p3"},
+		{DeviceID: "old", JWT: store.TokenHash(stale)},
+	}}
+
+	code, resp := request("GET", "/api/v1/devices", phone)
+	devices, _ := resp["devices"].([]interface{})
+	if code != http.StatusOK || len(devices) != 4 || devices[0].(map[string]interface{})["current"] != true || devices[1].(map[string]interface{})["current"] != false {
+		t.Fatalf("wrong devices %d %v", code, resp)
+	}
+
+	// refreshed token replaces the device one, the old is revoked
+	code, resp = request("POST", "/auth/refresh", phone)
+	refreshed, _ := resp["token"].(string)
+	if code != http.StatusOK || refreshed == "" || refreshed == phone {
+		t.Fatalf("wrong refresh %d %v", code, resp)
+	}
+	if db.user.Devices[0].JWT != store.TokenHash(refreshed) {
+		t.Errorf("device token isn't replaced")
+	}
+	if code, _ := request("GET", "/api/v1/devices", phone); code != http.StatusUnauthorized {
+		t.Errorf("refreshed token accepted %d", code)
+	}
+	if code, _ := request("GET", "/api/v1/devices", refreshed); code != http.StatusOK {
+		t.Errorf("new token isn't accepted %d", code)
+	}
+	if code, _ := request("POST", "/auth/refresh", stale); code != http.StatusUnauthorized {
+		t.Errorf("token refreshed after max refresh %d", code)
+	}
+
+	code, _ = request("POST", "/api/v1/logout", tablet)
+	if code != http.StatusOK || db.user.Devices[1].JWT != "" || db.user.Devices[1].PushToken != "" {
+		t.Errorf("wrong logout %d %+v", code, db.user.Devices[1])
+	}
+	if code, _ := request("GET", "/api/v1/devices", tablet); code != http.StatusUnauthorized {
+		t.Errorf("logged out token accepted %d", code)
+	}
+
+	// lost laptop is cut off from the phone
+	if code, _ := request("DELETE", "/api/v1/devices/laptop", refreshed); code != http.StatusOK || len(db.user.Devices) != 3 {
+		t.Errorf("wrong device deletion %d %+v", code, db.user.Devices)
+	}
+	if code, _ := request("GET", "/api/v1/devices", laptop); code != http.StatusUnauthorized {
+		t.Errorf("deleted device token accepted %d", code)
+	}
+	if code, _ := request("DELETE", "/api/v1/devices/laptop", refreshed); code != http.StatusBadRequest {
+		t.Errorf("deleted twice %d", code)
+	}
+
+	// the tablet is logged out already
+	code, resp = request("POST", "/api/v1/logout/others", refreshed)
+	if code != http.StatusOK || resp["devices"] != float64(1) || db.user.Devices[2].JWT != "" {
+		t.Errorf("wrong logout of others %d %v %+v", code, resp, db.user.Devices)
+	}
+	if _, revoked := db.revoked[store.TokenHash(stale)]; !revoked {
+		t.Errorf("other device token isn't revoked")
+	}
+	if code, _ := request("GET", "/api/v1/devices", refreshed); code != http.StatusOK {
+		t.Errorf("current device is logged out %d", code)
+	}
+	if len(db.events) != 2 || db.events[0].Type != store.SecurityDevicesLoggedOut || db.events[0].DeviceID != "phone" {
+		t.Errorf("wrong security events %+v", db.events)
+	}
+}
+
+func TestTokenRevokedCache(t *testing.T) {
+	db := &sessionsStore{revoked: map[string]time.Time{}}
+	restClient := &RestClient{userStore: db, log: slf.WithContext("test")}
+	initMiddlewareJWT(restClient)
+
+	if restClient.tokenRevoked("phone") {
+		t.Fatal("token isn't revoked")
+	}
+	// revoked by another instance, the cached lookup is trusted for a while
+	db.revoked[store.TokenHash("phone")] = time.Now()
+	if restClient.tokenRevoked("phone") {
+		t.Error("cached lookup isn't used")
+	}
+	restClient.revocations.checked[store.TokenHash("phone")] = time.Now().Add(-revocationCacheTimeout)
+	if !restClient.tokenRevoked("phone") {
+		t.Error("expired lookup is used")
+	}
+
+	// revoked by this instance
+	if restClient.tokenRevoked("tablet") {
+		t.Fatal("token isn't revoked")
+	}
+	if err := restClient.revokeTokens("alice", store.TokenHash("tablet")); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !restClient.tokenRevoked("tablet") {
+		t.Error("revoked token is cached")
+	}
+}
@@ -61,6 +61,10 @@ type GinJWTMiddleware struct {
 	// Optional, by default no additional data will be set.
 	PayloadFunc func(userID string) map[string]interface{}
 
+	// Callback function that checks the token is revoked before it expires, e.g. on logout.
+	// Optional, by default tokens aren't revoked.
+	Revoked func(token string) bool
+
 	// User can define own Unauthorized func.
 	Unauthorized func(*gin.Context, int, string)
 
@@ -90,6 +94,11 @@ type Login struct {
 	PushToken  string `form:"pushToken" json:"pushToken" binding:"required"`
 	AppVersion string `form:"appVersion" json:"appVersion" binding:"required"`
 	DeviceType int    `form:"deviceType" json:"deviceType" binding:"required"`
+	Locale     string `form:"locale" json:"locale"`
+	// PublicKey registers the login key, Nonce of /auth/challenge and Signature of it log in accounts with the key
+	PublicKey string `form:"publicKey" json:"publicKey"`
+	Nonce     string `form:"nonce" json:"nonce"`
+	Signature string `form:"signature" json:"signature"`
 }
 
 // MiddlewareInit initialize jwt configs.
@@ -172,6 +181,11 @@ func (mw *GinJWTMiddleware) middlewareImpl(c *gin.Context) {
 		return
 	}
 
+	if mw.Revoked != nil && mw.Revoked(token.Raw) {
+		mw.unauthorized(c, http.StatusUnauthorized, "Token is revoked.")
+		return
+	}
+
 	claims := token.Claims.(jwt.MapClaims)
 
 	id := mw.IdentityHandler(claims)
@@ -190,16 +204,31 @@ func (mw *GinJWTMiddleware) middlewareImpl(c *gin.Context) {
 // Shall be put under an endpoint that is using the GinJWTMiddleware.
 // Reply will be of the form {"token": "TOKEN"}.
 func (mw *GinJWTMiddleware) RefreshHandler(c *gin.Context) {
-	token, _ := mw.parseToken(c)
-	claims := token.Claims.(jwt.MapClaims)
-
-	origIat := int64(claims["orig_iat"].(float64))
+	token, err := mw.parseToken(c)
+	if err != nil {
+		mw.unauthorized(c, http.StatusUnauthorized, err.Error())
+		return
+	}
 
-	if origIat < mw.TimeFunc().Add(-mw.MaxRefresh).Unix() {
-		mw.unauthorized(c, http.StatusUnauthorized, "Token is expired.")
+	tokenString, expire, err := mw.RefreshToken(token.Claims.(jwt.MapClaims))
+	if err != nil {
+		mw.unauthorized(c, http.StatusUnauthorized, err.Error())
 		return
 	}
 
+	c.JSON(http.StatusOK, gin.H{
+		"token":  tokenString,
+		"expire": expire.Format(time.RFC3339),
+	})
+}
+
+// RefreshToken creates a new token with the claims until MaxRefresh has passed since login.
+func (mw *GinJWTMiddleware) RefreshToken(claims jwt.MapClaims) (string, time.Time, error) {
+	origIat, ok := claims["orig_iat"].(float64)
+	if !ok || int64(origIat) < mw.TimeFunc().Add(-mw.MaxRefresh).Unix() {
+		return "", time.Time{}, errors.New("Token is expired.")
+	}
+
 	// Create the token
 	newToken := jwt.New(jwt.GetSigningMethod(mw.SigningAlgorithm))
 	newClaims := newToken.Claims.(jwt.MapClaims)
@@ -208,22 +237,22 @@ func (mw *GinJWTMiddleware) RefreshHandler(c *gin.Context) {
 		newClaims[key] = claims[key]
 	}
 
+	// tokens refreshed within a second differ, so the old one can be revoked
+	jti, err := randomID()
+	if err != nil {
+		return "", time.Time{}, err
+	}
 	expire := mw.TimeFunc().Add(mw.Timeout)
 	newClaims["id"] = claims["id"]
 	newClaims["exp"] = expire.Unix()
-	newClaims["orig_iat"] = origIat
+	newClaims["orig_iat"] = int64(origIat)
+	newClaims["jti"] = jti
 
 	tokenString, err := newToken.SignedString(mw.Key)
-
 	if err != nil {
-		mw.unauthorized(c, http.StatusUnauthorized, "Create JWT Token faild")
-		return
+		return "", time.Time{}, errors.New("Create JWT Token faild")
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"token":  tokenString,
-		"expire": expire.Format(time.RFC3339),
-	})
+	return tokenString, expire, nil
 }
 
 // ExtractClaims help to extract the JWT claims
@@ -316,6 +345,12 @@ func (mw *GinJWTMiddleware) parseToken(c *gin.Context) (*jwt.Token, error) {
 	if err != nil {
 		return nil, err
 	}
+	return mw.ParseTokenString(token)
+}
+
+// ParseTokenString parses and validates the token signed with the middleware key,
+// it is used to authenticate connections outside of gin as well
+func (mw *GinJWTMiddleware) ParseTokenString(token string) (*jwt.Token, error) {
 	return jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
 		if jwt.GetSigningMethod(mw.SigningAlgorithm) != token.Method {
 			return nil, errors.New("invalid signing algorithm")
@@ -0,0 +1,332 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/gin-gonic/gin"
+)
+
+// candle intervals
+const (
+	Candle1m = "1m"
+	Candle1h = "1h"
+	Candle1d = "1d"
+)
+
+// sources of backfilled candles
+const (
+	CandleSourceCryptoCompare = "cryptocompare"
+	CandleSourceNone          = "none"
+)
+
+const (
+	// rates snapshots are downsampled into candles, they are kept for a while
+	snapshotsRetention = time.Hour * 24 * 2
+	candlesCleanTick   = time.Hour
+	candlesFillTick    = time.Hour * 6
+
+	// defaultCandles is the number of candles of history requests without from
+	defaultCandles = 100
+	maxCandles     = 2000
+
+	cryptoCompareAPIAddr = "https://min-api.cryptocompare.com/data/"
+	cryptoCompareLimit   = 2000
+
+	msgErrHistoryPair     = "wrong pair"
+	msgErrHistoryInterval = "wrong interval"
+	msgErrHistoryRange    = "wrong time range"
+	msgErrHistory         = "can't get rates history"
+)
+
+// candleInterval is how long candles of the interval are and how long they're kept
+type candleInterval struct {
+	name   string
+	period time.Duration
+	// retention is zero if candles are kept forever
+	retention time.Duration
+	// backfill is how far back gaps are filled
+	backfill time.Duration
+	// histo is the CryptoCompare endpoint of the interval
+	histo string
+}
+
+var candleIntervals = []candleInterval{
+	{name: Candle1m, period: time.Minute, retention: time.Hour * 24 * 2, backfill: time.Hour * 24 * 2, histo: "histominute"},
+	{name: Candle1h, period: time.Hour, retention: time.Hour * 24 * 90, backfill: time.Hour * 24 * 90, histo: "histohour"},
+	{name: Candle1d, period: time.Hour * 24, backfill: time.Hour * 24 * 2000, histo: "histoday"},
+}
+
+func findCandleInterval(name string) (candleInterval, bool) {
+	for _, interval := range candleIntervals {
+		if interval.name == name {
+			return interval, true
+		}
+	}
+	return candleInterval{}, false
+}
+
+// start returns the start of the candle the time is in
+func (interval candleInterval) start(t time.Time) int64 {
+	return t.Truncate(interval.period).Unix()
+}
+
+// CandleSource gives historical candles of the pair starting within [from, to] to fill gaps
+type CandleSource interface {
+	Candles(pair string, interval candleInterval, from, to int64) ([]store.Candle, error)
+}
+
+func newCandleSource(name string) (CandleSource, error) {
+	switch name {
+	case "", CandleSourceCryptoCompare:
+		return &CryptoCompareSource{addr: cryptoCompareAPIAddr, client: &http.Client{Timeout: time.Second * 30}}, nil
+	case CandleSourceNone:
+		return nil, nil
+	}
+	return nil, errors.New("unknown candle source " + name)
+}
+
+// CryptoCompareSource gives candles of CryptoCompare aggregated index
+type CryptoCompareSource struct {
+	addr   string
+	client *http.Client
+}
+
+// CryptoCompareHisto is a CryptoCompare json parser structure
+type CryptoCompareHisto struct {
+	Response string `json:"Response"`
+	Message  string `json:"Message"`
+	Data     []struct {
+		Time  int64   `json:"time"`
+		Open  float64 `json:"open"`
+		High  float64 `json:"high"`
+		Low   float64 `json:"low"`
+		Close float64 `json:"close"`
+	} `json:"Data"`
+}
+
+func (cc *CryptoCompareSource) Candles(pair string, interval candleInterval, from, to int64) ([]store.Candle, error) {
+	currencies := strings.Split(pair, "-")
+	if len(currencies) != 2 {
+		return nil, errors.New("wrong pair " + pair)
+	}
+
+	candles := []store.Candle{}
+	// pages go back from the end of the range
+	for to >= from {
+		limit := (to-from)/int64(interval.period.Seconds()) + 1
+		if limit > cryptoCompareLimit {
+			limit = cryptoCompareLimit
+		}
+		reqURI := fmt.Sprintf("%s%s?fsym=%s&tsym=%s&limit=%d&toTs=%d", cc.addr, interval.histo, currencies[0], currencies[1], limit, to)
+		resp, err := cc.client.Get(reqURI)
+		if err != nil {
+			return nil, err
+		}
+		histo := CryptoCompareHisto{}
+		err = json.NewDecoder(resp.Body).Decode(&histo)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if histo.Response != "Success" {
+			return nil, errors.New(histo.Message)
+		}
+		if len(histo.Data) == 0 {
+			break
+		}
+
+		for _, data := range histo.Data {
+			// there are zeros before the pair was traded
+			if data.Time < from || data.Time > to || data.Close <= 0 {
+				continue
+			}
+			candles = append(candles, store.Candle{
+				Pair:     pair,
+				Interval: interval.name,
+				Time:     data.Time,
+				Open:     data.Open,
+				High:     data.High,
+				Low:      data.Low,
+				Close:    data.Close,
+			})
+		}
+		to = histo.Data[0].Time - int64(interval.period.Seconds())
+	}
+	return candles, nil
+}
+
+// addCandleSamples adds canonical rates to candles of all intervals
+func (eChart *exchangeChart) addCandleSamples(rates []store.CanonicalRate, now time.Time) {
+	for _, rate := range rates {
+		if rate.Price <= 0 {
+			continue
+		}
+		for _, interval := range candleIntervals {
+			err := eChart.db.AddCandleSample(rate.Pair, interval.name, interval.start(now), rate.Price)
+			if err != nil {
+				// other pairs and intervals are sampled anyway, the candle is backfilled later
+				eChart.log.Errorf("addCandleSamples: db.AddCandleSample %s %s: %s", rate.Pair, interval.name, err.Error())
+			}
+		}
+	}
+}
+
+// cleanRates removes snapshots and candles out of the retention
+func (eChart *exchangeChart) cleanRates() {
+	ticker := time.NewTicker(candlesCleanTick)
+	for now := range ticker.C {
+		err := eChart.db.RemoveExchangeRates([]string{exchangeDdax, exchangePoloniex, store.StockExchangeCanonical}, now.Add(-snapshotsRetention).Unix())
+		if err != nil {
+			eChart.log.Errorf("cleanRates: db.RemoveExchangeRates: %s", err.Error())
+		}
+		for _, interval := range candleIntervals {
+			if interval.retention == 0 {
+				continue
+			}
+			err := eChart.db.RemoveCandles(interval.name, interval.start(now.Add(-interval.retention)))
+			if err != nil {
+				eChart.log.Errorf("cleanRates: db.RemoveCandles: %s", err.Error())
+			}
+		}
+	}
+}
+
+// fillCandles backfills gaps of completed candles now and then, when the instance was down for example
+func (eChart *exchangeChart) fillCandles() {
+	if eChart.candleSource == nil {
+		return
+	}
+	for {
+		if !eChart.lease.held(time.Now()) {
+			time.Sleep(candlesFillTick)
+			continue
+		}
+		for _, pair := range eChart.aggregator.pairs {
+			for _, interval := range candleIntervals {
+				err := eChart.backfill(pair, interval, time.Now())
+				if err != nil {
+					eChart.log.Errorf("fillCandles: %s %s: %s", pair, interval.name, err.Error())
+				}
+			}
+		}
+		time.Sleep(candlesFillTick)
+	}
+}
+
+// backfill fills gaps of the pair candles in the backfill window up to the last completed candle
+func (eChart *exchangeChart) backfill(pair string, interval candleInterval, now time.Time) error {
+	from := interval.start(now.Add(-interval.backfill))
+	to := interval.start(now) - int64(interval.period.Seconds())
+	candles, err := eChart.db.FindCandles(pair, interval.name, from, to)
+	if err != nil {
+		return fmt.Errorf("backfill: db.FindCandles: %s", err.Error())
+	}
+
+	for _, gap := range candleGaps(candles, interval, from, to) {
+		filled, err := eChart.candleSource.Candles(pair, interval, gap[0], gap[1])
+		if err != nil {
+			return fmt.Errorf("backfill: candleSource.Candles: %s", err.Error())
+		}
+		err = eChart.db.InsertCandles(filled)
+		if err != nil {
+			return fmt.Errorf("backfill: db.InsertCandles: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// candleGaps returns [from, to] ranges without candles, candles are sorted by time
+func candleGaps(candles []store.Candle, interval candleInterval, from, to int64) [][2]int64 {
+	step := int64(interval.period.Seconds())
+	gaps := [][2]int64{}
+	next := from
+	for _, candle := range append(candles, store.Candle{Time: to + step}) {
+		if candle.Time > next {
+			gaps = append(gaps, [2]int64{next, candle.Time - step})
+		}
+		if candle.Time >= next {
+			next = candle.Time + step
+		}
+	}
+	return gaps
+}
+
+// getExchangeDay returns hour candles of BTC-USD for the last day
+func (eChart *exchangeChart) getExchangeDay() []store.Candle {
+	interval, _ := findCandleInterval(Candle1h)
+	now := time.Now()
+	candles, err := eChart.db.FindCandles("BTC-USD", interval.name, interval.start(now.Add(-time.Hour*24)), now.Unix())
+	if err != nil {
+		eChart.log.Errorf("getExchangeDay: db.FindCandles: %s", err.Error())
+	}
+	return candles
+}
+
+// getRatesHistory returns candles of the pair, ?pair=BTC-USD&interval=1h&from=<unix>&to=<unix>
+func (restClient *RestClient) getRatesHistory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if restClient.chart == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"code":    http.StatusServiceUnavailable,
+				"message": msgErrRatesUnavailable,
+			})
+			return
+		}
+		pair := c.Query("pair")
+		if !isRatePair(restClient.chart.aggregator.pairs, pair) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrHistoryPair,
+			})
+			return
+		}
+		interval, ok := findCandleInterval(c.DefaultQuery("interval", Candle1h))
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrHistoryInterval,
+			})
+			return
+		}
+
+		step := int64(interval.period.Seconds())
+		to, errTo := strconv.ParseInt(c.DefaultQuery("to", strconv.FormatInt(time.Now().Unix(), 10)), 10, 64)
+		from, errFrom := strconv.ParseInt(c.DefaultQuery("from", strconv.FormatInt(to-step*(defaultCandles-1), 10)), 10, 64)
+		if errTo != nil || errFrom != nil || from > to || (to-from)/step >= maxCandles {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrHistoryRange,
+			})
+			return
+		}
+
+		candles, err := restClient.userStore.FindCandles(pair, interval.name, from-from%step, to)
+		if err != nil {
+			restClient.log.Errorf("getRatesHistory: restClient.userStore.FindCandles: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": msgErrHistory,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":     http.StatusOK,
+			"message":  http.StatusText(http.StatusOK),
+			"pair":     pair,
+			"interval": interval.name,
+			"candles":  candles,
+		})
+	}
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/jekabolt/slf"
+)
+
+// candlesStore keeps candles in memory
+type candlesStore struct {
+	store.UserStore
+	m       sync.Mutex
+	candles map[string]store.Candle
+	// failPair samples aren't added
+	failPair string
+}
+
+func candleKey(pair, interval string, time int64) string {
+	return fmt.Sprintf("%s/%s/%d", pair, interval, time)
+}
+
+func (cs *candlesStore) AddCandleSample(pair, interval string, time int64, price float64) error {
+	cs.m.Lock()
+	defer cs.m.Unlock()
+	if pair == cs.failPair {
+		return errors.New("db is down")
+	}
+	key := candleKey(pair, interval, time)
+	candle, ok := cs.candles[key]
+	if !ok {
+		candle = store.Candle{Pair: pair, Interval: interval, Time: time, Open: price, High: price, Low: price}
+	}
+	if price > candle.High {
+		candle.High = price
+	}
+	if price < candle.Low {
+		candle.Low = price
+	}
+	candle.Close = price
+	candle.Samples++
+	cs.candles[key] = candle
+	return nil
+}
+
+func (cs *candlesStore) InsertCandles(candles []store.Candle) error {
+	cs.m.Lock()
+	defer cs.m.Unlock()
+	for _, candle := range candles {
+		key := candleKey(candle.Pair, candle.Interval, candle.Time)
+		if _, ok := cs.candles[key]; !ok {
+			cs.candles[key] = candle
+		}
+	}
+	return nil
+}
+
+func (cs *candlesStore) FindCandles(pair, interval string, from, to int64) ([]store.Candle, error) {
+	cs.m.Lock()
+	defer cs.m.Unlock()
+	candles := []store.Candle{}
+	for _, candle := range cs.candles {
+		if candle.Pair == pair && candle.Interval == interval && candle.Time >= from && candle.Time <= to {
+			candles = append(candles, candle)
+		}
+	}
+	sort.Slice(candles, func(i, j int) bool { return candles[i].Time < candles[j].Time })
+	return candles, nil
+}
+
+// candleSourceFake returns a candle of every step and records requested ranges
+type candleSourceFake struct {
+	ranges [][2]int64
+}
+
+func (source *candleSourceFake) Candles(pair string, interval candleInterval, from, to int64) ([]store.Candle, error) {
+	source.ranges = append(source.ranges, [2]int64{from, to})
+	candles := []store.Candle{}
+	for t := from; t <= to; t += int64(interval.period.Seconds()) {
+		candles = append(candles, store.Candle{Pair: pair, Interval: interval.name, Time: t, Open: 1, High: 1, Low: 1, Close: 1})
+	}
+	return candles, nil
+}
+
+func TestCandleGaps(t *testing.T) {
+	interval, _ := findCandleInterval(Candle1m)
+	candles := []store.Candle{{Time: 120}, {Time: 180}, {Time: 360}}
+	if got := fmt.Sprint(candleGaps(candles, interval, 0, 480)); got != "[[0 60] [240 300] [420 480]]" {
+		t.Errorf("wrong gaps %s", got)
+	}
+	if got := fmt.Sprint(candleGaps(candles, interval, 120, 360)); got != "[[240 300]]" {
+		t.Errorf("wrong gaps %s", got)
+	}
+	if got := fmt.Sprint(candleGaps(nil, interval, 0, 120)); got != "[[0 120]]" {
+		t.Errorf("wrong gaps of empty history %s", got)
+	}
+}
+
+func TestCandleSamplesAndBackfill(t *testing.T) {
+	db := &candlesStore{candles: map[string]store.Candle{}}
+	source := &candleSourceFake{}
+	chart := &exchangeChart{db: db, candleSource: source, log: slf.WithContext("test")}
+
+	now := time.Unix(1550000000, 0).Truncate(time.Hour)
+	for i, price := range []float64{3600, 3650, 3550, 3610} {
+		chart.addCandleSamples([]store.CanonicalRate{{Pair: "BTC-USD", Price: price}, {Pair: "ETH-USD"}}, now.Add(-time.Hour+time.Duration(i)*time.Second*10))
+	}
+	hour, _ := findCandleInterval(Candle1h)
+	candles, _ := db.FindCandles("BTC-USD", Candle1h, 0, now.Unix())
+	if len(candles) != 1 {
+		t.Fatalf("%d hour candles", len(candles))
+	}
+	if candle := candles[0]; candle.Open != 3600 || candle.High != 3650 || candle.Low != 3550 || candle.Close != 3610 || candle.Samples != 4 {
+		t.Errorf("wrong candle %+v", candle)
+	}
+	if candles, _ := db.FindCandles("ETH-USD", Candle1h, 0, now.Unix()); len(candles) != 0 {
+		t.Errorf("candle of no rate %+v", candles)
+	}
+
+	// failed pair doesn't stop others
+	db.failPair = "BTC-USD"
+	chart.addCandleSamples([]store.CanonicalRate{{Pair: "BTC-USD", Price: 3700}, {Pair: "ETH-USD", Price: 120}}, now.Add(-time.Minute))
+	db.failPair = ""
+	if candles, _ := db.FindCandles("ETH-USD", Candle1h, 0, now.Unix()); len(candles) != 1 {
+		t.Errorf("pair after failed one isn't sampled %+v", candles)
+	}
+
+	// the sampled hour is kept and the current one is not completed yet
+	err := chart.backfill("BTC-USD", hour, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := now.Add(-hour.backfill).Unix()
+	want := fmt.Sprint([][2]int64{{from, now.Add(-2 * time.Hour).Unix()}})
+	if got := fmt.Sprint(source.ranges); got != want {
+		t.Errorf("backfilled %s, want %s", got, want)
+	}
+	candles, _ = db.FindCandles("BTC-USD", Candle1h, from, now.Unix())
+	if len(candles) != int(hour.backfill/time.Hour) || candles[len(candles)-1].Samples != 4 {
+		t.Errorf("wrong candles after backfill %d", len(candles))
+	}
+
+	// no gaps
+	source.ranges = nil
+	chart.backfill("BTC-USD", hour, now.Add(time.Minute))
+	if len(source.ranges) != 0 {
+		t.Errorf("backfilled again %v", source.ranges)
+	}
+}
+
+func TestCryptoCompareSource(t *testing.T) {
+	// two candles a page up to toTs
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		to, _ := strconv.ParseInt(r.URL.Query().Get("toTs"), 10, 64)
+		if r.URL.Path != "/histohour" || r.URL.Query().Get("fsym") != "ETH" || r.URL.Query().Get("tsym") != "BTC" {
+			fmt.Fprint(w, `{"Response":"Error","Message":"wrong request"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"Response":"Success","Data":[{"time":%d,"open":0,"close":0},{"time":%d,"open":0.03,"high":0.031,"low":0.029,"close":0.0305}]}`, to-3600, to)
+	}))
+	defer server.Close()
+
+	source := &CryptoCompareSource{addr: server.URL + "/", client: server.Client()}
+	hour, _ := findCandleInterval(Candle1h)
+	candles, err := source.Candles("ETH-BTC", hour, 3600, 3600*4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	times := []int64{}
+	for _, candle := range candles {
+		times = append(times, candle.Time)
+	}
+	if fmt.Sprint(times) != "[14400 7200]" || candles[0].Close != 0.0305 || candles[0].Pair != "ETH-BTC" {
+		t.Errorf("wrong candles %+v", candles)
+	}
+
+	day, _ := findCandleInterval(Candle1d)
+	if _, err := source.Candles("ETH-BTC", day, 0, 86400); err == nil {
+		t.Errorf("error response accepted")
+	}
+}
@@ -0,0 +1,679 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/gin-gonic/gin"
+	"github.com/jekabolt/slf"
+	nsq "github.com/nsqio/go-nsq"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Changelly transaction statuses, https://api-docs.changelly.com
+const (
+	changellyNew        = "new"
+	changellyWaiting    = "waiting"
+	changellyConfirming = "confirming"
+	changellyExchanging = "exchanging"
+	changellySending    = "sending"
+	changellyFinished   = "finished"
+	changellyFailed     = "failed"
+	changellyRefunded   = "refunded"
+	changellyOverdue    = "overdue"
+	changellyHold       = "hold"
+	changellyExpired    = "expired"
+)
+
+const (
+	changellyAPIAddr               = "https://api.changelly.com"
+	changellyTimeout               = 30 * time.Second
+	defaultChangellyStatusInterval = 30
+	// changellyTrackLimit is how long exchanges are tracked, the payout hash could never show up
+	changellyTrackLimit = 7 * 24 * time.Hour
+
+	msgErrExchangeUnavailable = "exchange is not available"
+	msgErrExchangeCurrency    = "wrong exchange currency"
+	msgErrExchangeAmount      = "wrong exchange amount"
+	msgErrExchangeMinAmount   = "exchange amount is less than minimal"
+	msgErrExchangeWallet      = "no such wallet to exchange to"
+	msgErrExchange            = "exchange error"
+)
+
+// changellyFinalStatuses are statuses exchanges don't leave
+var changellyFinalStatuses = map[string]bool{
+	changellyFinished: true,
+	changellyFailed:   true,
+	changellyRefunded: true,
+	changellyExpired:  true,
+}
+
+// changellyWallet is the Changelly code of the wallet currency and the network it's traded on
+type changellyWallet struct {
+	code      string
+	networkID int
+}
+
+// changellyWallets are currencies of wallets exchanges pay out to
+var changellyWallets = map[int]changellyWallet{
+	currencies.Bitcoin: {"btc", currencies.Main},
+	currencies.Ether:   {"eth", currencies.ETHMain},
+}
+
+// ChangellyConf is the Changelly API account, exchanges are off without the key
+type ChangellyConf struct {
+	Address   string
+	APIKey    string
+	APISecret string
+	// StatusInterval is seconds between status checks of active exchanges
+	StatusInterval int
+}
+
+// Changelly calls Changelly JSON-RPC API and tracks statuses of users exchanges
+type Changelly struct {
+	addr       string
+	apiKey     string
+	apiSecret  string
+	httpClient *http.Client
+	interval   time.Duration
+
+	db          store.UserStore
+	nsqProducer *nsq.Producer
+
+	log slf.StructuredLogger
+}
+
+type ChangellyReqest struct {
+	JsonRpc string      `json:"jsonrpc"`
+	ID      int         `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type ChangellyResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// ChangellyTransaction is the exchange on Changelly side
+type ChangellyTransaction struct {
+	ID               string `json:"id"`
+	Status           string `json:"status"`
+	PayinAddress     string `json:"payinAddress"`
+	PayinExtraID     string `json:"payinExtraId"`
+	PayoutAddress    string `json:"payoutAddress"`
+	PayoutHash       string `json:"payoutHash"`
+	AmountExpectedTo string `json:"amountExpectedTo"`
+	AmountTo         string `json:"amountTo"`
+}
+
+func InitChangelly(conf ChangellyConf, userStore store.UserStore, nsqAddr string) (*Changelly, error) {
+	log := slf.WithContext("changelly")
+	if conf.APIKey == "" || conf.APISecret == "" {
+		log.Info("Changelly API key is not set, exchanges are off")
+		return nil, nil
+	}
+	producer, err := nsq.NewProducer(nsqAddr, nsq.NewConfig())
+	if err != nil {
+		return nil, fmt.Errorf("nsq.NewProducer: %s", err.Error())
+	}
+	changelly := newChangelly(conf, userStore)
+	changelly.nsqProducer = producer
+	go changelly.track()
+	return changelly, nil
+}
+
+func newChangelly(conf ChangellyConf, userStore store.UserStore) *Changelly {
+	if conf.Address == "" {
+		conf.Address = changellyAPIAddr
+	}
+	if conf.StatusInterval <= 0 {
+		conf.StatusInterval = defaultChangellyStatusInterval
+	}
+	return &Changelly{
+		addr:       conf.Address,
+		apiKey:     conf.APIKey,
+		apiSecret:  conf.APISecret,
+		httpClient: &http.Client{Timeout: changellyTimeout},
+		interval:   time.Duration(conf.StatusInterval) * time.Second,
+		db:         userStore,
+		log:        slf.WithContext("changelly"),
+	}
+}
+
+// changellySign is hex HMAC-SHA512 of the request body with the API secret
+func changellySign(body []byte, secret string) string {
+	h := hmac.New(sha512.New, []byte(secret))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// call makes the JSON-RPC call and decodes it's result
+func (ch *Changelly) call(method string, params interface{}, result interface{}) error {
+	body, err := json.Marshal(ChangellyReqest{
+		JsonRpc: "2.0",
+		ID:      1,
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return fmt.Errorf("%s: json.Marshal: %s", method, err.Error())
+	}
+
+	req, err := http.NewRequest("POST", ch.addr, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%s: http.NewRequest: %s", method, err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("api-key", ch.apiKey)
+	req.Header.Set("sign", changellySign(body, ch.apiSecret))
+
+	resp, err := ch.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: httpClient.Do: %s", method, err.Error())
+	}
+	defer resp.Body.Close()
+
+	rpcResp := ChangellyResponse{}
+	err = json.NewDecoder(resp.Body).Decode(&rpcResp)
+	if err != nil {
+		return fmt.Errorf("%s: status %d: %s", method, resp.StatusCode, err.Error())
+	}
+	if rpcResp.Error != nil {
+		return fmt.Errorf("%s: %d %s", method, rpcResp.Error.Code, rpcResp.Error.Message)
+	}
+	err = json.Unmarshal(rpcResp.Result, result)
+	if err != nil {
+		return fmt.Errorf("%s: json.Unmarshal: %s", method, err.Error())
+	}
+	return nil
+}
+
+func (ch *Changelly) Currencies() ([]string, error) {
+	list := []string{}
+	err := ch.call("getCurrencies", map[string]string{}, &list)
+	return list, err
+}
+
+// MinAmount returns the least amount of from currency exchanged to the other one
+func (ch *Changelly) MinAmount(from, to string) (string, error) {
+	var amount string
+	err := ch.call("getMinAmount", map[string]string{"from": from, "to": to}, &amount)
+	return amount, err
+}
+
+// ExchangeAmount estimates amount of to currency the amount of from one is exchanged to
+func (ch *Changelly) ExchangeAmount(from, to, amount string) (string, error) {
+	var estimated string
+	err := ch.call("getExchangeAmount", map[string]string{"from": from, "to": to, "amount": amount}, &estimated)
+	return estimated, err
+}
+
+// CreateTransaction creates the exchange paid out to the address
+func (ch *Changelly) CreateTransaction(from, to, address, amount, refundAddress string) (ChangellyTransaction, error) {
+	params := map[string]string{
+		"from":    from,
+		"to":      to,
+		"address": address,
+		"amount":  amount,
+	}
+	if refundAddress != "" {
+		params["refundAddress"] = refundAddress
+	}
+	tx := ChangellyTransaction{}
+	err := ch.call("createTransaction", params, &tx)
+	if err == nil && tx.ID == "" {
+		err = errors.New("createTransaction: empty transaction id")
+	}
+	return tx, err
+}
+
+func (ch *Changelly) Status(exchangeID string) (string, error) {
+	var status string
+	err := ch.call("getStatus", map[string]string{"id": exchangeID}, &status)
+	return status, err
+}
+
+// Transaction returns the exchange with the payout hash once it's sent
+func (ch *Changelly) Transaction(exchangeID string) (ChangellyTransaction, error) {
+	txs := []ChangellyTransaction{}
+	err := ch.call("getTransactions", map[string]interface{}{"id": exchangeID, "limit": 1}, &txs)
+	if err != nil {
+		return ChangellyTransaction{}, err
+	}
+	for _, tx := range txs {
+		if tx.ID == exchangeID {
+			return tx, nil
+		}
+	}
+	return ChangellyTransaction{}, errors.New("getTransactions: no transaction " + exchangeID)
+}
+
+// track checks statuses of active exchanges and notifies users of changes
+func (ch *Changelly) track() {
+	ticker := time.NewTicker(ch.interval)
+	for now := range ticker.C {
+		exchanges, err := ch.db.FindActiveExchanges()
+		if err != nil {
+			ch.log.Errorf("track: db.FindActiveExchanges: %s", err.Error())
+			continue
+		}
+		for _, exchange := range exchanges {
+			updated, changed, err := ch.checkExchange(exchange, now)
+			if err != nil {
+				ch.log.Errorf("track: checkExchange %s: %s", exchange.ExchangeID, err.Error())
+			}
+			if changed {
+				ch.notify(updated)
+			}
+		}
+	}
+}
+
+// checkExchange saves the new status of the exchange and the payout hash of the finished one,
+// changed tells the user should know of it. The exchange is saved only as it was read,
+// so when trackers of several instances see the change only the one saving it notifies.
+func (ch *Changelly) checkExchange(exchange store.Exchange, now time.Time) (store.Exchange, bool, error) {
+	sel := bson.M{"exchangeid": exchange.ExchangeID, "status": exchange.Status, "txhash": exchange.TxHash, "done": false}
+	set := bson.M{}
+	status, err := ch.Status(exchange.ExchangeID)
+	if err != nil {
+		return exchange, false, err
+	}
+	if status != exchange.Status {
+		exchange.Status = status
+		set["status"] = status
+	}
+
+	if status == changellyFinished && exchange.TxHash == "" {
+		tx, err := ch.Transaction(exchange.ExchangeID)
+		if err != nil {
+			ch.log.Errorf("checkExchange: Transaction: %s", err.Error())
+		}
+		if tx.PayoutHash != "" {
+			exchange.TxHash = tx.PayoutHash
+			set["txhash"] = tx.PayoutHash
+		}
+		if tx.AmountTo != "" && tx.AmountTo != "0" {
+			exchange.AmountExpected = tx.AmountTo
+			set["amountexpected"] = tx.AmountTo
+		}
+	}
+	changed := len(set) > 0
+
+	done := changellyFinalStatuses[status] && (status != changellyFinished || exchange.TxHash != "")
+	if done || now.Sub(time.Unix(exchange.DateOfCreation, 0)) > changellyTrackLimit {
+		exchange.Done = true
+		set["done"] = true
+	}
+	if len(set) == 0 {
+		return exchange, false, nil
+	}
+
+	exchange.LastUpdate = now.Unix()
+	set["lastupdate"] = exchange.LastUpdate
+	err = ch.db.UpdateExchange(sel, bson.M{"$set": set})
+	if err == mgo.ErrNotFound {
+		return exchange, false, nil
+	}
+	if err != nil {
+		// the change is notified when it's saved
+		return exchange, false, fmt.Errorf("db.UpdateExchange: %s", err.Error())
+	}
+	return exchange, changed, nil
+}
+
+// exchangeNotification is the notification of the exchange status to the payout wallet
+func exchangeNotification(exchange store.Exchange) store.TransactionWithUserID {
+	return store.TransactionWithUserID{
+		UserID: exchange.UserID,
+		NotificationMsg: &store.WsTxNotify{
+			CurrencyID:      exchange.CurrencyID,
+			NetworkID:       exchange.NetworkID,
+			Address:         exchange.PayoutAddress,
+			Amount:          coinUnits(exchange.AmountExpected, exchange.CurrencyID),
+			TxID:            exchange.TxHash,
+			TransactionType: store.ExchangeStatusChanged,
+			WalletIndex:     exchange.WalletIndex,
+			ExchangeID:      exchange.ExchangeID,
+			ExchangeStatus:  exchange.Status,
+		},
+	}
+}
+
+func (ch *Changelly) notify(exchange store.Exchange) {
+	body, err := json.Marshal(exchangeNotification(exchange))
+	if err != nil {
+		ch.log.Errorf("notify: json.Marshal: %s", err.Error())
+		return
+	}
+	err = ch.nsqProducer.Publish(store.TopicTransaction, body)
+	if err != nil {
+		ch.log.Errorf("notify: nsqProducer.Publish: %s", err.Error())
+	}
+}
+
+// decimalAmount parses positive decimal amount like "0.015"
+func decimalAmount(amount string) (*big.Rat, bool) {
+	value, ok := new(big.Rat).SetString(amount)
+	if !ok || value.Sign() <= 0 || strings.ContainsAny(amount, "/eE") {
+		return nil, false
+	}
+	return value, true
+}
+
+// coinUnits converts decimal amount in coins to the smallest units of the currency, the rest is dropped
+func coinUnits(amount string, currencyID int) string {
+	value, ok := new(big.Rat).SetString(amount)
+	divider, okDivider := currencies.Dividers[currencyID]
+	if !ok || !okDivider {
+		return ""
+	}
+	value.Mul(value, new(big.Rat).SetInt64(divider))
+	return new(big.Int).Quo(value.Num(), value.Denom()).String()
+}
+
+// payoutAddress returns the address the wallet receives exchanges to, the last receiving one
+func payoutAddress(wallet store.Wallet) string {
+	address := ""
+	index := -1
+	for _, a := range wallet.Adresses {
+		if !a.IsChange && a.AddressIndex > index {
+			address, index = a.Address, a.AddressIndex
+		}
+	}
+	return address
+}
+
+// exchangePayouts returns ids of the user exchanges to the wallet by their payout transactions
+func (restClient *RestClient) exchangePayouts(userID string, currencyID, networkID, walletIndex int) map[string]string {
+	payouts := map[string]string{}
+	exchanges, err := restClient.userStore.FindExchanges(userID)
+	if err != nil {
+		restClient.log.Errorf("exchangePayouts: restClient.userStore.FindExchanges: %s", err.Error())
+		return payouts
+	}
+	for _, exchange := range exchanges {
+		if exchange.TxHash != "" && exchange.CurrencyID == currencyID && exchange.NetworkID == networkID && exchange.WalletIndex == walletIndex {
+			payouts[strings.ToLower(exchange.TxHash)] = exchange.ExchangeID
+		}
+	}
+	return payouts
+}
+
+// changellyUnavailable responds when Changelly isn't configured
+func (restClient *RestClient) changellyUnavailable(c *gin.Context) bool {
+	if restClient.changelly != nil {
+		return false
+	}
+	c.JSON(http.StatusServiceUnavailable, gin.H{
+		"code":    http.StatusServiceUnavailable,
+		"message": msgErrExchangeUnavailable,
+	})
+	return true
+}
+
+func (restClient *RestClient) changellyListCurrencies() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if restClient.changellyUnavailable(c) {
+			return
+		}
+		list, err := restClient.changelly.Currencies()
+		if err != nil {
+			restClient.log.Errorf("changellyListCurrencies: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusBadGateway, gin.H{
+				"code":    http.StatusBadGateway,
+				"message": msgErrExchange,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":       http.StatusOK,
+			"message":    http.StatusText(http.StatusOK),
+			"currencies": list,
+		})
+	}
+}
+
+// changellyMinAmount returns the least amount of the exchange, ?from=ltc&to=btc
+func (restClient *RestClient) changellyMinAmount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if restClient.changellyUnavailable(c) {
+			return
+		}
+		from, to := strings.ToLower(c.Query("from")), strings.ToLower(c.Query("to"))
+		if from == "" || to == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrExchangeCurrency,
+			})
+			return
+		}
+		amount, err := restClient.changelly.MinAmount(from, to)
+		if err != nil {
+			restClient.log.Errorf("changellyMinAmount: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusBadGateway, gin.H{
+				"code":    http.StatusBadGateway,
+				"message": msgErrExchange,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":      http.StatusOK,
+			"message":   http.StatusText(http.StatusOK),
+			"minamount": amount,
+		})
+	}
+}
+
+// changellyEstimate returns the amount the exchange gives, ?from=ltc&to=btc&amount=1.5
+func (restClient *RestClient) changellyEstimate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if restClient.changellyUnavailable(c) {
+			return
+		}
+		from, to := strings.ToLower(c.Query("from")), strings.ToLower(c.Query("to"))
+		if from == "" || to == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrExchangeCurrency,
+			})
+			return
+		}
+		if _, ok := decimalAmount(c.Query("amount")); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrExchangeAmount,
+			})
+			return
+		}
+		amount, err := restClient.changelly.ExchangeAmount(from, to, c.Query("amount"))
+		if err != nil {
+			restClient.log.Errorf("changellyEstimate: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusBadGateway, gin.H{
+				"code":    http.StatusBadGateway,
+				"message": msgErrExchange,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": http.StatusText(http.StatusOK),
+			"amount":  amount,
+		})
+	}
+}
+
+type ExchangeParams struct {
+	// From is Changelly code of the currency user sends
+	From        string `json:"from"`
+	CurrencyID  int    `json:"currencyid"`
+	NetworkID   int    `json:"networkid"`
+	WalletIndex int    `json:"walletindex"`
+	// Amount is decimal amount of From currency
+	Amount        string `json:"amount"`
+	RefundAddress string `json:"refundaddress"`
+}
+
+// createExchange creates Changelly exchange paid out to the user wallet, the user sends the amount
+// to the payin address of it
+func (restClient *RestClient) createExchange() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if restClient.changellyUnavailable(c) {
+			return
+		}
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+
+		var ep ExchangeParams
+		err = decodeBody(c, &ep)
+		if err != nil {
+			restClient.log.Errorf("createExchange: decodeBody: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrRequestBodyError,
+			})
+			return
+		}
+		to, ok := changellyWallets[ep.CurrencyID]
+		from := strings.ToLower(ep.From)
+		if !ok || to.networkID != ep.NetworkID || from == "" || from == to.code {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrExchangeCurrency,
+			})
+			return
+		}
+		amount, ok := decimalAmount(ep.Amount)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrExchangeAmount,
+			})
+			return
+		}
+
+		address := ""
+		for _, wallet := range fetchUndeletedWallets(user.Wallets) {
+			if wallet.CurrencyID == ep.CurrencyID && wallet.NetworkID == ep.NetworkID && wallet.WalletIndex == ep.WalletIndex {
+				address = payoutAddress(wallet)
+			}
+		}
+		if address == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrExchangeWallet,
+			})
+			return
+		}
+
+		minAmount, err := restClient.changelly.MinAmount(from, to.code)
+		if err != nil {
+			restClient.log.Errorf("createExchange: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusBadGateway, gin.H{
+				"code":    http.StatusBadGateway,
+				"message": msgErrExchange,
+			})
+			return
+		}
+		if min, ok := decimalAmount(minAmount); ok && amount.Cmp(min) < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":      http.StatusBadRequest,
+				"message":   msgErrExchangeMinAmount,
+				"minamount": minAmount,
+			})
+			return
+		}
+
+		tx, err := restClient.changelly.CreateTransaction(from, to.code, address, ep.Amount, ep.RefundAddress)
+		if err != nil {
+			restClient.log.Errorf("createExchange: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusBadGateway, gin.H{
+				"code":    http.StatusBadGateway,
+				"message": msgErrExchange,
+			})
+			return
+		}
+
+		now := time.Now().Unix()
+		exchange := store.Exchange{
+			ExchangeID:     tx.ID,
+			UserID:         user.UserID,
+			From:           from,
+			To:             to.code,
+			CurrencyID:     ep.CurrencyID,
+			NetworkID:      ep.NetworkID,
+			WalletIndex:    ep.WalletIndex,
+			Amount:         ep.Amount,
+			AmountExpected: tx.AmountExpectedTo,
+			PayinAddress:   tx.PayinAddress,
+			PayinExtraID:   tx.PayinExtraID,
+			PayoutAddress:  address,
+			RefundAddress:  ep.RefundAddress,
+			Status:         tx.Status,
+			DateOfCreation: now,
+			LastUpdate:     now,
+		}
+		if exchange.AmountExpected == "" {
+			exchange.AmountExpected = tx.AmountTo
+		}
+		if exchange.Status == "" {
+			exchange.Status = changellyNew
+		}
+		err = restClient.userStore.InsertExchange(exchange)
+		if err != nil {
+			restClient.log.Errorf("createExchange: restClient.userStore.InsertExchange: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": msgErrServerError,
+			})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"code":     http.StatusCreated,
+			"message":  http.StatusText(http.StatusCreated),
+			"exchange": exchange,
+		})
+	}
+}
+
+func (restClient *RestClient) getExchanges() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+		exchanges, err := restClient.userStore.FindExchanges(user.UserID)
+		if err != nil {
+			restClient.log.Errorf("getExchanges: restClient.userStore.FindExchanges: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":      http.StatusOK,
+			"message":   http.StatusText(http.StatusOK),
+			"exchanges": exchanges,
+		})
+	}
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// fakeChangelly is Changelly JSON-RPC server with a single exchange
+type fakeChangelly struct {
+	status     string
+	payoutHash string
+	calls      []string
+}
+
+func (fc *fakeChangelly) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	if r.Method != "POST" || r.Header.Get("api-key") != "key" || r.Header.Get("sign") != changellySign(body, "secret") {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"error":{"code":-32600,"message":"Unauthorized"}}`)
+		return
+	}
+	req := struct {
+		Method string            `json:"method"`
+		Params map[string]string `json:"params"`
+	}{}
+	json.Unmarshal(body, &req)
+	fc.calls = append(fc.calls, req.Method)
+
+	result := ""
+	switch req.Method {
+	case "getCurrencies":
+		result = `["btc","eth","ltc"]`
+	case "getMinAmount":
+		result = `"0.05"`
+	case "getExchangeAmount":
+		result = `"0.0123"`
+	case "createTransaction":
+		if req.Params["address"] == "" {
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"error":{"code":-32602,"message":"Invalid address"}}`)
+			return
+		}
+		result = fmt.Sprintf(`{"id":"ex1","status":"new","payinAddress":"Lpayin","payoutAddress":%q,"amountExpectedTo":"0.0123"}`, req.Params["address"])
+	case "getStatus":
+		result = fmt.Sprintf("%q", fc.status)
+	case "getTransactions":
+		result = fmt.Sprintf(`[{"id":"ex1","status":%q,"payoutHash":%q,"amountTo":"0.0121"}]`, fc.status, fc.payoutHash)
+	default:
+		fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"Method not found"}}`)
+		return
+	}
+	fmt.Fprintf(w, `{"jsonrpc":"2.0","id":1,"result":%s}`, result)
+}
+
+// exchangesStore records exchange updates, stale store has the exchange updated by another instance
+type exchangesStore struct {
+	store.UserStore
+	updates []bson.M
+	stale   bool
+}
+
+func (es *exchangesStore) UpdateExchange(sel, update bson.M) error {
+	if es.stale {
+		return mgo.ErrNotFound
+	}
+	es.updates = append(es.updates, update["$set"].(bson.M))
+	return nil
+}
+
+func TestChangellyCalls(t *testing.T) {
+	server := httptest.NewServer(&fakeChangelly{})
+	defer server.Close()
+	ch := newChangelly(ChangellyConf{Address: server.URL, APIKey: "key", APISecret: "secret"}, nil)
+
+	list, err := ch.Currencies()
+	if err != nil || fmt.Sprint(list) != "[btc eth ltc]" {
+		t.Errorf("wrong currencies %v %v", list, err)
+	}
+	if min, err := ch.MinAmount("ltc", "btc"); err != nil || min != "0.05" {
+		t.Errorf("wrong min amount %v %v", min, err)
+	}
+	if amount, err := ch.ExchangeAmount("ltc", "btc", "1"); err != nil || amount != "0.0123" {
+		t.Errorf("wrong estimate %v %v", amount, err)
+	}
+	tx, err := ch.CreateTransaction("ltc", "btc", "1payout", "1", "")
+	if err != nil || tx.ID != "ex1" || tx.PayinAddress != "Lpayin" || tx.AmountExpectedTo != "0.0123" {
+		t.Errorf("wrong transaction %+v %v", tx, err)
+	}
+	if _, err := ch.CreateTransaction("ltc", "btc", "", "1", ""); err == nil {
+		t.Errorf("rpc error accepted")
+	}
+
+	ch.apiSecret = "wrong"
+	if _, err := ch.Currencies(); err == nil {
+		t.Errorf("wrong sign accepted")
+	}
+}
+
+func TestChangellyCheckExchange(t *testing.T) {
+	fake := &fakeChangelly{status: changellyWaiting}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	db := &exchangesStore{}
+	ch := newChangelly(ChangellyConf{Address: server.URL, APIKey: "key", APISecret: "secret"}, db)
+
+	now := time.Unix(1550000000, 0)
+	exchange := store.Exchange{ExchangeID: "ex1", Status: changellyNew, DateOfCreation: now.Unix(), CurrencyID: currencies.Bitcoin, AmountExpected: "0.0123"}
+	exchange, changed, err := ch.checkExchange(exchange, now)
+	if err != nil || !changed || exchange.Status != changellyWaiting || exchange.Done {
+		t.Errorf("wrong waiting exchange %+v %v %v", exchange, changed, err)
+	}
+
+	// nothing is saved without changes
+	if _, changed, _ := ch.checkExchange(exchange, now); changed || len(db.updates) != 1 {
+		t.Errorf("unchanged exchange updated %v", db.updates)
+	}
+
+	// another instance saved the change first and notified of it
+	fake.status = changellyConfirming
+	db.stale = true
+	if _, changed, err := ch.checkExchange(exchange, now); changed || err != nil {
+		t.Errorf("change saved by another instance notified %v %v", changed, err)
+	}
+	db.stale = false
+
+	// finished, the payout isn't sent yet
+	fake.status = changellyFinished
+	exchange, changed, _ = ch.checkExchange(exchange, now)
+	if !changed || exchange.Done || exchange.TxHash != "" {
+		t.Errorf("wrong finished exchange %+v", exchange)
+	}
+
+	fake.payoutHash = "abc"
+	exchange, changed, _ = ch.checkExchange(exchange, now)
+	if !changed || !exchange.Done || exchange.TxHash != "abc" || exchange.AmountExpected != "0.0121" {
+		t.Errorf("wrong paid out exchange %+v", exchange)
+	}
+	if set := db.updates[len(db.updates)-1]; set["txhash"] != "abc" || set["done"] != true || set["status"] != nil {
+		t.Errorf("wrong update %v", set)
+	}
+
+	msg := exchangeNotification(exchange)
+	if msg.NotificationMsg.Amount != "1210000" || msg.NotificationMsg.TxID != "abc" || notifyEvent(msg.NotificationMsg.TransactionType) != store.NotifyExchange {
+		t.Errorf("wrong notification %+v", msg.NotificationMsg)
+	}
+
+	// stuck exchanges aren't tracked forever
+	fake.status = changellyHold
+	stuck, changed, _ := ch.checkExchange(store.Exchange{ExchangeID: "ex1", Status: changellyHold, DateOfCreation: now.Add(-changellyTrackLimit - time.Hour).Unix()}, now)
+	if changed || !stuck.Done {
+		t.Errorf("wrong stuck exchange %+v", stuck)
+	}
+}
+
+func TestExchangeAmounts(t *testing.T) {
+	for amount, want := range map[string]bool{"0.015": true, "1": true, "0": false, "-1": false, "1/2": false, "1e3": false, "": false} {
+		if _, ok := decimalAmount(amount); ok != want {
+			t.Errorf("decimal amount %q %v", amount, ok)
+		}
+	}
+	if units := coinUnits("1.000000000000000001", currencies.Ether); units != "1000000000000000001" {
+		t.Errorf("wrong wei %s", units)
+	}
+	if units := coinUnits("0.123456789", currencies.Bitcoin); units != "12345678" {
+		t.Errorf("wrong satoshis %s", units)
+	}
+
+	wallet := store.Wallet{Adresses: []store.Address{
+		{AddressIndex: 0, Address: "a0"},
+		{AddressIndex: 2, Address: "change", IsChange: true},
+		{AddressIndex: 1, Address: "a1"},
+	}}
+	if address := payoutAddress(wallet); address != "a1" {
+		t.Errorf("wrong payout address %s", address)
+	}
+}
@@ -6,12 +6,7 @@ See LICENSE for details
 package client
 
 import (
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
 	"math/rand"
-	"net/http"
-	"sync"
 	"time"
 
 	"github.com/Multy-io/Multy-back/store"
@@ -23,71 +18,46 @@ var (
 	r1 = rand.New(s1)
 )
 
-const (
-	saveToDBInterval       = time.Second * 10
-	updateForExchangeChart = time.Hour
-)
-
-// StockRate stores rates from specific stock and protected with mutex
-type StockRate struct {
-	exchange *store.ExchangeRates
-	m        *sync.Mutex
-}
-
-// Rates stores rates from all supported stocks
-type Rates struct {
-	BTCtoUSDDay []store.RatesAPIBitstamp
-	mDay        *sync.Mutex
-
-	exchangeGdax     *StockRate
-	exchangePoloniex *StockRate
-}
+const saveToDBInterval = time.Second * 10
 
 type exchangeChart struct {
-	rates        *Rates
-	gdaxConn     *GdaxAPI
-	poloniexConn *PoloniexAPI
+	aggregator   *RateAggregator
+	providers    []RateProvider
+	candleSource CandleSource
+	// lease is held by the instance saving rates and candles
+	lease *clusterLease
 
 	db  store.UserStore
 	log slf.StructuredLogger
 }
 
-func newExchangeChart(db store.UserStore) (*exchangeChart, error) {
-	chart := &exchangeChart{
-		rates: &Rates{
-			exchangeGdax: &StockRate{
-				exchange: &store.ExchangeRates{},
-				m:        &sync.Mutex{},
-			},
-			exchangePoloniex: &StockRate{
-				exchange: &store.ExchangeRates{},
-				m:        &sync.Mutex{},
-			},
-
-			BTCtoUSDDay: make([]store.RatesAPIBitstamp, 0),
-			mDay:        &sync.Mutex{},
-		},
-		db:  db,
-		log: slf.WithContext("chart"),
+func newExchangeChart(db store.UserStore, conf RatesConf) (*exchangeChart, error) {
+	aggregator := NewRateAggregator(conf)
+	providers, err := NewRateProviders(conf, aggregator.pairs)
+	if err != nil {
+		return nil, err
 	}
-	chart.log.Debug("new exchange chart")
-
-	//moved to next release
-	//chart.getDayAPIBitstamp()
-
-	gDaxConn, err := chart.newGdaxAPI(chart.log)
+	candleSource, err := newCandleSource(conf.CandleSource)
 	if err != nil {
-		return nil, fmt.Errorf("initGdaxAPI: %s", err)
+		return nil, err
 	}
-	chart.gdaxConn = gDaxConn
-
-	poloniexConn, err := chart.newPoloniexAPI(chart.log)
+	holder, err := randomID()
 	if err != nil {
-		return nil, fmt.Errorf("initPoloniexAPI: %s", err)
+		return nil, err
 	}
-	chart.poloniexConn = poloniexConn
+	chart := &exchangeChart{
+		aggregator:   aggregator,
+		providers:    providers,
+		candleSource: candleSource,
+		lease:        &clusterLease{db: db, name: "rates", holder: holder, ttl: 3 * saveToDBInterval},
+		db:           db,
+		log:          slf.WithContext("chart"),
+	}
+	chart.log.Debug("new exchange chart")
 
 	go chart.run()
+	go chart.cleanRates()
+	go chart.fillCandles()
 
 	return chart, nil
 }
@@ -95,102 +65,58 @@ func newExchangeChart(db store.UserStore) (*exchangeChart, error) {
 func (eChart *exchangeChart) run() error {
 	tickerSaveToDB := time.NewTicker(saveToDBInterval)
 
-	go eChart.gdaxConn.listen()
-	//TODO: fix poloniex
-	go eChart.poloniexConn.listen()
-
-	eChart.gdaxConn.subscribe()
-	eChart.poloniexConn.subscribe()
+	go eChart.aggregator.Run(eChart.providers, make(chan struct{}))
 
 	for {
 		select {
-		case _ = <-tickerSaveToDB.C:
-			eChart.saveToDB()
+		case now := <-tickerSaveToDB.C:
+			eChart.saveToDB(now)
 		}
 	}
 }
 
-func (eChart *exchangeChart) saveToDB() {
-	eChart.rates.exchangeGdax.m.Lock()
-	//eChart.log.Debugf("gdax=%+v", eChart.rates.exchangeGdax.exchange)
-	eChart.db.InsertExchangeRate(*eChart.rates.exchangeGdax.exchange, exchangeDdax)
-	eChart.rates.exchangeGdax.m.Unlock()
-
-	eChart.rates.exchangePoloniex.m.Lock()
-	eChart.db.InsertExchangeRate(*eChart.rates.exchangePoloniex.exchange, exchangePoloniex)
-	eChart.rates.exchangePoloniex.m.Unlock()
-}
-
-func (eChart *exchangeChart) updateDayRates() {
-	eChart.rates.mDay.Lock()
-	_, err := eChart.db.GetExchangeRatesDay()
-	if err != nil {
-		eChart.log.Errorf("GetExchangeRatesDay: %s", err.Error())
+func (eChart *exchangeChart) saveToDB(now time.Time) {
+	if !eChart.lease.held(now) {
 		return
 	}
-	eChart.rates.mDay.Unlock()
-}
-
-func (eChart *exchangeChart) getDayAPIBitstamp() {
-	eChart.log.Debug("update rates day")
-
-	reqURI := "https://www.bitstamp.net/api/transactions/?date=hour"
-
-	eChart.log.Debugf("reqURI=%s", reqURI)
-	resp, err := http.Get(reqURI)
+	// legacy records of charts
+	err := eChart.db.InsertExchangeRate(eChart.getExchangeGdax(), exchangeDdax)
 	if err != nil {
-		eChart.log.Errorf("[%s], err=%s", reqURI, err.Error())
-		return
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		eChart.log.Errorf("get exchange: response status code=%d", resp.StatusCode)
-		return
+		eChart.log.Errorf("saveToDB: InsertExchangeRate: %s", err.Error())
 	}
-
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	err = eChart.db.InsertExchangeRate(eChart.getExchangePoloniex(), exchangePoloniex)
 	if err != nil {
-		eChart.log.Errorf("get exchange: get response body: %s", err.Error())
-		return
+		eChart.log.Errorf("saveToDB: InsertExchangeRate: %s", err.Error())
 	}
 
-	var ratesAll = make([]store.RatesAPIBitstamp, 0)
-	err = json.Unmarshal(bodyBytes, &ratesAll)
+	rates := eChart.aggregator.Rates()
+	err = eChart.db.InsertCanonicalRate(eChart.aggregator.ExchangeRates(), rates)
 	if err != nil {
-		eChart.log.Errorf("%s\n", err.Error())
-		return
+		eChart.log.Errorf("saveToDB: InsertCanonicalRate: %s", err.Error())
 	}
-
-	eChart.log.Debugf("rates 24h=[%v]", ratesAll)
-
-	eChart.rates.mDay.Lock()
-	eChart.rates.BTCtoUSDDay = ratesAll
-	eChart.rates.mDay.Unlock()
+	eChart.addCandleSamples(rates, now)
 }
 
-func (eChart *exchangeChart) getExchangeDay() []store.RatesAPIBitstamp {
-	eChart.log.Debug("exchange chart: get exchanges for 24 hours")
-
-	eChart.rates.mDay.Lock()
-	defer eChart.rates.mDay.Unlock()
-
-	/*for _, k := range eChart.rates.BTCtoUSDDay {
-		i, _ := strconv.Atoi(k.Date)
-		log.Println(time.Unix(int64(i), 0).Format(time.RFC3339), "=", k.Price)
-	}*/
-	return eChart.rates.BTCtoUSDDay
+// getExchangeGdax returns rates of Gdax for exchangeGdax event
+func (eChart *exchangeChart) getExchangeGdax() store.ExchangeRates {
+	return eChart.aggregator.SourceRates(RateProviderGdax)
 }
 
-func (eChart *exchangeChart) getExchangeGdax() *store.ExchangeRates {
-	eChart.rates.exchangeGdax.m.Lock()
-	defer eChart.rates.exchangeGdax.m.Unlock()
-
-	return eChart.rates.exchangeGdax.exchange
+// getExchangePoloniex returns rates of HitBTC for exchangePoloniex event, it has always been HitBTC
+func (eChart *exchangeChart) getExchangePoloniex() store.ExchangeRates {
+	return eChart.aggregator.SourceRates(RateProviderHitBTC)
 }
 
-func (eChart *exchangeChart) getExchangePoloniex() *store.ExchangeRates {
-	eChart.rates.exchangePoloniex.m.Lock()
-	defer eChart.rates.exchangePoloniex.m.Unlock()
+// fiatPrice returns the canonical price of the currency in the fiat
+func (eChart *exchangeChart) fiatPrice(currencyID int, fiat string) float64 {
+	coin, ok := rateCoins[currencyID]
+	if !ok {
+		return 0
+	}
+	return eChart.pairRate(store.RatePair(coin, fiat))
+}
 
-	return eChart.rates.exchangePoloniex.exchange
+// pairRate returns the canonical rate of the pair like "BTC-USD"
+func (eChart *exchangeChart) pairRate(pair string) float64 {
+	return eChart.aggregator.Rate(pair).Price
 }
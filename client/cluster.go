@@ -0,0 +1,170 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/Multy-io/Multy-back/store"
+	nsq "github.com/bitly/go-nsq"
+)
+
+// Background loops run on every instance of the cluster:
+//   - socket.io token checks, rates broadcast, wireless sweep and user exchange updates serve
+//     connections of the instance only;
+//   - user events, rates snapshots and candles cleanups remove by time, repeating them is harmless;
+//   - rates saving and candles backfill write shared records, they run on the holder of clusterLease "rates";
+//   - price alerts fire by a conditional update of the alert, the instance which updates it notifies;
+//   - webhook retries lease a delivery with findAndModify before sending it;
+//   - Changelly tracking saves a status change over the status read, the instance which saves it notifies;
+//   - NSQ consumers of push notifications and webhooks share a channel, so each message is handled once.
+
+// clusterLease is held by a single instance at a time, it's renewed by the holder on every check.
+// Nil lease is always held, as by the only instance.
+type clusterLease struct {
+	db     store.UserStore
+	name   string
+	holder string
+	ttl    time.Duration
+}
+
+func (lease *clusterLease) held(now time.Time) bool {
+	if lease == nil {
+		return true
+	}
+	ok, err := lease.db.AcquireLease(lease.name, lease.holder, now, now.Add(lease.ttl))
+	return err == nil && ok
+}
+
+// ClusterEvent is relayed between socket.io instances through NSQ.
+// It carries the advertised receiver, the stored user event or the event for connections of the user.
+type ClusterEvent struct {
+	Instance string `json:"instance"`
+
+	Receiver  *store.Receiver  `json:"receiver,omitempty"`
+	UserEvent *store.UserEvent `json:"userevent,omitempty"`
+
+	UserID  string          `json:"userid,omitempty"`
+	Channel string          `json:"channel,omitempty"`
+	Event   string          `json:"event,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// instanceChannel is the NSQ channel of the instance. Every instance gets every message
+// of the topic on its own channel, which is dropped when the instance is gone.
+func instanceChannel(name, instance string) string {
+	return name + "-" + instance + "#ephemeral"
+}
+
+// publishCluster sends the event to other instances
+func (sConnPool *SocketIOConnectedPool) publishCluster(event ClusterEvent) {
+	event.Instance = sConnPool.instance
+	msg, err := json.Marshal(event)
+	if err != nil {
+		sConnPool.log.Errorf("publishCluster: json.Marshal: %s", err.Error())
+		return
+	}
+	err = sConnPool.nsqProducer.Publish(store.TopicSocketIO, msg)
+	if err != nil {
+		sConnPool.log.Errorf("publishCluster: nsq publish: %s", err.Error())
+	}
+}
+
+// emitCluster emits to the user connections subscribed to the channel on all instances except the given connection
+func (sConnPool *SocketIOConnectedPool) emitCluster(userID, channel, event string, data interface{}, exceptConnID string) {
+	sConnPool.emitChannel(userID, channel, event, data, exceptConnID)
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		sConnPool.log.Errorf("emitCluster: json.Marshal: %s", err.Error())
+		return
+	}
+	sConnPool.publishCluster(ClusterEvent{UserID: userID, Channel: channel, Event: event, Data: raw})
+}
+
+// clusterUserEvent relays the stored user event, user ID of the event isn't marshaled so it goes with the envelope
+func clusterUserEvent(event store.UserEvent) ClusterEvent {
+	return ClusterEvent{UserID: event.UserID, UserEvent: &event}
+}
+
+// handleCluster emits the event relayed by another instance
+func (sConnPool *SocketIOConnectedPool) handleCluster(body []byte) error {
+	event := ClusterEvent{}
+	if err := json.Unmarshal(body, &event); err != nil {
+		sConnPool.log.Errorf("topic socketio event: %s", err.Error())
+		return err
+	}
+	if event.Instance == sConnPool.instance {
+		return nil
+	}
+	switch {
+	case event.Receiver != nil:
+		sConnPool.wireless.Announce(*event.Receiver)
+	case event.UserEvent != nil:
+		userEvent := *event.UserEvent
+		userEvent.UserID = event.UserID
+		sConnPool.sendUserEvent(userEvent)
+	case event.Event != "":
+		sConnPool.emitChannel(event.UserID, event.Channel, event.Event, event.Data, "")
+	}
+	return nil
+}
+
+func (sConnPool *SocketIOConnectedPool) newConsumerCluster(nsqAddr string) (*nsq.Consumer, error) {
+	consumer, err := nsq.NewConsumer(store.TopicSocketIO, instanceChannel("socketio", sConnPool.instance), nsq.NewConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	consumer.AddHandler(nsq.HandlerFunc(func(message *nsq.Message) error {
+		return sConnPool.handleCluster(message.Body)
+	}))
+
+	err = consumer.ConnectToNSQD(nsqAddr)
+	if err != nil {
+		sConnPool.log.Errorf("nsq socketio event: %s", err.Error())
+	}
+	return consumer, nil
+}
+
+// clusterRegistry keeps receiver advertisements in the database and announces them to other instances
+type clusterRegistry struct {
+	pool *SocketIOConnectedPool
+	db   store.UserStore
+}
+
+func (registry *clusterRegistry) advertise(receiver store.WirelessReceiver) error {
+	receiver.Instance = registry.pool.instance
+	err := registry.db.AdvertiseWirelessReceiver(receiver)
+	if err != nil {
+		return err
+	}
+	registry.pool.publishCluster(ClusterEvent{Receiver: &store.Receiver{
+		ID:         receiver.UserID,
+		UserCode:   receiver.UserCode,
+		CurrencyID: receiver.CurrencyID,
+		NetworkID:  receiver.NetworkID,
+		Address:    receiver.Address,
+		Amount:     receiver.Amount,
+	}})
+	return nil
+}
+
+func (registry *clusterRegistry) withdraw(userCodes []string) {
+	err := registry.db.RemoveWirelessReceivers(userCodes, registry.pool.instance)
+	if err != nil {
+		registry.pool.log.Errorf("withdraw: db.RemoveWirelessReceivers: %s", err.Error())
+	}
+}
+
+func (registry *clusterRegistry) find(userCodes []string) []store.WirelessReceiver {
+	receivers, err := registry.db.FindWirelessReceivers(userCodes)
+	if err != nil {
+		registry.pool.log.Errorf("find: db.FindWirelessReceivers: %s", err.Error())
+	}
+	return receivers
+}
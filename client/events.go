@@ -0,0 +1,251 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+	nsq "github.com/bitly/go-nsq"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// ReplayDone is emitted after missed events of the connection, data is ReplayResult
+	ReplayDone = "event:replay:done"
+	// lastEventHeader is the last event sequence id the client has seen, missed events are replayed on connection
+	lastEventHeader = "lastEventID"
+
+	userEventsRetention    = 7 * 24 * time.Hour
+	userEventsCleanTick    = time.Hour
+	userEventsPage         = 200
+	maxUserEventsPageLimit = 1000
+
+	msgErrDecodeUserEventsReq = "wrong after sequence id"
+	msgErrUserEvents          = "can't get events"
+)
+
+// ReplayResult closes the replay, Truncated tells some missed events are out of the retention window
+type ReplayResult struct {
+	Seq       int64 `json:"seq"`
+	Truncated bool  `json:"truncated"`
+}
+
+// connReplay holds live events of the connection until its replay is done
+type connReplay struct {
+	pending []store.UserEvent
+}
+
+// truncated checks events after the sequence id start later than it, so older ones are removed
+func truncated(afterSeq int64, events []store.UserEvent) bool {
+	return afterSeq > 0 && len(events) > 0 && events[0].Seq > afterSeq+1
+}
+
+// newConsumerUserEvents stores transaction notifications as user events and emits them to users on all instances.
+// The channel is shared by instances, so every notification is stored once.
+func (sConnPool *SocketIOConnectedPool) newConsumerUserEvents(nsqAddr string) (*nsq.Consumer, error) {
+	consumer, err := nsq.NewConsumer(store.TopicTransaction, "socketio", nsq.NewConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	consumer.AddHandler(nsq.HandlerFunc(func(message *nsq.Message) error {
+		msg := store.TransactionWithUserID{}
+		if err := json.Unmarshal(message.Body, &msg); err != nil {
+			sConnPool.log.Errorf("topic transaction update: %s", err.Error())
+			return err
+		}
+		event := sConnPool.recordUserEvent(msg)
+		sConnPool.sendUserEvent(event)
+		sConnPool.publishCluster(clusterUserEvent(event))
+		return nil
+	}))
+
+	err = consumer.ConnectToNSQD(nsqAddr)
+	if err != nil {
+		sConnPool.log.Errorf("nsq transaction update: %s", err.Error())
+	}
+	return consumer, nil
+}
+
+// recordUserEvent stores the notification with the next sequence id of the user.
+// The event is emitted even if the database fails, without the sequence id then.
+func (sConnPool *SocketIOConnectedPool) recordUserEvent(msg store.TransactionWithUserID) store.UserEvent {
+	event := store.UserEvent{
+		UserID:         msg.UserID,
+		Event:          store.TopicTransaction,
+		Channel:        notifyChannel(msg.NotificationMsg),
+		Data:           msg,
+		DateOfCreation: time.Now().Unix(),
+	}
+	if tx := msg.NotificationMsg; tx != nil && tx.CurrencyID == currencies.Bitcoin && tx.WalletIndex == store.MultisigWalletIndex {
+		user := store.User{}
+		err := sConnPool.db.FindUser(bson.M{"userID": msg.UserID}, &user)
+		if err != nil {
+			sConnPool.log.Errorf("recordUserEvent: db.FindUser: %s", err.Error())
+		} else if channel, ok := multisigChannel(user.Multisigs, tx.Address); ok {
+			event.Channel = channel
+		}
+	}
+	seq, err := sConnPool.db.NextUserEventSeq(msg.UserID)
+	if err != nil {
+		sConnPool.log.Errorf("recordUserEvent: db.NextUserEventSeq: %s", err.Error())
+		return event
+	}
+	event.Seq = seq
+	event.Data.Seq = seq
+	err = sConnPool.db.InsertUserEvent(event)
+	if err != nil {
+		sConnPool.log.Errorf("recordUserEvent: db.InsertUserEvent: %s", err.Error())
+	}
+	return event
+}
+
+// sendUserEvent emits the event to subscribed connections and streams of the user
+func (sConnPool *SocketIOConnectedPool) sendUserEvent(event store.UserEvent) {
+	if sConnPool.hub != nil {
+		sConnPool.hub.publishUser(event.UserID, userEvent(event))
+	}
+
+	sConnPool.m.RLock()
+	defer sConnPool.m.RUnlock()
+	user, ok := sConnPool.users[event.UserID]
+	if !ok {
+		return
+	}
+	conns := user.connList()
+	sConnPool.log.Debugf("user event socketio: userID=%s, conns=%d, channel=%s, seq=%d", event.UserID, len(conns), event.Channel, event.Seq)
+	for connID, conn := range conns {
+		user.emitUserEvent(connID, conn, event)
+	}
+}
+
+// emitUserEvent emits the event if the connection is subscribed to it, connections replaying missed events get it after the replay
+func (sIOUser *SocketIOUser) emitUserEvent(connID string, conn socketConn, event store.UserEvent) {
+	sIOUser.connM.Lock()
+	defer sIOUser.connM.Unlock()
+	if replay, ok := sIOUser.replays[connID]; ok {
+		replay.pending = append(replay.pending, event)
+		return
+	}
+	if subs, ok := sIOUser.subs[connID]; ok && subs.has(event.Channel) {
+		conn.Emit(event.Event, event.Data)
+	}
+}
+
+// startReplay makes live events of the connection wait for its replay
+func (sIOUser *SocketIOUser) startReplay(connID string) {
+	sIOUser.connM.Lock()
+	sIOUser.replays[connID] = &connReplay{}
+	sIOUser.connM.Unlock()
+}
+
+// replay emits events of the user after the sequence id, then live events held meanwhile
+func (sConnPool *SocketIOConnectedPool) replay(user *SocketIOUser, c socketConn, afterSeq int64) {
+	result := ReplayResult{Seq: afterSeq}
+	for {
+		events, err := sConnPool.db.FindUserEvents(user.userID, result.Seq, userEventsPage)
+		if err != nil {
+			sConnPool.log.Errorf("replay: db.FindUserEvents: %s", err.Error())
+			break
+		}
+		if result.Seq == afterSeq {
+			result.Truncated = truncated(afterSeq, events)
+		}
+		for _, event := range events {
+			if user.connSubscribed(c.Id(), event.Channel) {
+				c.Emit(event.Event, event.Data)
+			}
+			result.Seq = event.Seq
+		}
+		if len(events) < userEventsPage {
+			break
+		}
+	}
+
+	user.connM.Lock()
+	defer user.connM.Unlock()
+	replay, ok := user.replays[c.Id()]
+	if !ok {
+		return
+	}
+	delete(user.replays, c.Id())
+	for _, event := range replay.pending {
+		// replayed already, or live before the event was stored
+		if event.Seq != 0 && event.Seq <= result.Seq {
+			continue
+		}
+		if subs, ok := user.subs[c.Id()]; ok && subs.has(event.Channel) {
+			c.Emit(event.Event, event.Data)
+		}
+		if event.Seq > result.Seq {
+			result.Seq = event.Seq
+		}
+	}
+	c.Emit(ReplayDone, result)
+}
+
+// cleanUserEvents removes events out of the retention window
+func (sConnPool *SocketIOConnectedPool) cleanUserEvents() {
+	ticker := time.NewTicker(userEventsCleanTick)
+	for now := range ticker.C {
+		err := sConnPool.db.RemoveUserEvents(now.Add(-userEventsRetention).Unix())
+		if err != nil {
+			sConnPool.log.Errorf("cleanUserEvents: db.RemoveUserEvents: %s", err.Error())
+		}
+	}
+}
+
+// getUserEvents is the events feed for clients which poll, ?after=<seq>&limit=<n>.
+// It's the Server-Sent Events stream for clients accepting text/event-stream.
+func (restClient *RestClient) getUserEvents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+			restClient.streamUserEvents(c)
+			return
+		}
+
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+
+		afterSeq, err := strconv.ParseInt(c.DefaultQuery("after", "0"), 10, 64)
+		if err != nil || afterSeq < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrDecodeUserEventsReq,
+			})
+			return
+		}
+		limit := userEventsPage
+		if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= maxUserEventsPageLimit {
+			limit = l
+		}
+
+		events, err := restClient.userStore.FindUserEvents(user.UserID, afterSeq, limit)
+		if err != nil {
+			restClient.log.Errorf("getUserEvents: restClient.userStore.FindUserEvents: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": msgErrUserEvents,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":      http.StatusOK,
+			"message":   http.StatusText(http.StatusOK),
+			"events":    events,
+			"truncated": truncated(afterSeq, events),
+		})
+	}
+}
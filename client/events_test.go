@@ -0,0 +1,257 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/jekabolt/slf"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// eventsStore keeps user events in memory, onFind is called before the lookup
+type eventsStore struct {
+	store.UserStore
+	m      sync.Mutex
+	seqs   map[string]int64
+	events []store.UserEvent
+	onFind func()
+	fail   bool
+	user   store.User
+}
+
+func (es *eventsStore) FindUser(query bson.M, user *store.User) error {
+	*user = es.user
+	return nil
+}
+
+func (es *eventsStore) NextUserEventSeq(userID string) (int64, error) {
+	es.m.Lock()
+	defer es.m.Unlock()
+	if es.fail {
+		return 0, errors.New("db is down")
+	}
+	es.seqs[userID]++
+	return es.seqs[userID], nil
+}
+
+func (es *eventsStore) InsertUserEvent(event store.UserEvent) error {
+	es.m.Lock()
+	defer es.m.Unlock()
+	es.events = append(es.events, event)
+	return nil
+}
+
+func (es *eventsStore) FindUserEvents(userID string, afterSeq int64, limit int) ([]store.UserEvent, error) {
+	if es.onFind != nil {
+		onFind := es.onFind
+		es.onFind = nil
+		onFind()
+	}
+	es.m.Lock()
+	defer es.m.Unlock()
+	events := []store.UserEvent{}
+	for _, event := range es.events {
+		if event.UserID == userID && event.Seq > afterSeq && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func walletTx(userID string, walletIndex int, txID string) store.TransactionWithUserID {
+	return store.TransactionWithUserID{
+		UserID:          userID,
+		NotificationMsg: &store.WsTxNotify{WalletIndex: walletIndex, TxID: txID},
+	}
+}
+
+func testEventsPool(db store.UserStore) *SocketIOConnectedPool {
+	return &SocketIOConnectedPool{
+		m:     &sync.RWMutex{},
+		users: map[string]*SocketIOUser{},
+		db:    db,
+		log:   slf.WithContext("test"),
+	}
+}
+
+func testEventsUser(connID string) *SocketIOUser {
+	return &SocketIOUser{
+		userID:  "alice",
+		subs:    map[string]*connSubscriptions{connID: newConnSubscriptions("")},
+		tokens:  map[string]connToken{},
+		replays: map[string]*connReplay{},
+	}
+}
+
+// emittedTxs returns txids of emitted transaction updates in order
+func emittedTxs(conn *fakeConn) []string {
+	txIDs := []string{}
+	for _, args := range conn.emitted(store.TopicTransaction) {
+		txIDs = append(txIDs, args.(store.TransactionWithUserID).NotificationMsg.TxID)
+	}
+	return txIDs
+}
+
+func TestRecordUserEvent(t *testing.T) {
+	db := &eventsStore{seqs: map[string]int64{}}
+	pool := testEventsPool(db)
+
+	first := pool.recordUserEvent(walletTx("alice", 1, "a"))
+	pool.recordUserEvent(walletTx("bob", 1, "b"))
+	second := pool.recordUserEvent(walletTx("alice", 2, "c"))
+	if first.Seq != 1 || second.Seq != 2 || second.Data.Seq != 2 {
+		t.Errorf("wrong sequence ids %d %d %d", first.Seq, second.Seq, second.Data.Seq)
+	}
+	if second.Channel != "wallet:0:0:2" || second.Event != store.TopicTransaction {
+		t.Errorf("wrong event %+v", second)
+	}
+	if len(db.events) != 3 {
+		t.Errorf("%d events stored", len(db.events))
+	}
+
+	// node notifications of BTC multisig are on the invite code channel
+	db.user = store.User{Multisigs: []store.Multisig{{CurrencyID: currencies.Bitcoin, InviteCode: "5f0c", Addresses: []store.MultisigAddress{{Address: "2N1LGaGg836mqSQqiuUBLfcyGBhyZbremDX"}}}}}
+	tx := walletTx("alice", store.MultisigWalletIndex, "m")
+	tx.NotificationMsg.Address = "2N1LGaGg836mqSQqiuUBLfcyGBhyZbremDX"
+	if event := pool.recordUserEvent(tx); event.Channel != "multisig:5f0c" {
+		t.Errorf("wrong BTC multisig channel %s", event.Channel)
+	}
+
+	// live event goes on without the database
+	db.fail = true
+	if event := pool.recordUserEvent(walletTx("alice", 1, "d")); event.Seq != 0 || event.Data.NotificationMsg.TxID != "d" {
+		t.Errorf("wrong event without database %+v", event)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	db := &eventsStore{seqs: map[string]int64{}}
+	pool := testEventsPool(db)
+	for i, txID := range []string{"1", "2", "3", "4"} {
+		pool.recordUserEvent(walletTx("alice", i%2, txID))
+	}
+
+	conn := &fakeConn{id: "c"}
+	user := testEventsUser(conn.id)
+	user.subs[conn.id].subscribe([]string{"wallet:0:0:1"})
+	user.startReplay(conn.id)
+
+	// events come live while the replay reads the database: the stored one
+	// is replayed once, the next one waits for the replay
+	db.onFind = func() {
+		user.emitUserEvent(conn.id, conn, pool.recordUserEvent(walletTx("alice", 1, "5")))
+		user.emitUserEvent(conn.id, conn, store.UserEvent{Seq: 6, Event: store.TopicTransaction, Channel: "wallet:0:0:1", Data: walletTx("alice", 1, "6")})
+	}
+	pool.replay(user, conn, 1)
+
+	if got := emittedTxs(conn); fmt.Sprint(got) != "[2 4 5 6]" {
+		t.Errorf("wrong replay %v", got)
+	}
+	done := conn.emitted(ReplayDone)
+	if len(done) != 1 || done[0].(ReplayResult).Seq != 6 || done[0].(ReplayResult).Truncated {
+		t.Errorf("wrong replay result %v", done)
+	}
+
+	// live again
+	user.emitUserEvent(conn.id, conn, store.UserEvent{Seq: 7, Event: store.TopicTransaction, Channel: "wallet:0:0:1", Data: walletTx("alice", 1, "7")})
+	if got := emittedTxs(conn); len(got) != 5 {
+		t.Errorf("live event after replay missed %v", got)
+	}
+}
+
+func TestReplayTruncated(t *testing.T) {
+	db := &eventsStore{seqs: map[string]int64{}, events: []store.UserEvent{
+		{UserID: "alice", Seq: 9, Event: store.TopicTransaction, Channel: "wallet:0:0:0", Data: walletTx("alice", 0, "9")},
+	}}
+	pool := testEventsPool(db)
+	conn := &fakeConn{id: "c"}
+	user := testEventsUser(conn.id)
+	user.startReplay(conn.id)
+
+	pool.replay(user, conn, 3)
+	done := conn.emitted(ReplayDone)
+	if len(done) != 1 || !done[0].(ReplayResult).Truncated || done[0].(ReplayResult).Seq != 9 {
+		t.Errorf("wrong replay result %v", done)
+	}
+	if truncated(0, db.events) || truncated(8, db.events) || !truncated(7, db.events) {
+		t.Errorf("wrong truncated")
+	}
+}
+
+func TestClusterUserEvent(t *testing.T) {
+	pool := testEventsPool(&eventsStore{})
+	pool.instance = "b"
+	pool.hub = NewEventHub()
+	alice := pool.hub.subscribe("alice", nil)
+
+	relayed := clusterUserEvent(store.UserEvent{UserID: "alice", Seq: 3, Event: store.TopicTransaction, Channel: "wallet:0:0:1", Data: walletTx("alice", 1, "a")})
+	relayed.Instance = "a"
+	body, err := json.Marshal(relayed)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := pool.handleCluster(body); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(alice.events) != 1 {
+		t.Errorf("relayed event isn't delivered to the user")
+	}
+
+	// own events are delivered already
+	relayed.Instance = "b"
+	body, _ = json.Marshal(relayed)
+	pool.handleCluster(body)
+	if len(alice.events) != 1 {
+		t.Errorf("own event delivered twice")
+	}
+}
+
+// leasesStore keeps a single lease as the leases collection does
+type leasesStore struct {
+	store.UserStore
+	holder   string
+	expireAt time.Time
+}
+
+func (ls *leasesStore) AcquireLease(name, holder string, now, until time.Time) (bool, error) {
+	if ls.holder != "" && ls.holder != holder && !ls.expireAt.Before(now) {
+		return false, nil
+	}
+	ls.holder, ls.expireAt = holder, until
+	return true, nil
+}
+
+func TestClusterLease(t *testing.T) {
+	db := &leasesStore{}
+	a := &clusterLease{db: db, name: "rates", holder: "a", ttl: 30 * time.Second}
+	b := &clusterLease{db: db, name: "rates", holder: "b", ttl: 30 * time.Second}
+
+	now := time.Unix(1550000000, 0)
+	if !a.held(now) || b.held(now) {
+		t.Fatal("lease is held by both instances")
+	}
+	// renewed by the holder
+	if !a.held(now.Add(20*time.Second)) || b.held(now.Add(40*time.Second)) {
+		t.Error("lease isn't renewed")
+	}
+	// the holder is gone
+	if !b.held(now.Add(time.Minute)) || a.held(now.Add(time.Minute)) {
+		t.Error("expired lease isn't taken over")
+	}
+
+	var single *clusterLease
+	if !single.held(now) {
+		t.Error("nil lease isn't held")
+	}
+}
@@ -6,16 +6,23 @@ See LICENSE for details
 package client
 
 import (
+	"bufio"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/jekabolt/slf"
+	"github.com/Multy-io/Multy-back/store"
 	"github.com/gorilla/websocket"
+	"github.com/jekabolt/slf"
 )
 
 const (
+	// stock exchanges of legacy rates records and exchange events, Poloniex rates have always come from HitBTC
 	exchangeDdax     = "Gdax"
 	exchangePoloniex = "Poloniex"
 
@@ -23,187 +30,412 @@ const (
 )
 
 const (
-	poloniexAPIAddr = "wss://api.hitbtc.com/api/2/ws"
+	hitBTCAPIAddr   = "wss://api.hitbtc.com/api/2/ws"
 	gdaxAPIAddr     = "wss://ws-feed.gdax.com"
+	bitstampAPIAddr = "https://www.bitstamp.net/api/v2/ticker/"
+
+	bitstampPollInterval      = time.Second * 10
+	cryptoComparePollInterval = time.Second * 30
 )
 
-// GdaxAPI wraps websocket connection for GdaxAPI
-type GdaxAPI struct {
-	conn  *websocket.Conn
-	rates *StockRate
+// runWebSocket keeps the connection to the exchange until stop is closed,
+// subscribe is called on every connection and handle on every message
+func runWebSocket(addr string, subscribe func(conn *websocket.Conn) error, handle func(message []byte), stop <-chan struct{}, log slf.StructuredLogger) {
+	for {
+		conn, err := reconnectWebSocketConn(addr, log)
+		if err != nil {
+			log.Errorf("runWebSocket: reconnection: %s", err.Error())
+			select {
+			case <-stop:
+				return
+			case <-time.After(backOffLimit):
+				continue
+			}
+		}
+
+		closed := make(chan struct{})
+		go func() {
+			select {
+			case <-stop:
+			case <-closed:
+			}
+			conn.Close()
+		}()
+
+		err = subscribe(conn)
+		for err == nil {
+			var message []byte
+			_, message, err = conn.ReadMessage()
+			if err == nil {
+				handle(message)
+			}
+		}
+		close(closed)
+
+		select {
+		case <-stop:
+			return
+		default:
+			log.Errorf("runWebSocket: read message: %s", err.Error())
+		}
+	}
+}
+
+// sendQuote sends the quote unless the provider is stopped
+func sendQuote(quotes chan<- Quote, quote Quote, stop <-chan struct{}) {
+	select {
+	case quotes <- quote:
+	case <-stop:
+	}
+}
 
+// GdaxProvider streams ticker of Gdax WebSocket feed
+type GdaxProvider struct {
 	log slf.StructuredLogger
 }
 
-//GDAXSocketEvent is a GDAX json parser structure
+// GDAXSocketEvent is a GDAX json parser structure
 type GDAXSocketEvent struct {
-	ProductID string `json:"product_id"`
-	Price     string `json:"price"`
+	ProductID string    `json:"product_id"`
+	Price     string    `json:"price"`
+	Volume    string    `json:"volume_24h"`
+	Time      time.Time `json:"time"`
 }
 
-func (eChart *exchangeChart) newGdaxAPI(log slf.StructuredLogger) (*GdaxAPI, error) {
-	gdaxAPI := &GdaxAPI{rates: eChart.rates.exchangeGdax, log: log.WithField("api", "gdax")}
+func NewGdaxProvider() *GdaxProvider {
+	return &GdaxProvider{log: slf.WithContext("rates").WithField("api", RateProviderGdax)}
+}
 
-	c, err := newWebSocketConn(gdaxAPIAddr)
-	if err != nil {
-		eChart.log.Errorf("new gdax connection: %s", err.Error())
-		c, err = reconnectWebSocketConn(gdaxAPIAddr, log)
+func (gdax *GdaxProvider) Name() string { return RateProviderGdax }
+
+func (gdax *GdaxProvider) Run(quotes chan<- Quote, stop <-chan struct{}) {
+	subscribe := func(conn *websocket.Conn) error {
+		subscribtion := `{"type":"subscribe","channels":[{"name":"ticker_1000","product_ids":["BTC-USD","BTC-EUR","ETH-BTC","ETH-USD","ETH-EUR"]}]}`
+		return conn.WriteMessage(websocket.TextMessage, []byte(subscribtion))
+	}
+	handle := func(message []byte) {
+		rateRaw := GDAXSocketEvent{}
+		err := json.Unmarshal(message, &rateRaw)
 		if err != nil {
-			eChart.log.Errorf("gdax reconnection: %s", err.Error())
-			return nil, err
+			gdax.log.Errorf("unmarshal error %s", err.Error())
+			return
+		}
+		quote, err := gdax.quote(rateRaw)
+		if err != nil {
+			// subscriptions and heartbeats have no price
+			return
 		}
+		sendQuote(quotes, quote, stop)
 	}
+	runWebSocket(gdaxAPIAddr, subscribe, handle, stop, gdax.log)
+}
 
-	gdaxAPI.conn = c
+func (gdax *GdaxProvider) quote(rateRaw GDAXSocketEvent) (Quote, error) {
+	price, err := strconv.ParseFloat(rateRaw.Price, 64)
+	if err != nil {
+		return Quote{}, err
+	}
+	volume, _ := strconv.ParseFloat(rateRaw.Volume, 64)
+	quote := Quote{Source: RateProviderGdax, Pair: rateRaw.ProductID, Price: price, Volume: volume, Time: rateRaw.Time}
+	if quote.Time.IsZero() {
+		quote.Time = time.Now()
+	}
+	return quote, nil
+}
 
-	return gdaxAPI, nil
+// HitBTCProvider streams ticker of HitBTC WebSocket API
+type HitBTCProvider struct {
+	log slf.StructuredLogger
 }
 
-func (gdax *GdaxAPI) subscribe() {
-	subscribtion := `{"type":"subscribe","channels":[{"name":"ticker_1000","product_ids":["BTC-USD","BTC-EUR","ETH-BTC","ETH-USD","ETH-EUR"]}]}`
-	gdax.conn.WriteMessage(websocket.TextMessage, []byte(subscribtion))
+// HitBTCSocketEvent is a HitBTC json parser structure
+type HitBTCSocketEvent struct {
+	Data struct {
+		Price     string    `json:"last"`
+		Volume    string    `json:"volume"`
+		Time      time.Time `json:"timestamp"`
+		ProductID string    `json:"symbol"`
+	} `json:"params"`
 }
 
-func (gdax *GdaxAPI) listen() {
-	for {
-		_, message, err := gdax.conn.ReadMessage()
-		if err != nil {
-			gdax.log.Errorf("read message: %s", err.Error())
-			c, err := reconnectWebSocketConn(gdaxAPIAddr, gdax.log)
-			if err != nil {
-				gdax.log.Errorf("gdax reconnection: %s", err.Error())
-				return
+// hitBTCPairs are pairs by HitBTC symbols
+var hitBTCPairs = map[string]string{
+	"BTCUSD": "BTC-USD",
+	"ETHBTC": "ETH-BTC",
+	"ETHUSD": "ETH-USD",
+}
+
+func NewHitBTCProvider() *HitBTCProvider {
+	return &HitBTCProvider{log: slf.WithContext("rates").WithField("api", RateProviderHitBTC)}
+}
+
+func (hitBTC *HitBTCProvider) Name() string { return RateProviderHitBTC }
+
+func (hitBTC *HitBTCProvider) Run(quotes chan<- Quote, stop <-chan struct{}) {
+	subscribe := func(conn *websocket.Conn) error {
+		for symbol := range hitBTCPairs {
+			subscribtion := fmt.Sprintf(`{"method":"subscribeTicker","params":{"symbol":"%s"},"id":10000}`, symbol)
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(subscribtion)); err != nil {
+				return err
 			}
-			gdax.conn = c
-			continue
 		}
-
-		rateRaw := &GDAXSocketEvent{}
-		err = json.Unmarshal(message, rateRaw)
+		return nil
+	}
+	handle := func(message []byte) {
+		rateRaw := HitBTCSocketEvent{}
+		err := json.Unmarshal(message, &rateRaw)
 		if err != nil {
-			gdax.log.Errorf("unmarshal error %s", err.Error())
-			continue
+			hitBTC.log.Errorf("unmarshal error %s", err.Error())
+			return
 		}
-
-		gdax.updateRate(rateRaw)
+		quote, err := hitBTC.quote(rateRaw)
+		if err != nil {
+			// seems like here would be ttl messages, which is empty structures
+			return
+		}
+		sendQuote(quotes, quote, stop)
 	}
+	runWebSocket(hitBTCAPIAddr, subscribe, handle, stop, hitBTC.log)
 }
 
-func (gdax *GdaxAPI) updateRate(rawRate *GDAXSocketEvent) {
-	floatPrice, err := strconv.ParseFloat(rawRate.Price, 32)
+func (hitBTC *HitBTCProvider) quote(rateRaw HitBTCSocketEvent) (Quote, error) {
+	pair, ok := hitBTCPairs[rateRaw.Data.ProductID]
+	if !ok {
+		return Quote{}, fmt.Errorf("unknown symbol %s", rateRaw.Data.ProductID)
+	}
+	price, err := strconv.ParseFloat(rateRaw.Data.Price, 64)
 	if err != nil {
-		gdax.log.Errorf("parseFloat %s: %s", rawRate.Price, err.Error())
-		return
+		return Quote{}, err
 	}
-
-	gdax.rates.m.Lock()
-
-	switch rawRate.ProductID {
-	case "BTC-USD":
-		gdax.rates.exchange.BTCtoUSD = floatPrice
-		gdax.rates.exchange.USDtoBTC = 1 / floatPrice
-	case "BTC-EUR":
-		gdax.rates.exchange.EURtoBTC = 1 / floatPrice
-	case "ETH-BTC":
-		gdax.rates.exchange.ETHtoBTC = floatPrice
-		//gdax.rates.exchange.BTCtoETH = 1 / floatPrice
-	case "ETH-USD":
-		gdax.rates.exchange.ETHtoUSD = floatPrice
-	case "ETH-EUR":
-		gdax.rates.exchange.ETHtoEUR = floatPrice
-	default:
-		gdax.log.Warnf("unknown rate: %+v", rawRate)
+	volume, _ := strconv.ParseFloat(rateRaw.Data.Volume, 64)
+	quote := Quote{Source: RateProviderHitBTC, Pair: pair, Price: price, Volume: volume, Time: rateRaw.Data.Time}
+	if quote.Time.IsZero() {
+		quote.Time = time.Now()
 	}
-
-	gdax.rates.m.Unlock()
+	return quote, nil
 }
 
-// PoloniexAPI wraps websocket connection for PoloniexAPI
-type PoloniexAPI struct {
-	conn  *websocket.Conn
-	rates *StockRate
+// BitstampProvider polls ticker of Bitstamp REST API
+type BitstampProvider struct {
+	client *http.Client
+	log    slf.StructuredLogger
+}
 
-	log slf.StructuredLogger
+// BitstampTicker is a Bitstamp json parser structure
+type BitstampTicker struct {
+	Price     string `json:"last"`
+	Volume    string `json:"volume"`
+	Timestamp string `json:"timestamp"`
 }
 
-// PoloniexSocketEvent is a Poloniex json parser structure
-type PoloniexSocketEvent struct {
-	Data struct {
-		Price     string `json:"last"`
-		ProductID string `json:"symbol"`
-	} `json:"params"`
+// bitstampPairs are pairs Bitstamp has
+var bitstampPairs = []string{"BTC-USD", "BTC-EUR", "ETH-USD", "ETH-EUR", "ETH-BTC"}
+
+func NewBitstampProvider() *BitstampProvider {
+	return &BitstampProvider{
+		client: &http.Client{Timeout: bitstampPollInterval},
+		log:    slf.WithContext("rates").WithField("api", RateProviderBitstamp),
+	}
 }
 
-func (eChart *exchangeChart) newPoloniexAPI(log slf.StructuredLogger) (*PoloniexAPI, error) {
-	poloniexAPI := &PoloniexAPI{rates: eChart.rates.exchangePoloniex, log: log.WithField("api", "poloniex")}
+func (bitstamp *BitstampProvider) Name() string { return RateProviderBitstamp }
 
-	c, err := newWebSocketConn(poloniexAPIAddr)
-	if err != nil {
-		eChart.log.Errorf("new poloniex connection: %s", err.Error())
-		c, err = reconnectWebSocketConn(gdaxAPIAddr, log)
-		if err != nil {
-			eChart.log.Errorf("poloniex connection: %s", err.Error())
-			return nil, err
+func (bitstamp *BitstampProvider) Run(quotes chan<- Quote, stop <-chan struct{}) {
+	ticker := time.NewTicker(bitstampPollInterval)
+	defer ticker.Stop()
+	for {
+		for _, pair := range bitstampPairs {
+			quote, err := bitstamp.ticker(pair)
+			if err != nil {
+				bitstamp.log.Errorf("ticker %s: %s", pair, err.Error())
+				continue
+			}
+			sendQuote(quotes, quote, stop)
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
 		}
 	}
+}
 
-	poloniexAPI.conn = c
+func (bitstamp *BitstampProvider) ticker(pair string) (Quote, error) {
+	resp, err := bitstamp.client.Get(bitstampAPIAddr + strings.ToLower(strings.Replace(pair, "-", "", 1)) + "/")
+	if err != nil {
+		return Quote{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Quote{}, fmt.Errorf("response status code=%d", resp.StatusCode)
+	}
+
+	rateRaw := BitstampTicker{}
+	err = json.NewDecoder(resp.Body).Decode(&rateRaw)
+	if err != nil {
+		return Quote{}, err
+	}
+	price, err := strconv.ParseFloat(rateRaw.Price, 64)
+	if err != nil {
+		return Quote{}, err
+	}
+	volume, _ := strconv.ParseFloat(rateRaw.Volume, 64)
+	quote := Quote{Source: RateProviderBitstamp, Pair: pair, Price: price, Volume: volume, Time: time.Now()}
+	if timestamp, err := strconv.ParseInt(rateRaw.Timestamp, 10, 64); err == nil {
+		quote.Time = time.Unix(timestamp, 0)
+	}
+	return quote, nil
+}
 
-	return poloniexAPI, nil
+// CryptoCompareProvider polls prices of CryptoCompare aggregated index, it has rates of any fiat
+type CryptoCompareProvider struct {
+	addr   string
+	client *http.Client
+	// pairs are the ones polled
+	pairs []string
+	log   slf.StructuredLogger
 }
 
-func (poloniex *PoloniexAPI) subscribe() {
-	subscribtionBTCUSD := `{"method":"subscribeTicker","params":{"symbol": "BTCUSD"},"id": 10000}`
-	subscribtionETHBTC := `{"method":"subscribeTicker","params":{"symbol":"ETHBTC"},"id": 10000}`
-	subscribtionETHUSD := `{"method":"subscribeTicker","params":{"symbol":"ETHUSD"},"id": 10000}`
+// CryptoCompareFull is a CryptoCompare json parser structure
+type CryptoCompareFull struct {
+	Response string `json:"Response"`
+	Message  string `json:"Message"`
+	Raw      map[string]map[string]struct {
+		Price      float64 `json:"PRICE"`
+		Volume     float64 `json:"VOLUME24HOUR"`
+		LastUpdate int64   `json:"LASTUPDATE"`
+	} `json:"RAW"`
+}
 
-	poloniex.conn.WriteMessage(websocket.TextMessage, []byte(subscribtionBTCUSD))
-	poloniex.conn.WriteMessage(websocket.TextMessage, []byte(subscribtionETHBTC))
-	poloniex.conn.WriteMessage(websocket.TextMessage, []byte(subscribtionETHUSD))
+func NewCryptoCompareProvider(pairs []string) *CryptoCompareProvider {
+	return &CryptoCompareProvider{
+		addr:   cryptoCompareAPIAddr,
+		client: &http.Client{Timeout: cryptoComparePollInterval},
+		pairs:  pairs,
+		log:    slf.WithContext("rates").WithField("api", RateProviderCryptoCompare),
+	}
 }
 
-func (poloniex *PoloniexAPI) listen() {
+func (cc *CryptoCompareProvider) Name() string { return RateProviderCryptoCompare }
+
+func (cc *CryptoCompareProvider) Run(quotes chan<- Quote, stop <-chan struct{}) {
+	ticker := time.NewTicker(cryptoComparePollInterval)
+	defer ticker.Stop()
 	for {
-		_, message, err := poloniex.conn.ReadMessage()
+		prices, err := cc.prices(cc.pairs)
 		if err != nil {
-			poloniex.log.Errorf("read message: %s", err.Error())
-			c, err := reconnectWebSocketConn(poloniexAPIAddr, poloniex.log)
-			if err != nil {
-				poloniex.log.Errorf("gdax reconnection: %s", err.Error())
-				return
-			}
-			poloniex.conn = c
-			continue
+			cc.log.Errorf("prices: %s", err.Error())
+		}
+		for _, quote := range prices {
+			sendQuote(quotes, quote, stop)
 		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
 
-		rateRaw := PoloniexSocketEvent{}
-		err = json.Unmarshal(message, &rateRaw)
-		if err != nil {
-			log.Printf("error marshaling: %s/n", err.Error())
+// prices returns quotes of the pairs
+func (cc *CryptoCompareProvider) prices(pairs []string) ([]Quote, error) {
+	bases, quoteCurrencies := []string{}, []string{}
+	seen := map[string]bool{}
+	for _, pair := range pairs {
+		base, quote, _ := store.SplitRatePair(pair)
+		if !seen["base:"+base] {
+			seen["base:"+base] = true
+			bases = append(bases, base)
+		}
+		if !seen["quote:"+quote] {
+			seen["quote:"+quote] = true
+			quoteCurrencies = append(quoteCurrencies, quote)
+		}
+	}
+
+	resp, err := cc.client.Get(fmt.Sprintf("%spricemultifull?fsyms=%s&tsyms=%s", cc.addr, strings.Join(bases, ","), strings.Join(quoteCurrencies, ",")))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	full := CryptoCompareFull{}
+	err = json.NewDecoder(resp.Body).Decode(&full)
+	if err != nil {
+		return nil, err
+	}
+	if full.Response == "Error" {
+		return nil, errors.New(full.Message)
+	}
+
+	quotes := []Quote{}
+	for _, pair := range pairs {
+		base, quote, _ := store.SplitRatePair(pair)
+		raw, ok := full.Raw[base][quote]
+		if !ok || raw.Price <= 0 {
 			continue
 		}
-		poloniex.updateRate(&rateRaw)
+		quotes = append(quotes, Quote{
+			Source: RateProviderCryptoCompare,
+			Pair:   pair,
+			Price:  raw.Price,
+			Volume: raw.Volume,
+			Time:   time.Unix(raw.LastUpdate, 0),
+		})
 	}
+	return quotes, nil
+}
+
+// ReplayProvider replays recorded quotes of any sources as if they were live, it's for tests and development
+type ReplayProvider struct {
+	quotes []Quote
+	// Speed speeds up pauses between quotes, they are sent at once if it's zero
+	Speed float64
+}
+
+func NewReplayProvider(quotes []Quote) *ReplayProvider {
+	return &ReplayProvider{quotes: quotes}
 }
 
-func (poloniex *PoloniexAPI) updateRate(rateRaw *PoloniexSocketEvent) {
-	floatPrice, err := strconv.ParseFloat(rateRaw.Data.Price, 32)
+// LoadReplayProvider reads quotes from JSON lines file, they are replayed in real time
+func LoadReplayProvider(file string) (*ReplayProvider, error) {
+	f, err := os.Open(file)
 	if err != nil {
-		// seems like here would be ttl messages, which is empty structures
-		return
-	}
-
-	poloniex.rates.m.Lock()
-	switch rateRaw.Data.ProductID {
-	case "BTCUSD":
-		poloniex.rates.exchange.BTCtoUSD = floatPrice
-		poloniex.rates.exchange.USDtoBTC = 1 / floatPrice
-	case "ETHBTC":
-		poloniex.rates.exchange.ETHtoBTC = floatPrice
-	case "ETHUSD":
-		poloniex.rates.exchange.ETHtoUSD = floatPrice
-	default:
-		poloniex.log.Warnf("unknown rate: %+v", rateRaw)
-	}
-	poloniex.rates.m.Unlock()
+		return nil, err
+	}
+	defer f.Close()
+
+	quotes := []Quote{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		quote := Quote{}
+		if err := json.Unmarshal(scanner.Bytes(), &quote); err != nil {
+			return nil, fmt.Errorf("LoadReplayProvider: %s", err.Error())
+		}
+		quotes = append(quotes, quote)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &ReplayProvider{quotes: quotes, Speed: 1}, nil
+}
+
+func (replay *ReplayProvider) Name() string { return RateProviderReplay }
+
+// Run sends quotes once with recorded pauses, their time is the time they're sent
+func (replay *ReplayProvider) Run(quotes chan<- Quote, stop <-chan struct{}) {
+	for i, quote := range replay.quotes {
+		if i > 0 && replay.Speed > 0 {
+			pause := quote.Time.Sub(replay.quotes[i-1].Time)
+			select {
+			case <-time.After(time.Duration(float64(pause) / replay.Speed)):
+			case <-stop:
+				return
+			}
+		}
+		quote.Time = time.Now()
+		sendQuote(quotes, quote, stop)
+	}
 }
@@ -0,0 +1,147 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// hubBuffer is the number of events a subscriber could lag behind before it's dropped
+const hubBuffer = 256
+
+// HubEvent is the event delivered to subscribers of SSE and WebSocket endpoints
+type HubEvent struct {
+	// ID is the sequence id of the stored user event, rates have none
+	ID      int64       `json:"id,omitempty"`
+	Event   string      `json:"event"`
+	Channel string      `json:"channel,omitempty"`
+	Data    interface{} `json:"data"`
+}
+
+// hubSubscriber gets events matching its subscriptions. Events are dropped when it doesn't keep up,
+// lost is closed then and the client reconnects with the last seen id.
+type hubSubscriber struct {
+	userID string
+	events chan HubEvent
+	lost   chan struct{}
+	once   sync.Once
+
+	m    sync.Mutex
+	subs *connSubscriptions
+}
+
+func (sub *hubSubscriber) has(channel string) bool {
+	sub.m.Lock()
+	defer sub.m.Unlock()
+	return sub.subs.has(channel)
+}
+
+// update applies the update to subscriptions and returns channels
+func (sub *hubSubscriber) update(update func(subs *connSubscriptions)) []string {
+	sub.m.Lock()
+	defer sub.m.Unlock()
+	update(sub.subs)
+	return sub.subs.list()
+}
+
+func (sub *hubSubscriber) deliver(event HubEvent) {
+	if !sub.has(event.Channel) {
+		return
+	}
+	select {
+	case sub.events <- event:
+	default:
+		sub.once.Do(func() { close(sub.lost) })
+	}
+}
+
+// EventHub fans out user events and rates of socket.io instance to SSE and WebSocket subscribers
+type EventHub struct {
+	m     sync.RWMutex
+	users map[string]map[*hubSubscriber]bool
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{users: map[string]map[*hubSubscriber]bool{}}
+}
+
+// subscribe adds the subscriber of the user, default channels are used when there are no channels
+func (hub *EventHub) subscribe(userID string, channels []string) *hubSubscriber {
+	sub := &hubSubscriber{
+		userID: userID,
+		events: make(chan HubEvent, hubBuffer),
+		lost:   make(chan struct{}),
+		subs:   newConnSubscriptions(""),
+	}
+	if len(channels) > 0 {
+		sub.subs.subscribe(channels)
+	}
+
+	hub.m.Lock()
+	defer hub.m.Unlock()
+	if _, ok := hub.users[userID]; !ok {
+		hub.users[userID] = map[*hubSubscriber]bool{}
+	}
+	hub.users[userID][sub] = true
+	return sub
+}
+
+func (hub *EventHub) unsubscribe(sub *hubSubscriber) {
+	hub.m.Lock()
+	defer hub.m.Unlock()
+	delete(hub.users[sub.userID], sub)
+	if len(hub.users[sub.userID]) == 0 {
+		delete(hub.users, sub.userID)
+	}
+}
+
+// publishUser delivers the event to subscribers of the user
+func (hub *EventHub) publishUser(userID string, event HubEvent) {
+	hub.m.RLock()
+	defer hub.m.RUnlock()
+	for sub := range hub.users[userID] {
+		sub.deliver(event)
+	}
+}
+
+// publishAll delivers the event to all subscribers
+func (hub *EventHub) publishAll(event HubEvent) {
+	hub.m.RLock()
+	defer hub.m.RUnlock()
+	for _, subs := range hub.users {
+		for sub := range subs {
+			sub.deliver(event)
+		}
+	}
+}
+
+// publishRates sends rates to the hub as often as to socket.io connections
+func (sConnPool *SocketIOConnectedPool) publishRates() {
+	ticker := time.NewTicker(updateExchangeClient)
+	for range ticker.C {
+		sConnPool.hub.publishAll(HubEvent{
+			Event:   topicExchangeGdax,
+			Channel: channelExchange + ":" + strings.ToLower(exchangeDdax),
+			Data:    sConnPool.chart.getExchangeGdax(),
+		})
+		sConnPool.hub.publishAll(HubEvent{
+			Event:   topicExchangePoloniex,
+			Channel: channelExchange + ":" + strings.ToLower(exchangePoloniex),
+			Data:    sConnPool.chart.getExchangePoloniex(),
+		})
+		for _, pair := range sConnPool.chart.aggregator.pairs {
+			if rate := sConnPool.chart.pairRate(pair); rate > 0 {
+				sConnPool.hub.publishAll(HubEvent{
+					Event:   topicExchangeRate,
+					Channel: channelRates + ":" + pair,
+					Data:    RateUpdate{Pair: pair, Rate: rate},
+				})
+			}
+		}
+	}
+}
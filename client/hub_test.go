@@ -0,0 +1,226 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/gin-gonic/gin"
+	"github.com/jekabolt/slf"
+)
+
+func TestEventHub(t *testing.T) {
+	hub := NewEventHub()
+	all := hub.subscribe("alice", nil)
+	rates := hub.subscribe("alice", []string{"rates:BTC-USD", "wallet:0:0:1"})
+	bob := hub.subscribe("bob", nil)
+
+	hub.publishUser("alice", HubEvent{ID: 1, Event: store.TopicTransaction, Channel: "wallet:0:0:1"})
+	hub.publishUser("alice", HubEvent{ID: 2, Event: store.TopicTransaction, Channel: "wallet:0:0:2"})
+	hub.publishAll(HubEvent{Event: topicExchangeRate, Channel: "rates:BTC-USD"})
+	hub.publishAll(HubEvent{Event: topicExchangeGdax, Channel: "exchange:gdax"})
+
+	for _, test := range []struct {
+		sub  *hubSubscriber
+		want int
+	}{{all, 3}, {rates, 2}, {bob, 1}} {
+		if got := len(test.sub.events); got != test.want {
+			t.Errorf("%s got %d events, want %d", test.sub.userID, got, test.want)
+		}
+	}
+
+	hub.unsubscribe(all)
+	hub.unsubscribe(rates)
+	if _, ok := hub.users["alice"]; ok {
+		t.Errorf("user kept without subscribers")
+	}
+}
+
+func TestHubSubscriberLost(t *testing.T) {
+	hub := NewEventHub()
+	sub := hub.subscribe("alice", nil)
+	for i := 0; i <= hubBuffer; i++ {
+		hub.publishUser("alice", HubEvent{ID: int64(i), Channel: "wallet:0:0:1"})
+	}
+	select {
+	case <-sub.lost:
+	default:
+		t.Errorf("lagging subscriber kept")
+	}
+	// dropped once
+	hub.publishUser("alice", HubEvent{Channel: "wallet:0:0:1"})
+}
+
+// recorder collects events sent to the stream client
+type recorder struct {
+	m      sync.Mutex
+	events []HubEvent
+}
+
+func (r *recorder) send(event HubEvent) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *recorder) names() []string {
+	r.m.Lock()
+	defer r.m.Unlock()
+	names := []string{}
+	for _, event := range r.events {
+		name := event.Event
+		if event.ID != 0 {
+			name = fmt.Sprintf("%s#%d", name, event.ID)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestEventStreamRun(t *testing.T) {
+	db := &eventsStore{seqs: map[string]int64{}}
+	pool := testEventsPool(db)
+	for i := 0; i < 3; i++ {
+		pool.recordUserEvent(walletTx("alice", 1, fmt.Sprint(i)))
+	}
+
+	hub := NewEventHub()
+	stream := &eventStream{
+		db:       db,
+		userID:   "alice",
+		expire:   time.Now().Add(time.Hour),
+		afterSeq: 1,
+		replay:   true,
+		sub:      hub.subscribe("alice", []string{"wallet:0:0:1"}),
+	}
+	// published while the stream replays, #3 is replayed already
+	hub.publishUser("alice", HubEvent{ID: 3, Event: store.TopicTransaction, Channel: "wallet:0:0:1"})
+	hub.publishUser("alice", HubEvent{ID: 4, Event: store.TopicTransaction, Channel: "wallet:0:0:1"})
+	hub.publishAll(HubEvent{Event: topicExchangeRate, Channel: "rates:BTC-USD"})
+
+	r := &recorder{}
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		stream.run(hub, r.send, done)
+		close(finished)
+	}()
+	for i := 0; i < 100 && len(r.names()) < 4; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(done)
+	<-finished
+
+	want := "[TransactionUpdate#2 TransactionUpdate#3 event:replay:done TransactionUpdate#4]"
+	if got := fmt.Sprint(r.names()); got != want {
+		t.Errorf("sent %s, want %s", got, want)
+	}
+	if len(hub.users) != 0 {
+		t.Errorf("finished stream kept subscribed")
+	}
+}
+
+func TestEventStreamRequest(t *testing.T) {
+	stream := &eventStream{sub: NewEventHub().subscribe("alice", nil), pairs: makeRatePairs(rateFiats(nil))}
+
+	event := stream.handleRequest(StreamRequest{Type: streamSubscribe, Channels: []string{"rates:ETH-USD"}})
+	if fmt.Sprint(event.Data) != "[rates:ETH-USD]" || event.Event != Subscriptions {
+		t.Errorf("wrong subscribe reply %+v", event)
+	}
+	event = stream.handleRequest(StreamRequest{Type: streamUnsubscribe, Channels: []string{"rates:ETH-USD"}})
+	if fmt.Sprint(event.Data) != "[]" {
+		t.Errorf("wrong unsubscribe reply %+v", event)
+	}
+	event = stream.handleRequest(StreamRequest{Type: streamSubscribe, Channels: []string{"news:*"}})
+	if _, ok := event.Data.(string); !ok {
+		t.Errorf("wrong channel accepted %+v", event)
+	}
+}
+
+func TestStreamTicket(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := devicesStore{tokens: map[string]string{}, revoked: map[string]bool{}}
+	restClient := &RestClient{userStore: db, Secretkey: "secret", log: slf.WithContext("test")}
+	initMiddlewareJWT(restClient)
+	restClient.middlewareJWT.MiddlewareInit()
+	auth := &socketAuth{jwt: restClient.middlewareJWT, db: db}
+
+	expire := time.Now().Add(time.Hour)
+	token := signedToken(t, []byte("secret"), "alice", expire)
+	db.tokens[store.TokenHash(token)] = "alice"
+
+	ticket, ticketExpire, err := auth.issueTicket("alice", token, expire)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if ticketExpire.After(time.Now().Add(streamTicketTimeout)) {
+		t.Errorf("ticket expires at %v", ticketExpire)
+	}
+	userID, hash, streamExpire, err := auth.verifyTicket(ticket)
+	if err != nil || userID != "alice" || hash != store.TokenHash(token) || streamExpire.Unix() != expire.Unix() {
+		t.Errorf("wrong ticket %s %s %v %v", userID, hash, streamExpire, err)
+	}
+
+	// tickets and device tokens can't be used for each other
+	if _, _, _, err := auth.verifyTicket(token); err == nil {
+		t.Error("device token accepted as ticket")
+	}
+	if _, err := auth.verify("alice", ticket); err == nil {
+		t.Error("ticket accepted as device token")
+	}
+	r := gin.New()
+	r.GET("/api", restClient.middlewareJWT.MiddlewareFunc(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	for credential, code := range map[string]int{token: http.StatusOK, ticket: http.StatusForbidden} {
+		req := httptest.NewRequest("GET", "/api", nil)
+		req.Header.Set("Authorization", "Bearer "+credential)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != code {
+			t.Errorf("API replied %d, expected %d", w.Code, code)
+		}
+	}
+
+	expired, _, _ := auth.issueTicket("alice", token, time.Now().Add(-time.Second))
+	if _, _, _, err := auth.verifyTicket(expired); err == nil {
+		t.Error("ticket of expired token accepted")
+	}
+	db.revoked[store.TokenHash(token)] = true
+	if _, _, _, err := auth.verifyTicket(ticket); err == nil {
+		t.Error("ticket of revoked token accepted")
+	}
+}
+
+func TestStreamOrigin(t *testing.T) {
+	conf := StreamConf{Origins: []string{"https://wallet.multy.io/"}}
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"", true},
+		{"https://api.multy.io", true},
+		{"https://wallet.multy.io", true},
+		{"https://WALLET.multy.io", true},
+		{"http://wallet.multy.io", false},
+		{"https://evil.example", false},
+		{"null", false},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "https://api.multy.io/api/v1/events/ws", nil)
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+		if conf.allowed(req) != test.allowed {
+			t.Errorf("%q: allowed=%v", test.origin, !test.allowed)
+		}
+	}
+}
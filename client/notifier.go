@@ -0,0 +1,309 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/jekabolt/slf"
+	"github.com/nsqio/go-nsq"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// push notification providers
+const (
+	NotifierFirebase = "firebase"
+	NotifierAPNs     = "apns"
+	NotifierFile     = "file"
+	NotifierMemory   = "memory"
+)
+
+// device types of store.Device
+const (
+	DeviceTypeIOS     = 1
+	DeviceTypeAndroid = 2
+)
+
+// NotifiersConf chooses push notification providers
+type NotifiersConf struct {
+	// Providers are tried in the order, device gets the push from the first one supporting it.
+	// Firebase is used if empty.
+	Providers []string
+
+	// FirebaseCredentials is service account file, "./multy.config" if empty
+	FirebaseCredentials string
+	APNs                APNsConf
+	// File is JSON lines file of the file sink
+	File string
+	// LargeOutgoing are outgoing amounts in the smallest units by currency id raising a security event,
+	// like {"0": "10000000"}. Defaults are 0.1 BTC and 1 ETH, zero turns events of the currency off.
+	LargeOutgoing map[string]string
+}
+
+// Push is a notification to the device
+type Push struct {
+	Token      string            `json:"token"`
+	DeviceType int               `json:"deviceType"`
+	Title      string            `json:"title"`
+	Body       string            `json:"body"`
+	Data       map[string]string `json:"data"`
+}
+
+// Notifier delivers pushes through a provider
+type Notifier interface {
+	Name() string
+	// Supports tells if the provider can deliver to the device type
+	Supports(deviceType int) bool
+	Notify(push Push) error
+}
+
+// NewNotifiers creates enabled providers in the configured order
+func NewNotifiers(conf NotifiersConf) ([]Notifier, error) {
+	providers := conf.Providers
+	if len(providers) == 0 {
+		providers = []string{NotifierFirebase}
+	}
+
+	notifiers := []Notifier{}
+	for _, provider := range providers {
+		var (
+			notifier Notifier
+			err      error
+		)
+		switch provider {
+		case NotifierFirebase:
+			credentials := conf.FirebaseCredentials
+			if credentials == "" {
+				credentials = "./multy.config"
+			}
+			notifier, err = NewFirebaseNotifier(credentials)
+		case NotifierAPNs:
+			notifier, err = NewAPNsNotifier(conf.APNs)
+		case NotifierFile:
+			notifier, err = NewFileNotifier(conf.File)
+		case NotifierMemory:
+			notifier = NewMemoryNotifier()
+		default:
+			err = errors.New("unknown provider " + provider)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("NewNotifiers: %s: %s", provider, err.Error())
+		}
+		notifiers = append(notifiers, notifier)
+	}
+	return notifiers, nil
+}
+
+// routeNotifier returns the first provider supporting the device type
+func routeNotifier(notifiers []Notifier, deviceType int) (Notifier, bool) {
+	for _, notifier := range notifiers {
+		if notifier.Supports(deviceType) {
+			return notifier, true
+		}
+	}
+	return nil, false
+}
+
+// MemoryNotifier keeps pushes in memory for tests
+type MemoryNotifier struct {
+	m      sync.Mutex
+	pushes []Push
+}
+
+func NewMemoryNotifier() *MemoryNotifier {
+	return &MemoryNotifier{}
+}
+
+func (mn *MemoryNotifier) Name() string { return NotifierMemory }
+
+func (mn *MemoryNotifier) Supports(deviceType int) bool { return true }
+
+func (mn *MemoryNotifier) Notify(push Push) error {
+	mn.m.Lock()
+	defer mn.m.Unlock()
+	mn.pushes = append(mn.pushes, push)
+	return nil
+}
+
+// Pushes returns notified pushes
+func (mn *MemoryNotifier) Pushes() []Push {
+	mn.m.Lock()
+	defer mn.m.Unlock()
+	return append([]Push{}, mn.pushes...)
+}
+
+// FileNotifier appends pushes to JSON lines file for local development
+type FileNotifier struct {
+	m    sync.Mutex
+	file *os.File
+}
+
+func NewFileNotifier(path string) (*FileNotifier, error) {
+	if path == "" {
+		path = "pushes.log"
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileNotifier{file: file}, nil
+}
+
+func (fn *FileNotifier) Name() string { return NotifierFile }
+
+func (fn *FileNotifier) Supports(deviceType int) bool { return true }
+
+func (fn *FileNotifier) Notify(push Push) error {
+	line, err := json.Marshal(struct {
+		Time int64 `json:"time"`
+		Push
+	}{time.Now().Unix(), push})
+	if err != nil {
+		return err
+	}
+	fn.m.Lock()
+	defer fn.m.Unlock()
+	_, err = fn.file.Write(append(line, '\n'))
+	return err
+}
+
+// NotifyClient consumes wallet events and pushes them to user devices
+type NotifyClient struct {
+	notifiers []Notifier
+	userStore store.UserStore
+	// largeOutgoing are amounts of outgoing transactions raising a security event by currency id
+	largeOutgoing map[int]*big.Int
+
+	nsqConsumer         *nsq.Consumer
+	nsqConsumerSecurity *nsq.Consumer
+
+	log slf.StructuredLogger
+}
+
+func InitNotifyClient(conf NotifiersConf, nsqAddr string, userStore store.UserStore) (*NotifyClient, error) {
+	nClient := &NotifyClient{
+		userStore: userStore,
+		log:       slf.WithContext("notify"),
+	}
+	nClient.log.Info("Push notifications initialization")
+
+	notifiers, err := NewNotifiers(conf)
+	if err != nil {
+		return nil, err
+	}
+	nClient.notifiers = notifiers
+
+	nClient.largeOutgoing, err = largeOutgoingLimits(conf.LargeOutgoing)
+	if err != nil {
+		return nil, err
+	}
+
+	nsqConsumer, err := nsq.NewConsumer(store.TopicTransaction, "firebase", nsq.NewConfig())
+	if err != nil {
+		return nil, fmt.Errorf("new nsq consumer: %s", err.Error())
+	}
+	nsqConsumer.AddHandler(nsq.HandlerFunc(func(message *nsq.Message) error {
+		msg := store.TransactionWithUserID{}
+		err := json.Unmarshal(message.Body, &msg)
+		if err != nil {
+			return err
+		}
+		if msg.NotificationMsg == nil {
+			return nil
+		}
+		if largeOutgoing(msg.NotificationMsg, nClient.largeOutgoing) {
+			nClient.securityOutgoing(msg)
+		}
+		nClient.notify(msg)
+		return nil
+	}))
+	if err = nsqConsumer.ConnectToNSQD(nsqAddr); err != nil {
+		return nil, fmt.Errorf("connecting to nsq: %s", err.Error())
+	}
+	nClient.nsqConsumer = nsqConsumer
+
+	nsqConsumerSecurity, err := nsq.NewConsumer(store.TopicSecurity, "firebase", nsq.NewConfig())
+	if err != nil {
+		return nil, fmt.Errorf("new nsq consumer: %s", err.Error())
+	}
+	nsqConsumerSecurity.AddHandler(nsq.HandlerFunc(func(message *nsq.Message) error {
+		event := store.SecurityEvent{}
+		err := json.Unmarshal(message.Body, &event)
+		if err != nil {
+			return err
+		}
+		nClient.notifySecurity(event)
+		return nil
+	}))
+	if err = nsqConsumerSecurity.ConnectToNSQD(nsqAddr); err != nil {
+		return nil, fmt.Errorf("connecting to nsq: %s", err.Error())
+	}
+	nClient.nsqConsumerSecurity = nsqConsumerSecurity
+
+	nClient.log.Debugf("Push notifications initialization done")
+	return nClient, nil
+}
+
+// notify sends the event to every device of the user its preferences allow,
+// pushes go to device tokens as devices differ in preferences and language
+func (nClient *NotifyClient) notify(msg store.TransactionWithUserID) {
+	event := notifyEvent(msg.NotificationMsg.TransactionType)
+	if event == "" {
+		return
+	}
+
+	user := store.User{}
+	err := nClient.userStore.FindUser(bson.M{"userID": msg.UserID}, &user)
+	if err != nil {
+		nClient.log.Errorf("notify: userStore.FindUser: %s", err.Error())
+		return
+	}
+
+	now := time.Now()
+	for _, device := range user.Devices {
+		if device.PushToken == "" || !notifyAllowed(devicePreferences(user, device), event, msg.NotificationMsg, now) {
+			continue
+		}
+		notifier, ok := routeNotifier(nClient.notifiers, device.DeviceType)
+		if !ok {
+			continue
+		}
+		err := notifier.Notify(devicePush(event, msg, device))
+		if err != nil {
+			nClient.log.Errorf("notify: %s: %s", notifier.Name(), err.Error())
+		}
+	}
+}
+
+// devicePush builds localized push of the event to the device
+func devicePush(event string, msg store.TransactionWithUserID, device store.Device) Push {
+	title, body := localizedPush(device.Locale, event, msg.NotificationMsg)
+	return Push{
+		Token:      device.PushToken,
+		DeviceType: device.DeviceType,
+		Title:      title,
+		Body:       body,
+		Data: map[string]string{
+			"score":           "1",
+			"time":            time.Now().Format(time.Kitchen),
+			"amount":          msg.NotificationMsg.Amount,
+			"transactionType": strconv.Itoa(msg.NotificationMsg.TransactionType),
+			"currencyid":      strconv.Itoa(msg.NotificationMsg.CurrencyID),
+			"networkid":       strconv.Itoa(msg.NotificationMsg.NetworkID),
+			"walletindex":     strconv.Itoa(msg.NotificationMsg.WalletIndex),
+			"txid":            msg.NotificationMsg.TxID,
+			"event":           event,
+		},
+	}
+}
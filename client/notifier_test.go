@@ -0,0 +1,74 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"testing"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+)
+
+// iosOnly is a provider like APNs which can't deliver to Android
+type iosOnly struct {
+	*MemoryNotifier
+}
+
+func (iosOnly) Supports(deviceType int) bool { return deviceType == DeviceTypeIOS }
+
+func TestRouteNotifier(t *testing.T) {
+	apns := iosOnly{NewMemoryNotifier()}
+	memory := NewMemoryNotifier()
+	notifiers := []Notifier{apns, memory}
+
+	if n, ok := routeNotifier(notifiers, DeviceTypeIOS); !ok || n != Notifier(apns) {
+		t.Errorf("ios device routed to %v", n)
+	}
+	if n, ok := routeNotifier(notifiers, DeviceTypeAndroid); !ok || n != Notifier(memory) {
+		t.Errorf("android device routed to %v", n)
+	}
+	if _, ok := routeNotifier([]Notifier{apns}, DeviceTypeAndroid); ok {
+		t.Errorf("android device routed to ios only provider")
+	}
+}
+
+func TestNewNotifiersWithoutCredentials(t *testing.T) {
+	notifiers, err := NewNotifiers(NotifiersConf{Providers: []string{NotifierMemory}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(notifiers) != 1 || notifiers[0].Name() != NotifierMemory {
+		t.Fatalf("unexpected notifiers %v", notifiers)
+	}
+
+	if _, err := NewNotifiers(NotifiersConf{Providers: []string{"pigeon"}}); err == nil {
+		t.Errorf("unknown provider accepted")
+	}
+}
+
+func TestDevicePush(t *testing.T) {
+	msg := store.TransactionWithUserID{
+		UserID: "user",
+		NotificationMsg: &store.WsTxNotify{
+			CurrencyID:      currencies.Bitcoin,
+			TransactionType: store.TxStatusAppearedInMempoolIncoming,
+			Amount:          "150000000",
+			TxID:            "txid",
+		},
+	}
+	device := store.Device{PushToken: "token", DeviceType: DeviceTypeIOS, Locale: "en_US"}
+
+	push := devicePush(store.NotifyIncoming, msg, device)
+	if push.Token != "token" || push.DeviceType != DeviceTypeIOS {
+		t.Errorf("wrong push target %+v", push)
+	}
+	if push.Title != "Incoming transaction" || push.Body != "You received 1.5 Bitcoin" {
+		t.Errorf("wrong push text %q %q", push.Title, push.Body)
+	}
+	if push.Data["txid"] != "txid" || push.Data["event"] != store.NotifyIncoming {
+		t.Errorf("wrong push data %v", push.Data)
+	}
+}
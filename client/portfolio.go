@@ -0,0 +1,370 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/gin-gonic/gin"
+	mgo "gopkg.in/mgo.v2"
+
+	ethpb "github.com/Multy-io/Multy-back/node-streamer/eth"
+)
+
+const (
+	// pastRateLookback is how many candles before the time the past rate is looked for
+	pastRateLookback = 3
+
+	msgErrPortfolioFiat    = "wrong fiat"
+	msgErrPortfolioBalance = "can't get balances"
+)
+
+// PortfolioWallet is a wallet with it's value, balances are in the smallest units of the currency.
+// Pending is the change of the balance by transactions not confirmed yet, it could be negative.
+type PortfolioWallet struct {
+	CurrencyID      int     `json:"currencyid"`
+	NetworkID       int     `json:"networkid"`
+	WalletIndex     int     `json:"walletindex"`
+	WalletName      string  `json:"walletname"`
+	ContractAddress string  `json:"contractaddress,omitempty"`
+	InviteCode      string  `json:"invitecode,omitempty"`
+	Balance         string  `json:"balance"`
+	Pending         string  `json:"pending"`
+	Amount          float64 `json:"amount"`
+	Value           float64 `json:"value"`
+	Change          float64 `json:"change24h"`
+	ChangePercent   float64 `json:"changepercent24h"`
+	// Error is set when the balance can't be got, the wallet isn't valued then
+	Error string `json:"error,omitempty"`
+}
+
+// PortfolioCurrency sums wallets of the currency
+type PortfolioCurrency struct {
+	CurrencyID    int     `json:"currencyid"`
+	Amount        float64 `json:"amount"`
+	Rate          float64 `json:"rate"`
+	Rate24h       float64 `json:"rate24h"`
+	Value         float64 `json:"value"`
+	Change        float64 `json:"change24h"`
+	ChangePercent float64 `json:"changepercent24h"`
+}
+
+// Portfolio values wallets in the fiat. Wallet values are rounded to cents and currency and total
+// values are sums of them, so apps show numbers adding up without own math.
+// Partial portfolio misses values of wallets with errors.
+type Portfolio struct {
+	Fiat          string              `json:"fiat"`
+	Wallets       []PortfolioWallet   `json:"wallets"`
+	Currencies    []PortfolioCurrency `json:"currencies"`
+	Value         float64             `json:"value"`
+	Change        float64             `json:"change24h"`
+	ChangePercent float64             `json:"changepercent24h"`
+	Updated       int64               `json:"updated"`
+	Partial       bool                `json:"partial"`
+}
+
+// portfolioRate is the price of the currency now and a day ago
+type portfolioRate struct {
+	now, day float64
+}
+
+// valuePortfolio values wallet balances by rates of their currencies, the change is of the rate
+// for the last day with balances as they're now
+func valuePortfolio(fiat string, wallets []PortfolioWallet, rates map[int]portfolioRate) Portfolio {
+	portfolio := Portfolio{
+		Fiat:       fiat,
+		Wallets:    []PortfolioWallet{},
+		Currencies: []PortfolioCurrency{},
+	}
+	sums := map[int]int{}
+	for _, wallet := range wallets {
+		if wallet.Error != "" {
+			portfolio.Wallets = append(portfolio.Wallets, wallet)
+			portfolio.Partial = true
+			continue
+		}
+		rate := rates[wallet.CurrencyID]
+		wallet.Amount = coinAmount(wallet.CurrencyID, wallet.Balance, wallet.Pending)
+		wallet.Value = roundFiat(wallet.Amount * rate.now)
+		if rate.day > 0 {
+			wallet.Change = roundFiat(wallet.Value - wallet.Amount*rate.day)
+		}
+		wallet.ChangePercent = changePercent(wallet.Value, wallet.Change)
+		portfolio.Wallets = append(portfolio.Wallets, wallet)
+
+		i, ok := sums[wallet.CurrencyID]
+		if !ok {
+			portfolio.Currencies = append(portfolio.Currencies, PortfolioCurrency{
+				CurrencyID: wallet.CurrencyID,
+				Rate:       rate.now,
+				Rate24h:    rate.day,
+			})
+			i = len(portfolio.Currencies) - 1
+			sums[wallet.CurrencyID] = i
+		}
+		sum := &portfolio.Currencies[i]
+		sum.Amount += wallet.Amount
+		sum.Value = roundFiat(sum.Value + wallet.Value)
+		sum.Change = roundFiat(sum.Change + wallet.Change)
+	}
+
+	for i := range portfolio.Currencies {
+		sum := &portfolio.Currencies[i]
+		sum.ChangePercent = changePercent(sum.Value, sum.Change)
+		portfolio.Value = roundFiat(portfolio.Value + sum.Value)
+		portfolio.Change = roundFiat(portfolio.Change + sum.Change)
+	}
+	portfolio.ChangePercent = changePercent(portfolio.Value, portfolio.Change)
+	return portfolio
+}
+
+// coinAmount returns the balance with pending changes in coins, like BTC for satoshis
+func coinAmount(currencyID int, balance, pending string) float64 {
+	divider, ok := currencies.Dividers[currencyID]
+	if !ok {
+		return 0
+	}
+	total := new(big.Int)
+	for _, part := range []string{balance, pending} {
+		amount, ok := new(big.Int).SetString(part, 10)
+		if ok {
+			total.Add(total, amount)
+		}
+	}
+	if total.Sign() < 0 {
+		return 0
+	}
+	amount, _ := new(big.Float).Quo(new(big.Float).SetInt(total), new(big.Float).SetInt64(divider)).Float64()
+	return amount
+}
+
+// changePercent returns the change in percents of the value before it
+func changePercent(value, change float64) float64 {
+	before := value - change
+	if before <= 0 {
+		return 0
+	}
+	return roundFiat(change / before * 100)
+}
+
+// roundFiat rounds to cents, negative values rounded to zero are zeros
+func roundFiat(value float64) float64 {
+	rounded := math.Round(value*100) / 100
+	if rounded == 0 {
+		return 0
+	}
+	return rounded
+}
+
+// pastRate returns the close of the last candle of the pair completed by the time, minute candles
+// are tried first and hour ones are used when they're missed
+func (restClient *RestClient) pastRate(pair string, at time.Time) (float64, error) {
+	for _, name := range []string{Candle1m, Candle1h} {
+		interval, _ := findCandleInterval(name)
+		to := interval.start(at) - int64(interval.period.Seconds())
+		candles, err := restClient.userStore.FindCandles(pair, interval.name, to-pastRateLookback*int64(interval.period.Seconds()), to)
+		if err != nil {
+			return 0, err
+		}
+		if len(candles) > 0 {
+			return candles[len(candles)-1].Close, nil
+		}
+	}
+	return 0, nil
+}
+
+// btcBalance sums spendable outputs of the addresses, outputs of mempool transactions are pending
+func (restClient *RestClient) btcBalance(addresses []string, networkID int) (string, string, error) {
+	var balance, pending int64
+	for _, address := range addresses {
+		outs, err := restClient.userStore.GetAddressSpendableOutputs(address, currencies.Bitcoin, networkID)
+		if err != nil && err != mgo.ErrNotFound {
+			return "", "", fmt.Errorf("GetAddressSpendableOutputs: %s", err.Error())
+		}
+		for _, out := range outs {
+			if out.TxStatus == store.TxStatusAppearedInMempoolIncoming || out.TxStatus == store.TxStatusAppearedInMempoolOutcoming {
+				pending += out.TxOutAmount
+				continue
+			}
+			balance += out.TxOutAmount
+		}
+	}
+	return strconv.FormatInt(balance, 10), strconv.FormatInt(pending, 10), nil
+}
+
+// btcWalletBalance sums spendable outputs of the wallet addresses
+func (restClient *RestClient) btcWalletBalance(wallet store.Wallet) (string, string, error) {
+	addresses := []string{}
+	for _, address := range wallet.Adresses {
+		addresses = append(addresses, address.Address)
+	}
+	return restClient.btcBalance(addresses, wallet.NetworkID)
+}
+
+// ethBalance returns the balance of the address and the change of it by pending transactions
+func (restClient *RestClient) ethBalance(address string, networkID int) (*big.Int, *big.Int, error) {
+	var (
+		amount *ethpb.Balance
+		err    error
+	)
+	adr := ethpb.AddressToResync{Address: address}
+	switch networkID {
+	case currencies.ETHMain:
+		amount, err = restClient.ETH.CliMain.EventGetAdressBalance(context.Background(), &adr)
+	case currencies.ETHTest:
+		amount, err = restClient.ETH.CliTest.EventGetAdressBalance(context.Background(), &adr)
+	default:
+		return nil, nil, errors.New(msgErrMethodNotImplennted)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("EventGetAdressBalance: %s", err.Error())
+	}
+
+	balance, ok := new(big.Int).SetString(amount.GetBalance(), 10)
+	if !ok {
+		balance = new(big.Int)
+	}
+	pending := new(big.Int)
+	// pending balance is the balance with pending transactions
+	if withPending, ok := new(big.Int).SetString(amount.GetPendingBalance(), 10); ok {
+		pending.Sub(withPending, balance)
+	}
+	return balance, pending, nil
+}
+
+// ethWalletBalance sums balances of the wallet addresses
+func (restClient *RestClient) ethWalletBalance(wallet store.Wallet) (string, string, error) {
+	balance, pending := new(big.Int), new(big.Int)
+	for _, address := range wallet.Adresses {
+		addressBalance, addressPending, err := restClient.ethBalance(address.Address, wallet.NetworkID)
+		if err != nil {
+			return "", "", err
+		}
+		balance.Add(balance, addressBalance)
+		pending.Add(pending, addressPending)
+	}
+	return balance.String(), pending.String(), nil
+}
+
+// portfolioWallets returns balances of mainnet wallets and multisigs of the user,
+// coins of test networks are worth nothing. Wallets which balances can't be got have the error.
+func (restClient *RestClient) portfolioWallets(user store.User) []PortfolioWallet {
+	wallets := []PortfolioWallet{}
+	for _, wallet := range fetchUndeletedWallets(user.Wallets) {
+		portfolioWallet := PortfolioWallet{
+			CurrencyID:  wallet.CurrencyID,
+			NetworkID:   wallet.NetworkID,
+			WalletIndex: wallet.WalletIndex,
+			WalletName:  wallet.WalletName,
+		}
+		var err error
+		switch {
+		case wallet.CurrencyID == currencies.Bitcoin && wallet.NetworkID == currencies.Main:
+			portfolioWallet.Balance, portfolioWallet.Pending, err = restClient.btcWalletBalance(wallet)
+		case wallet.CurrencyID == currencies.Ether && wallet.NetworkID == currencies.ETHMain:
+			portfolioWallet.Balance, portfolioWallet.Pending, err = restClient.ethWalletBalance(wallet)
+		default:
+			continue
+		}
+		if err != nil {
+			restClient.log.Errorf("portfolioWallets: wallet %d: %s", wallet.WalletIndex, err.Error())
+			portfolioWallet.Error = msgErrPortfolioBalance
+		}
+		wallets = append(wallets, portfolioWallet)
+	}
+
+	for _, multisig := range user.Multisigs {
+		portfolioWallet := PortfolioWallet{
+			CurrencyID:      multisig.CurrencyID,
+			NetworkID:       multisig.NetworkID,
+			WalletName:      multisig.WalletName,
+			ContractAddress: multisig.ContractAddress,
+			InviteCode:      multisig.InviteCode,
+		}
+		var err error
+		switch {
+		case multisig.CurrencyID == currencies.Bitcoin && multisig.NetworkID == currencies.Main && len(multisig.Addresses) > 0:
+			addresses := []string{}
+			for _, ma := range multisig.Addresses {
+				addresses = append(addresses, ma.Address)
+			}
+			portfolioWallet.Balance, portfolioWallet.Pending, err = restClient.btcBalance(addresses, multisig.NetworkID)
+		case multisig.CurrencyID == currencies.Ether && multisig.NetworkID == currencies.ETHMain && multisig.ContractAddress != "":
+			var balance, pending *big.Int
+			balance, pending, err = restClient.ethBalance(multisig.ContractAddress, multisig.NetworkID)
+			if err == nil {
+				portfolioWallet.Balance, portfolioWallet.Pending = balance.String(), pending.String()
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			restClient.log.Errorf("portfolioWallets: multisig %s%s: %s", multisig.ContractAddress, multisig.InviteCode, err.Error())
+			portfolioWallet.Error = msgErrPortfolioBalance
+		}
+		wallets = append(wallets, portfolioWallet)
+	}
+	return wallets
+}
+
+// getPortfolio values wallets of the user by canonical rates, ?fiat=USD
+func (restClient *RestClient) getPortfolio() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if restClient.chart == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"code":    http.StatusServiceUnavailable,
+				"message": msgErrRatesUnavailable,
+			})
+			return
+		}
+		fiat := strings.ToUpper(c.DefaultQuery("fiat", fiatUSD))
+		if !isRateFiat(restClient.chart.aggregator.fiats, fiat) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrPortfolioFiat,
+			})
+			return
+		}
+
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+		// wallets which balances can't be got are listed with the error
+		wallets := restClient.portfolioWallets(user)
+
+		now := time.Now()
+		rates := map[int]portfolioRate{}
+		for currencyID, coin := range rateCoins {
+			day, err := restClient.pastRate(store.RatePair(coin, fiat), now.Add(-time.Hour*24))
+			if err != nil {
+				restClient.log.Errorf("getPortfolio: pastRate: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			}
+			rates[currencyID] = portfolioRate{
+				now: restClient.chart.fiatPrice(currencyID, fiat),
+				day: day,
+			}
+		}
+
+		portfolio := valuePortfolio(fiat, wallets, rates)
+		portfolio.Updated = now.Unix()
+		c.JSON(http.StatusOK, gin.H{
+			"code":      http.StatusOK,
+			"message":   http.StatusText(http.StatusOK),
+			"portfolio": portfolio,
+		})
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/jekabolt/slf"
+)
+
+func TestValuePortfolio(t *testing.T) {
+	wallets := []PortfolioWallet{
+		{CurrencyID: currencies.Bitcoin, WalletIndex: 0, Balance: "150000000", Pending: "50000000"},
+		{CurrencyID: currencies.Ether, NetworkID: currencies.ETHMain, WalletIndex: 0, Balance: "20000000000000000000", Pending: "-10000000000000000000"},
+		{CurrencyID: currencies.Bitcoin, WalletIndex: 1, Balance: "333", Pending: "0"},
+		{CurrencyID: currencies.Ether, NetworkID: currencies.ETHMain, ContractAddress: "0x1", Balance: "500000000000000000", Pending: "0"},
+	}
+	rates := map[int]portfolioRate{
+		currencies.Bitcoin: {now: 4000, day: 3200},
+		currencies.Ether:   {now: 120},
+	}
+	portfolio := valuePortfolio("USD", wallets, rates)
+
+	values := []string{}
+	for _, wallet := range portfolio.Wallets {
+		values = append(values, fmt.Sprintf("%g/%g/%g/%g", wallet.Amount, wallet.Value, wallet.Change, wallet.ChangePercent))
+	}
+	// wallets over 9.2 ether don't overflow, there is no change of ETH without the rate a day ago
+	if got := fmt.Sprint(values); got != "[2/8000/1600/25 10/1200/0/0 3.33e-06/0.01/0/0 0.5/60/0/0]" {
+		t.Errorf("wrong wallets %s", got)
+	}
+
+	sums := []string{}
+	for _, sum := range portfolio.Currencies {
+		sums = append(sums, fmt.Sprintf("%d:%g/%g/%g", sum.CurrencyID, sum.Value, sum.Change, sum.ChangePercent))
+	}
+	if got := fmt.Sprint(sums); got != "[0:8000.01/1600/25 60:1260/0/0]" {
+		t.Errorf("wrong currencies %s", got)
+	}
+	if portfolio.Value != 9260.01 || portfolio.Change != 1600 || portfolio.ChangePercent != 20.89 {
+		t.Errorf("wrong total %+v", portfolio)
+	}
+
+	if empty := valuePortfolio("EUR", nil, rates); len(empty.Wallets) != 0 || empty.Currencies == nil || empty.Value != 0 || empty.Partial {
+		t.Errorf("wrong empty portfolio %+v", empty)
+	}
+
+	// wallets with errors are listed without values
+	wallets = append(wallets, PortfolioWallet{CurrencyID: currencies.Bitcoin, WalletIndex: 2, Error: msgErrPortfolioBalance})
+	if partial := valuePortfolio("USD", wallets, rates); !partial.Partial || len(partial.Wallets) != 5 || partial.Value != portfolio.Value {
+		t.Errorf("wrong partial portfolio %+v", partial)
+	}
+}
+
+// outputsStore keeps spendable outputs by address, outputs of failAddress can't be read
+type outputsStore struct {
+	store.UserStore
+	outputs     map[string][]store.SpendableOutputs
+	failAddress string
+}
+
+func (outs *outputsStore) GetAddressSpendableOutputs(address string, currencyID, networkID int) ([]store.SpendableOutputs, error) {
+	if address == outs.failAddress {
+		return nil, errors.New("db is down")
+	}
+	return outs.outputs[address], nil
+}
+
+func TestPortfolioWallets(t *testing.T) {
+	db := &outputsStore{
+		outputs: map[string][]store.SpendableOutputs{
+			"1wallet":   {{TxOutAmount: 1000}, {TxOutAmount: 500, TxStatus: store.TxStatusAppearedInMempoolIncoming}},
+			"3multisig": {{TxOutAmount: 7000}},
+			"3change":   {{TxOutAmount: 3000}},
+		},
+		failAddress: "1broken",
+	}
+	restClient := &RestClient{userStore: db, log: slf.WithContext("test")}
+	user := store.User{
+		Wallets: []store.Wallet{
+			{CurrencyID: currencies.Bitcoin, NetworkID: currencies.Main, WalletIndex: 0, Adresses: []store.Address{{Address: "1wallet"}}, Status: store.WalletStatusOK},
+			{CurrencyID: currencies.Bitcoin, NetworkID: currencies.Main, WalletIndex: 1, Adresses: []store.Address{{Address: "1broken"}}, Status: store.WalletStatusOK},
+			{CurrencyID: currencies.Bitcoin, NetworkID: currencies.Test, WalletIndex: 2, Adresses: []store.Address{{Address: "mtest"}}, Status: store.WalletStatusOK},
+		},
+		Multisigs: []store.Multisig{
+			{CurrencyID: currencies.Bitcoin, NetworkID: currencies.Main, InviteCode: "5f0c", Addresses: []store.MultisigAddress{{Address: "3multisig"}, {Address: "3change"}}},
+			{CurrencyID: currencies.Bitcoin, NetworkID: currencies.Test, InviteCode: "aa01", Addresses: []store.MultisigAddress{{Address: "2test"}}},
+		},
+	}
+
+	wallets := []string{}
+	for _, wallet := range restClient.portfolioWallets(user) {
+		wallets = append(wallets, fmt.Sprintf("%d%s:%s/%s/%s", wallet.WalletIndex, wallet.InviteCode, wallet.Balance, wallet.Pending, wallet.Error))
+	}
+	if got := fmt.Sprint(wallets); got != "[0:1000/500/ 1://"+msgErrPortfolioBalance+" 05f0c:10000/0/]" {
+		t.Errorf("wrong wallets %s", got)
+	}
+}
+
+func TestPastRate(t *testing.T) {
+	db := &candlesStore{candles: map[string]store.Candle{}}
+	restClient := &RestClient{userStore: db}
+
+	at := time.Unix(1550000000, 0).Truncate(time.Hour).Add(time.Minute * 30)
+	db.InsertCandles([]store.Candle{
+		{Pair: "BTC-USD", Interval: Candle1h, Time: at.Truncate(time.Hour).Add(-time.Hour).Unix(), Close: 3500},
+		{Pair: "BTC-USD", Interval: Candle1m, Time: at.Add(-time.Minute * 3).Unix(), Close: 3590},
+		{Pair: "BTC-USD", Interval: Candle1m, Time: at.Add(-time.Minute * 2).Unix(), Close: 3600},
+		// the candle isn't completed by the time
+		{Pair: "BTC-USD", Interval: Candle1m, Time: at.Unix(), Close: 3700},
+	})
+
+	if rate, err := restClient.pastRate("BTC-USD", at); err != nil || rate != 3600 {
+		t.Errorf("minute rate %v %v", rate, err)
+	}
+	// minutes are retained for a while, hours are used then
+	if rate, _ := restClient.pastRate("BTC-USD", at.Add(time.Minute*10)); rate != 3500 {
+		t.Errorf("hour rate %v", rate)
+	}
+	if rate, _ := restClient.pastRate("ETH-USD", at); rate != 0 {
+		t.Errorf("rate without candles %v", rate)
+	}
+}
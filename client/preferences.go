@@ -0,0 +1,248 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"errors"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	msgErrPreferences = "wrong notification preferences"
+	quietHoursLayout  = "15:04"
+)
+
+// notifyEvent returns event type of the notification to check preferences
+func notifyEvent(transactionType int) string {
+	switch transactionType {
+	case store.TxStatusAppearedInMempoolIncoming:
+		return store.NotifyIncoming
+	case store.TxStatusAppearedInMempoolOutcoming:
+		return store.NotifyOutgoing
+	case store.TxStatusAppearedInBlockIncoming, store.TxStatusAppearedInBlockOutcoming:
+		return store.NotifyFirstConfirmation
+	case store.TxStatusInBlockConfirmedIncoming, store.TxStatusInBlockConfirmedOutcoming:
+		return store.NotifyFinalConfirmation
+	case store.MultisigOwnerAdded, store.MultisigOwnerRemoved, store.MultisigOwnersChanged,
+		store.MultisigSpendProposed, store.MultisigSpendSigned:
+		return store.NotifyMultisigAction
+	case store.PriceAlertTriggered:
+		return store.NotifyPriceAlert
+	case store.ExchangeStatusChanged:
+		return store.NotifyExchange
+	}
+	return ""
+}
+
+func knownEvent(event string) bool {
+	switch event {
+	case store.NotifyIncoming, store.NotifyOutgoing, store.NotifyFirstConfirmation,
+		store.NotifyFinalConfirmation, store.NotifyMultisigAction, store.NotifyPriceAlert, store.NotifyExchange:
+		return true
+	}
+	return false
+}
+
+// devicePreferences returns preferences used for the device, device ones override user ones
+func devicePreferences(user store.User, device store.Device) *store.NotifyPreferences {
+	if device.Preferences != nil {
+		return device.Preferences
+	}
+	return user.Preferences
+}
+
+// notifyAllowed checks preferences let push the notification of the event at the moment
+func notifyAllowed(p *store.NotifyPreferences, event string, msg *store.WsTxNotify, now time.Time) bool {
+	if p == nil {
+		return store.DefaultNotifyEvents[event]
+	}
+
+	enabled, ok := p.Events[event]
+	if !ok {
+		enabled = store.DefaultNotifyEvents[event]
+	}
+	if !enabled {
+		return false
+	}
+
+	// wallet mutes and minimal amounts are about transactions
+	if msg != nil && event != store.NotifyPriceAlert {
+		for _, mute := range p.MutedWallets {
+			if mute.CurrencyID != msg.CurrencyID || mute.NetworkID != msg.NetworkID {
+				continue
+			}
+			if mute.Address != "" {
+				if strings.EqualFold(mute.Address, msg.Address) {
+					return false
+				}
+				continue
+			}
+			if mute.WalletIndex == msg.WalletIndex {
+				return false
+			}
+		}
+
+		if min, ok := p.MinAmounts[strconv.Itoa(msg.CurrencyID)]; ok && msg.Amount != "" {
+			minAmount, okMin := new(big.Int).SetString(min, 10)
+			amount, okAmount := new(big.Int).SetString(strings.TrimPrefix(msg.Amount, "-"), 10)
+			if okMin && okAmount && amount.Cmp(minAmount) < 0 {
+				return false
+			}
+		}
+	}
+
+	return !inQuietHours(p.QuietHours, now)
+}
+
+func inQuietHours(q *store.QuietHours, now time.Time) bool {
+	if q == nil {
+		return false
+	}
+	location, err := time.LoadLocation(q.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	from, errFrom := time.Parse(quietHoursLayout, q.From)
+	to, errTo := time.Parse(quietHoursLayout, q.To)
+	if errFrom != nil || errTo != nil {
+		return false
+	}
+
+	local := now.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	start := from.Hour()*60 + from.Minute()
+	end := to.Hour()*60 + to.Minute()
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	// wraps midnight
+	return minute >= start || minute < end
+}
+
+func checkPreferences(p *store.NotifyPreferences) error {
+	if p == nil {
+		return nil
+	}
+	for event := range p.Events {
+		if !knownEvent(event) {
+			return errors.New("checkPreferences: unknown event " + event)
+		}
+	}
+	for currencyID, min := range p.MinAmounts {
+		if _, err := strconv.Atoi(currencyID); err != nil {
+			return errors.New("checkPreferences: wrong currency id " + currencyID)
+		}
+		if _, ok := new(big.Int).SetString(min, 10); !ok {
+			return errors.New("checkPreferences: wrong amount " + min)
+		}
+	}
+	if q := p.QuietHours; q != nil {
+		if _, err := time.LoadLocation(q.Timezone); err != nil {
+			return errors.New("checkPreferences: wrong timezone " + q.Timezone)
+		}
+		_, errFrom := time.Parse(quietHoursLayout, q.From)
+		_, errTo := time.Parse(quietHoursLayout, q.To)
+		if errFrom != nil || errTo != nil {
+			return errors.New("checkPreferences: wrong quiet hours")
+		}
+	}
+	return nil
+}
+
+type PreferencesParams struct {
+	// set preferences of the current device only, null preferences drop the device override
+	Device      bool                     `json:"device"`
+	Preferences *store.NotifyPreferences `json:"preferences"`
+}
+
+func (restClient *RestClient) getPreferences() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+		token, _ := getToken(c)
+
+		var device *store.NotifyPreferences
+		for _, d := range user.Devices {
+			if d.JWT == token {
+				device = d.Preferences
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"code":          http.StatusOK,
+			"message":       http.StatusText(http.StatusOK),
+			"preferences":   user.Preferences,
+			"device":        device,
+			"defaultEvents": store.DefaultNotifyEvents,
+		})
+	}
+}
+
+func (restClient *RestClient) setPreferences() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+		token, _ := getToken(c)
+
+		var pp PreferencesParams
+		err = decodeBody(c, &pp)
+		if err != nil {
+			restClient.log.Errorf("setPreferences: decodeBody: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrRequestBodyError,
+			})
+			return
+		}
+		err = checkPreferences(pp.Preferences)
+		if err != nil {
+			restClient.log.Errorf("setPreferences: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrPreferences,
+			})
+			return
+		}
+
+		sel := bson.M{"userID": user.UserID}
+		field := "preferences"
+		if pp.Device {
+			sel = bson.M{"userID": user.UserID, "devices.JWT": token}
+			field = "devices.$.preferences"
+		}
+		update := bson.M{"$set": bson.M{field: pp.Preferences}}
+		if pp.Preferences == nil {
+			update = bson.M{"$unset": bson.M{field: ""}}
+		}
+
+		err = restClient.userStore.Update(sel, update)
+		if err != nil {
+			restClient.log.Errorf("setPreferences: restClient.userStore.Update: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": msgErrServerError,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": http.StatusText(http.StatusOK),
+		})
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+)
+
+func TestNotifyAllowed(t *testing.T) {
+	// 12:30 UTC
+	noon := time.Date(2018, 6, 1, 12, 30, 0, 0, time.UTC)
+	night := time.Date(2018, 6, 1, 23, 30, 0, 0, time.UTC)
+	incoming := &store.WsTxNotify{CurrencyID: currencies.Bitcoin, NetworkID: currencies.Main, WalletIndex: 1, Amount: "5000"}
+	multisig := &store.WsTxNotify{CurrencyID: currencies.Ether, NetworkID: currencies.ETHMain, Address: "0xABC", TransactionType: store.MultisigSpendProposed}
+
+	prefs := &store.NotifyPreferences{
+		Events:       map[string]bool{store.NotifyOutgoing: true, store.NotifyExchange: false},
+		MinAmounts:   map[string]string{"0": "10000"},
+		MutedWallets: []store.WalletMute{{CurrencyID: currencies.Bitcoin, NetworkID: currencies.Main, WalletIndex: 2}, {CurrencyID: currencies.Ether, NetworkID: currencies.ETHMain, Address: "0xabc"}},
+		QuietHours:   &store.QuietHours{From: "23:00", To: "07:00", Timezone: "UTC"},
+	}
+
+	tests := map[string]struct {
+		p       *store.NotifyPreferences
+		event   string
+		msg     *store.WsTxNotify
+		now     time.Time
+		allowed bool
+	}{
+		"default incoming":       {nil, store.NotifyIncoming, incoming, noon, true},
+		"default outgoing":       {nil, store.NotifyOutgoing, incoming, noon, false},
+		"enabled outgoing":       {prefs, store.NotifyOutgoing, &store.WsTxNotify{Amount: "-20000"}, noon, true},
+		"disabled exchange":      {prefs, store.NotifyExchange, nil, noon, false},
+		"below min amount":       {prefs, store.NotifyIncoming, incoming, noon, false},
+		"muted wallet":           {prefs, store.NotifyIncoming, &store.WsTxNotify{WalletIndex: 2, Amount: "20000"}, noon, false},
+		"muted multisig":         {prefs, store.NotifyMultisigAction, multisig, noon, false},
+		"multisig without value": {prefs, store.NotifyMultisigAction, &store.WsTxNotify{CurrencyID: currencies.Bitcoin}, noon, true},
+		"price alert of muted":   {prefs, store.NotifyPriceAlert, &store.WsTxNotify{WalletIndex: 2}, noon, true},
+		"quiet hours":            {prefs, store.NotifyPriceAlert, nil, night, false},
+	}
+	for name, test := range tests {
+		if notifyAllowed(test.p, test.event, test.msg, test.now) != test.allowed {
+			t.Errorf("%s: allowed=%v", name, !test.allowed)
+		}
+	}
+}
+
+func TestInQuietHours(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2018, 6, 1, hour, minute, 0, 0, time.UTC)
+	}
+	day := &store.QuietHours{From: "13:00", To: "14:00", Timezone: "UTC"}
+	night := &store.QuietHours{From: "22:00", To: "07:00", Timezone: "Europe/Kiev"}
+	tests := []struct {
+		q     *store.QuietHours
+		now   time.Time
+		quiet bool
+	}{
+		{day, at(13, 0), true},
+		{day, at(14, 0), false},
+		{day, at(12, 59), false},
+		// Kiev is UTC+3 in summer
+		{night, at(19, 30), true},
+		{night, at(3, 59), true},
+		{night, at(4, 0), false},
+		{nil, at(0, 0), false},
+	}
+	for _, test := range tests {
+		if inQuietHours(test.q, test.now) != test.quiet {
+			t.Errorf("%+v at %v: quiet=%v", test.q, test.now, !test.quiet)
+		}
+	}
+}
+
+func TestCheckPreferences(t *testing.T) {
+	valid := []*store.NotifyPreferences{
+		nil,
+		{Events: map[string]bool{store.NotifyMultisigAction: false}, MinAmounts: map[string]string{"60": "1000000000"}},
+		{QuietHours: &store.QuietHours{From: "22:00", To: "07:00", Timezone: "America/New_York"}},
+	}
+	for _, p := range valid {
+		if err := checkPreferences(p); err != nil {
+			t.Errorf("%+v: %s", p, err.Error())
+		}
+	}
+
+	wrong := []*store.NotifyPreferences{
+		{Events: map[string]bool{"spam": true}},
+		{MinAmounts: map[string]string{"btc": "1"}},
+		{MinAmounts: map[string]string{"0": "0.1"}},
+		{QuietHours: &store.QuietHours{From: "22:00", To: "07:00", Timezone: "Mars/Olympus"}},
+		{QuietHours: &store.QuietHours{From: "10pm", To: "07:00", Timezone: "UTC"}},
+	}
+	for _, p := range wrong {
+		if err := checkPreferences(p); err == nil {
+			t.Errorf("%+v accepted", p)
+		}
+	}
+}
+
+func TestMultisigPush(t *testing.T) {
+	// multisig events have no amount
+	for _, transactionType := range []int{store.MultisigOwnerAdded, store.MultisigSpendProposed, store.MultisigSpendSigned} {
+		msg := store.TransactionWithUserID{UserID: "alice", NotificationMsg: &store.WsTxNotify{CurrencyID: currencies.Bitcoin, TransactionType: transactionType}}
+		event := notifyEvent(transactionType)
+		if event != store.NotifyMultisigAction {
+			t.Errorf("%d: event %s", transactionType, event)
+		}
+		push := devicePush(event, msg, store.Device{PushToken: "push", Locale: "ru_RU"})
+		if push.Data["event"] != store.NotifyMultisigAction {
+			t.Errorf("wrong push %+v", push)
+		}
+	}
+	if amount := convertToHuman("", currencies.Dividers[currencies.Bitcoin]); amount != "" {
+		t.Errorf("wrong empty amount %q", amount)
+	}
+	if amount := convertToHuman("150000000", currencies.Dividers[currencies.Bitcoin]); amount != "1.5" {
+		t.Errorf("wrong amount %q", amount)
+	}
+}
@@ -0,0 +1,412 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/gin-gonic/gin"
+	"github.com/jekabolt/slf"
+	nsq "github.com/nsqio/go-nsq"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	fiatUSD = "USD"
+	fiatEUR = "EUR"
+
+	priceAlertsInterval       = updateExchangeClient
+	priceHistoryLimit         = 24 * time.Hour
+	defaultPriceAlertCooldown = time.Hour
+	minPriceAlertWindow       = time.Minute
+	minPriceAlertCooldown     = time.Minute
+	maxPriceAlertsPerUser     = 50
+
+	msgErrPriceAlert       = "wrong price alert"
+	msgErrPriceAlertsLimit = "too many price alerts"
+	msgErrNoPriceAlert     = "no such price alert"
+)
+
+// priceAlertCurrencies are currencies exchangeChart has fiat rates of
+var priceAlertCurrencies = map[int]int{
+	currencies.Bitcoin: currencies.Main,
+	currencies.Ether:   currencies.ETHMain,
+}
+
+type priceSample struct {
+	time  int64
+	price float64
+}
+
+// priceAlerts evaluates users price alerts as exchange rates update
+// and sends fired ones to push and socket.io through NSQ
+type priceAlerts struct {
+	chart       *exchangeChart
+	db          store.UserStore
+	nsqProducer *nsq.Producer
+
+	// fiat price history of the pair for change alerts
+	m       sync.Mutex
+	history map[string][]priceSample
+	// last evaluated price of the pair, alerts are evaluated only when it moves
+	last map[string]float64
+
+	log slf.StructuredLogger
+}
+
+func newPriceAlerts(chart *exchangeChart, db store.UserStore, nsqAddr string) (*priceAlerts, error) {
+	producer, err := nsq.NewProducer(nsqAddr, nsq.NewConfig())
+	if err != nil {
+		return nil, fmt.Errorf("nsq.NewProducer: %s", err.Error())
+	}
+	alerts := &priceAlerts{
+		chart:       chart,
+		db:          db,
+		nsqProducer: producer,
+		history:     map[string][]priceSample{},
+		last:        map[string]float64{},
+		log:         slf.WithContext("priceAlerts"),
+	}
+	go alerts.run()
+	return alerts, nil
+}
+
+func pricePair(currencyID int, fiat string) string {
+	return fmt.Sprintf("%d-%s", currencyID, fiat)
+}
+
+func (pa *priceAlerts) run() {
+	ticker := time.NewTicker(priceAlertsInterval)
+	for now := range ticker.C {
+		prices := pa.updateHistory(now)
+		for currencyID := range priceAlertCurrencies {
+			for _, fiat := range pa.chart.aggregator.fiats {
+				pair := pricePair(currencyID, fiat)
+				price, ok := prices[pair]
+				if !ok || !pa.moved(pair, price) {
+					continue
+				}
+				pa.evaluatePair(currencyID, fiat, price, now)
+			}
+		}
+	}
+}
+
+// moved checks the price of the pair differs from the last evaluated one
+func (pa *priceAlerts) moved(pair string, price float64) bool {
+	pa.m.Lock()
+	defer pa.m.Unlock()
+	if pa.last[pair] == price {
+		return false
+	}
+	pa.last[pair] = price
+	return true
+}
+
+func (pa *priceAlerts) evaluatePair(currencyID int, fiat string, price float64, now time.Time) {
+	err := pa.db.RearmPriceAlerts(currencyID, fiat, price)
+	if err != nil {
+		pa.log.Errorf("evaluatePair: db.RearmPriceAlerts: %s", err.Error())
+	}
+
+	alerts, err := pa.db.FindPriceAlertsToEvaluate(currencyID, fiat, price)
+	if err != nil {
+		pa.log.Errorf("evaluatePair: db.FindPriceAlertsToEvaluate: %s", err.Error())
+		return
+	}
+	pair := pricePair(currencyID, fiat)
+	pa.m.Lock()
+	history := pa.history[pair]
+	pa.m.Unlock()
+	for _, alert := range alerts {
+		pa.evaluate(alert, price, history, now)
+	}
+}
+
+// updateHistory samples current prices of all pairs, pairs without rates yet are skipped
+func (pa *priceAlerts) updateHistory(now time.Time) map[string]float64 {
+	prices := map[string]float64{}
+	since := now.Add(-priceHistoryLimit).Unix()
+
+	pa.m.Lock()
+	defer pa.m.Unlock()
+	for currencyID := range priceAlertCurrencies {
+		for _, fiat := range pa.chart.aggregator.fiats {
+			price := pa.chart.fiatPrice(currencyID, fiat)
+			if price <= 0 {
+				continue
+			}
+			pair := pricePair(currencyID, fiat)
+			prices[pair] = price
+
+			history := append(pa.history[pair], priceSample{time: now.Unix(), price: price})
+			for len(history) > 0 && history[0].time < since {
+				history = history[1:]
+			}
+			pa.history[pair] = history
+		}
+	}
+	return prices
+}
+
+func (pa *priceAlerts) evaluate(alert store.PriceAlert, price float64, history []priceSample, now time.Time) {
+	met, change := priceAlertMet(alert, price, history, now)
+	if !met {
+		if alert.Triggered {
+			// rearm the alert
+			err := pa.db.UpdatePriceAlert(bson.M{"alertid": alert.AlertID, "triggered": true}, bson.M{"$set": bson.M{"triggered": false}})
+			if err != nil && err != mgo.ErrNotFound {
+				pa.log.Errorf("evaluate: db.UpdatePriceAlert: %s", err.Error())
+			}
+		}
+		return
+	}
+	if !priceAlertFires(alert, now) {
+		return
+	}
+
+	// the alert fires once: only the evaluation updating it as it was read notifies,
+	// others of this or another instance don't match
+	sel := bson.M{"alertid": alert.AlertID, "triggered": false, "lasttriggered": alert.LastTriggered}
+	err := pa.db.UpdatePriceAlert(sel, bson.M{"$set": bson.M{
+		"triggered":     true,
+		"lasttriggered": now.Unix(),
+		"lastprice":     price,
+	}})
+	if err == mgo.ErrNotFound {
+		return
+	}
+	if err != nil {
+		// don't notify what can't be deduplicated
+		pa.log.Errorf("evaluate: db.UpdatePriceAlert: %s", err.Error())
+		return
+	}
+	pa.notify(alert, price, change)
+}
+
+func (pa *priceAlerts) notify(alert store.PriceAlert, price, change float64) {
+	amount := fmt.Sprintf("%.2f %s", price, alert.Fiat)
+	if alert.Condition == store.PriceChange {
+		amount += fmt.Sprintf(" (%+.2f%%)", change)
+	}
+	msg := store.TransactionWithUserID{
+		UserID: alert.UserID,
+		NotificationMsg: &store.WsTxNotify{
+			CurrencyID:      alert.CurrencyID,
+			NetworkID:       priceAlertCurrencies[alert.CurrencyID],
+			Amount:          amount,
+			TransactionType: store.PriceAlertTriggered,
+			AlertID:         alert.AlertID,
+		},
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		pa.log.Errorf("notify: json.Marshal: %s", err.Error())
+		return
+	}
+	err = pa.nsqProducer.Publish(store.TopicTransaction, body)
+	if err != nil {
+		pa.log.Errorf("notify: nsqProducer.Publish: %s", err.Error())
+	}
+}
+
+// priceAlertMet checks the alert condition, change is the price move in percent for change alerts
+func priceAlertMet(alert store.PriceAlert, price float64, history []priceSample, now time.Time) (bool, float64) {
+	switch alert.Condition {
+	case store.PriceAbove:
+		return price >= alert.Price, 0
+	case store.PriceBelow:
+		return price <= alert.Price, 0
+	case store.PriceChange:
+		since := now.Unix() - alert.Window
+		for _, sample := range history {
+			// the earliest price within the window is the reference
+			if sample.time >= since && sample.price > 0 {
+				change := (price - sample.price) / sample.price * 100
+				return math.Abs(change) >= alert.Percent, change
+			}
+		}
+	}
+	return false, 0
+}
+
+// priceAlertFires deduplicates the met alert: it fires once until rearmed and not within cooldown
+func priceAlertFires(alert store.PriceAlert, now time.Time) bool {
+	if alert.Triggered {
+		return false
+	}
+	return now.Unix()-alert.LastTriggered >= alert.Cooldown
+}
+
+type PriceAlertParams struct {
+	CurrencyID int     `json:"currencyid"`
+	Fiat       string  `json:"fiat"`
+	Condition  string  `json:"condition"`
+	Price      float64 `json:"price"`
+	Percent    float64 `json:"percent"`
+	// Window and Cooldown are in seconds
+	Window   int64 `json:"window"`
+	Cooldown int64 `json:"cooldown"`
+}
+
+// checkPriceAlert validates the alert, fiats are the ones of rates
+func checkPriceAlert(pp PriceAlertParams, fiats []string) error {
+	if _, ok := priceAlertCurrencies[pp.CurrencyID]; !ok || !isRateFiat(fiats, pp.Fiat) {
+		return fmt.Errorf("checkPriceAlert: unsupported pair %d %s", pp.CurrencyID, pp.Fiat)
+	}
+	switch pp.Condition {
+	case store.PriceAbove, store.PriceBelow:
+		if pp.Price <= 0 {
+			return errors.New("checkPriceAlert: price must be positive")
+		}
+	case store.PriceChange:
+		if pp.Percent <= 0 {
+			return errors.New("checkPriceAlert: percent must be positive")
+		}
+		if pp.Window < int64(minPriceAlertWindow/time.Second) || pp.Window > int64(priceHistoryLimit/time.Second) {
+			return errors.New("checkPriceAlert: window out of range")
+		}
+	default:
+		return errors.New("checkPriceAlert: unknown condition " + pp.Condition)
+	}
+	if pp.Cooldown != 0 && pp.Cooldown < int64(minPriceAlertCooldown/time.Second) {
+		return errors.New("checkPriceAlert: cooldown is too short")
+	}
+	return nil
+}
+
+func (restClient *RestClient) createPriceAlert() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if restClient.chart == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"code":    http.StatusServiceUnavailable,
+				"message": msgErrRatesUnavailable,
+			})
+			return
+		}
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+
+		var pp PriceAlertParams
+		err = decodeBody(c, &pp)
+		if err != nil {
+			restClient.log.Errorf("createPriceAlert: decodeBody: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrRequestBodyError,
+			})
+			return
+		}
+		err = checkPriceAlert(pp, restClient.chart.aggregator.fiats)
+		if err != nil {
+			restClient.log.Errorf("createPriceAlert: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrPriceAlert,
+			})
+			return
+		}
+
+		alerts, err := restClient.userStore.FindPriceAlerts(user.UserID)
+		if err == nil && len(alerts) >= maxPriceAlertsPerUser {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrPriceAlertsLimit,
+			})
+			return
+		}
+
+		alertID, err := randomID()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": msgErrServerError,
+			})
+			return
+		}
+		cooldown := pp.Cooldown
+		if cooldown == 0 {
+			cooldown = int64(defaultPriceAlertCooldown / time.Second)
+		}
+		alert := store.PriceAlert{
+			AlertID:        alertID,
+			UserID:         user.UserID,
+			CurrencyID:     pp.CurrencyID,
+			Fiat:           pp.Fiat,
+			Condition:      pp.Condition,
+			Price:          pp.Price,
+			Percent:        pp.Percent,
+			Window:         pp.Window,
+			Cooldown:       cooldown,
+			DateOfCreation: time.Now().Unix(),
+		}
+		err = restClient.userStore.InsertPriceAlert(alert)
+		if err != nil {
+			restClient.log.Errorf("createPriceAlert: restClient.userStore.InsertPriceAlert: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": msgErrServerError,
+			})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"code":    http.StatusCreated,
+			"message": http.StatusText(http.StatusCreated),
+			"alert":   alert,
+		})
+	}
+}
+
+func (restClient *RestClient) getPriceAlerts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+		alerts, err := restClient.userStore.FindPriceAlerts(user.UserID)
+		if err != nil {
+			restClient.log.Errorf("getPriceAlerts: restClient.userStore.FindPriceAlerts: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": http.StatusText(http.StatusOK),
+			"alerts":  alerts,
+		})
+	}
+}
+
+func (restClient *RestClient) deletePriceAlert() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+		err = restClient.userStore.DeletePriceAlert(user.UserID, c.Param("alertid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrNoPriceAlert,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": http.StatusText(http.StatusOK),
+		})
+	}
+}
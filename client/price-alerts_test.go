@@ -0,0 +1,121 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/jekabolt/slf"
+	nsq "github.com/nsqio/go-nsq"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestPriceAlertMet(t *testing.T) {
+	now := time.Unix(10000, 0)
+	history := []priceSample{
+		{time: 6000, price: 100},
+		{time: 7000, price: 200},
+		{time: 9000, price: 210},
+	}
+
+	tests := []struct {
+		alert store.PriceAlert
+		price float64
+		met   bool
+	}{
+		{store.PriceAlert{Condition: store.PriceAbove, Price: 10000}, 10000, true},
+		{store.PriceAlert{Condition: store.PriceAbove, Price: 10000}, 9999, false},
+		{store.PriceAlert{Condition: store.PriceBelow, Price: 150}, 149, true},
+		{store.PriceAlert{Condition: store.PriceBelow, Price: 150}, 151, false},
+		// reference is 200 three thousand seconds ago, not 100 out of the window
+		{store.PriceAlert{Condition: store.PriceChange, Percent: 5, Window: 3600}, 209, false},
+		{store.PriceAlert{Condition: store.PriceChange, Percent: 5, Window: 3600}, 210, true},
+		{store.PriceAlert{Condition: store.PriceChange, Percent: 5, Window: 3600}, 190, true},
+		{store.PriceAlert{Condition: store.PriceChange, Percent: 5, Window: 600}, 215, false},
+	}
+	for i, test := range tests {
+		met, _ := priceAlertMet(test.alert, test.price, history, now)
+		if met != test.met {
+			t.Errorf("%d: %s at %v: met=%v, expected %v", i, test.alert.Condition, test.price, met, test.met)
+		}
+	}
+}
+
+func TestPriceAlertFires(t *testing.T) {
+	now := time.Unix(10000, 0)
+	tests := []struct {
+		alert store.PriceAlert
+		fires bool
+	}{
+		{store.PriceAlert{Cooldown: 3600}, true},
+		{store.PriceAlert{Cooldown: 3600, Triggered: true, LastTriggered: 1000}, false},
+		{store.PriceAlert{Cooldown: 3600, LastTriggered: 7000}, false},
+		{store.PriceAlert{Cooldown: 3600, LastTriggered: 6400}, true},
+	}
+	for i, test := range tests {
+		if fires := priceAlertFires(test.alert, now); fires != test.fires {
+			t.Errorf("%d: fires=%v, expected %v", i, fires, test.fires)
+		}
+	}
+}
+
+// alertsStore keeps price alerts by id, updates match the selector as mongo does for the fields used
+type alertsStore struct {
+	store.UserStore
+	alerts  map[string]store.PriceAlert
+	updates int
+}
+
+func (as *alertsStore) UpdatePriceAlert(sel, update bson.M) error {
+	alert, ok := as.alerts[sel["alertid"].(string)]
+	if !ok {
+		return mgo.ErrNotFound
+	}
+	if triggered, ok := sel["triggered"]; ok && alert.Triggered != triggered.(bool) {
+		return mgo.ErrNotFound
+	}
+	if last, ok := sel["lasttriggered"]; ok && alert.LastTriggered != last.(int64) {
+		return mgo.ErrNotFound
+	}
+	set := update["$set"].(bson.M)
+	alert.Triggered = set["triggered"].(bool)
+	if last, ok := set["lasttriggered"]; ok {
+		alert.LastTriggered = last.(int64)
+	}
+	as.alerts[alert.AlertID] = alert
+	as.updates++
+	return nil
+}
+
+func TestPriceAlertEvaluate(t *testing.T) {
+	now := time.Unix(10000, 0)
+	alert := store.PriceAlert{AlertID: "a", Condition: store.PriceAbove, Price: 100, Cooldown: 60}
+	db := &alertsStore{alerts: map[string]store.PriceAlert{"a": alert}}
+	// notifications are published to nowhere
+	producer, _ := nsq.NewProducer("127.0.0.1:1", nsq.NewConfig())
+	producer.SetLogger(nil, nsq.LogLevelError)
+	pa := &priceAlerts{db: db, nsqProducer: producer, log: slf.WithContext("test")}
+
+	// two instances evaluate the same alert they read before it fired
+	pa.evaluate(alert, 110, nil, now)
+	pa.evaluate(alert, 111, nil, now)
+	if !db.alerts["a"].Triggered || db.alerts["a"].LastTriggered != now.Unix() || db.updates != 1 {
+		t.Errorf("alert fired %d times %+v", db.updates, db.alerts["a"])
+	}
+
+	// rearmed once the price is back
+	pa.evaluate(db.alerts["a"], 90, nil, now.Add(time.Minute))
+	if db.alerts["a"].Triggered || db.updates != 2 {
+		t.Errorf("alert isn't rearmed %+v", db.alerts["a"])
+	}
+	pa.evaluate(db.alerts["a"], 120, nil, now.Add(2*time.Minute))
+	if !db.alerts["a"].Triggered || db.updates != 3 {
+		t.Errorf("rearmed alert doesn't fire %+v", db.alerts["a"])
+	}
+}
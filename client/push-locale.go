@@ -0,0 +1,134 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+)
+
+const defaultLocale = "en"
+
+// pushTemplate is localized push text, {amount} and {currency} are replaced with values of the notification
+type pushTemplate struct {
+	Title string
+	Body  string
+}
+
+// pushTemplates is the catalogue of push texts: locale -> event -> template
+var pushTemplates = map[string]map[string]pushTemplate{
+	"en": {
+		store.NotifyIncoming:          {"Incoming transaction", "You received {amount} {currency}"},
+		store.NotifyOutgoing:          {"Outgoing transaction", "You sent {amount} {currency}"},
+		store.NotifyFirstConfirmation: {"Transaction confirmed", "Your {amount} {currency} transaction got into a block"},
+		store.NotifyFinalConfirmation: {"Transaction completed", "Your {amount} {currency} transaction is fully confirmed"},
+		store.NotifyMultisigAction:    {"Multisig wallet", "Your {currency} multisig wallet needs attention"},
+		store.NotifyPriceAlert:        {"Price alert", "{currency} price is {amount}"},
+		store.NotifyExchange:          {"Exchange", "Status of your exchange for {amount} {currency} changed"},
+	},
+	"ru": {
+		store.NotifyIncoming:          {"Входящая транзакция", "Вы получили {amount} {currency}"},
+		store.NotifyOutgoing:          {"Исходящая транзакция", "Вы отправили {amount} {currency}"},
+		store.NotifyFirstConfirmation: {"Транзакция подтверждена", "Ваша транзакция на {amount} {currency} попала в блок"},
+		store.NotifyFinalConfirmation: {"Транзакция завершена", "Ваша транзакция на {amount} {currency} полностью подтверждена"},
+		store.NotifyMultisigAction:    {"Мультиподписной кошелек", "Ваш мультиподписной {currency} кошелек требует внимания"},
+		store.NotifyPriceAlert:        {"Изменение цены", "Цена {currency} составляет {amount}"},
+		store.NotifyExchange:          {"Обмен", "Статус вашего обмена на {amount} {currency} изменился"},
+	},
+	"uk": {
+		store.NotifyIncoming:          {"Вхідна транзакція", "Ви отримали {amount} {currency}"},
+		store.NotifyOutgoing:          {"Вихідна транзакція", "Ви надіслали {amount} {currency}"},
+		store.NotifyFirstConfirmation: {"Транзакцію підтверджено", "Ваша транзакція на {amount} {currency} потрапила в блок"},
+		store.NotifyFinalConfirmation: {"Транзакцію завершено", "Ваша транзакція на {amount} {currency} повністю підтверджена"},
+		store.NotifyMultisigAction:    {"Мультипідписний гаманець", "Ваш мультипідписний {currency} гаманець потребує уваги"},
+		store.NotifyPriceAlert:        {"Зміна ціни", "Ціна {currency} становить {amount}"},
+		store.NotifyExchange:          {"Обмін", "Статус вашого обміну на {amount} {currency} змінився"},
+	},
+}
+
+// numberFormat is decimal and thousands separators of the locale
+type numberFormat struct {
+	decimal   string
+	thousands string
+}
+
+var numberFormats = map[string]numberFormat{
+	"en": {".", ","},
+	"ru": {",", " "},
+	"uk": {",", " "},
+}
+
+// pushLocale returns catalogue locale of the device locale like "ru_RU" or "uk-UA"
+func pushLocale(locale string) string {
+	locale = strings.ToLower(locale)
+	if i := strings.IndexAny(locale, "-_"); i >= 0 {
+		locale = locale[:i]
+	}
+	if _, ok := pushTemplates[locale]; ok {
+		return locale
+	}
+	return defaultLocale
+}
+
+// localizedPush returns title and body of the notification in the device locale
+func localizedPush(locale, event string, msg *store.WsTxNotify) (string, string) {
+	locale = pushLocale(locale)
+	template, ok := pushTemplates[locale][event]
+	if !ok {
+		template = pushTemplates[defaultLocale][event]
+	}
+
+	replacer := strings.NewReplacer(
+		"{amount}", formatAmount(msg.Amount, msg.CurrencyID, locale),
+		"{currency}", currencies.CurrencyNames[msg.CurrencyID],
+	)
+	return replacer.Replace(template.Title), replacer.Replace(template.Body)
+}
+
+// formatAmount formats amount in the smallest units of the currency with the locale separators
+func formatAmount(amount string, currencyID int, locale string) string {
+	value, ok := new(big.Int).SetString(amount, 10)
+	if !ok {
+		return amount
+	}
+	divider, ok := currencies.Dividers[currencyID]
+	if !ok {
+		divider = 1
+	}
+	decimals := len(big.NewInt(divider).String()) - 1
+
+	sign := ""
+	if value.Sign() < 0 {
+		sign = "-"
+		value.Neg(value)
+	}
+	integer, fraction := new(big.Int).QuoRem(value, big.NewInt(divider), new(big.Int))
+
+	format, ok := numberFormats[pushLocale(locale)]
+	if !ok {
+		format = numberFormats[defaultLocale]
+	}
+
+	digits := integer.String()
+	grouped := ""
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			grouped += format.thousands
+		}
+		grouped += string(digit)
+	}
+
+	result := sign + grouped
+	if decimals > 0 && fraction.Sign() > 0 {
+		frac := fraction.String()
+		frac = strings.Repeat("0", decimals-len(frac)) + frac
+		result += format.decimal + strings.TrimRight(frac, "0")
+	}
+	return result
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"testing"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+)
+
+func TestPushLocale(t *testing.T) {
+	for locale, want := range map[string]string{
+		"en":    "en",
+		"ru":    "ru",
+		"ru_RU": "ru",
+		"uk-UA": "uk",
+		"UK":    "uk",
+		"de_DE": defaultLocale,
+		"":      defaultLocale,
+	} {
+		if got := pushLocale(locale); got != want {
+			t.Errorf("pushLocale(%q) = %q, want %q", locale, got, want)
+		}
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		amount     string
+		currencyID int
+		locale     string
+		want       string
+	}{
+		{"en separators", "123456789012", currencies.Bitcoin, "en", "1,234.56789012"},
+		{"ru separators", "123456789012", currencies.Bitcoin, "ru_RU", "1\u00a0234,56789012"},
+		{"uk separators", "123456789012", currencies.Bitcoin, "uk", "1\u00a0234,56789012"},
+		{"no grouping under thousand", "99900000000", currencies.Bitcoin, "en", "999"},
+		{"millions grouping", "123456700000000", currencies.Bitcoin, "en", "1,234,567"},
+		{"negative", "-150000000", currencies.Bitcoin, "en", "-1.5"},
+		{"negative grouped", "-100000000000", currencies.Bitcoin, "ru", "-1\u00a0000"},
+		{"negative fraction only", "-1", currencies.Bitcoin, "en", "-0.00000001"},
+		{"trailing zeroes trimmed", "120000000", currencies.Bitcoin, "en", "1.2"},
+		{"whole amount has no fraction", "200000000", currencies.Bitcoin, "en", "2"},
+		{"leading fraction zeroes kept", "100000001", currencies.Bitcoin, "en", "1.00000001"},
+		{"zero", "0", currencies.Bitcoin, "en", "0"},
+		{"ether 18 decimals", "1500000000000000000", currencies.Ether, "en", "1.5"},
+		{"one wei", "1", currencies.Ether, "ru", "0,000000000000000001"},
+		{"ether grouped", "12345000000000000000000", currencies.Ether, "uk", "12\u00a0345"},
+		{"unknown locale falls back to en", "123456789012", currencies.Bitcoin, "de_DE", "1,234.56789012"},
+		{"unknown currency has no decimals", "1234567", currencies.Litecoin, "en", "1,234,567"},
+		{"not a number is kept", "1e5", currencies.Bitcoin, "en", "1e5"},
+	} {
+		if got := formatAmount(tc.amount, tc.currencyID, tc.locale); got != tc.want {
+			t.Errorf("%s: formatAmount(%s, %d, %s) = %q, want %q", tc.name, tc.amount, tc.currencyID, tc.locale, got, tc.want)
+		}
+	}
+}
+
+func TestLocalizedPush(t *testing.T) {
+	msg := &store.WsTxNotify{CurrencyID: currencies.Bitcoin, Amount: "150000000"}
+	for _, tc := range []struct {
+		name   string
+		locale string
+		event  string
+		title  string
+		body   string
+	}{
+		{"en", "en_US", store.NotifyIncoming, "Incoming transaction", "You received 1.5 Bitcoin"},
+		{"ru", "ru_RU", store.NotifyIncoming, "Входящая транзакция", "Вы получили 1,5 Bitcoin"},
+		{"uk", "uk", store.NotifyOutgoing, "Вихідна транзакція", "Ви надіслали 1,5 Bitcoin"},
+		{"price alert", "ru", store.NotifyPriceAlert, "Изменение цены", "Цена Bitcoin составляет 1,5"},
+		{"unknown locale falls back to en", "fr_FR", store.NotifyOutgoing, "Outgoing transaction", "You sent 1.5 Bitcoin"},
+		{"unknown event has no text", "ru", "unknown", "", ""},
+	} {
+		title, body := localizedPush(tc.locale, tc.event, msg)
+		if title != tc.title || body != tc.body {
+			t.Errorf("%s: localizedPush = %q %q, want %q %q", tc.name, title, body, tc.title, tc.body)
+		}
+	}
+}
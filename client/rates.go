@@ -0,0 +1,410 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/gin-gonic/gin"
+)
+
+// exchange rate providers
+const (
+	RateProviderGdax     = "gdax"
+	RateProviderHitBTC   = "hitbtc"
+	RateProviderBitstamp = "bitstamp"
+	RateProviderReplay   = "replay"
+	// CryptoCompare has rates of all fiats
+	RateProviderCryptoCompare = "cryptocompare"
+)
+
+// aggregation methods of the canonical rate
+const (
+	RateMedian = "median"
+	RateVWAP   = "vwap"
+	// RateCross is the rate derived from other pairs when no source has the pair
+	RateCross = "cross"
+)
+
+// reasons a source is dropped from the canonical rate
+const (
+	rateDroppedStale   = "stale"
+	rateDroppedOutlier = "outlier"
+)
+
+const (
+	defaultRateMaxAge       = time.Minute * 2
+	defaultRateMaxDeviation = 0.05
+	// outliers are told apart when there are at least that many sources
+	rateOutlierMinSources = 3
+
+	msgErrRatesUnavailable = "rates are not available"
+)
+
+// RatesConf chooses exchange rate providers and how their quotes are aggregated
+type RatesConf struct {
+	// Providers are exchanges quotes come from, all of them if empty
+	Providers []string
+	// Fiats are currencies coins are priced in, USD and EUR if empty
+	Fiats []string
+	// Method is median or vwap, median if empty. VWAP falls back to median when a source has no volume.
+	Method string
+	// MaxAge is seconds a quote is fresh for, 120 if zero
+	MaxAge int
+	// MaxDeviation is the fraction of the median a quote could differ by, 0.05 if zero
+	MaxDeviation float64
+	// ReplayFile is JSON lines file of recorded quotes for the replay provider
+	ReplayFile string
+	// CandleSource fills gaps of rates history, cryptocompare if empty or none
+	CandleSource string
+}
+
+// Quote is the last price of the pair like "BTC-USD" on the exchange
+type Quote struct {
+	Source string  `json:"source"`
+	Pair   string  `json:"pair"`
+	Price  float64 `json:"price"`
+	// Volume is 24h volume in the base currency, zero if the exchange doesn't tell
+	Volume float64   `json:"volume"`
+	Time   time.Time `json:"time"`
+}
+
+// rateCoins are currencies priced in every fiat by their symbols
+var rateCoins = map[int]string{
+	currencies.Bitcoin: "BTC",
+	currencies.Ether:   "ETH",
+}
+
+// rateFiats are fiat currencies of RatesConf in upper case, USD and EUR if none
+func rateFiats(fiats []string) []string {
+	if len(fiats) == 0 {
+		return []string{fiatUSD, fiatEUR}
+	}
+	upper := []string{}
+	for _, fiat := range fiats {
+		upper = append(upper, strings.ToUpper(fiat))
+	}
+	return upper
+}
+
+// makeRatePairs prices every coin in the fiats and Ether in Bitcoin
+func makeRatePairs(fiats []string) []string {
+	pairs := []string{}
+	for _, coin := range []string{"BTC", "ETH"} {
+		for _, fiat := range fiats {
+			pairs = append(pairs, store.RatePair(coin, fiat))
+		}
+	}
+	return append(pairs, store.RatePair("ETH", "BTC"))
+}
+
+func isRatePair(pairs []string, pair string) bool {
+	for _, ratePair := range pairs {
+		if pair == ratePair {
+			return true
+		}
+	}
+	return false
+}
+
+func isRateFiat(fiats []string, fiat string) bool {
+	for _, rateFiat := range fiats {
+		if fiat == rateFiat {
+			return true
+		}
+	}
+	return false
+}
+
+// RateProvider streams quotes of an exchange
+type RateProvider interface {
+	Name() string
+	// Run sends quotes until stop is closed, it reconnects by itself
+	Run(quotes chan<- Quote, stop <-chan struct{})
+}
+
+// NewRateProviders creates enabled providers, pairs are asked of the providers polling them
+func NewRateProviders(conf RatesConf, pairs []string) ([]RateProvider, error) {
+	names := conf.Providers
+	if len(names) == 0 {
+		names = []string{RateProviderGdax, RateProviderHitBTC, RateProviderBitstamp, RateProviderCryptoCompare}
+	}
+
+	providers := []RateProvider{}
+	for _, name := range names {
+		var (
+			provider RateProvider
+			err      error
+		)
+		switch name {
+		case RateProviderGdax:
+			provider = NewGdaxProvider()
+		case RateProviderHitBTC:
+			provider = NewHitBTCProvider()
+		case RateProviderBitstamp:
+			provider = NewBitstampProvider()
+		case RateProviderCryptoCompare:
+			provider = NewCryptoCompareProvider(pairs)
+		case RateProviderReplay:
+			provider, err = LoadReplayProvider(conf.ReplayFile)
+		default:
+			err = errors.New("unknown provider " + name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("NewRateProviders: %s: %s", name, err.Error())
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+// RateAggregator keeps the last quote of every source and makes the canonical rate of them
+type RateAggregator struct {
+	method       string
+	maxAge       time.Duration
+	maxDeviation float64
+	now          func() time.Time
+	// fiats are currencies coins are priced in, pairs are pairs of canonical rates and rates channels
+	fiats []string
+	pairs []string
+
+	m sync.RWMutex
+	// quotes are the last quotes by pair and source
+	quotes map[string]map[string]Quote
+}
+
+func NewRateAggregator(conf RatesConf) *RateAggregator {
+	agg := &RateAggregator{
+		method:       conf.Method,
+		maxAge:       time.Duration(conf.MaxAge) * time.Second,
+		maxDeviation: conf.MaxDeviation,
+		now:          time.Now,
+		fiats:        rateFiats(conf.Fiats),
+		quotes:       map[string]map[string]Quote{},
+	}
+	agg.pairs = makeRatePairs(agg.fiats)
+	if agg.method != RateVWAP {
+		agg.method = RateMedian
+	}
+	if agg.maxAge <= 0 {
+		agg.maxAge = defaultRateMaxAge
+	}
+	if agg.maxDeviation <= 0 {
+		agg.maxDeviation = defaultRateMaxDeviation
+	}
+	return agg
+}
+
+// Run runs providers and adds their quotes until stop is closed
+func (agg *RateAggregator) Run(providers []RateProvider, stop <-chan struct{}) {
+	quotes := make(chan Quote, 64)
+	for _, provider := range providers {
+		go provider.Run(quotes, stop)
+	}
+	for {
+		select {
+		case quote := <-quotes:
+			agg.Add(quote)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Add keeps the quote as the last one of its source
+func (agg *RateAggregator) Add(quote Quote) {
+	if quote.Price <= 0 || math.IsInf(quote.Price, 0) || math.IsNaN(quote.Price) {
+		return
+	}
+	agg.m.Lock()
+	defer agg.m.Unlock()
+	if _, ok := agg.quotes[quote.Pair]; !ok {
+		agg.quotes[quote.Pair] = map[string]Quote{}
+	}
+	agg.quotes[quote.Pair][quote.Source] = quote
+}
+
+// Rate returns the canonical rate of the pair, it's derived from other pairs when no fresh source has it.
+// The price is zero when there is no way to get it.
+func (agg *RateAggregator) Rate(pair string) store.CanonicalRate {
+	rate := agg.directRate(pair)
+	if rate.Price > 0 {
+		return rate
+	}
+	base, quote, ok := store.SplitRatePair(pair)
+	if !ok {
+		return rate
+	}
+	if price, ok := agg.PairRates().Rate(base, quote); ok {
+		rate.Price, rate.Method = price, RateCross
+	}
+	return rate
+}
+
+// PairRates returns canonical rates of pairs fresh sources have
+func (agg *RateAggregator) PairRates() store.PairRates {
+	agg.m.RLock()
+	pairs := make([]string, 0, len(agg.quotes))
+	for pair := range agg.quotes {
+		pairs = append(pairs, pair)
+	}
+	agg.m.RUnlock()
+
+	rates := store.PairRates{}
+	for _, pair := range pairs {
+		if rate := agg.directRate(pair); rate.Price > 0 {
+			rates[pair] = rate.Price
+		}
+	}
+	return rates
+}
+
+// directRate returns the rate of the pair made of fresh sources, the price is zero when there are none
+func (agg *RateAggregator) directRate(pair string) store.CanonicalRate {
+	agg.m.RLock()
+	quotes := make([]Quote, 0, len(agg.quotes[pair]))
+	for _, quote := range agg.quotes[pair] {
+		quotes = append(quotes, quote)
+	}
+	agg.m.RUnlock()
+	sort.Slice(quotes, func(i, j int) bool { return quotes[i].Source < quotes[j].Source })
+
+	rate := store.CanonicalRate{Pair: pair, Method: agg.method, Sources: []store.RateSource{}}
+	now := agg.now()
+	fresh := []float64{}
+	for _, quote := range quotes {
+		source := store.RateSource{
+			Name:    quote.Source,
+			Price:   quote.Price,
+			Volume:  quote.Volume,
+			Updated: quote.Time.Unix(),
+		}
+		if now.Sub(quote.Time) > agg.maxAge {
+			source.Dropped = rateDroppedStale
+		} else {
+			fresh = append(fresh, quote.Price)
+		}
+		rate.Sources = append(rate.Sources, source)
+	}
+	if len(fresh) == 0 {
+		return rate
+	}
+
+	// with two sources there is no telling which one is off
+	median := medianPrice(fresh)
+	if len(fresh) >= rateOutlierMinSources {
+		for i, source := range rate.Sources {
+			if source.Dropped == "" && math.Abs(source.Price-median)/median > agg.maxDeviation {
+				rate.Sources[i].Dropped = rateDroppedOutlier
+			}
+		}
+	}
+
+	used := []store.RateSource{}
+	for _, source := range rate.Sources {
+		if source.Dropped == "" {
+			used = append(used, source)
+			if source.Updated > rate.Updated {
+				rate.Updated = source.Updated
+			}
+		}
+	}
+	rate.Price, rate.Method = aggregatePrice(used, agg.method)
+	return rate
+}
+
+// aggregatePrice returns the median or the volume-weighted price of sources and the method used
+func aggregatePrice(sources []store.RateSource, method string) (float64, string) {
+	prices := make([]float64, 0, len(sources))
+	var value, volume float64
+	weighted := method == RateVWAP
+	for _, source := range sources {
+		prices = append(prices, source.Price)
+		if source.Volume <= 0 {
+			weighted = false
+		}
+		value += source.Price * source.Volume
+		volume += source.Volume
+	}
+	if weighted {
+		return value / volume, RateVWAP
+	}
+	return medianPrice(prices), RateMedian
+}
+
+func medianPrice(prices []float64) float64 {
+	sorted := append([]float64{}, prices...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+// Rates returns canonical rates of all pairs
+func (agg *RateAggregator) Rates() []store.CanonicalRate {
+	rates := []store.CanonicalRate{}
+	for _, pair := range agg.pairs {
+		rates = append(rates, agg.Rate(pair))
+	}
+	return rates
+}
+
+// ExchangeRates returns canonical rates of all pairs in the form stored with transactions
+func (agg *RateAggregator) ExchangeRates() store.ExchangeRates {
+	direct := agg.PairRates()
+	rates := store.ExchangeRates{Pairs: store.PairRates{}}
+	for _, pair := range agg.pairs {
+		base, quote, _ := store.SplitRatePair(pair)
+		if price, ok := direct.Rate(base, quote); ok {
+			rates.Pairs[pair] = price
+		}
+	}
+	rates.Normalize()
+	return rates
+}
+
+// SourceRates returns the last rates of the source even if they are stale, as Gdax and Poloniex events had them
+func (agg *RateAggregator) SourceRates(source string) store.ExchangeRates {
+	rates := store.ExchangeRates{Pairs: store.PairRates{}}
+	agg.m.RLock()
+	for pair, quotes := range agg.quotes {
+		if quote, ok := quotes[source]; ok {
+			rates.Pairs[pair] = quote.Price
+		}
+	}
+	agg.m.RUnlock()
+	rates.Normalize()
+	return rates
+}
+
+// getRates returns canonical rates with sources they are made of
+func (restClient *RestClient) getRates() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if restClient.chart == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"code":    http.StatusServiceUnavailable,
+				"message": msgErrRatesUnavailable,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": http.StatusText(http.StatusOK),
+			"rates":   restClient.chart.aggregator.Rates(),
+		})
+	}
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Multy-io/Multy-back/store"
+)
+
+// sourcesDropped returns sources of the rate with the reason they are dropped
+func sourcesDropped(agg *RateAggregator, pair string) string {
+	dropped := []string{}
+	for _, source := range agg.Rate(pair).Sources {
+		dropped = append(dropped, source.Name+":"+source.Dropped)
+	}
+	return fmt.Sprint(dropped)
+}
+
+func TestRateAggregatorMedian(t *testing.T) {
+	now := time.Unix(1550000000, 0)
+	agg := NewRateAggregator(RatesConf{})
+	agg.now = func() time.Time { return now }
+
+	agg.Add(Quote{Source: "a", Pair: "BTC-USD", Price: 100, Time: now})
+	agg.Add(Quote{Source: "b", Pair: "BTC-USD", Price: 101, Time: now.Add(-time.Minute)})
+	agg.Add(Quote{Source: "c", Pair: "BTC-USD", Price: 130, Time: now})
+	agg.Add(Quote{Source: "d", Pair: "BTC-USD", Price: 99, Time: now.Add(-time.Hour)})
+	agg.Add(Quote{Source: "e", Pair: "BTC-USD", Price: 0, Time: now})
+
+	rate := agg.Rate("BTC-USD")
+	if rate.Price != 100.5 || rate.Method != RateMedian || rate.Updated != now.Unix() {
+		t.Errorf("wrong rate %+v", rate)
+	}
+	if got := sourcesDropped(agg, "BTC-USD"); got != "[a: b: c:outlier d:stale]" {
+		t.Errorf("wrong sources %s", got)
+	}
+
+	// two sources are kept however far they are
+	agg.Add(Quote{Source: "a", Pair: "ETH-USD", Price: 100, Time: now})
+	agg.Add(Quote{Source: "b", Pair: "ETH-USD", Price: 200, Time: now})
+	if rate := agg.Rate("ETH-USD"); rate.Price != 150 {
+		t.Errorf("wrong rate of two sources %+v", rate)
+	}
+
+	// all stale
+	now = now.Add(time.Hour)
+	if rate := agg.Rate("BTC-USD"); rate.Price != 0 || len(rate.Sources) != 4 {
+		t.Errorf("stale rate %+v", rate)
+	}
+}
+
+func TestRateAggregatorVWAP(t *testing.T) {
+	now := time.Now()
+	agg := NewRateAggregator(RatesConf{Method: RateVWAP})
+
+	agg.Add(Quote{Source: "a", Pair: "ETH-BTC", Price: 0.03, Volume: 300, Time: now})
+	agg.Add(Quote{Source: "b", Pair: "ETH-BTC", Price: 0.031, Volume: 100, Time: now})
+	agg.Add(Quote{Source: "c", Pair: "ETH-BTC", Price: 0.0305, Volume: 100, Time: now})
+	if rate := agg.Rate("ETH-BTC"); fmt.Sprintf("%.4f", rate.Price) != "0.0303" || rate.Method != RateVWAP {
+		t.Errorf("wrong rate %+v", rate)
+	}
+
+	// median without volume
+	agg.Add(Quote{Source: "c", Pair: "ETH-BTC", Price: 0.0305, Time: now})
+	if rate := agg.Rate("ETH-BTC"); rate.Price != 0.0305 || rate.Method != RateMedian {
+		t.Errorf("wrong rate without volume %+v", rate)
+	}
+}
+
+func TestReplayProvider(t *testing.T) {
+	recorded := time.Unix(1550000000, 0)
+	replay := NewReplayProvider([]Quote{
+		{Source: RateProviderGdax, Pair: "BTC-USD", Price: 3600, Time: recorded},
+		{Source: RateProviderGdax, Pair: "BTC-EUR", Price: 3200, Time: recorded},
+		{Source: RateProviderHitBTC, Pair: "BTC-USD", Price: 3610, Time: recorded},
+		{Source: RateProviderBitstamp, Pair: "BTC-USD", Price: 3605, Time: recorded.Add(time.Second)},
+	})
+	agg := NewRateAggregator(RatesConf{})
+	stop := make(chan struct{})
+	defer close(stop)
+	go agg.Run([]RateProvider{replay}, stop)
+
+	for i := 0; i < 100 && len(agg.Rate("BTC-USD").Sources) < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// replayed quotes are fresh
+	if rate := agg.Rate("BTC-USD"); rate.Price != 3605 {
+		t.Errorf("wrong replayed rate %+v", rate)
+	}
+
+	rates := agg.ExchangeRates()
+	if rates.BTCtoUSD != 3605 || rates.USDtoBTC != 1/3605.0 || rates.EURtoBTC != 1/3200.0 {
+		t.Errorf("wrong exchange rates %+v", rates)
+	}
+	if gdax := agg.SourceRates(RateProviderGdax); gdax.BTCtoUSD != 3600 {
+		t.Errorf("wrong gdax rates %+v", gdax)
+	}
+}
+
+func TestProviderQuotes(t *testing.T) {
+	gdaxRaw := GDAXSocketEvent{}
+	json.Unmarshal([]byte(`{"type":"ticker","product_id":"ETH-EUR","price":"105.5","volume_24h":"1200.5","time":"2019-02-12T19:33:20.000Z"}`), &gdaxRaw)
+	quote, err := NewGdaxProvider().quote(gdaxRaw)
+	if err != nil || quote.Pair != "ETH-EUR" || quote.Price != 105.5 || quote.Volume != 1200.5 || quote.Time.Unix() != 1550000000 {
+		t.Errorf("wrong gdax quote %+v %v", quote, err)
+	}
+
+	hitBTCRaw := HitBTCSocketEvent{}
+	json.Unmarshal([]byte(`{"method":"ticker","params":{"last":"0.0301","volume":"5000","timestamp":"2019-02-12T19:33:20.000Z","symbol":"ETHBTC"}}`), &hitBTCRaw)
+	quote, err = NewHitBTCProvider().quote(hitBTCRaw)
+	if err != nil || quote.Pair != "ETH-BTC" || quote.Price != 0.0301 || quote.Source != RateProviderHitBTC {
+		t.Errorf("wrong hitbtc quote %+v %v", quote, err)
+	}
+	if _, err := NewHitBTCProvider().quote(HitBTCSocketEvent{}); err == nil {
+		t.Errorf("empty message quoted")
+	}
+}
+
+func TestCrossRates(t *testing.T) {
+	now := time.Now()
+	agg := NewRateAggregator(RatesConf{Fiats: []string{"usd", "uah"}})
+	if fmt.Sprint(agg.pairs) != "[BTC-USD BTC-UAH ETH-USD ETH-UAH ETH-BTC]" || !isRateFiat(agg.fiats, "UAH") || isRateFiat(agg.fiats, "EUR") {
+		t.Errorf("wrong pairs %v", agg.pairs)
+	}
+	if pairs := NewRateAggregator(RatesConf{}).pairs; fmt.Sprint(pairs) != "[BTC-USD BTC-EUR ETH-USD ETH-EUR ETH-BTC]" {
+		t.Errorf("wrong default pairs %v", pairs)
+	}
+	agg.Add(Quote{Source: "a", Pair: "BTC-USD", Price: 4000, Time: now})
+	agg.Add(Quote{Source: "a", Pair: "USD-UAH", Price: 27, Time: now})
+	agg.Add(Quote{Source: "a", Pair: "ETH-BTC", Price: 0.03, Time: now})
+
+	for pair, want := range map[string]string{
+		"BTC-UAH": "108000.00 cross",
+		"ETH-USD": "120.00 cross",
+		"ETH-UAH": "3240.00 cross",
+		"BTC-USD": "4000.00 median",
+		"ETH-EUR": "0.00 median",
+	} {
+		if rate := agg.Rate(pair); fmt.Sprintf("%.2f %s", rate.Price, rate.Method) != want {
+			t.Errorf("%s rate %+v, want %s", pair, rate, want)
+		}
+	}
+
+	rates := agg.ExchangeRates()
+	if len(rates.Pairs) != 5 || rates.Pairs["ETH-UAH"] != 3240 || rates.ETHtoUSD != 120 || rates.USDtoBTC != 1/4000.0 || rates.EURtoBTC != 0 {
+		t.Errorf("wrong exchange rates %+v", rates)
+	}
+}
+
+func TestNormalizeLegacyRates(t *testing.T) {
+	rates := store.ExchangeRates{EURtoBTC: 1 / 3200.0, USDtoBTC: 1 / 3600.0, ETHtoBTC: 0.03, ETHtoEUR: 96}
+	rates.Normalize()
+	if fmt.Sprintf("%.2f %.2f %.2f", rates.Pairs["BTC-USD"], rates.Pairs["BTC-EUR"], rates.Pairs["ETH-EUR"]) != "3600.00 3200.00 96.00" {
+		t.Errorf("wrong pairs %v", rates.Pairs)
+	}
+	// fixed fields missed before are derived
+	if fmt.Sprintf("%.2f %.2f", rates.BTCtoUSD, rates.ETHtoUSD) != "3600.00 108.00" {
+		t.Errorf("wrong fixed fields %+v", rates)
+	}
+
+	// pairs win
+	rates = store.ExchangeRates{Pairs: store.PairRates{"BTC-USD": 4000}, BTCtoUSD: 3600}
+	rates.Normalize()
+	if rates.BTCtoUSD != 4000 || len(rates.Pairs) != 1 {
+		t.Errorf("wrong rates %+v", rates)
+	}
+}
+
+func TestCryptoCompareProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pricemultifull" || r.URL.Query().Get("fsyms") != "BTC,ETH" || r.URL.Query().Get("tsyms") != "RUB,BTC" {
+			fmt.Fprint(w, `{"Response":"Error","Message":"wrong request"}`)
+			return
+		}
+		fmt.Fprint(w, `{"RAW":{"BTC":{"RUB":{"PRICE":260000,"VOLUME24HOUR":12.5,"LASTUPDATE":1550000000}},"ETH":{"BTC":{"PRICE":0.03}}}}`)
+	}))
+	defer server.Close()
+
+	cc := &CryptoCompareProvider{addr: server.URL + "/", client: server.Client()}
+	quotes, err := cc.prices([]string{"BTC-RUB", "ETH-RUB", "ETH-BTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quotes) != 2 || quotes[0].Pair != "BTC-RUB" || quotes[0].Price != 260000 || quotes[0].Volume != 12.5 || quotes[0].Time.Unix() != 1550000000 {
+		t.Errorf("wrong quotes %+v", quotes)
+	}
+	if _, err := cc.prices([]string{"BTC-UAH"}); err == nil {
+		t.Errorf("error response accepted")
+	}
+}
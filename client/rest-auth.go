@@ -40,6 +40,25 @@ func (restClient *RestClient) LoginHandler() gin.HandlerFunc {
 
 		user, ok := restClient.middlewareJWT.Authenticator(loginVals.UserID, loginVals.DeviceID, loginVals.PushToken, loginVals.DeviceType, c) // user can be empty
 
+		// accounts with the login key are proved by the challenge signed with it. The key is registered
+		// with a new account only, existing accounts add it from a logged in device with /api/v1/auth/key
+		loginKey := user.PublicKey
+		if !ok && loginVals.PublicKey != "" {
+			if _, err := parseLoginKey(loginVals.PublicKey); err != nil {
+				restClient.middlewareJWT.unauthorized(c, http.StatusBadRequest, msgErrLoginKey)
+				return
+			}
+			loginKey = loginVals.PublicKey
+		}
+		if loginKey != "" {
+			err := restClient.verifyLogin(loginVals.UserID, loginKey, loginVals)
+			if err != nil {
+				restClient.log.Errorf("LoginHandler: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+				restClient.middlewareJWT.unauthorized(c, http.StatusUnauthorized, msgErrLoginSigned)
+				return
+			}
+		}
+
 		userID := loginVals.UserID
 
 		// Create the token
@@ -51,26 +70,35 @@ func (restClient *RestClient) LoginHandler() gin.HandlerFunc {
 			}
 		}
 
+		// devices logged in within a second get different tokens, only hashes of them are stored
+		jti, err := randomID()
+		if err != nil {
+			restClient.middlewareJWT.unauthorized(c, http.StatusInternalServerError, "Create JWT Token faild")
+			return
+		}
 		expire := restClient.middlewareJWT.TimeFunc().Add(restClient.middlewareJWT.Timeout)
 		claims["id"] = userID
 		claims["exp"] = expire.Unix()
 		claims["orig_iat"] = restClient.middlewareJWT.TimeFunc().Unix()
+		claims["jti"] = jti
 
 		tokenString, err := token.SignedString(restClient.middlewareJWT.Key)
 		if err != nil {
 			restClient.middlewareJWT.unauthorized(c, http.StatusUnauthorized, "Create JWT Token faild")
 			return
 		}
+		tokenHash := store.TokenHash(tokenString)
 
 		if !ok {
 			// new User with new Device
-			device := createDevice(loginVals.DeviceID, c.ClientIP(), tokenString, loginVals.PushToken, loginVals.AppVersion, loginVals.DeviceType)
+			device := createDevice(loginVals.DeviceID, c.ClientIP(), tokenHash, loginVals.PushToken, loginVals.AppVersion, loginVals.Locale, loginVals.DeviceType)
 
 			var wallet []store.Wallet
 			var devices []store.Device
 			devices = append(devices, device)
 
 			newUser := createUser(loginVals.UserID, devices, wallet)
+			newUser.PublicKey = loginKey
 			err = restClient.userStore.Insert(newUser)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{
@@ -95,15 +123,26 @@ func (restClient *RestClient) LoginHandler() gin.HandlerFunc {
 			// userID and deviceID existed in DB
 			if concreteDevice.DeviceID == loginVals.DeviceID {
 				restClient.log.Infof("update token for device %s", loginVals.DeviceID)
-				sel := bson.M{"userID": user.UserID, "devices.JWT": concreteDevice.JWT}
-				update := bson.M{"$set": bson.M{"devices.$.JWT": tokenString}}
-				err = restClient.userStore.Update(sel, update)
+				// tokens of logged out devices are empty
+				sel := bson.M{"userID": user.UserID, "devices.deviceID": concreteDevice.DeviceID}
+				set := bson.M{"devices.$.JWT": tokenHash, "devices.$.pushToken": loginVals.PushToken}
+				if loginVals.Locale != "" {
+					set["devices.$.locale"] = loginVals.Locale
+				}
+				err = restClient.userStore.Update(sel, bson.M{"$set": set})
 				if err != nil {
 					c.JSON(http.StatusInternalServerError, gin.H{
 						"token":  "",
 						"expire": "",
 					})
 				} else {
+					// the token replaced by relogin isn't valid any more
+					if err := restClient.revokeTokens(user.UserID, concreteDevice.JWT); err != nil {
+						restClient.log.Errorf("LoginHandler: revokeTokens: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+					}
+					if concreteDevice.PushToken != "" && concreteDevice.PushToken != loginVals.PushToken {
+						restClient.securityEvent(user.UserID, store.SecurityPushTokenChanged, concreteDevice, c.ClientIP(), nil)
+					}
 					c.JSON(http.StatusOK, gin.H{
 						"token":  tokenString,
 						"expire": expire.Format(time.RFC3339),
@@ -117,7 +156,7 @@ func (restClient *RestClient) LoginHandler() gin.HandlerFunc {
 		restClient.log.Infof("creating new device %s", loginVals.DeviceID)
 		// case of adding new device to user account
 		// e.g. user want to use app on another device
-		device := createDevice(loginVals.DeviceID, c.ClientIP(), tokenString, loginVals.PushToken, loginVals.AppVersion, loginVals.DeviceType)
+		device := createDevice(loginVals.DeviceID, c.ClientIP(), tokenHash, loginVals.PushToken, loginVals.AppVersion, loginVals.Locale, loginVals.DeviceType)
 		user.Devices = append(user.Devices, device)
 
 		sel := bson.M{"userID": userID}
@@ -128,6 +167,9 @@ func (restClient *RestClient) LoginHandler() gin.HandlerFunc {
 				"expire": "",
 			})
 		} else {
+			restClient.securityEvent(user.UserID, store.SecurityNewDevice, device, c.ClientIP(), map[string]string{
+				"appversion": loginVals.AppVersion,
+			})
 			c.JSON(http.StatusOK, gin.H{
 				"token":  tokenString,
 				"expire": expire.Format(time.RFC3339),
@@ -0,0 +1,676 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Multy-io/Multy-back/btc"
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/gin-gonic/gin"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	msgErrNoMultisig         = "no such multisig"
+	msgErrMultisigParams     = "wrong multisig parameters"
+	msgErrMultisigDeployed   = "multisig already has all owners"
+	msgErrMultisigNotDeployd = "multisig still waits for owners"
+	msgErrMultisigOwner      = "already multisig owner"
+	msgErrWrongXpub          = "wrong extended public key"
+	msgErrWrongPSBT          = "wrong partially signed transaction"
+	msgErrPSBTNotSigned      = "transaction isn't signed by the owner"
+	msgErrNoSpend            = "no such spend"
+	msgErrSpendSigned        = "spend already signed"
+	msgErrNotMultisigOwner   = "not a multisig owner"
+	msgErrMultisigChanged    = "multisig owners changed, try again"
+)
+
+type BTCMultisigParams struct {
+	CurrencyID    int    `json:"currencyid"`
+	NetworkID     int    `json:"networkid"`
+	WalletName    string `json:"walletname"`
+	Confirmations int    `json:"confirmations"`
+	OwnersCount   int    `json:"ownerscount"`
+	AddressType   string `json:"addresstype"`
+	Xpub          string `json:"xpub"`
+}
+
+type BTCMultisigJoin struct {
+	InviteCode string `json:"invitecode"`
+	WalletName string `json:"walletname"`
+	Xpub       string `json:"xpub"`
+}
+
+type BTCMultisigSpendParams struct {
+	InviteCode string `json:"invitecode"`
+	SpendID    string `json:"spendid"`
+	PSBT       string `json:"psbt"`
+}
+
+func (restClient *RestClient) createBTCMultisig() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+
+		var mp BTCMultisigParams
+		err = decodeBody(c, &mp)
+		if err != nil {
+			restClient.log.Errorf("createBTCMultisig: decodeBody: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrRequestBodyError,
+			})
+			return
+		}
+
+		if mp.CurrencyID != currencies.Bitcoin || mp.OwnersCount < 2 || mp.OwnersCount > btc.MaxMultisigOwners ||
+			mp.Confirmations < 1 || mp.Confirmations > mp.OwnersCount ||
+			(mp.AddressType != store.MultisigAddressP2SH && mp.AddressType != store.MultisigAddressP2WSH) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrMultisigParams,
+			})
+			return
+		}
+
+		if err := btc.CheckExtendedKey(mp.Xpub, mp.NetworkID); err != nil {
+			restClient.log.Errorf("createBTCMultisig: btc.CheckExtendedKey: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrWrongXpub,
+			})
+			return
+		}
+
+		inviteCode, err := randomID()
+		if err != nil {
+			restClient.log.Errorf("createBTCMultisig: randomID: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": msgErrServerError,
+			})
+			return
+		}
+
+		multisig := store.Multisig{
+			CurrencyID:     mp.CurrencyID,
+			NetworkID:      mp.NetworkID,
+			Confirmations:  mp.Confirmations,
+			WalletName:     mp.WalletName,
+			LastActionTime: time.Now().Unix(),
+			DateOfCreation: time.Now().Unix(),
+			Owners: []store.AddressExtended{
+				{
+					UserID:     user.UserID,
+					Address:    mp.Xpub,
+					Associated: true,
+				},
+			},
+			Status:      store.WalletStatusOK,
+			AddressType: mp.AddressType,
+			OwnersCount: mp.OwnersCount,
+			InviteCode:  inviteCode,
+		}
+
+		err = restClient.userStore.Update(bson.M{"userID": user.UserID}, bson.M{"$push": bson.M{"multisig": multisig}})
+		if err != nil {
+			restClient.log.Errorf("createBTCMultisig: restClient.userStore.Update: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": msgErrServerError,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"code":       http.StatusOK,
+			"message":    http.StatusText(http.StatusOK),
+			"invitecode": inviteCode,
+		})
+	}
+}
+
+func (restClient *RestClient) joinBTCMultisig() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+
+		var mj BTCMultisigJoin
+		err = decodeBody(c, &mj)
+		if err != nil {
+			restClient.log.Errorf("joinBTCMultisig: decodeBody: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrRequestBodyError,
+			})
+			return
+		}
+
+		holder := store.User{}
+		err = restClient.userStore.FindUser(bson.M{"multisig.invitecode": mj.InviteCode}, &holder)
+		multisig, ok := btcMultisig(holder, mj.InviteCode)
+		if err != nil || mj.InviteCode == "" || !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrNoMultisig,
+			})
+			return
+		}
+
+		if multisig.DeployStatus || len(multisig.Owners) >= multisig.OwnersCount {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrMultisigDeployed,
+			})
+			return
+		}
+
+		for _, owner := range multisig.Owners {
+			if owner.UserID == user.UserID || owner.Address == mj.Xpub {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"code":    http.StatusBadRequest,
+					"message": msgErrMultisigOwner,
+				})
+				return
+			}
+		}
+
+		if err := btc.CheckExtendedKey(mj.Xpub, multisig.NetworkID); err != nil {
+			restClient.log.Errorf("joinBTCMultisig: btc.CheckExtendedKey: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrWrongXpub,
+			})
+			return
+		}
+
+		owner := store.AddressExtended{
+			UserID:     user.UserID,
+			Address:    mj.Xpub,
+			Associated: true,
+		}
+		joined := multisig
+		joined.Owners = append(append([]store.AddressExtended{}, multisig.Owners...), owner)
+		joined.LastActionTime = time.Now().Unix()
+		joined.WalletName = mj.WalletName
+		joined.DateOfCreation = time.Now().Unix()
+
+		// the copy of the joined owner is stored first so concurrent joins propagate to it
+		err = restClient.userStore.Update(bson.M{"userID": user.UserID}, bson.M{"$push": bson.M{"multisig": joined}})
+		if err != nil {
+			restClient.log.Errorf("joinBTCMultisig: restClient.userStore.Update: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": msgErrServerError,
+			})
+			return
+		}
+
+		// the creator's copy decides: the owner is added only if nobody joined since it was read
+		creator := bson.M{
+			"userID":   multisig.Owners[0].UserID,
+			"multisig": bson.M{"$elemMatch": bson.M{"invitecode": mj.InviteCode, "owners": bson.M{"$size": len(multisig.Owners)}}},
+		}
+		err = restClient.userStore.Update(creator, bson.M{
+			"$push": bson.M{"multisig.$.owners": owner},
+			"$set":  bson.M{"multisig.$.lastactiontime": joined.LastActionTime},
+		})
+		if err != nil {
+			restClient.log.Errorf("joinBTCMultisig: restClient.userStore.Update: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			if err := restClient.userStore.Update(bson.M{"userID": user.UserID}, bson.M{"$pull": bson.M{"multisig": bson.M{"invitecode": mj.InviteCode}}}); err != nil {
+				restClient.log.Errorf("joinBTCMultisig: restClient.userStore.Update: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			}
+			c.JSON(http.StatusConflict, gin.H{
+				"code":    http.StatusConflict,
+				"message": msgErrMultisigChanged,
+			})
+			return
+		}
+
+		// copies of the other owners, $addToSet keeps the ones already having the owner as they are
+		update := bson.M{
+			"$addToSet": bson.M{"multisig.$.owners": owner},
+			"$set":      bson.M{"multisig.$.lastactiontime": joined.LastActionTime},
+		}
+		err = restClient.userStore.UpdateMultisig(mj.InviteCode, update)
+		if err != nil {
+			restClient.log.Errorf("joinBTCMultisig: restClient.userStore.UpdateMultisig: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": msgErrServerError,
+			})
+			return
+		}
+		multisig.Owners = joined.Owners
+		multisig.LastActionTime = joined.LastActionTime
+
+		// the last owner joined: derive first address and start watching it
+		if len(multisig.Owners) == multisig.OwnersCount {
+			address, err := restClient.addBTCMultisigAddress(&multisig)
+			if err != nil {
+				restClient.log.Errorf("joinBTCMultisig: addBTCMultisigAddress: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"code":    http.StatusInternalServerError,
+					"message": msgErrServerError,
+				})
+				return
+			}
+			update := bson.M{
+				"$set": bson.M{
+					"multisig.$.deploystatus":    true,
+					"multisig.$.contractaddress": address.Address,
+				},
+			}
+			err = restClient.userStore.UpdateMultisig(mj.InviteCode, update)
+			if err != nil {
+				restClient.log.Errorf("joinBTCMultisig: restClient.userStore.UpdateMultisig: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			}
+			multisig.DeployStatus = true
+			multisig.ContractAddress = address.Address
+		}
+
+		restClient.notifyMultisigOwners(multisig, store.MultisigOwnerAdded, user.UserID, "")
+
+		c.JSON(http.StatusOK, gin.H{
+			"code":     http.StatusOK,
+			"message":  http.StatusText(http.StatusOK),
+			"multisig": multisig,
+		})
+	}
+}
+
+func (restClient *RestClient) newBTCMultisigAddress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+
+		var sp BTCMultisigSpendParams
+		err = decodeBody(c, &sp)
+		if err != nil {
+			restClient.log.Errorf("newBTCMultisigAddress: decodeBody: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrRequestBodyError,
+			})
+			return
+		}
+
+		multisig, ok := btcMultisig(user, sp.InviteCode)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrNoMultisig,
+			})
+			return
+		}
+		if !multisig.DeployStatus {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrMultisigNotDeployd,
+			})
+			return
+		}
+
+		address, err := restClient.addBTCMultisigAddress(&multisig)
+		if err != nil {
+			restClient.log.Errorf("newBTCMultisigAddress: addBTCMultisigAddress: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": msgErrServerError,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": http.StatusText(http.StatusOK),
+			"address": address,
+		})
+	}
+}
+
+func (restClient *RestClient) getBTCMultisig() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+
+		multisig, ok := btcMultisig(user, c.Param("invitecode"))
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrNoMultisig,
+			})
+			return
+		}
+
+		av := []AddressVerbose{}
+		for _, address := range multisig.Addresses {
+			spOuts, err := restClient.userStore.GetAddressSpendableOutputs(address.Address, multisig.CurrencyID, multisig.NetworkID)
+			if err != nil {
+				restClient.log.Errorf("getBTCMultisig: GetAddressSpendableOutputs: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			}
+			var amount int64
+			for _, out := range spOuts {
+				amount += out.TxOutAmount
+			}
+			av = append(av, AddressVerbose{
+				Address:       address.Address,
+				AddressIndex:  address.AddressIndex,
+				Amount:        amount,
+				SpendableOuts: spOuts,
+			})
+		}
+
+		spends, err := restClient.userStore.FindMultisigSpends(multisig.InviteCode)
+		if err != nil {
+			restClient.log.Errorf("getBTCMultisig: FindMultisigSpends: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"code":      http.StatusOK,
+			"message":   http.StatusText(http.StatusOK),
+			"multisig":  multisig,
+			"addresses": av,
+			"spends":    spends,
+		})
+	}
+}
+
+func (restClient *RestClient) proposeBTCMultisigSpend() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+
+		var sp BTCMultisigSpendParams
+		err = decodeBody(c, &sp)
+		if err != nil {
+			restClient.log.Errorf("proposeBTCMultisigSpend: decodeBody: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrRequestBodyError,
+			})
+			return
+		}
+
+		multisig, ok := btcMultisig(user, sp.InviteCode)
+		if !ok || !multisig.DeployStatus {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrNoMultisig,
+			})
+			return
+		}
+
+		if _, err := psbtTxID(sp.PSBT); err != nil {
+			restClient.log.Errorf("proposeBTCMultisigSpend: psbtTxID: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrWrongPSBT,
+			})
+			return
+		}
+
+		spendID, err := randomID()
+		if err != nil {
+			restClient.log.Errorf("proposeBTCMultisigSpend: randomID: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": msgErrServerError,
+			})
+			return
+		}
+
+		spend := store.MultisigSpend{
+			SpendID:        spendID,
+			InviteCode:     multisig.InviteCode,
+			NetworkID:      multisig.NetworkID,
+			Initiator:      user.UserID,
+			PSBT:           sp.PSBT,
+			Signed:         []string{},
+			Owners:         []store.OwnerHistory{},
+			Required:       multisig.Confirmations,
+			Status:         store.MultisigSpendPending,
+			DateOfCreation: time.Now().Unix(),
+		}
+		for _, owner := range multisig.Owners {
+			spend.Owners = append(spend.Owners, store.OwnerHistory{
+				Address: owner.Address,
+			})
+		}
+
+		err = restClient.userStore.InsertMultisigSpend(spend)
+		if err != nil {
+			restClient.log.Errorf("proposeBTCMultisigSpend: InsertMultisigSpend: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": msgErrServerError,
+			})
+			return
+		}
+
+		restClient.notifyMultisigOwners(multisig, store.MultisigSpendProposed, user.UserID, spendID)
+
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": http.StatusText(http.StatusOK),
+			"spend":   spend,
+		})
+	}
+}
+
+func (restClient *RestClient) signBTCMultisigSpend() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+
+		var sp BTCMultisigSpendParams
+		err = decodeBody(c, &sp)
+		if err != nil {
+			restClient.log.Errorf("signBTCMultisigSpend: decodeBody: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrRequestBodyError,
+			})
+			return
+		}
+
+		spend := store.MultisigSpend{}
+		err = restClient.userStore.FindMultisigSpend(sp.SpendID, &spend)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrNoSpend,
+			})
+			return
+		}
+
+		multisig, ok := btcMultisig(user, spend.InviteCode)
+		var xpub string
+		for _, owner := range multisig.Owners {
+			if owner.UserID == user.UserID {
+				xpub = owner.Address
+			}
+		}
+		if !ok || xpub == "" {
+			c.JSON(http.StatusForbidden, gin.H{
+				"code":    http.StatusForbidden,
+				"message": msgErrNotMultisigOwner,
+			})
+			return
+		}
+
+		// the owner signs the very transaction proposed
+		txID, err := psbtTxID(sp.PSBT)
+		proposed, _ := psbtTxID(spend.PSBT)
+		if err != nil || txID != proposed {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrWrongPSBT,
+			})
+			return
+		}
+
+		// and the psbt holds the owner's signatures of every input
+		scripts := map[string]int{}
+		for _, address := range multisig.Addresses {
+			scripts[address.Script] = address.AddressIndex
+		}
+		data, _ := base64.StdEncoding.DecodeString(sp.PSBT)
+		err = btc.CheckPSBTSigned(data, xpub, scripts, multisig.AddressType)
+		if err != nil {
+			restClient.log.Errorf("signBTCMultisigSpend: CheckPSBTSigned: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrPSBTNotSigned,
+			})
+			return
+		}
+
+		err = restClient.userStore.SignMultisigSpend(spend.SpendID, xpub, sp.PSBT, time.Now().Unix(), &spend)
+		if err == mgo.ErrNotFound {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrSpendSigned,
+			})
+			return
+		}
+		if err != nil {
+			restClient.log.Errorf("signBTCMultisigSpend: SignMultisigSpend: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": msgErrServerError,
+			})
+			return
+		}
+
+		signed := 0
+		for _, owner := range spend.Owners {
+			if owner.Confirmed {
+				signed++
+			}
+		}
+		if signed >= spend.Required {
+			spend.Status = store.MultisigSpendReady
+			err = restClient.userStore.UpdateMultisigSpend(spend.SpendID, bson.M{"$set": bson.M{"status": spend.Status}})
+			if err != nil {
+				restClient.log.Errorf("signBTCMultisigSpend: UpdateMultisigSpend: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"code":    http.StatusInternalServerError,
+					"message": msgErrServerError,
+				})
+				return
+			}
+		}
+
+		restClient.notifyMultisigOwners(multisig, store.MultisigSpendSigned, user.UserID, spend.SpendID)
+
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": http.StatusText(http.StatusOK),
+			"spend":   spend,
+		})
+	}
+}
+
+// addBTCMultisigAddress derives next address of the multisig, stores it for every owner and
+// registers it on the node under the creator
+func (restClient *RestClient) addBTCMultisigAddress(multisig *store.Multisig) (store.MultisigAddress, error) {
+	xpubs := []string{}
+	for _, owner := range multisig.Owners {
+		xpubs = append(xpubs, owner.Address)
+	}
+
+	index := len(multisig.Addresses)
+	address, script, err := btc.MultisigAddress(xpubs, multisig.Confirmations, index, multisig.AddressType, multisig.NetworkID)
+	if err != nil {
+		return store.MultisigAddress{}, err
+	}
+	ma := store.MultisigAddress{
+		Address:      address,
+		AddressIndex: index,
+		Script:       script,
+	}
+
+	err = restClient.userStore.UpdateMultisig(multisig.InviteCode, bson.M{"$push": bson.M{"multisig.$.addresses": ma}})
+	if err != nil {
+		return ma, err
+	}
+	multisig.Addresses = append(multisig.Addresses, ma)
+
+	err = AddWatchAndResync(currencies.Bitcoin, multisig.NetworkID, store.MultisigWalletIndex, index, multisig.Owners[0].UserID, address, restClient)
+	return ma, err
+}
+
+func (restClient *RestClient) notifyMultisigOwners(multisig store.Multisig, notifyType int, initiator, txid string) {
+	address := multisig.ContractAddress
+	if address == "" {
+		address = multisig.InviteCode
+	}
+	for _, owner := range multisig.Owners {
+		if owner.UserID == initiator {
+			continue
+		}
+		msg, err := json.Marshal(store.TransactionWithUserID{
+			UserID: owner.UserID,
+			NotificationMsg: &store.WsTxNotify{
+				CurrencyID:      multisig.CurrencyID,
+				NetworkID:       multisig.NetworkID,
+				Address:         address,
+				TxID:            txid,
+				TransactionType: notifyType,
+			},
+		})
+		if err != nil {
+			restClient.log.Errorf("notifyMultisigOwners: json.Marshal: %s", err.Error())
+			continue
+		}
+		err = restClient.BTC.NsqProducer.Publish(store.TopicTransaction, msg)
+		if err != nil {
+			restClient.log.Errorf("notifyMultisigOwners: nsq publish: %s", err.Error())
+		}
+	}
+}
+
+func btcMultisig(user store.User, inviteCode string) (store.Multisig, bool) {
+	for _, multisig := range user.Multisigs {
+		if multisig.CurrencyID == currencies.Bitcoin && multisig.InviteCode != "" && multisig.InviteCode == inviteCode {
+			return multisig, true
+		}
+	}
+	return store.Multisig{}, false
+}
+
+// psbtTxID is the hash of the unsigned transaction of the base64 encoded psbt
+func psbtTxID(psbt string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(psbt)
+	if err != nil {
+		return "", fmt.Errorf("psbtTxID: %s", err.Error())
+	}
+	tx, err := btc.PSBTUnsignedTx(data)
+	if err != nil {
+		return "", err
+	}
+	return tx.TxHash().String(), nil
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Multy-io/Multy-back/btc"
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+	nsq "github.com/bitly/go-nsq"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/gin-gonic/gin"
+	"github.com/jekabolt/slf"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// spendsStore keeps users by their token hash and a single multisig spend
+type spendsStore struct {
+	store.UserStore
+	users map[string]store.User
+	spend store.MultisigSpend
+}
+
+func (ss *spendsStore) FindUser(query bson.M, user *store.User) error {
+	u, ok := ss.users[query["devices.JWT"].(string)]
+	if !ok {
+		return errors.New("not found")
+	}
+	*user = u
+	return nil
+}
+
+func (ss *spendsStore) FindMultisigSpend(spendID string, spend *store.MultisigSpend) error {
+	if spendID != ss.spend.SpendID {
+		return mgo.ErrNotFound
+	}
+	*spend = ss.spend
+	spend.Owners = append([]store.OwnerHistory{}, ss.spend.Owners...)
+	return nil
+}
+
+func (ss *spendsStore) SignMultisigSpend(spendID, xpub, psbt string, now int64, spend *store.MultisigSpend) error {
+	for i, owner := range ss.spend.Owners {
+		if owner.Address == xpub && !owner.Confirmed && ss.spend.Status == store.MultisigSpendPending {
+			ss.spend.Owners[i].Confirmed = true
+			ss.spend.Owners[i].ConfirmationTime = now
+			ss.spend.Signed = append(ss.spend.Signed, psbt)
+			return ss.FindMultisigSpend(spendID, spend)
+		}
+	}
+	return mgo.ErrNotFound
+}
+
+func (ss *spendsStore) UpdateMultisigSpend(spendID string, update bson.M) error {
+	ss.spend.Status = update["$set"].(bson.M)["status"].(string)
+	return nil
+}
+
+// testMultisigPSBT spends an output of the P2SH multisig, every key signs the input
+func testMultisigPSBT(value int64, script []byte, keys ...*btcec.PrivateKey) string {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(value, []byte{0x00, 0x14}))
+
+	var b bytes.Buffer
+	b.Write([]byte{'p', 's', 'b', 't', 0xff})
+	unsigned := bytes.Buffer{}
+	tx.SerializeNoWitness(&unsigned)
+	wire.WriteVarBytes(&b, 0, []byte{0x00})
+	wire.WriteVarBytes(&b, 0, unsigned.Bytes())
+	b.WriteByte(0x00)
+
+	// SIGHASH_ALL digest of the legacy input
+	tx.TxIn[0].SignatureScript = script
+	preimage := bytes.Buffer{}
+	tx.SerializeNoWitness(&preimage)
+	preimage.Write([]byte{0x01, 0x00, 0x00, 0x00})
+	hash := chainhash.DoubleHashB(preimage.Bytes())
+	for _, key := range keys {
+		sig, _ := key.Sign(hash)
+		wire.WriteVarBytes(&b, 0, append([]byte{0x02}, key.PubKey().SerializeCompressed()...))
+		wire.WriteVarBytes(&b, 0, append(sig.Serialize(), 0x01))
+	}
+	wire.WriteVarBytes(&b, 0, []byte{0x04})
+	wire.WriteVarBytes(&b, 0, script)
+	b.Write([]byte{0x00, 0x00})
+	return base64.StdEncoding.EncodeToString(b.Bytes())
+}
+
+func TestSignBTCMultisigSpend(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	xpubs := []string{}
+	keys := []*btcec.PrivateKey{}
+	for i := byte(1); i <= 3; i++ {
+		master, _ := hdkeychain.NewMaster(bytes.Repeat([]byte{i}, 32), &chaincfg.TestNet3Params)
+		pub, _ := master.Neuter()
+		xpubs = append(xpubs, pub.String())
+		external, _ := master.Child(0)
+		child, _ := external.Child(0)
+		key, _ := child.ECPrivKey()
+		keys = append(keys, key)
+	}
+	address, script, err := btc.MultisigAddress(xpubs, 2, 0, store.MultisigAddressP2SH, currencies.Test)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redeem, _ := hex.DecodeString(script)
+
+	multisig := store.Multisig{
+		CurrencyID:   0,
+		NetworkID:    currencies.Test,
+		InviteCode:   "invite",
+		DeployStatus: true,
+		AddressType:  store.MultisigAddressP2SH,
+		Owners: []store.AddressExtended{
+			{UserID: "alice", Address: xpubs[0]},
+			{UserID: "bob", Address: xpubs[1]},
+			{UserID: "carol", Address: xpubs[2]},
+		},
+		Addresses: []store.MultisigAddress{{Address: address, AddressIndex: 0, Script: script}},
+	}
+	db := &spendsStore{
+		users: map[string]store.User{
+			store.TokenHash("alice"):   {UserID: "alice", Multisigs: []store.Multisig{multisig}},
+			store.TokenHash("bob"):     {UserID: "bob", Multisigs: []store.Multisig{multisig}},
+			store.TokenHash("mallory"): {UserID: "mallory"},
+		},
+		spend: store.MultisigSpend{
+			SpendID:    "spend",
+			InviteCode: "invite",
+			PSBT:       testMultisigPSBT(100000, redeem),
+			Owners:     []store.OwnerHistory{{Address: xpubs[0]}, {Address: xpubs[1]}, {Address: xpubs[2]}},
+			Required:   2,
+			Status:     store.MultisigSpendPending,
+		},
+	}
+	// notifications are published to nowhere
+	producer, _ := nsq.NewProducer("127.0.0.1:1", nsq.NewConfig())
+	producer.SetLogger(nil, nsq.LogLevelError)
+	restClient := &RestClient{userStore: db, log: slf.WithContext("test"), BTC: &btc.BTCConn{NsqProducer: producer}}
+
+	r := gin.New()
+	r.POST("/multisig/btc/sign", restClient.signBTCMultisigSpend())
+	sign := func(token, spendID, psbt string) int {
+		req := httptest.NewRequest("POST", "/multisig/btc/sign", bytes.NewBufferString(`{"spendid":"`+spendID+`","psbt":"`+psbt+`"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	signed := testMultisigPSBT(100000, redeem, keys[0])
+	if code := sign("mallory", "spend", signed); code != http.StatusForbidden {
+		t.Errorf("non owner signed %d", code)
+	}
+	if code := sign("alice", "other", signed); code != http.StatusBadRequest {
+		t.Errorf("unknown spend signed %d", code)
+	}
+	// the same inputs paying another amount
+	if code := sign("alice", "spend", testMultisigPSBT(900000, redeem, keys[0])); code != http.StatusBadRequest {
+		t.Errorf("other transaction signed %d", code)
+	}
+	// the proposed transaction without the owner's signature
+	if code := sign("alice", "spend", testMultisigPSBT(100000, redeem)); code != http.StatusBadRequest {
+		t.Errorf("unsigned transaction confirmed %d", code)
+	}
+	if code := sign("alice", "spend", testMultisigPSBT(100000, redeem, keys[1])); code != http.StatusBadRequest {
+		t.Errorf("transaction confirmed by signature of another owner %d", code)
+	}
+	if len(db.spend.Signed) != 0 {
+		t.Fatalf("rejected signatures stored %v", db.spend.Signed)
+	}
+
+	if code := sign("alice", "spend", signed); code != http.StatusOK || db.spend.Status != store.MultisigSpendPending {
+		t.Errorf("wrong first signature %d %s", code, db.spend.Status)
+	}
+	if code := sign("alice", "spend", signed); code != http.StatusBadRequest || len(db.spend.Signed) != 1 {
+		t.Errorf("signed twice %d %v", code, db.spend.Signed)
+	}
+	if code := sign("bob", "spend", testMultisigPSBT(100000, redeem, keys[0], keys[1])); code != http.StatusOK || db.spend.Status != store.MultisigSpendReady {
+		t.Errorf("wrong second signature %d %s", code, db.spend.Status)
+	}
+	if !db.spend.Owners[0].Confirmed || !db.spend.Owners[1].Confirmed || db.spend.Owners[2].Confirmed {
+		t.Errorf("wrong owners %+v", db.spend.Owners)
+	}
+}
@@ -7,12 +7,7 @@ package client
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/sha512"
-	"encoding/base64"
-	"encoding/json"
 	"errors"
-	"io/ioutil"
 	"net/http"
 	"sort"
 	"strconv"
@@ -57,6 +52,9 @@ const (
 	msgErrAdressBalance         = "empty address or 3-rd party server error"
 	msgErrChainIsNotImplemented = "current chain is not implemented"
 	msgErrUserHaveNoTxs         = "user have no transactions"
+	msgErrAddressType           = "address doesn't match wallet address type"
+	msgErrScriptType            = "unknown input or output type"
+	msgErrGapLimit              = "gap limit is out of range"
 )
 
 type RestClient struct {
@@ -78,6 +76,17 @@ type RestClient struct {
 	ETH          *eth.ETHConn
 	MultyVerison store.ServerConfig
 	Secretkey    string
+
+	// hub delivers events of socket.io instance to SSE and WebSocket streams
+	hub *EventHub
+	// chart is set with socket.io handlers
+	chart *exchangeChart
+	// changelly is nil if Changelly API key isn't configured
+	changelly *Changelly
+	// streams are origins allowed to open event streams
+	streams StreamConf
+	// revocations are tokens recently found not revoked
+	revocations revocationCache
 }
 
 type BTCApiConf struct {
@@ -92,6 +101,8 @@ func SetRestHandlers(
 	eth *eth.ETHConn,
 	mv store.ServerConfig,
 	secretkey string,
+	changelly *Changelly,
+	streams StreamConf,
 ) (*RestClient, error) {
 	restClient := &RestClient{
 		userStore:         userDB,
@@ -101,14 +112,23 @@ func SetRestHandlers(
 		ETH:               eth,
 		MultyVerison:      mv,
 		Secretkey:         secretkey,
+		hub:               NewEventHub(),
+		changelly:         changelly,
+		streams:           streams,
 	}
 	initMiddlewareJWT(restClient)
 
 	r.POST("/auth", restClient.LoginHandler())
+	r.POST("/auth/challenge", restClient.authChallenge())
+	r.POST("/auth/refresh", restClient.middlewareJWT.MiddlewareFunc(), restClient.refreshHandler())
 	r.GET("/server/config", restClient.getServerConfig())
 
 	r.GET("/donations", restClient.donations())
 
+	// browsers open streams with ?ticket=, the handlers authenticate them
+	r.GET("/api/v1/events/stream", restClient.streamUserEvents)
+	r.GET("/api/v1/events/ws", restClient.wsUserEvents())
+
 	v1 := r.Group("/api/v1")
 	v1.Use(restClient.middlewareJWT.MiddlewareFunc())
 	{
@@ -124,6 +144,37 @@ func SetRestHandlers(
 		v1.POST("/wallet/name", restClient.changeWalletName())
 		v1.POST("/resync/wallet/:currencyid/:networkid/:walletindex", restClient.resyncWallet())
 		v1.GET("/exchange/changelly/list", restClient.changellyListCurrencies())
+		v1.GET("/exchange/changelly/minamount", restClient.changellyMinAmount())
+		v1.GET("/exchange/changelly/estimate", restClient.changellyEstimate())
+		v1.POST("/exchange/changelly/transaction", restClient.createExchange())
+		v1.GET("/exchange/changelly/transactions", restClient.getExchanges())
+		v1.POST("/multisig/btc", restClient.createBTCMultisig())
+		v1.POST("/multisig/btc/join", restClient.joinBTCMultisig())
+		v1.POST("/multisig/btc/address", restClient.newBTCMultisigAddress())
+		v1.GET("/multisig/btc/:invitecode", restClient.getBTCMultisig())
+		v1.POST("/multisig/btc/spend", restClient.proposeBTCMultisigSpend())
+		v1.POST("/multisig/btc/spend/sign", restClient.signBTCMultisigSpend())
+		v1.GET("/preferences", restClient.getPreferences())
+		v1.POST("/preferences", restClient.setPreferences())
+		v1.POST("/webhooks", restClient.createWebhook())
+		v1.GET("/webhooks", restClient.getWebhooks())
+		v1.DELETE("/webhooks/:webhookid", restClient.deleteWebhook())
+		v1.GET("/webhooks/:webhookid/deliveries", restClient.getWebhookDeliveries())
+		v1.POST("/webhooks/deliveries/:deliveryid/replay", restClient.replayWebhookDelivery())
+		v1.POST("/pricealerts", restClient.createPriceAlert())
+		v1.GET("/pricealerts", restClient.getPriceAlerts())
+		v1.DELETE("/pricealerts/:alertid", restClient.deletePriceAlert())
+		v1.GET("/security/events", restClient.getSecurityEvents())
+		v1.GET("/events", restClient.getUserEvents())
+		v1.POST("/events/ticket", restClient.streamTicket())
+		v1.GET("/rates", restClient.getRates())
+		v1.GET("/rates/history", restClient.getRatesHistory())
+		v1.GET("/portfolio", restClient.getPortfolio())
+		v1.POST("/auth/key", restClient.addLoginKey())
+		v1.POST("/logout", restClient.logout())
+		v1.POST("/logout/others", restClient.logoutOthers())
+		v1.GET("/devices", restClient.getDevices())
+		v1.DELETE("/devices/:deviceid", restClient.deleteDevice())
 	}
 	return restClient, nil
 }
@@ -133,7 +184,13 @@ func initMiddlewareJWT(restClient *RestClient) {
 		Realm:      "test zone",
 		Key:        []byte(restClient.Secretkey), // config
 		Timeout:    time.Hour,
-		MaxRefresh: time.Hour,
+		MaxRefresh: jwtMaxRefresh,
+		Revoked:    restClient.tokenRevoked,
+		// stream tickets open event streams only
+		Authorizator: func(userID string, c *gin.Context) bool {
+			_, ticket := ExtractClaims(c)["aud"]
+			return !ticket
+		},
 		Authenticator: func(userId, deviceId, pushToken string, deviceType int, c *gin.Context) (store.User, bool) {
 			query := bson.M{"userID": userId}
 
@@ -162,6 +219,11 @@ type WalletParams struct {
 	AddressIndex int    `json:"addressIndex"`
 	WalletIndex  int    `json:"walletIndex"`
 	WalletName   string `json:"walletName"`
+	// p2pkh, p2sh-p2wpkh (BIP49) or p2wpkh (BIP84), bitcoin wallets are legacy if empty
+	AddressType string `json:"addressType"`
+	// account extended public key of watch-only wallet, address is not needed then
+	Xpub     string `json:"xpub"`
+	GapLimit int    `json:"gapLimit"`
 }
 
 type SelectWallet struct {
@@ -210,7 +272,8 @@ type AddressEx struct { // extended
 	Amount    int    `bson:"amount"` //float64
 }
 
-func getToken(c *gin.Context) (string, error) {
+// requestToken returns JWT of the request
+func requestToken(c *gin.Context) (string, error) {
 	authHeader := strings.Split(c.GetHeader("Authorization"), " ")
 	if len(authHeader) < 2 {
 		return "", errors.New(msgErrHeaderError)
@@ -218,6 +281,39 @@ func getToken(c *gin.Context) (string, error) {
 	return authHeader[1], nil
 }
 
+// getToken returns hash of the request JWT, devices are found by it
+func getToken(c *gin.Context) (string, error) {
+	token, err := requestToken(c)
+	if err != nil {
+		return "", err
+	}
+	return store.TokenHash(token), nil
+}
+
+// userByToken finds user of the request, responds with error itself
+func (restClient *RestClient) userByToken(c *gin.Context) (store.User, error) {
+	user := store.User{}
+	token, err := getToken(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": msgErrHeaderError,
+		})
+		return user, err
+	}
+
+	err = restClient.userStore.FindUser(bson.M{"devices.JWT": token}, &user)
+	if err != nil {
+		restClient.log.Errorf("userByToken: restClient.userStore.FindUser: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    http.StatusBadRequest,
+			"message": msgErrUserNotFound,
+		})
+		return user, err
+	}
+	return user, nil
+}
+
 func createCustomWallet(wp WalletParams, token string, restClient *RestClient, c *gin.Context) error {
 	user := store.User{}
 	query := bson.M{"devices.JWT": token}
@@ -236,8 +332,18 @@ func createCustomWallet(wp WalletParams, token string, restClient *RestClient, c
 		}
 	}
 
+	address, err := checkAddress(wp.Address, wp.CurrencyID, wp.NetworkID, wp.AddressType)
+	if err != nil {
+		restClient.log.Errorf("createCustomWallet: checkAddress: %s %s\t[addr=%s]", wp.Address, err.Error(), c.Request.RemoteAddr)
+		return err
+	}
+	wp.Address = address
+
 	sel := bson.M{"devices.JWT": token}
 	wallet := createWallet(wp.CurrencyID, wp.NetworkID, wp.Address, wp.AddressIndex, wp.WalletIndex, wp.WalletName)
+	if wp.CurrencyID == currencies.Bitcoin {
+		wallet.AddressType = wp.AddressType
+	}
 	update := bson.M{"$push": bson.M{"wallets": wallet}}
 
 	err = restClient.userStore.Update(sel, update)
@@ -257,6 +363,74 @@ func createCustomWallet(wp WalletParams, token string, restClient *RestClient, c
 	return nil
 }
 
+// createXpubWallet adds watch-only wallet of the account extended public key.
+// Server derives gap limit window of receive and change addresses and keeps it moving as addresses get used.
+func createXpubWallet(wp WalletParams, token string, restClient *RestClient, c *gin.Context) error {
+	if wp.CurrencyID != currencies.Bitcoin {
+		return errors.New(msgErrChainIsNotImplemented)
+	}
+	if wp.NetworkID != currencies.Main && wp.NetworkID != currencies.Test {
+		return errors.New(msgErrDecodeNetworkIDErr)
+	}
+	// the window is derived and watched in the request, it's kept small
+	gapLimit := wp.GapLimit
+	if gapLimit == 0 {
+		gapLimit = store.DefaultGapLimit
+	}
+	if gapLimit < 1 || gapLimit > store.MaxGapLimit {
+		return errors.New(msgErrGapLimit)
+	}
+	addressType, err := btc.XpubAddressType(wp.Xpub, wp.NetworkID)
+	if err != nil {
+		restClient.log.Errorf("createXpubWallet: btc.XpubAddressType: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+		return errors.New(msgErrWrongXpub)
+	}
+
+	user := store.User{}
+	query := bson.M{"devices.JWT": token}
+	err = restClient.userStore.FindUser(query, &user)
+	if err != nil {
+		restClient.log.Errorf("createXpubWallet: restClient.userStore.FindUser: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+		return errors.New(msgErrUserNotFound)
+	}
+	for _, wallet := range user.Wallets {
+		if wallet.CurrencyID == wp.CurrencyID && wallet.NetworkID == wp.NetworkID && wallet.WalletIndex == wp.WalletIndex {
+			return errors.New(msgErrWalletIndex)
+		}
+	}
+
+	wallet := createWallet(wp.CurrencyID, wp.NetworkID, "", 0, wp.WalletIndex, wp.WalletName)
+	wallet.Xpub = wp.Xpub
+	wallet.AddressType = addressType
+	wallet.GapLimit = gapLimit
+	wallet.Adresses = []store.Address{}
+	addresses, err := btc.XpubWindow(wallet)
+	if err != nil {
+		restClient.log.Errorf("createXpubWallet: btc.XpubWindow: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+		return errors.New(msgErrWrongXpub)
+	}
+	wallet.Adresses = addresses
+
+	sel := bson.M{"devices.JWT": token}
+	update := bson.M{"$push": bson.M{"wallets": wallet}}
+	err = restClient.userStore.Update(sel, update)
+	if err != nil {
+		restClient.log.Errorf("createXpubWallet: restClient.userStore.Update: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+		return errors.New(msgErrServerError)
+	}
+
+	go func() {
+		for _, address := range addresses {
+			err := AddWatchAndResync(wp.CurrencyID, wp.NetworkID, wp.WalletIndex, address.AddressIndex, user.UserID, address.Address, restClient)
+			if err != nil {
+				restClient.log.Errorf("createXpubWallet: AddWatchAndResync: %s", err.Error())
+			}
+		}
+	}()
+
+	return nil
+}
+
 func changeName(cn ChangeName, token string, restClient *RestClient, c *gin.Context) error {
 	user := store.User{}
 	query := bson.M{"devices.JWT": token}
@@ -313,17 +487,25 @@ func addAddressToWallet(address, token string, currencyID, networkid, walletInde
 	}
 
 	var position int
+	addressType := ""
 	for i, wallet := range user.Wallets {
 		if wallet.NetworkID == networkid && wallet.CurrencyID == currencyID && wallet.WalletIndex == walletIndex {
 			position = i
+			addressType = wallet.AddressType
 			for _, walletAddress := range wallet.Adresses {
-				if walletAddress.AddressIndex == addressIndex {
+				// change addresses of watch-only wallets are indexed on their own chain
+				if !walletAddress.IsChange && walletAddress.AddressIndex == addressIndex {
 					return errors.New(msgErrAddressIndex)
 				}
 			}
 		}
 	}
 
+	address, err := checkAddress(address, currencyID, networkid, addressType)
+	if err != nil {
+		return err
+	}
+
 	addr := store.Address{
 		Address:        address,
 		AddressIndex:   addressIndex,
@@ -430,7 +612,11 @@ func (restClient *RestClient) addWallet() gin.HandlerFunc {
 			return
 		}
 
-		err = createCustomWallet(wp, token, restClient, c)
+		if wp.Xpub != "" {
+			err = createXpubWallet(wp, token, restClient, c)
+		} else {
+			err = createCustomWallet(wp, token, restClient, c)
+		}
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"code":    http.StatusBadRequest,
@@ -556,6 +742,24 @@ func getBTCAddressSpendableOutputs(address string, currencyID, networkID int, re
 	if err != nil && err != mgo.ErrNotFound {
 		restClient.log.Errorf("getBTCAddressSpendableOutputs: GetAddressSpendableOutputs: %s\t", err.Error())
 	}
+	for i := range spOuts {
+		if spOuts[i].ScriptType == "" {
+			spOuts[i].ScriptType = btc.ScriptType(spOuts[i].TxOutScript)
+		}
+	}
+	return spOuts
+}
+
+// walletScriptTypes marks P2SH outputs of nested segwit wallet, script alone doesn't tell it
+func walletScriptTypes(spOuts []store.SpendableOutputs, wallet store.Wallet) []store.SpendableOutputs {
+	if wallet.AddressType != store.AddressP2SHP2WPKH {
+		return spOuts
+	}
+	for i := range spOuts {
+		if spOuts[i].ScriptType == store.AddressP2SH {
+			spOuts[i].ScriptType = store.AddressP2SHP2WPKH
+		}
+	}
 	return spOuts
 }
 
@@ -732,6 +936,13 @@ func (restClient *RestClient) deleteWallet() gin.HandlerFunc {
 			})
 			return
 		}
+
+		restClient.securityEvent(user.UserID, store.SecurityWalletDeleted, tokenDevice(user, token), c.ClientIP(), map[string]string{
+			"currencyid":  strconv.Itoa(currencyId),
+			"networkid":   strconv.Itoa(networkid),
+			"walletindex": c.Param("walletindex"),
+		})
+
 		c.JSON(code, gin.H{
 			"code":    code,
 			"message": message,
@@ -907,11 +1118,39 @@ func (restClient *RestClient) getFeeRate() gin.HandlerFunc {
 
 			restClient.log.Debugf("FeeRates for Bitcoin network id %d is: %v :\n memPoolSize is: %v ", networkid, sp, memPoolSize)
 
-			c.JSON(http.StatusOK, gin.H{
+			resp := gin.H{
 				"speeds":  sp,
 				"code":    http.StatusOK,
 				"message": http.StatusText(http.StatusOK),
-			})
+			}
+
+			// rates are per virtual byte, so fee of the transaction depends on types of it's inputs and outputs
+			// ?inputs=p2wpkh,p2pkh&outputs=p2wpkh,p2sh, without outputs payment and change to P2WPKH are counted
+			if inputs := c.Query("inputs"); inputs != "" {
+				var outputs []string
+				if c.Query("outputs") != "" {
+					outputs = strings.Split(c.Query("outputs"), ",")
+				}
+				vsize, err := btc.EstimateVsize(strings.Split(inputs, ","), outputs)
+				if err != nil {
+					restClient.log.Errorf("getFeeRate: btc.EstimateVsize: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+					c.JSON(http.StatusBadRequest, gin.H{
+						"code":    http.StatusBadRequest,
+						"message": msgErrScriptType,
+					})
+					return
+				}
+				resp["vsize"] = vsize
+				resp["fees"] = EstimationSpeeds{
+					VerySlow: sp.VerySlow * vsize,
+					Slow:     sp.Slow * vsize,
+					Medium:   sp.Medium * vsize,
+					Fast:     sp.Fast * vsize,
+					VeryFast: sp.VeryFast * vsize,
+				}
+			}
+
+			c.JSON(http.StatusOK, resp)
 		case currencies.Ether:
 			//TODO: make eth feerate
 			//var rate *ethpb.GasPrice
@@ -1059,6 +1298,11 @@ func (restClient *RestClient) getSpendableOutputs() gin.HandlerFunc {
 		if err != nil {
 			restClient.log.Errorf("getSpendableOutputs: GetAddressSpendableOutputs:[%d] %s \t[addr=%s]", currencyID, err.Error(), c.Request.RemoteAddr)
 		}
+		for i := range spOuts {
+			if currencyID == currencies.Bitcoin && spOuts[i].ScriptType == "" {
+				spOuts[i].ScriptType = btc.ScriptType(spOuts[i].TxOutScript)
+			}
+		}
 
 		c.JSON(code, gin.H{
 			"code":    code,
@@ -1372,7 +1616,7 @@ func (restClient *RestClient) getWalletVerbose() gin.HandlerFunc {
 			}
 			var pending bool
 			for _, address := range wallet.Adresses {
-				spOuts := getBTCAddressSpendableOutputs(address.Address, currencyId, networkId, restClient)
+				spOuts := walletScriptTypes(getBTCAddressSpendableOutputs(address.Address, currencyId, networkId, restClient), wallet)
 				for _, spOut := range spOuts {
 					if spOut.TxStatus == store.TxStatusAppearedInMempoolIncoming {
 						pending = true
@@ -1388,6 +1632,7 @@ func (restClient *RestClient) getWalletVerbose() gin.HandlerFunc {
 					Amount:         int64(checkBTCAddressbalance(address.Address, currencyId, networkId, restClient)),
 					SpendableOuts:  spOuts,
 					IsSyncing:      sync,
+					IsChange:       address.IsChange,
 				})
 			}
 			wv = append(wv, WalletVerbose{
@@ -1399,6 +1644,8 @@ func (restClient *RestClient) getWalletVerbose() gin.HandlerFunc {
 				DateOfCreation: wallet.DateOfCreation,
 				VerboseAddress: av,
 				Pending:        pending,
+				WatchOnly:      wallet.Xpub != "",
+				AddressType:    wallet.AddressType,
 			})
 			av = []AddressVerbose{}
 
@@ -1631,6 +1878,8 @@ type WalletVerbose struct {
 	DateOfCreation int64            `json:"dateofcreation"`
 	VerboseAddress []AddressVerbose `json:"addresses"`
 	Pending        bool             `json:"pending"`
+	WatchOnly      bool             `json:"watchonly,omitempty"`
+	AddressType    string           `json:"addresstype,omitempty"`
 }
 
 type WalletVerboseETH struct {
@@ -1656,6 +1905,7 @@ type AddressVerbose struct {
 	SpendableOuts  []store.SpendableOutputs `json:"spendableoutputs,omitempty"`
 	Nonce          int64                    `json:"nonce,omitempty"`
 	IsSyncing      bool                     `json:"issyncing"`
+	IsChange       bool                     `json:"ischange,omitempty"`
 }
 
 type ETHAddressVerbose struct {
@@ -1759,7 +2009,7 @@ func (restClient *RestClient) getAllWalletsVerbose() gin.HandlerFunc {
 				var av []AddressVerbose
 				var pending bool
 				for _, address := range wallet.Adresses {
-					spOuts := getBTCAddressSpendableOutputs(address.Address, wallet.CurrencyID, wallet.NetworkID, restClient)
+					spOuts := walletScriptTypes(getBTCAddressSpendableOutputs(address.Address, wallet.CurrencyID, wallet.NetworkID, restClient), wallet)
 
 					//all user txs
 					err = restClient.userStore.GetAllWalletTransactions(user.UserID, wallet.CurrencyID, wallet.NetworkID, &userTxs)
@@ -1791,6 +2041,7 @@ func (restClient *RestClient) getAllWalletsVerbose() gin.HandlerFunc {
 						Amount:         int64(checkBTCAddressbalance(address.Address, wallet.CurrencyID, wallet.NetworkID, restClient)),
 						SpendableOuts:  spOuts,
 						IsSyncing:      sync,
+						IsChange:       address.IsChange,
 					})
 
 				}
@@ -1804,6 +2055,8 @@ func (restClient *RestClient) getAllWalletsVerbose() gin.HandlerFunc {
 					DateOfCreation: wallet.DateOfCreation,
 					VerboseAddress: av,
 					Pending:        pending,
+					WatchOnly:      wallet.Xpub != "",
+					AddressType:    wallet.AddressType,
 				})
 				av = []AddressVerbose{}
 				userTxs = []store.MultyTX{}
@@ -1888,6 +2141,10 @@ func (restClient *RestClient) getAllWalletsVerbose() gin.HandlerFunc {
 
 		}
 		for _, multisig := range user.Multisigs {
+			if multisig.CurrencyID != currencies.Ether {
+				// bitcoin multisig has own verbose
+				continue
+			}
 			var av []ETHAddressVerbose
 			var pending bool
 
@@ -2101,12 +2358,14 @@ func (restClient *RestClient) getWalletTransactionsHistory() gin.HandlerFunc {
 					}
 				}
 			}
+			payouts := restClient.exchangePayouts(user.UserID, currencyId, networkid, walletIndex)
 			for i := 0; i < len(walletTxs); i++ {
 				if walletTxs[i].BlockHeight == -1 {
 					walletTxs[i].Confirmations = 0
 				} else {
 					walletTxs[i].Confirmations = int(blockHeight-walletTxs[i].BlockHeight) + 1
 				}
+				walletTxs[i].ExchangeID = payouts[strings.ToLower(walletTxs[i].TxID)]
 			}
 
 			c.JSON(http.StatusOK, gin.H{
@@ -2165,9 +2424,11 @@ func (restClient *RestClient) getWalletTransactionsHistory() gin.HandlerFunc {
 					}
 				}
 
+				payouts := restClient.exchangePayouts(user.UserID, currencyId, networkid, walletIndex)
 				history := []store.TransactionETH{}
 				for _, tx := range userTxs {
 					if tx.WalletIndex == walletIndex {
+						tx.ExchangeID = payouts[strings.ToLower(tx.Hash)]
 						history = append(history, tx)
 					}
 				}
@@ -2342,73 +2603,14 @@ func (restClient *RestClient) resyncWallet() gin.HandlerFunc {
 
 			}
 		case currencies.Ether:
-			if networkID == currencies.ETHMain {
-
-			}
-
-			if networkID == currencies.ETHTest {
-
+			// the node resyncs watched addresses and ResyncCompleted is sent for each of them
+			for _, address := range walletToResync.Adresses {
+				err := NewAddressNode(address.Address, user.UserID, currencyID, networkID, walletIndex, address.AddressIndex, restClient)
+				if err != nil {
+					restClient.log.Errorf("resyncWallet case currencies.Ether: %v", err.Error())
+				}
 			}
 		}
 
 	}
 }
-
-func (restClient *RestClient) changellyListCurrencies() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		apiUrl := "https://api.changelly.com"
-		apiKey := "8015e09ba78243ad889db470ec48fed4"
-		apiSecret := "712bfcf899dd235b0af1d66922d5962e8c85a909635f838688a38b5f12c4d03a"
-		cr := ChangellyReqest{
-			JsonRpc: "2.0",
-			ID:      1,
-			Method:  "getCurrencies",
-			Params:  []string{},
-		}
-		bs, err := json.Marshal(cr)
-		if err != nil {
-			restClient.log.Errorf("changellyListCurrencies: json.Marshal: %s \t[addr=%s]", err.Error(), c.Request.RemoteAddr)
-			//
-			return
-		}
-
-		sign := ComputeHmac512(bs, apiSecret)
-		req, err := http.NewRequest("GET", apiUrl, nil)
-		if err != nil {
-			restClient.log.Errorf("changellyListCurrencies: http.NewRequest: %s \t[addr=%s]", err.Error(), c.Request.RemoteAddr)
-			//
-			return
-		}
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("api-key", apiKey)
-		req.Header.Set("sign", sign)
-
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			restClient.log.Errorf("changellyListCurrencies: http.Client.Do: %s \t[addr=%s]", err.Error(), c.Request.RemoteAddr)
-			//
-			return
-		}
-		defer resp.Body.Close()
-		body, _ := ioutil.ReadAll(resp.Body)
-		c.JSON(http.StatusOK, gin.H{
-			"code":    resp.StatusCode,
-			"message": string(body),
-		})
-	}
-}
-
-func ComputeHmac512(message []byte, secret string) string {
-	key := []byte(secret)
-	h := hmac.New(sha512.New, key)
-	h.Write(message)
-	return base64.StdEncoding.EncodeToString(h.Sum(nil))
-}
-
-type ChangellyReqest struct {
-	JsonRpc string   `json:"jsonrpc"`
-	ID      int      `json:"id"`
-	Method  string   `json:"method"`
-	Params  []string `json:"params"`
-}
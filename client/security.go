@@ -0,0 +1,269 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// securityPushEvent is event data of security pushes
+	securityPushEvent = "security"
+
+	securityEventsLimit    = 50
+	maxSecurityEventsLimit = 500
+	// securityEventsRetention is how long security events are kept
+	securityEventsRetention = 180 * 24 * time.Hour
+)
+
+// defaultLargeOutgoing are outgoing amounts in the smallest units which raise a security event
+// for currencies not configured in NotifiersConf.LargeOutgoing
+var defaultLargeOutgoing = map[int]*big.Int{
+	currencies.Bitcoin: big.NewInt(10000000),                                           // 0.1 BTC
+	currencies.Ether:   new(big.Int).Exp(big.NewInt(10), big.NewInt(18), new(big.Int)), // 1 ETH
+}
+
+// largeOutgoingLimits returns defaults with configured amounts by currency id, zero amount turns events of the currency off
+func largeOutgoingLimits(conf map[string]string) (map[int]*big.Int, error) {
+	limits := map[int]*big.Int{}
+	for currencyID, limit := range defaultLargeOutgoing {
+		limits[currencyID] = limit
+	}
+	for currency, amount := range conf {
+		currencyID, err := strconv.Atoi(currency)
+		if err != nil {
+			return nil, errors.New("largeOutgoingLimits: wrong currency id " + currency)
+		}
+		limit, ok := new(big.Int).SetString(amount, 10)
+		if !ok || limit.Sign() < 0 {
+			return nil, errors.New("largeOutgoingLimits: wrong amount " + amount)
+		}
+		if limit.Sign() == 0 {
+			delete(limits, currencyID)
+			continue
+		}
+		limits[currencyID] = limit
+	}
+	return limits, nil
+}
+
+// securityTemplates is the catalogue of security push texts: locale -> event type -> template,
+// {ip}, {device}, {amount} and {currency} are replaced with event details
+var securityTemplates = map[string]map[string]pushTemplate{
+	"en": {
+		store.SecurityNewDevice:        {"New device", "A new {device} device signed in to your account from {ip}"},
+		store.SecurityWalletDeleted:    {"Wallet deleted", "A {currency} wallet was deleted from your account"},
+		store.SecurityPushTokenChanged: {"Device changed", "Notifications of your {device} device were moved to another app installation"},
+		store.SecurityLargeOutgoing:    {"Large transaction", "{amount} {currency} is being sent from your wallet"},
+		store.SecurityLoginKeyAdded:    {"Login key added", "Your account now requires a signed login, it was set from a {device} device at {ip}"},
+		store.SecurityDevicesLoggedOut: {"Devices logged out", "Other devices were logged out of your account from a {device} device at {ip}"},
+	},
+	"ru": {
+		store.SecurityNewDevice:        {"Новое устройство", "В ваш аккаунт вошли с нового устройства {device} с адреса {ip}"},
+		store.SecurityWalletDeleted:    {"Кошелек удален", "Из вашего аккаунта удален {currency} кошелек"},
+		store.SecurityPushTokenChanged: {"Устройство изменено", "Уведомления вашего устройства {device} перенесены на другую установку приложения"},
+		store.SecurityLargeOutgoing:    {"Крупная транзакция", "С вашего кошелька отправляется {amount} {currency}"},
+		store.SecurityLoginKeyAdded:    {"Добавлен ключ входа", "Для входа в ваш аккаунт теперь нужна подпись, ключ добавлен с устройства {device} с адреса {ip}"},
+		store.SecurityDevicesLoggedOut: {"Выход на устройствах", "С устройства {device} с адреса {ip} выполнен выход из вашего аккаунта на других устройствах"},
+	},
+	"uk": {
+		store.SecurityNewDevice:        {"Новий пристрій", "До вашого акаунту увійшли з нового пристрою {device} з адреси {ip}"},
+		store.SecurityWalletDeleted:    {"Гаманець видалено", "З вашого акаунту видалено {currency} гаманець"},
+		store.SecurityPushTokenChanged: {"Пристрій змінено", "Сповіщення вашого пристрою {device} перенесено на іншу інсталяцію застосунку"},
+		store.SecurityLargeOutgoing:    {"Велика транзакція", "З вашого гаманця надсилається {amount} {currency}"},
+		store.SecurityLoginKeyAdded:    {"Додано ключ входу", "Для входу до вашого акаунту тепер потрібен підпис, ключ додано з пристрою {device} з адреси {ip}"},
+		store.SecurityDevicesLoggedOut: {"Вихід на пристроях", "З пристрою {device} з адреси {ip} виконано вихід з вашого акаунту на інших пристроях"},
+	},
+}
+
+func deviceTypeName(deviceType int) string {
+	switch deviceType {
+	case DeviceTypeIOS:
+		return "iOS"
+	case DeviceTypeAndroid:
+		return "Android"
+	}
+	return ""
+}
+
+// localizedSecurityPush returns title and body of the security event in the device locale
+func localizedSecurityPush(locale string, event store.SecurityEvent) (string, string) {
+	locale = pushLocale(locale)
+	template, ok := securityTemplates[locale][event.Type]
+	if !ok {
+		template = securityTemplates[defaultLocale][event.Type]
+	}
+
+	currencyID, err := strconv.Atoi(event.Details["currencyid"])
+	currency := ""
+	if err == nil {
+		currency = currencies.CurrencyNames[currencyID]
+	}
+	replacer := strings.NewReplacer(
+		"{ip}", event.IP,
+		"{device}", deviceTypeName(event.DeviceType),
+		"{amount}", formatAmount(event.Details["amount"], currencyID, locale),
+		"{currency}", currency,
+	)
+	return replacer.Replace(template.Title), replacer.Replace(template.Body)
+}
+
+// largeOutgoing checks the outgoing transaction notification is large enough to be a security event
+func largeOutgoing(msg *store.WsTxNotify, limits map[int]*big.Int) bool {
+	if msg.TransactionType != store.TxStatusAppearedInMempoolOutcoming {
+		return false
+	}
+	limit, ok := limits[msg.CurrencyID]
+	if !ok {
+		return false
+	}
+	amount, ok := new(big.Int).SetString(strings.TrimPrefix(msg.Amount, "-"), 10)
+	return ok && amount.Cmp(limit) >= 0
+}
+
+func newSecurityEvent(userID, eventType string, device store.Device, ip string, details map[string]string) (store.SecurityEvent, error) {
+	eventID, err := randomID()
+	if err != nil {
+		return store.SecurityEvent{}, err
+	}
+	now := time.Now()
+	return store.SecurityEvent{
+		EventID:        eventID,
+		UserID:         userID,
+		Type:           eventType,
+		DeviceID:       device.DeviceID,
+		DeviceType:     device.DeviceType,
+		IP:             ip,
+		Details:        details,
+		DateOfCreation: now.Unix(),
+		ExpireAt:       now.Add(securityEventsRetention),
+	}, nil
+}
+
+// securityEvent records the event and hands it to push notifications through NSQ
+func (restClient *RestClient) securityEvent(userID, eventType string, device store.Device, ip string, details map[string]string) {
+	event, err := newSecurityEvent(userID, eventType, device, ip, details)
+	if err != nil {
+		restClient.log.Errorf("securityEvent: newSecurityEvent: %s", err.Error())
+		return
+	}
+	err = restClient.userStore.InsertSecurityEvent(event)
+	if err != nil {
+		restClient.log.Errorf("securityEvent: restClient.userStore.InsertSecurityEvent: %s", err.Error())
+	}
+
+	msg, err := json.Marshal(event)
+	if err != nil {
+		restClient.log.Errorf("securityEvent: json.Marshal: %s", err.Error())
+		return
+	}
+	err = restClient.BTC.NsqProducer.Publish(store.TopicSecurity, msg)
+	if err != nil {
+		restClient.log.Errorf("securityEvent: nsq publish: %s", err.Error())
+	}
+}
+
+// tokenDevice returns the user device signed in with the token
+func tokenDevice(user store.User, token string) store.Device {
+	for _, device := range user.Devices {
+		if device.JWT == token {
+			return device
+		}
+	}
+	return store.Device{}
+}
+
+func (restClient *RestClient) getSecurityEvents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+
+		limit := securityEventsLimit
+		if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= maxSecurityEventsLimit {
+			limit = l
+		}
+
+		events, err := restClient.userStore.FindSecurityEvents(user.UserID, limit)
+		if err != nil {
+			restClient.log.Errorf("getSecurityEvents: restClient.userStore.FindSecurityEvents: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": http.StatusText(http.StatusOK),
+			"events":  events,
+		})
+	}
+}
+
+// notifySecurity pushes the event to all user devices but the one caused it.
+// Security pushes aren't subject to preferences, a stolen account could mute them otherwise.
+func (nClient *NotifyClient) notifySecurity(event store.SecurityEvent) {
+	user := store.User{}
+	err := nClient.userStore.FindUser(bson.M{"userID": event.UserID}, &user)
+	if err != nil {
+		nClient.log.Errorf("notifySecurity: userStore.FindUser: %s", err.Error())
+		return
+	}
+
+	for _, device := range user.Devices {
+		if device.PushToken == "" || (event.DeviceID != "" && device.DeviceID == event.DeviceID) {
+			continue
+		}
+		notifier, ok := routeNotifier(nClient.notifiers, device.DeviceType)
+		if !ok {
+			continue
+		}
+		title, body := localizedSecurityPush(device.Locale, event)
+		err := notifier.Notify(Push{
+			Token:      device.PushToken,
+			DeviceType: device.DeviceType,
+			Title:      title,
+			Body:       body,
+			Data: map[string]string{
+				"event":    securityPushEvent,
+				"type":     event.Type,
+				"eventid":  event.EventID,
+				"deviceid": event.DeviceID,
+			},
+		})
+		if err != nil {
+			nClient.log.Errorf("notifySecurity: %s: %s", notifier.Name(), err.Error())
+		}
+	}
+}
+
+// securityOutgoing records large outgoing transaction of the notification and pushes it
+func (nClient *NotifyClient) securityOutgoing(msg store.TransactionWithUserID) {
+	event, err := newSecurityEvent(msg.UserID, store.SecurityLargeOutgoing, store.Device{}, "", map[string]string{
+		"currencyid":  strconv.Itoa(msg.NotificationMsg.CurrencyID),
+		"networkid":   strconv.Itoa(msg.NotificationMsg.NetworkID),
+		"walletindex": strconv.Itoa(msg.NotificationMsg.WalletIndex),
+		"amount":      strings.TrimPrefix(msg.NotificationMsg.Amount, "-"),
+		"txid":        msg.NotificationMsg.TxID,
+	})
+	if err != nil {
+		nClient.log.Errorf("securityOutgoing: newSecurityEvent: %s", err.Error())
+		return
+	}
+	err = nClient.userStore.InsertSecurityEvent(event)
+	if err != nil {
+		nClient.log.Errorf("securityOutgoing: userStore.InsertSecurityEvent: %s", err.Error())
+	}
+	nClient.notifySecurity(event)
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"testing"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+)
+
+func TestLargeOutgoing(t *testing.T) {
+	tests := []struct {
+		msg   store.WsTxNotify
+		large bool
+	}{
+		{store.WsTxNotify{CurrencyID: currencies.Bitcoin, TransactionType: store.TxStatusAppearedInMempoolOutcoming, Amount: "10000000"}, true},
+		{store.WsTxNotify{CurrencyID: currencies.Bitcoin, TransactionType: store.TxStatusAppearedInMempoolOutcoming, Amount: "-20000000"}, true},
+		{store.WsTxNotify{CurrencyID: currencies.Bitcoin, TransactionType: store.TxStatusAppearedInMempoolOutcoming, Amount: "9999999"}, false},
+		{store.WsTxNotify{CurrencyID: currencies.Bitcoin, TransactionType: store.TxStatusAppearedInMempoolIncoming, Amount: "10000000"}, false},
+		{store.WsTxNotify{CurrencyID: currencies.Ether, TransactionType: store.TxStatusAppearedInMempoolOutcoming, Amount: "1000000000000000000"}, true},
+		{store.WsTxNotify{CurrencyID: currencies.Litecoin, TransactionType: store.TxStatusAppearedInMempoolOutcoming, Amount: "1000000000"}, false},
+	}
+	for i, test := range tests {
+		if large := largeOutgoing(&test.msg, defaultLargeOutgoing); large != test.large {
+			t.Errorf("%d: large=%v, expected %v", i, large, test.large)
+		}
+	}
+}
+
+func TestLargeOutgoingLimits(t *testing.T) {
+	limits, err := largeOutgoingLimits(map[string]string{"0": "50000000", "60": "0", "2": "100000000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(limits) != 2 || limits[currencies.Bitcoin].String() != "50000000" || limits[currencies.Litecoin].String() != "100000000" {
+		t.Errorf("wrong limits %v", limits)
+	}
+	btc := store.WsTxNotify{CurrencyID: currencies.Bitcoin, TransactionType: store.TxStatusAppearedInMempoolOutcoming, Amount: "20000000"}
+	if largeOutgoing(&btc, limits) {
+		t.Errorf("amount below configured limit is large")
+	}
+	if defaultLargeOutgoing[currencies.Bitcoin].String() != "10000000" {
+		t.Errorf("defaults changed")
+	}
+
+	for _, conf := range []map[string]string{{"btc": "1"}, {"0": "0.1"}, {"0": "-1"}} {
+		if _, err := largeOutgoingLimits(conf); err == nil {
+			t.Errorf("%v accepted", conf)
+		}
+	}
+}
+
+func TestLocalizedSecurityPush(t *testing.T) {
+	event := store.SecurityEvent{
+		Type:       store.SecurityNewDevice,
+		DeviceType: DeviceTypeAndroid,
+		IP:         "10.0.0.1",
+	}
+	title, body := localizedSecurityPush("en_US", event)
+	if title != "New device" || body != "A new Android device signed in to your account from 10.0.0.1" {
+		t.Errorf("wrong push text %q %q", title, body)
+	}
+
+	event = store.SecurityEvent{
+		Type:    store.SecurityLargeOutgoing,
+		Details: map[string]string{"currencyid": "0", "amount": "150000000"},
+	}
+	_, body = localizedSecurityPush("pl", event)
+	if body != "1.5 Bitcoin is being sent from your wallet" {
+		t.Errorf("wrong push body %q", body)
+	}
+}
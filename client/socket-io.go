@@ -9,8 +9,8 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
-	"sync"
 
 	"github.com/Multy-io/Multy-back/btc"
 	"github.com/Multy-io/Multy-back/currencies"
@@ -78,23 +78,53 @@ func getHeaderDataSocketIO(headers http.Header) (*SocketIOUser, error) {
 	}, nil
 }
 
-func SetSocketIOHandlers(restClient *RestClient, BTC *btc.BTCConn, ETH *eth.ETHConn, r *gin.RouterGroup, address, nsqAddr string, ratesDB store.UserStore) (*SocketIOConnectedPool, error) {
+// connUserID returns the user the connection is authenticated for, headers alone aren't trusted
+func (sConnPool *SocketIOConnectedPool) connUserID(c *gosocketio.Channel) (string, error) {
+	header, err := getHeaderDataSocketIO(c.RequestHeader())
+	if err != nil {
+		return "", fmt.Errorf("connUserID: %s", err.Error())
+	}
+	sConnPool.m.RLock()
+	user, ok := sConnPool.users[header.userID]
+	sConnPool.m.RUnlock()
+	if !ok || !user.hasConn(c.Id()) {
+		return "", fmt.Errorf("connUserID: connection %s isn't authenticated", c.Id())
+	}
+	return header.userID, nil
+}
+
+func SetSocketIOHandlers(restClient *RestClient, BTC *btc.BTCConn, ETH *eth.ETHConn, r *gin.RouterGroup, address, nsqAddr string, ratesDB store.UserStore, ratesConf RatesConf) (*SocketIOConnectedPool, error) {
 	server := gosocketio.NewServer(transport.GetDefaultWebsocketTransport())
 	pool, err := InitConnectedPool(server, address, nsqAddr, ratesDB)
 	if err != nil {
 		return nil, fmt.Errorf("connection pool initialization: %s", err.Error())
 	}
 
-	chart, err := newExchangeChart(ratesDB)
+	chart, err := newExchangeChart(ratesDB, ratesConf)
 	if err != nil {
 		return nil, fmt.Errorf("exchange chart initialization: %s", err.Error())
 	}
 	pool.chart = chart
+	restClient.chart = chart
+
+	alerts, err := newPriceAlerts(chart, ratesDB, nsqAddr)
+	if err != nil {
+		return nil, fmt.Errorf("price alerts initialization: %s", err.Error())
+	}
+	pool.priceAlerts = alerts
 
-	receivers := make(map[string]store.Receiver)
-	receiversM := sync.Mutex{}
+	pool.auth = &socketAuth{jwt: restClient.middlewareJWT, db: ratesDB}
+	go pool.checkTokens()
 
-	senders := []store.Sender{}
+	pool.hub = restClient.hub
+	go pool.publishRates()
+
+	wireless := pool.wireless
+	wireless.onStatus = func(intent PaymentIntent) {
+		// other devices of the receiver following the invoice, on any instance
+		pool.emitCluster(intent.ReceiverID, invoiceChannel(intent.UserCode), PaymentStatus, intent, connID(intent.receiverConn))
+	}
+	go wireless.Run()
 
 	server.On(gosocketio.OnConnection, func(c *gosocketio.Channel) {
 		// pool.log.Debugf("connected: %s", c.Id())
@@ -103,13 +133,24 @@ func SetSocketIOHandlers(restClient *RestClient, BTC *btc.BTCConn, ETH *eth.ETHC
 		//ratesDay := pool.chart.getExchangeDay()
 		//c.Emit(topicExchangeDay, ratesDay)
 
+		// connections without the token are closed as unauthorized ones, handlers rely on it
 		user, err := getHeaderDataSocketIO(c.RequestHeader())
 		if err != nil {
 			pool.log.Errorf("get socketio headers: %s", err.Error())
+			c.Emit(Unauthorized, err.Error())
+			c.Close()
+			return
+		}
+		expire, err := pool.auth.verify(user.userID, user.jwtToken)
+		if err != nil {
+			pool.log.Errorf("socketio auth: %s", err.Error())
+			c.Emit(Unauthorized, err.Error())
+			c.Close()
 			return
 		}
 		user.pool = pool
 		connectionID := c.Id()
+		appVersion := c.RequestHeader().Get(appVersionHeader)
 		user.chart = pool.chart
 
 		pool.m.Lock()
@@ -117,75 +158,124 @@ func SetSocketIOHandlers(restClient *RestClient, BTC *btc.BTCConn, ETH *eth.ETHC
 		userFromPool, ok := pool.users[user.userID]
 		if !ok {
 			pool.log.Debugf("new user")
-			newSocketIOUser(connectionID, user, c, pool.log)
+			newSocketIOUser(connectionID, user, c, appVersion, pool.log)
 			pool.users[user.userID] = user
 			userFromPool = user
 		}
 
-		userFromPool.conns[connectionID] = c
+		userFromPool.addConn(connectionID, c, appVersion)
+		userFromPool.setConnToken(connectionID, connToken{token: user.jwtToken, expire: expire})
 		pool.closeChByConnID[connectionID] = userFromPool.closeCh
 
+		if lastSeq, err := strconv.ParseInt(c.RequestHeader().Get(lastEventHeader), 10, 64); err == nil && lastSeq >= 0 {
+			userFromPool.startReplay(connectionID)
+			go pool.replay(userFromPool, c, lastSeq)
+		}
+
 		sendExchange(user, c)
 		pool.log.Debugf("OnConnection done")
 	})
 
 	//TODO: feature logic
 
-	server.On(gosocketio.OnError, func(c *gosocketio.Channel) {
-		pool.log.Errorf("Error occurs %s", c.Id())
+	server.On(Auth, func(c *gosocketio.Channel, params AuthParams) string {
+		err := pool.refreshToken(c, params.Token)
+		if err != nil {
+			pool.log.Errorf("auth: %s", err.Error())
+			return "err: " + err.Error()
+		}
+		return Auth + ":ok"
 	})
 
-	server.On(ReceiverOn, func(c *gosocketio.Channel, data store.Receiver) string {
-		pool.log.Infof("Got messeage Receiver On:", data)
-		c.Join(WirelessRoom)
-		receiver := store.Receiver{
-			ID:         data.ID,
-			UserCode:   data.UserCode,
-			CurrencyID: data.CurrencyID,
-			NetworkID:  data.NetworkID,
-			Amount:     data.Amount,
-			Address:    data.Address,
-			Socket:     c,
+	server.On(Subscribe, func(c *gosocketio.Channel, channels []string) []string {
+		channels, err := checkChannels(channels, pool.chart.aggregator.pairs)
+		if err != nil {
+			pool.log.Errorf("subscribe: %s", err.Error())
+			c.Emit(Subscriptions, err.Error())
+			return nil
+		}
+		subscribed, err := pool.updateSubscriptions(c, func(subs *connSubscriptions) {
+			subs.subscribe(channels)
+		})
+		if err != nil {
+			pool.log.Errorf("subscribe: %s", err.Error())
+			return nil
 		}
+		c.Emit(Subscriptions, subscribed)
+		return subscribed
+	})
 
-		receiversM.Lock()
-		_, ok := receivers[receiver.UserCode]
-		if !ok {
-			receivers[receiver.UserCode] = receiver
+	server.On(Unsubscribe, func(c *gosocketio.Channel, channels []string) []string {
+		channels, err := checkChannels(channels, pool.chart.aggregator.pairs)
+		if err != nil {
+			pool.log.Errorf("unsubscribe: %s", err.Error())
+			c.Emit(Subscriptions, err.Error())
+			return nil
+		}
+		subscribed, err := pool.updateSubscriptions(c, func(subs *connSubscriptions) {
+			subs.unsubscribe(channels)
+		})
+		if err != nil {
+			pool.log.Errorf("unsubscribe: %s", err.Error())
+			return nil
+		}
+		c.Emit(Subscriptions, subscribed)
+		return subscribed
+	})
+
+	server.On(Subscriptions, func(c *gosocketio.Channel) []string {
+		subscribed, err := pool.updateSubscriptions(c, nil)
+		if err != nil {
+			pool.log.Errorf("subscriptions: %s", err.Error())
+			return nil
 		}
-		receiversM.Unlock()
+		c.Emit(Subscriptions, subscribed)
+		return subscribed
+	})
 
-		//TODO:
-		// wait for incoming tx
+	server.On(gosocketio.OnError, func(c *gosocketio.Channel) {
+		pool.log.Errorf("Error occurs %s", c.Id())
+	})
 
+	server.On(ReceiverOn, func(c *gosocketio.Channel, data store.Receiver) string {
+		pool.log.Infof("Got messeage Receiver On: %s", data.UserCode)
+		userID, err := pool.connUserID(c)
+		if err != nil {
+			return "err: " + err.Error()
+		}
+		c.Join(WirelessRoom)
+		err = wireless.ReceiverOn(c, userID, data)
+		if err != nil {
+			pool.log.Errorf("receiver on: %s", err.Error())
+			return "err: " + err.Error()
+		}
 		return "ok"
 	})
 
-	// go func() {
-	// 	for {
-	// 		receiversM.Lock()
-	// 		fmt.Println("+++++++++++++receivers", receivers)
-	// 		fmt.Println("+++++++++++++senders", senders)
-	// 		receiversM.Unlock()
-
-	// 		fmt.Println("restClient ", restClient)
-	// 		time.Sleep(7 * time.Second)
-	// 	}
-	// }()
-
-	server.On(SenderCheck, func(c *gosocketio.Channel, nearIDs store.NearVisible) []store.Receiver {
-		nearReceivers := []store.Receiver{}
-		receiversM.Lock()
-		allR := receivers
-		receiversM.Unlock()
-
-		for _, id := range nearIDs.IDs {
-			if res, ok := allR[id]; ok {
-				nearReceivers = append(nearReceivers, res)
-			}
+	// senders keep getting NewReceiver of visible codes advertised later
+	senderOn := func(c *gosocketio.Channel, nearIDs store.NearVisible) []store.Receiver {
+		userID, err := pool.connUserID(c)
+		if err != nil {
+			return nil
 		}
+		nearReceivers := wireless.SenderOn(c, userID, nearIDs.IDs)
 		c.Emit(SenderCheck, nearReceivers)
 		return nearReceivers
+	}
+	server.On(SenderOn, senderOn)
+	server.On(SenderCheck, senderOn)
+
+	server.On(PaymentSend, func(c *gosocketio.Channel, request PaymentRequest) string {
+		userID, err := pool.connUserID(c)
+		if err != nil {
+			return "err: " + err.Error()
+		}
+		intent, err := wireless.PaymentSend(c, userID, request)
+		if err != nil {
+			pool.log.Errorf("payment send: %s", err.Error())
+			return "err: " + err.Error()
+		}
+		return intent.IntentID
 	})
 
 	server.On(SendRaw, func(c *gosocketio.Channel, raw store.RawHDTx) string {
@@ -217,15 +307,28 @@ func SetSocketIOHandlers(restClient *RestClient, BTC *btc.BTCConn, ETH *eth.ETHC
 			}
 
 			if raw.IsHD && !strings.Contains("err:", resp.GetMessage()) {
-				err = addAddressToWallet(raw.Address, raw.JWT, raw.CurrencyID, raw.NetworkID, raw.WalletIndex, raw.AddressIndex, restClient, nil)
+				err = addAddressToWallet(raw.Address, store.TokenHash(raw.JWT), raw.CurrencyID, raw.NetworkID, raw.WalletIndex, raw.AddressIndex, restClient, nil)
 				if err != nil {
 					pool.log.Errorf("addAddressToWallet: %v", err.Error())
 				}
 				c.Emit(SendRaw, resp.GetMessage())
-				receiversM.Lock()
-				res := receivers[raw.UserCode]
-				receiversM.Unlock()
-				res.Socket.Emit(PaymentReceived, raw)
+				if raw.UserCode != "" {
+					userID, err := pool.connUserID(c)
+					if err != nil {
+						pool.log.Errorf("sendRawHDTransaction: %s", err.Error())
+						return "err: " + err.Error()
+					}
+					intent, err := wireless.Broadcast(c, userID, raw.UserCode, txID(resp.GetMessage()))
+					if err != nil {
+						pool.log.Errorf("sendRawHDTransaction: wireless.Broadcast: %s", err.Error())
+					} else {
+						// old app versions wait for it
+						if intent.receiverConn != nil {
+							intent.receiverConn.Emit(PaymentReceived, raw)
+						}
+						pool.emitCluster(intent.ReceiverID, invoiceChannel(intent.UserCode), PaymentReceived, raw, connID(intent.receiverConn))
+					}
+				}
 			}
 
 			return "success:" + resp.GetMessage()
@@ -269,47 +372,19 @@ func SetSocketIOHandlers(restClient *RestClient, BTC *btc.BTCConn, ETH *eth.ETHC
 	server.On(gosocketio.OnDisconnection, func(c *gosocketio.Channel) {
 		pool.log.Infof("Disconnected %s", c.Id())
 		pool.removeUserConn(c.Id())
-		for _, receiver := range receivers {
-			if receiver.Socket.Id() == c.Id() {
-				delete(receivers, receiver.UserCode)
-				continue
-			}
-		}
-		pool.log.Infof("Got messeage Senders ...........:", senders)
-		for i, sender := range senders {
-			if sender.Socket.Id() == c.Id() {
-				senders = append(senders[:i], senders[i+1:]...)
-				continue
-			}
-		}
-		pool.log.Infof("Done Senders ...........:", senders)
+		wireless.Disconnect(c.Id())
 	})
 
 	server.On(stopReceive, func(c *gosocketio.Channel) string {
 		pool.log.Infof("Stop receive %s", c.Id())
-		for _, receiver := range receivers {
-			if receiver.Socket.Id() == c.Id() {
-				delete(receivers, receiver.UserCode)
-				continue
-			}
-		}
-
-		receiversM.Lock()
-		fmt.Println("stopReceive", receivers)
-		receiversM.Unlock()
+		wireless.ReceiverOff(c.Id())
 		return stopReceive + ":ok"
 	})
 
 	server.On(stopSend, func(c *gosocketio.Channel) string {
 		pool.log.Infof("Stop send %s", c.Id())
-		for i, sender := range senders {
-			if sender.Socket.Id() == c.Id() {
-				senders = append(senders[:i], senders[i+1:]...)
-				continue
-			}
-		}
+		wireless.SenderOff(c.Id())
 		return stopSend + ":ok"
-
 	})
 
 	serveMux := http.NewServeMux()
@@ -0,0 +1,179 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/graarh/golang-socketio"
+	"gopkg.in/dgrijalva/jwt-go.v3"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// socket.io authentication events
+const (
+	// Auth takes a refreshed token of the connection
+	Auth = "event:auth"
+	// Unauthorized is emitted before the connection is closed for bad, expired or revoked token
+	Unauthorized = "event:unauthorized"
+
+	socketAuthCheckInterval = 30 * time.Second
+)
+
+// connToken is the token the connection is authenticated with
+type connToken struct {
+	token  string
+	expire time.Time
+}
+
+// AuthParams is data of Auth event
+type AuthParams struct {
+	Token string `json:"token"`
+}
+
+// socketAuth verifies socket.io tokens with the key and claims of REST API
+type socketAuth struct {
+	jwt *GinJWTMiddleware
+	db  store.UserStore
+}
+
+// verify checks the token is valid, issued to the user and not revoked, returns its expiration time
+func (sa *socketAuth) verify(userID, tokenString string) (time.Time, error) {
+	token, err := sa.jwt.ParseTokenString(tokenString)
+	if err != nil {
+		return time.Time{}, errors.New("verify: " + err.Error())
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return time.Time{}, errors.New("verify: invalid token")
+	}
+	if id, _ := claims["id"].(string); id == "" || id != userID {
+		return time.Time{}, errors.New("verify: token is issued to another user")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return time.Time{}, errors.New("verify: token doesn't expire")
+	}
+	if revoked := sa.revoked(userID, tokenString); revoked {
+		return time.Time{}, errors.New("verify: token is revoked")
+	}
+	return time.Unix(int64(exp), 0), nil
+}
+
+// revoked checks the token is in the revocation list or no more of any user device,
+// relogin and logout replace device token
+func (sa *socketAuth) revoked(userID, token string) bool {
+	return sa.revokedHash(userID, store.TokenHash(token))
+}
+
+// revokedHash is revoked of the token hash
+func (sa *socketAuth) revokedHash(userID, hash string) bool {
+	revoked, err := sa.db.IsTokenRevoked(hash)
+	if err != nil || revoked {
+		return true
+	}
+	user := store.User{}
+	err = sa.db.FindUser(bson.M{"userID": userID, "devices.JWT": hash}, &user)
+	return err != nil
+}
+
+// deviceTokens returns token hashes of the user devices, tokens of all user connections are checked
+// against them with a single lookup. Revoked tokens are no more of any device as well.
+func (sa *socketAuth) deviceTokens(userID string) (map[string]bool, error) {
+	user := store.User{}
+	err := sa.db.FindUser(bson.M{"userID": userID}, &user)
+	if err == mgo.ErrNotFound {
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	hashes := map[string]bool{}
+	for _, device := range user.Devices {
+		if device.JWT != "" {
+			hashes[device.JWT] = true
+		}
+	}
+	return hashes, nil
+}
+
+func (sIOUser *SocketIOUser) setConnToken(connID string, token connToken) {
+	sIOUser.connM.Lock()
+	sIOUser.tokens[connID] = token
+	sIOUser.connM.Unlock()
+}
+
+// refreshToken replaces token of the connection with the refreshed one
+func (sConnPool *SocketIOConnectedPool) refreshToken(c *gosocketio.Channel, token string) error {
+	header, err := getHeaderDataSocketIO(c.RequestHeader())
+	if err != nil {
+		return err
+	}
+	expire, err := sConnPool.auth.verify(header.userID, token)
+	if err != nil {
+		return err
+	}
+
+	sConnPool.m.RLock()
+	user, ok := sConnPool.users[header.userID]
+	sConnPool.m.RUnlock()
+	if !ok {
+		return errors.New("refreshToken: no connected user " + header.userID)
+	}
+	user.setConnToken(c.Id(), connToken{token: token, expire: expire})
+	return nil
+}
+
+type connCheck struct {
+	userID string
+	conn   *gosocketio.Channel
+	token  connToken
+}
+
+// checkTokens disconnects connections of expired and revoked tokens
+func (sConnPool *SocketIOConnectedPool) checkTokens() {
+	ticker := time.NewTicker(socketAuthCheckInterval)
+	for now := range ticker.C {
+		checks := map[string][]connCheck{}
+		sConnPool.m.RLock()
+		for userID, user := range sConnPool.users {
+			user.connM.Lock()
+			for connID, token := range user.tokens {
+				if conn, ok := user.conns[connID]; ok {
+					checks[userID] = append(checks[userID], connCheck{userID: userID, conn: conn, token: token})
+				}
+			}
+			user.connM.Unlock()
+		}
+		sConnPool.m.RUnlock()
+
+		// closing triggers disconnection handler which takes the pool lock
+		for userID, userChecks := range checks {
+			hashes, err := sConnPool.auth.deviceTokens(userID)
+			if err != nil {
+				sConnPool.log.Errorf("checkTokens: deviceTokens: %s", err.Error())
+				continue
+			}
+			for _, check := range userChecks {
+				reason := ""
+				switch {
+				case now.After(check.token.expire):
+					reason = "token is expired"
+				case !hashes[store.TokenHash(check.token.token)]:
+					reason = "token is revoked"
+				default:
+					continue
+				}
+				sConnPool.log.Infof("disconnecting %s of user %s: %s", check.conn.Id(), check.userID, reason)
+				check.conn.Emit(Unauthorized, reason)
+				check.conn.Close()
+			}
+		}
+	}
+}
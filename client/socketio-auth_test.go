@@ -0,0 +1,118 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Multy-io/Multy-back/store"
+	"gopkg.in/dgrijalva/jwt-go.v3"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// devicesStore finds users by token hashes of their devices
+type devicesStore struct {
+	store.UserStore
+	tokens  map[string]string // token hash -> userID
+	revoked map[string]bool
+}
+
+func (ds devicesStore) IsTokenRevoked(hash string) (bool, error) {
+	return ds.revoked[hash], nil
+}
+
+func (ds devicesStore) FindUser(query bson.M, user *store.User) error {
+	hash, ok := query["devices.JWT"]
+	if !ok {
+		for hash, userID := range ds.tokens {
+			if userID == query["userID"] {
+				user.UserID = userID
+				user.Devices = append(user.Devices, store.Device{JWT: hash})
+			}
+		}
+		if user.UserID == "" {
+			return mgo.ErrNotFound
+		}
+		return nil
+	}
+	userID, ok := ds.tokens[hash.(string)]
+	if !ok || userID != query["userID"] {
+		return errors.New("not found")
+	}
+	user.UserID = userID
+	return nil
+}
+
+func signedToken(t *testing.T, key []byte, userID string, expire time.Time) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"id": userID, "exp": expire.Unix()})
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return signed
+}
+
+func TestSocketAuthVerify(t *testing.T) {
+	key := []byte("secret")
+	mw := &GinJWTMiddleware{Key: key}
+	mw.MiddlewareInit()
+
+	expire := time.Now().Add(time.Hour)
+	valid := signedToken(t, key, "alice", expire)
+	foreign := signedToken(t, []byte("other"), "alice", expire)
+	expired := signedToken(t, key, "alice", time.Now().Add(-time.Minute))
+	revoked := signedToken(t, key, "alice", expire.Add(time.Second))
+	loggedOut := signedToken(t, key, "alice", expire.Add(time.Second*2))
+
+	auth := &socketAuth{jwt: mw, db: devicesStore{tokens: map[string]string{
+		store.TokenHash(valid):     "alice",
+		store.TokenHash(foreign):   "alice",
+		store.TokenHash(expired):   "alice",
+		store.TokenHash(loggedOut): "alice",
+	}, revoked: map[string]bool{
+		store.TokenHash(loggedOut): true,
+	}}}
+
+	got, err := auth.verify("alice", valid)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if got.Unix() != expire.Unix() {
+		t.Errorf("expire %v, expected %v", got, expire)
+	}
+
+	tests := map[string]struct {
+		userID string
+		token  string
+	}{
+		"other user": {"bob", valid},
+		"other key":  {"alice", foreign},
+		"expired":    {"alice", expired},
+		"revoked":    {"alice", revoked},
+		"logged out": {"alice", loggedOut},
+		"garbage":    {"alice", "token"},
+	}
+	for name, test := range tests {
+		if _, err := auth.verify(test.userID, test.token); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+}
+
+func TestSocketAuthDeviceTokens(t *testing.T) {
+	auth := &socketAuth{db: devicesStore{tokens: map[string]string{"h1": "alice", "h2": "alice", "h3": "bob"}}}
+	hashes, err := auth.deviceTokens("alice")
+	if err != nil || len(hashes) != 2 || !hashes["h1"] || !hashes["h2"] {
+		t.Errorf("wrong device tokens %v %v", hashes, err)
+	}
+	// all tokens of the deleted user are revoked
+	if hashes, err := auth.deviceTokens("carol"); err != nil || len(hashes) != 0 {
+		t.Errorf("wrong tokens of unknown user %v %v", hashes, err)
+	}
+}
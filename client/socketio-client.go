@@ -8,7 +8,7 @@ package client
 import (
 	"encoding/json"
 	"log"
-
+	"strings"
 	"sync"
 	"time"
 
@@ -21,31 +21,60 @@ import (
 const updateExchangeClient = time.Second * 5
 
 type SocketIOConnectedPool struct {
-	address         string
+	address string
+	// instance distinguishes socket.io instances sharing NSQ and the database
+	instance        string
 	users           map[string]*SocketIOUser // socketio connections by client id
 	closeChByConnID map[string]chan string   // when connection was finished, send close signal to his goroutine
 	m               *sync.RWMutex
 
 	nsqConsumerExchange       *nsq.Consumer
 	nsqConsumerBTCTransaction *nsq.Consumer
+	nsqConsumerCluster        *nsq.Consumer
+	nsqConsumerUserEvents     *nsq.Consumer
+	nsqProducer               *nsq.Producer
 
 	db store.UserStore // TODO: fix store name
 
-	chart  *exchangeChart
-	server *gosocketio.Server
-	log    slf.StructuredLogger
+	chart       *exchangeChart
+	priceAlerts *priceAlerts
+	auth        *socketAuth
+	wireless    *WirelessService
+	hub         *EventHub
+	server      *gosocketio.Server
+	log         slf.StructuredLogger
 }
 
 func InitConnectedPool(server *gosocketio.Server, address, nsqAddr string, db store.UserStore) (*SocketIOConnectedPool, error) {
+	instance, err := randomID()
+	if err != nil {
+		return nil, err
+	}
 	pool := &SocketIOConnectedPool{
+		instance:        instance,
 		m:               &sync.RWMutex{},
 		users:           make(map[string]*SocketIOUser, 0),
 		address:         address,
 		log:             slf.WithContext("connectedPool"),
 		closeChByConnID: make(map[string]chan string, 0),
 		db:              db,
+		wireless:        NewWirelessService(),
+	}
+	pool.log.Infof("InitConnectedPool: instance %s", instance)
+
+	pool.nsqProducer, err = nsq.NewProducer(nsqAddr, nsq.NewConfig())
+	if err != nil {
+		pool.log.Errorf("NSQ producer initialization: %s", err.Error())
+		return nil, err
 	}
-	pool.log.Info("InitConnectedPool")
+	pool.wireless.registry = &clusterRegistry{pool: pool, db: db}
+
+	nsqConsumerCluster, err := pool.newConsumerCluster(nsqAddr)
+	if err != nil {
+		pool.log.Errorf("Socket.io events: NSQ initialization: %s", err.Error())
+		return nil, err
+	}
+	pool.nsqConsumerCluster = nsqConsumerCluster
 
 	nsqConsumerBTCTransaction, err := pool.newConsumerBTCTransaction(nsqAddr)
 	if err != nil {
@@ -54,12 +83,21 @@ func InitConnectedPool(server *gosocketio.Server, address, nsqAddr string, db st
 	}
 	pool.nsqConsumerBTCTransaction = nsqConsumerBTCTransaction
 
+	nsqConsumerUserEvents, err := pool.newConsumerUserEvents(nsqAddr)
+	if err != nil {
+		pool.log.Errorf("User events: NSQ initialization: %s", err.Error())
+		return nil, err
+	}
+	pool.nsqConsumerUserEvents = nsqConsumerUserEvents
+	go pool.cleanUserEvents()
+
 	return pool, nil
 }
 
 func (sConnPool *SocketIOConnectedPool) newConsumerBTCTransaction(nsqAddr string) (*nsq.Consumer, error) {
 	sConnPool.log.Info("newConsumerBTCTransaction: init")
-	consumer, err := nsq.NewConsumer(store.TopicTransaction, "socketio", nsq.NewConfig())
+	// intents of wireless payments could be on any instance, each one gets all transactions
+	consumer, err := nsq.NewConsumer(store.TopicTransaction, instanceChannel("socketio", sConnPool.instance), nsq.NewConfig())
 	if err != nil {
 		return nil, err
 	}
@@ -73,7 +111,7 @@ func (sConnPool *SocketIOConnectedPool) newConsumerBTCTransaction(nsqAddr string
 			sConnPool.log.Errorf("topic btc transaction update: %s", err.Error())
 			return err
 		}
-		go sConnPool.sendTransactionNotify(newTransactionWithUserID)
+		go sConnPool.wireless.Transaction(newTransactionWithUserID)
 		return nil
 	}))
 
@@ -85,23 +123,6 @@ func (sConnPool *SocketIOConnectedPool) newConsumerBTCTransaction(nsqAddr string
 	return consumer, nil
 }
 
-func (sConnPool *SocketIOConnectedPool) sendTransactionNotify(newTransactionWithUserID store.TransactionWithUserID) {
-	// sConnPool.log.Debug("sendTransactionNotify")
-	sConnPool.m.Lock()
-	defer sConnPool.m.Unlock()
-
-	if _, ok := sConnPool.users[newTransactionWithUserID.UserID]; !ok {
-		return
-	}
-	userID := newTransactionWithUserID.UserID
-	userConns := sConnPool.users[userID].conns
-
-	sConnPool.log.Debugf("btc nofify socketio: userID=%s, conns=%d", userID, len(userConns))
-	for _, conn := range userConns {
-		conn.Emit(store.TopicTransaction, newTransactionWithUserID)
-	}
-}
-
 func (sConnPool *SocketIOConnectedPool) removeUserConn(connID string) {
 	sConnPool.log.Debugf("RemoveUserConn by conn ID: %s", connID)
 	sConnPool.m.Lock()
@@ -138,6 +159,11 @@ type SocketIOUser struct {
 	nsqConfig                 *nsq.Config
 
 	conns map[string]*gosocketio.Channel
+	// subscribed channels and tokens by connection id
+	subs    map[string]*connSubscriptions
+	tokens  map[string]connToken
+	replays map[string]*connReplay
+	connM   sync.Mutex
 
 	closeCh            chan string
 	tickerLastExchange *time.Ticker
@@ -145,9 +171,12 @@ type SocketIOUser struct {
 	log slf.StructuredLogger
 }
 
-func newSocketIOUser(id string, newUser *SocketIOUser, conn *gosocketio.Channel, log slf.StructuredLogger) *SocketIOUser {
+func newSocketIOUser(id string, newUser *SocketIOUser, conn *gosocketio.Channel, appVersion string, log slf.StructuredLogger) *SocketIOUser {
 	newUser.conns = make(map[string]*gosocketio.Channel, 0)
-	newUser.conns[id] = conn
+	newUser.subs = make(map[string]*connSubscriptions, 0)
+	newUser.tokens = make(map[string]connToken, 0)
+	newUser.replays = make(map[string]*connReplay, 0)
+	newUser.addConn(id, conn, appVersion)
 	newUser.log = log.WithField("userID", newUser.userID)
 	newUser.closeCh = make(chan string, 0)
 
@@ -158,11 +187,25 @@ func newSocketIOUser(id string, newUser *SocketIOUser, conn *gosocketio.Channel,
 
 // send right now exchanges to prevent pauses
 func sendExchange(newUser *SocketIOUser, conn *gosocketio.Channel) {
-	gdaxRate := newUser.chart.getExchangeGdax()
-	poloniexRate := newUser.chart.getExchangePoloniex()
+	newUser.emitExchange(conn.Id(), conn)
+}
 
-	conn.Emit(topicExchangeGdax, gdaxRate)
-	conn.Emit(topicExchangePoloniex, poloniexRate)
+// emitExchange emits rates the connection is subscribed to
+func (sIOUser *SocketIOUser) emitExchange(connID string, conn *gosocketio.Channel) {
+	if sIOUser.connSubscribed(connID, channelExchange+":"+strings.ToLower(exchangeDdax)) {
+		conn.Emit(topicExchangeGdax, sIOUser.chart.getExchangeGdax())
+	}
+	if sIOUser.connSubscribed(connID, channelExchange+":"+strings.ToLower(exchangePoloniex)) {
+		conn.Emit(topicExchangePoloniex, sIOUser.chart.getExchangePoloniex())
+	}
+	for _, pair := range sIOUser.chart.aggregator.pairs {
+		if !sIOUser.connSubscribed(connID, channelRates+":"+pair) {
+			continue
+		}
+		if rate := sIOUser.chart.pairRate(pair); rate > 0 {
+			conn.Emit(topicExchangeRate, RateUpdate{Pair: pair, Rate: rate})
+		}
+	}
 }
 
 func (sIOUser *SocketIOUser) runUpdateExchange() {
@@ -172,21 +215,17 @@ func (sIOUser *SocketIOUser) runUpdateExchange() {
 	for {
 		select {
 		case _ = <-sIOUser.tickerLastExchange.C:
-			gdaxRate := sIOUser.chart.getExchangeGdax()
-			poloniexRate := sIOUser.chart.getExchangePoloniex()
-			for _, c := range sIOUser.conns {
+			for connID, c := range sIOUser.connList() {
 				sIOUser.log.Debugf("sending updated exchange: conn id=%s", c.Id())
-				c.Emit(topicExchangeGdax, gdaxRate)
-				c.Emit(topicExchangePoloniex, poloniexRate)
+				sIOUser.emitExchange(connID, c)
 			}
 		case connID := <-sIOUser.closeCh:
 			log.Println("disconnecting conn id=", connID)
-			if conn, ok := sIOUser.conns[connID]; !ok {
+			if conn, ok, left := sIOUser.removeConn(connID); !ok {
 				sIOUser.log.Warnf("trying to close conn which doesnt' exists: %s", connID)
 			} else {
 				conn.Close()
-				delete(sIOUser.conns, connID)
-				if len(sIOUser.conns) == 0 {
+				if left == 0 {
 					sIOUser.log.Infof("no connections for user %s", sIOUser.userID)
 					sIOUser.tickerLastExchange.Stop()
 					sIOUser.pool.removeUserFromPool(sIOUser.userID)
@@ -0,0 +1,398 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+const (
+	// Heartbeat is sent to SSE and WebSocket clients when there are no events for a while
+	Heartbeat = "event:heartbeat"
+
+	// WebSocket clients send StreamRequest to change subscriptions
+	streamSubscribe   = "subscribe"
+	streamUnsubscribe = "unsubscribe"
+
+	msgErrStreamChannels = "wrong channels"
+	msgErrStreamOrigin   = "origin is not allowed"
+	msgErrStreamTicket   = "can't issue stream ticket"
+
+	// tickets are the audience of streams only, they aren't device tokens
+	streamTicketAudience = "stream"
+	// streamTicketTimeout is how long the ticket can open a stream
+	streamTicketTimeout = 30 * time.Second
+)
+
+// StreamConf lists web origins allowed to open event streams,
+// the API host itself and requests without Origin are always allowed
+type StreamConf struct {
+	Origins []string
+}
+
+// allowed checks Origin of the request, browsers send it and apps and servers don't
+func (conf StreamConf) allowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	originURL, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(originURL.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range conf.Origins {
+		if strings.EqualFold(strings.TrimRight(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// StreamRequest is the message of WebSocket client
+type StreamRequest struct {
+	Type     string   `json:"type"`
+	Channels []string `json:"channels"`
+}
+
+// eventStream is the SSE or WebSocket connection of the user on the event hub
+type eventStream struct {
+	db     store.UserStore
+	auth   *socketAuth
+	userID string
+	// hash is of the device token, the stream is closed when it's revoked
+	hash   string
+	expire time.Time
+	sub    *hubSubscriber
+	// pairs are the ones of rates channels
+	pairs []string
+
+	// afterSeq is the last event id seen by the client, replay is done if it is given
+	afterSeq int64
+	replay   bool
+}
+
+// issueTicket signs the ticket opening streams of the device token, browsers can't set headers
+// of EventSource and WebSocket requests, so they pass the ticket in the query instead of the token
+func (sa *socketAuth) issueTicket(userID, token string, tokenExpire time.Time) (string, time.Time, error) {
+	expire := time.Now().Add(streamTicketTimeout)
+	if tokenExpire.Before(expire) {
+		expire = tokenExpire
+	}
+	ticket := jwt.NewWithClaims(jwt.GetSigningMethod(sa.jwt.SigningAlgorithm), jwt.MapClaims{
+		"id":   userID,
+		"aud":  streamTicketAudience,
+		"exp":  expire.Unix(),
+		"dev":  store.TokenHash(token),
+		"dexp": tokenExpire.Unix(),
+	})
+	signed, err := ticket.SignedString(sa.jwt.Key)
+	if err != nil {
+		return "", time.Time{}, errors.New("issueTicket: " + err.Error())
+	}
+	return signed, expire, nil
+}
+
+// verifyTicket checks the ticket and the device token it is issued for,
+// returns the user, the hash and the expiration time of the device token
+func (sa *socketAuth) verifyTicket(ticketString string) (string, string, time.Time, error) {
+	ticket, err := sa.jwt.ParseTokenString(ticketString)
+	if err != nil {
+		return "", "", time.Time{}, errors.New("verifyTicket: " + err.Error())
+	}
+	claims, ok := ticket.Claims.(jwt.MapClaims)
+	if !ok || !ticket.Valid || !claims.VerifyAudience(streamTicketAudience, true) {
+		return "", "", time.Time{}, errors.New("verifyTicket: invalid ticket")
+	}
+	userID, _ := claims["id"].(string)
+	hash, _ := claims["dev"].(string)
+	exp, ok := claims["dexp"].(float64)
+	if userID == "" || hash == "" || !ok {
+		return "", "", time.Time{}, errors.New("verifyTicket: invalid ticket")
+	}
+	if sa.revokedHash(userID, hash) {
+		return "", "", time.Time{}, errors.New("verifyTicket: token is revoked")
+	}
+	return userID, hash, time.Unix(int64(exp), 0), nil
+}
+
+// streamTicket issues the ticket for ?ticket= of /events/stream and /events/ws
+func (restClient *RestClient) streamTicket() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+		token, _ := requestToken(c)
+		auth := &socketAuth{jwt: restClient.middlewareJWT, db: restClient.userStore}
+		tokenExpire, err := auth.verify(user.UserID, token)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"code":    http.StatusUnauthorized,
+				"message": err.Error(),
+			})
+			return
+		}
+
+		ticket, expire, err := auth.issueTicket(user.UserID, token, tokenExpire)
+		if err != nil {
+			restClient.log.Errorf("streamTicket: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": msgErrStreamTicket,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":   http.StatusOK,
+			"ticket": ticket,
+			"expire": expire.Format(time.RFC3339),
+		})
+	}
+}
+
+// openStream checks the origin, authenticates the request with the ticket or the token header
+// and subscribes it to the hub, the error response is written on failure
+func (restClient *RestClient) openStream(c *gin.Context) (*eventStream, bool) {
+	if !restClient.streams.allowed(c.Request) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    http.StatusForbidden,
+			"message": msgErrStreamOrigin,
+		})
+		return nil, false
+	}
+
+	auth := &socketAuth{jwt: restClient.middlewareJWT, db: restClient.userStore}
+	stream := &eventStream{
+		db:   restClient.userStore,
+		auth: auth,
+	}
+	if restClient.chart != nil {
+		stream.pairs = restClient.chart.aggregator.pairs
+	}
+	var err error
+	if ticket := c.Query("ticket"); ticket != "" {
+		stream.userID, stream.hash, stream.expire, err = auth.verifyTicket(ticket)
+	} else {
+		user, userErr := restClient.userByToken(c)
+		if userErr != nil {
+			return nil, false
+		}
+		token, _ := requestToken(c)
+		stream.userID, stream.hash = user.UserID, store.TokenHash(token)
+		stream.expire, err = auth.verify(user.UserID, token)
+	}
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"code":    http.StatusUnauthorized,
+			"message": err.Error(),
+		})
+		return nil, false
+	}
+
+	channels := []string{}
+	if query := c.Query("channels"); query != "" {
+		channels, err = checkChannels(strings.Split(query, ","), stream.pairs)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrStreamChannels,
+			})
+			return nil, false
+		}
+	}
+
+	// SSE clients send Last-Event-ID on reconnection by themselves
+	lastSeen := c.GetHeader("Last-Event-ID")
+	if lastSeen == "" {
+		lastSeen = c.Query("after")
+	}
+	if afterSeq, err := strconv.ParseInt(lastSeen, 10, 64); err == nil && afterSeq >= 0 {
+		stream.afterSeq, stream.replay = afterSeq, true
+	}
+	stream.sub = restClient.hub.subscribe(stream.userID, channels)
+	return stream, true
+}
+
+// userEvent is the hub event of the stored user event
+func userEvent(event store.UserEvent) HubEvent {
+	return HubEvent{ID: event.Seq, Event: event.Event, Channel: event.Channel, Data: event.Data}
+}
+
+// replayMissed sends stored events after the last seen one and returns the id of the latest sent
+func (stream *eventStream) replayMissed(send func(HubEvent) error) (int64, error) {
+	result := ReplayResult{Seq: stream.afterSeq}
+	if !stream.replay {
+		return 0, nil
+	}
+	for {
+		events, err := stream.db.FindUserEvents(stream.userID, result.Seq, userEventsPage)
+		if err != nil {
+			return result.Seq, err
+		}
+		if result.Seq == stream.afterSeq {
+			result.Truncated = truncated(stream.afterSeq, events)
+		}
+		for _, event := range events {
+			if stream.sub.has(event.Channel) {
+				if err := send(userEvent(event)); err != nil {
+					return result.Seq, err
+				}
+			}
+			result.Seq = event.Seq
+		}
+		if len(events) < userEventsPage {
+			break
+		}
+	}
+	return result.Seq, send(HubEvent{Event: ReplayDone, Data: result})
+}
+
+// run replays missed events, then sends live ones until the client is gone,
+// it lags too much or its token expires or is revoked
+func (stream *eventStream) run(hub *EventHub, send func(HubEvent) error, done <-chan struct{}) {
+	defer hub.unsubscribe(stream.sub)
+
+	lastSeq, err := stream.replayMissed(send)
+	if err != nil {
+		return
+	}
+
+	check := time.NewTicker(socketAuthCheckInterval)
+	defer check.Stop()
+	expire := time.NewTimer(stream.expire.Sub(time.Now()))
+	defer expire.Stop()
+	for {
+		select {
+		case event := <-stream.sub.events:
+			// replayed already
+			if event.ID != 0 && event.ID <= lastSeq {
+				continue
+			}
+			if err := send(event); err != nil {
+				return
+			}
+		case <-stream.sub.lost:
+			return
+		case <-done:
+			return
+		case <-expire.C:
+			send(HubEvent{Event: Unauthorized, Data: "token is expired"})
+			return
+		case <-check.C:
+			if stream.auth.revokedHash(stream.userID, stream.hash) {
+				send(HubEvent{Event: Unauthorized, Data: "token is revoked"})
+				return
+			}
+			if err := send(HubEvent{Event: Heartbeat}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// streamUserEvents is the Server-Sent Events stream of /events/stream and of /events requested with Accept: text/event-stream
+func (restClient *RestClient) streamUserEvents(c *gin.Context) {
+	stream, ok := restClient.openStream(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	if origin := c.GetHeader("Origin"); origin != "" {
+		c.Header("Access-Control-Allow-Origin", origin)
+		c.Header("Vary", "Origin")
+	}
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	send := func(event HubEvent) error {
+		sseEvent := sse.Event{Event: event.Event, Data: event.Data}
+		if event.ID != 0 {
+			sseEvent.Id = strconv.FormatInt(event.ID, 10)
+		}
+		if err := sseEvent.Render(c.Writer); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	}
+	stream.run(restClient.hub, send, c.Request.Context().Done())
+}
+
+// wsUserEvents is the JSON over WebSocket stream of events, clients change subscriptions with StreamRequest
+func (restClient *RestClient) wsUserEvents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stream, ok := restClient.openStream(c)
+		if !ok {
+			return
+		}
+		upgrader := websocket.Upgrader{CheckOrigin: restClient.streams.allowed}
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			restClient.log.Errorf("wsUserEvents: upgrade: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			restClient.hub.unsubscribe(stream.sub)
+			return
+		}
+		defer conn.Close()
+
+		// the connection has one writer at a time
+		writeM := sync.Mutex{}
+		send := func(event HubEvent) error {
+			writeM.Lock()
+			defer writeM.Unlock()
+			return conn.WriteJSON(event)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				request := StreamRequest{}
+				if err := conn.ReadJSON(&request); err != nil {
+					return
+				}
+				send(stream.handleRequest(request))
+			}
+		}()
+		stream.run(restClient.hub, send, done)
+	}
+}
+
+// handleRequest changes subscriptions of the stream and returns them as Subscriptions event
+func (stream *eventStream) handleRequest(request StreamRequest) HubEvent {
+	channels, err := checkChannels(request.Channels, stream.pairs)
+	if err != nil {
+		return HubEvent{Event: Subscriptions, Data: err.Error()}
+	}
+	switch request.Type {
+	case streamSubscribe:
+		return HubEvent{Event: Subscriptions, Data: stream.sub.update(func(subs *connSubscriptions) {
+			subs.subscribe(channels)
+		})}
+	case streamUnsubscribe:
+		return HubEvent{Event: Subscriptions, Data: stream.sub.update(func(subs *connSubscriptions) {
+			subs.unsubscribe(channels)
+		})}
+	}
+	return HubEvent{Event: Subscriptions, Data: "unknown request " + request.Type}
+}
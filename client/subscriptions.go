@@ -0,0 +1,332 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/graarh/golang-socketio"
+)
+
+// socket.io subscription events, data is a list of channels
+const (
+	Subscribe     = "event:subscribe"
+	Unsubscribe   = "event:unsubscribe"
+	Subscriptions = "event:subscriptions"
+
+	topicExchangeRate = "exchangeRate"
+)
+
+// channel kinds, channel is "<kind>:<id>" and "<kind>:*" subscribes to all channels of the kind
+const (
+	// wallet:<currencyid>:<networkid>:<walletindex>
+	channelWallet = "wallet"
+	// multisig:<contract address or invite code>
+	channelMultisig = "multisig"
+	// rates:<pair> like rates:BTC-USD, emitted as exchangeRate
+	channelRates = "rates"
+	// exchange:<gdax|poloniex> is full rates of the exchange as exchangeGdax and exchangePoloniex
+	channelExchange = "exchange"
+	// invoice:<usercode> of wireless payment
+	channelInvoice = "invoice"
+	// alerts:price
+	channelAlerts = "alerts"
+
+	channelAll = "*"
+)
+
+// legacyChannels are subscribed on connection of app versions before subscriptionsAppVersion,
+// they never subscribe and keep getting everything. The first subscribe of the connection drops them.
+var legacyChannels = []string{
+	channelWallet + ":" + channelAll,
+	channelMultisig + ":" + channelAll,
+	channelExchange + ":" + channelAll,
+	channelInvoice + ":" + channelAll,
+	channelAlerts + ":" + channelAll,
+}
+
+// subscriptionsAppVersion is the first app version subscribing to channels it shows,
+// its connections start with no channels
+const subscriptionsAppVersion = "1.3.0"
+
+// appVersionHeader is the socket.io handshake header with the app version, old apps don't send it
+const appVersionHeader = "appVersion"
+
+// defaultChannels returns channels the connection of the app version starts with
+func defaultChannels(appVersion string) []string {
+	if appVersion != "" && compareVersions(appVersion, subscriptionsAppVersion) >= 0 {
+		return nil
+	}
+	return legacyChannels
+}
+
+// compareVersions compares dotted versions like 1.2.10 number by number, non-numeric parts are 0
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		x, y := 0, 0
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// RateUpdate is the rate of the subscribed pair
+type RateUpdate struct {
+	Pair string  `json:"pair"`
+	Rate float64 `json:"rate"`
+}
+
+// connSubscriptions are channels of the connection
+type connSubscriptions struct {
+	explicit bool
+	channels map[string]bool
+}
+
+func newConnSubscriptions(appVersion string) *connSubscriptions {
+	subs := &connSubscriptions{channels: map[string]bool{}}
+	for _, channel := range defaultChannels(appVersion) {
+		subs.channels[channel] = true
+	}
+	return subs
+}
+
+func (subs *connSubscriptions) subscribe(channels []string) {
+	if !subs.explicit {
+		subs.explicit = true
+		subs.channels = map[string]bool{}
+	}
+	for _, channel := range channels {
+		subs.channels[channel] = true
+	}
+}
+
+func (subs *connSubscriptions) unsubscribe(channels []string) {
+	subs.explicit = true
+	for _, channel := range channels {
+		delete(subs.channels, channel)
+	}
+}
+
+// has checks the channel is subscribed directly or by the kind wildcard
+func (subs *connSubscriptions) has(channel string) bool {
+	if subs.channels[channel] {
+		return true
+	}
+	kind := strings.SplitN(channel, ":", 2)[0]
+	return subs.channels[kind+":"+channelAll]
+}
+
+func (subs *connSubscriptions) list() []string {
+	channels := make([]string, 0, len(subs.channels))
+	for channel := range subs.channels {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// checkChannel validates the channel name, pairs are the ones of rates channels
+func checkChannel(channel string, pairs []string) error {
+	parts := strings.SplitN(channel, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return errors.New("checkChannel: wrong channel " + channel)
+	}
+	kind, id := parts[0], parts[1]
+	switch kind {
+	case channelWallet, channelMultisig, channelRates, channelExchange, channelInvoice, channelAlerts:
+	default:
+		return errors.New("checkChannel: unknown channel kind " + kind)
+	}
+	if id == channelAll {
+		return nil
+	}
+
+	switch kind {
+	case channelWallet:
+		ids := strings.Split(id, ":")
+		if len(ids) != 3 {
+			return errors.New("checkChannel: wallet channel is wallet:<currencyid>:<networkid>:<walletindex>")
+		}
+		for _, i := range ids {
+			if _, err := strconv.Atoi(i); err != nil {
+				return errors.New("checkChannel: wrong wallet channel " + channel)
+			}
+		}
+	case channelRates:
+		if !isRatePair(pairs, id) {
+			return errors.New("checkChannel: unknown pair " + id)
+		}
+	case channelExchange:
+		if id != strings.ToLower(exchangeDdax) && id != strings.ToLower(exchangePoloniex) {
+			return errors.New("checkChannel: unknown exchange " + id)
+		}
+	case channelAlerts:
+		if id != "price" {
+			return errors.New("checkChannel: unknown alerts " + id)
+		}
+	}
+	return nil
+}
+
+func walletChannel(currencyID, networkID, walletIndex int) string {
+	return fmt.Sprintf("%s:%d:%d:%d", channelWallet, currencyID, networkID, walletIndex)
+}
+
+func invoiceChannel(userCode string) string {
+	return channelInvoice + ":" + userCode
+}
+
+// notifyChannel returns the channel of the transaction notification.
+// Node notifications of BTC multisigs carry the multisig address, multisigChannel gives their invite code channel.
+func notifyChannel(msg *store.WsTxNotify) string {
+	if msg == nil {
+		return channelWallet + ":" + channelAll
+	}
+	switch {
+	case msg.TransactionType == store.PriceAlertTriggered:
+		return channelAlerts + ":price"
+	case notifyEvent(msg.TransactionType) == store.NotifyMultisigAction || msg.WalletIndex == store.MultisigWalletIndex:
+		return channelMultisig + ":" + strings.ToLower(msg.Address)
+	}
+	return walletChannel(msg.CurrencyID, msg.NetworkID, msg.WalletIndex)
+}
+
+// multisigChannel returns the invite code channel of the BTC multisig the address is of
+func multisigChannel(multisigs []store.Multisig, address string) (string, bool) {
+	for _, multisig := range multisigs {
+		if multisig.CurrencyID != currencies.Bitcoin || multisig.InviteCode == "" {
+			continue
+		}
+		for _, ma := range multisig.Addresses {
+			if ma.Address == address {
+				return channelMultisig + ":" + multisig.InviteCode, true
+			}
+		}
+	}
+	return "", false
+}
+
+// connSubscribed checks subscriptions of the connection, connection state is guarded by connM
+// as exchange updates go from the user goroutine
+func (sIOUser *SocketIOUser) connSubscribed(connID, channel string) bool {
+	sIOUser.connM.Lock()
+	defer sIOUser.connM.Unlock()
+	subs, ok := sIOUser.subs[connID]
+	return ok && subs.has(channel)
+}
+
+func (sIOUser *SocketIOUser) addConn(connID string, conn *gosocketio.Channel, appVersion string) {
+	sIOUser.connM.Lock()
+	sIOUser.conns[connID] = conn
+	sIOUser.subs[connID] = newConnSubscriptions(appVersion)
+	sIOUser.connM.Unlock()
+}
+
+// connList returns a copy of the user connections to emit to without holding connM,
+// emitting checks subscriptions under it
+func (sIOUser *SocketIOUser) connList() map[string]*gosocketio.Channel {
+	sIOUser.connM.Lock()
+	defer sIOUser.connM.Unlock()
+	conns := make(map[string]*gosocketio.Channel, len(sIOUser.conns))
+	for connID, conn := range sIOUser.conns {
+		conns[connID] = conn
+	}
+	return conns
+}
+
+// hasConn checks the connection is of the user, it's added after its token is verified
+func (sIOUser *SocketIOUser) hasConn(connID string) bool {
+	sIOUser.connM.Lock()
+	defer sIOUser.connM.Unlock()
+	_, ok := sIOUser.conns[connID]
+	return ok
+}
+
+// removeConn drops the closed connection with its state, returns the connection and how many are left
+func (sIOUser *SocketIOUser) removeConn(connID string) (*gosocketio.Channel, bool, int) {
+	sIOUser.connM.Lock()
+	defer sIOUser.connM.Unlock()
+	conn, ok := sIOUser.conns[connID]
+	if ok {
+		delete(sIOUser.conns, connID)
+		delete(sIOUser.subs, connID)
+		delete(sIOUser.tokens, connID)
+		delete(sIOUser.replays, connID)
+	}
+	return conn, ok, len(sIOUser.conns)
+}
+
+// updateSubscriptions applies the update to subscriptions of the connection and returns its channels
+func (sConnPool *SocketIOConnectedPool) updateSubscriptions(c *gosocketio.Channel, update func(subs *connSubscriptions)) ([]string, error) {
+	header, err := getHeaderDataSocketIO(c.RequestHeader())
+	if err != nil {
+		return nil, err
+	}
+	sConnPool.m.RLock()
+	user, ok := sConnPool.users[header.userID]
+	sConnPool.m.RUnlock()
+	if !ok {
+		return nil, errors.New("updateSubscriptions: no connected user " + header.userID)
+	}
+
+	user.connM.Lock()
+	defer user.connM.Unlock()
+	subs, ok := user.subs[c.Id()]
+	if !ok {
+		return nil, errors.New("updateSubscriptions: no connection " + c.Id())
+	}
+	if update != nil {
+		update(subs)
+	}
+	return subs.list(), nil
+}
+
+// checkChannels validates channels and brings addresses to lower case they are compared in
+func checkChannels(channels []string, pairs []string) ([]string, error) {
+	checked := make([]string, 0, len(channels))
+	for _, channel := range channels {
+		if err := checkChannel(channel, pairs); err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(channel, channelMultisig+":") {
+			channel = strings.ToLower(channel)
+		}
+		checked = append(checked, channel)
+	}
+	return checked, nil
+}
+
+// emitChannel emits to the user connections subscribed to the channel except the one given
+func (sConnPool *SocketIOConnectedPool) emitChannel(userID, channel, event string, data interface{}, exceptConnID string) {
+	sConnPool.m.RLock()
+	defer sConnPool.m.RUnlock()
+	user, ok := sConnPool.users[userID]
+	if !ok {
+		return
+	}
+	for connID, conn := range user.connList() {
+		if connID != exceptConnID && user.connSubscribed(connID, channel) {
+			conn.Emit(event, data)
+		}
+	}
+}
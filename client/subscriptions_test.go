@@ -0,0 +1,145 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/graarh/golang-socketio"
+)
+
+func TestCheckChannel(t *testing.T) {
+	pairs := makeRatePairs(rateFiats(nil))
+	valid := []string{"wallet:*", "wallet:0:0:1", "multisig:0xabc", "rates:BTC-USD", "exchange:gdax", "invoice:1234", "alerts:price"}
+	for _, channel := range valid {
+		if err := checkChannel(channel, pairs); err != nil {
+			t.Errorf("%s: %s", channel, err.Error())
+		}
+	}
+	invalid := []string{"", "wallet", "wallet:", "wallet:0:0", "wallet:0:x:1", "rates:BTC-RUB", "exchange:kraken", "alerts:volume", "news:*"}
+	for _, channel := range invalid {
+		if err := checkChannel(channel, pairs); err == nil {
+			t.Errorf("%s: accepted", channel)
+		}
+	}
+}
+
+func TestConnSubscriptions(t *testing.T) {
+	if subs := newConnSubscriptions(subscriptionsAppVersion); len(subs.list()) != 0 {
+		t.Errorf("app subscribing to channels has defaults %v", subs.list())
+	}
+	subs := newConnSubscriptions("1.2.9")
+	// old app versions get everything
+	for _, channel := range []string{"wallet:0:0:1", "multisig:0xabc", "exchange:gdax", "invoice:1", "alerts:price"} {
+		if !subs.has(channel) {
+			t.Errorf("default subscriptions miss %s", channel)
+		}
+	}
+	if subs.has("rates:BTC-USD") {
+		t.Errorf("default subscriptions have rates")
+	}
+
+	subs.subscribe([]string{"wallet:0:0:1", "rates:BTC-USD"})
+	if !subs.has("wallet:0:0:1") || !subs.has("rates:BTC-USD") {
+		t.Errorf("subscribed channels missed")
+	}
+	if subs.has("wallet:0:0:2") || subs.has("exchange:gdax") {
+		t.Errorf("default subscriptions kept after subscribe")
+	}
+
+	subs.unsubscribe([]string{"wallet:0:0:1"})
+	if subs.has("wallet:0:0:1") {
+		t.Errorf("unsubscribed channel kept")
+	}
+	if list := subs.list(); len(list) != 1 || list[0] != "rates:BTC-USD" {
+		t.Errorf("wrong channels %v", list)
+	}
+}
+
+func TestUserConnsConcurrent(t *testing.T) {
+	user := testEventsUser("c")
+	user.conns = map[string]*gosocketio.Channel{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		connID := fmt.Sprint(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				user.addConn(connID, nil, "")
+				user.removeConn(connID)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				for connID := range user.connList() {
+					user.connSubscribed(connID, "wallet:0:0:1")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if _, ok, left := user.removeConn("0"); ok || left != 0 {
+		t.Errorf("connections left %d", left)
+	}
+
+	// wireless and raw transaction handlers take the user of authenticated connections only
+	user.addConn("authenticated", nil, "")
+	if !user.hasConn("authenticated") || user.hasConn("other") {
+		t.Errorf("wrong connections %v", user.connList())
+	}
+}
+
+func TestNotifyChannel(t *testing.T) {
+	tests := []struct {
+		msg     store.WsTxNotify
+		channel string
+	}{
+		{store.WsTxNotify{CurrencyID: currencies.Bitcoin, NetworkID: currencies.Test, WalletIndex: 2, TransactionType: store.TxStatusAppearedInMempoolIncoming}, "wallet:0:1:2"},
+		{store.WsTxNotify{CurrencyID: currencies.Ether, Address: "0xABC", TransactionType: store.MultisigSpendProposed}, "multisig:0xabc"},
+		{store.WsTxNotify{CurrencyID: currencies.Ether, Address: "0xABC", WalletIndex: store.MultisigWalletIndex, TransactionType: store.TxStatusAppearedInBlockIncoming}, "multisig:0xabc"},
+		{store.WsTxNotify{CurrencyID: currencies.Bitcoin, TransactionType: store.PriceAlertTriggered}, "alerts:price"},
+	}
+	for _, test := range tests {
+		if channel := notifyChannel(&test.msg); channel != test.channel {
+			t.Errorf("channel %s, expected %s", channel, test.channel)
+		}
+	}
+
+	multisigs := []store.Multisig{
+		{CurrencyID: currencies.Ether, ContractAddress: "0xabc"},
+		{CurrencyID: currencies.Bitcoin, InviteCode: "5f0c", Addresses: []store.MultisigAddress{{Address: "2N1LGaGg836mqSQqiuUBLfcyGBhyZbremDX"}, {Address: "tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7"}}},
+	}
+	if channel, ok := multisigChannel(multisigs, "tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7"); !ok || channel != "multisig:5f0c" {
+		t.Errorf("wrong BTC multisig channel %s", channel)
+	}
+	if _, ok := multisigChannel(multisigs, "2N1LGaGg836mqSQqiuUBLfcyGBhyZbremDY"); ok {
+		t.Errorf("channel of unknown address")
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		cmp  int
+	}{
+		{"1.3.0", "1.3.0", 0},
+		{"1.3", "1.3.0", 0},
+		{"1.10.0", "1.9.2", 1},
+		{"1.2.9", "1.3.0", -1},
+		{"2.0-beta", "1.3.0", 1},
+	}
+	for _, test := range tests {
+		if cmp := compareVersions(test.a, test.b); cmp != test.cmp {
+			t.Errorf("compareVersions(%s, %s) = %d, want %d", test.a, test.b, cmp, test.cmp)
+		}
+	}
+}
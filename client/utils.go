@@ -6,7 +6,10 @@ See LICENSE for details
 package client
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/big"
 	"time"
@@ -31,7 +34,7 @@ func createUser(userid string, device []store.Device, wallets []store.Wallet) st
 		Wallets: wallets,
 	}
 }
-func createDevice(deviceid, ip, jwt, pushToken, appVersion string, deviceType int) store.Device {
+func createDevice(deviceid, ip, jwt, pushToken, appVersion, locale string, deviceType int) store.Device {
 	return store.Device{
 		DeviceID:       deviceid,
 		PushToken:      pushToken,
@@ -40,6 +43,7 @@ func createDevice(deviceid, ip, jwt, pushToken, appVersion string, deviceType in
 		LastActionTime: time.Now().Unix(),
 		AppVersion:     appVersion,
 		DeviceType:     deviceType,
+		Locale:         locale,
 	}
 }
 
@@ -111,7 +115,11 @@ func reconnectWebSocketConn(addr string, log slf.StructuredLogger) (*websocket.C
 	}
 }
 func convertToHuman(amount string, d int64) string {
-	n, _ := new(big.Float).SetString(amount)
+	// multisig and price alert events have no amount
+	n, ok := new(big.Float).SetString(amount)
+	if !ok || d == 0 {
+		return amount
+	}
 	divider := new(big.Float).SetInt64(d)
 	hu := ""
 	flag := false
@@ -133,3 +141,12 @@ func Reverse(s string) string {
 	}
 	return string(r)
 }
+
+// randomID is a random hex identifier of spends, webhooks and other records
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.New("randomID: " + err.Error())
+	}
+	return hex.EncodeToString(b), nil
+}
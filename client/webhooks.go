@@ -0,0 +1,551 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/gin-gonic/gin"
+	"github.com/jekabolt/slf"
+	nsq "github.com/nsqio/go-nsq"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	webhookTimeout     = 10 * time.Second
+	webhookRetryBase   = 30 * time.Second
+	webhookMaxAttempts = 8
+	webhookPoll        = 5 * time.Second
+	webhookLogLimit    = 100
+	// webhookLease is how long the delivery is held by an attempt, other attempts take it after
+	webhookLease = 2 * webhookTimeout
+
+	// webhookEventSecurity is the event of security events, they are sent to webhooks but not pushed by preferences
+	webhookEventSecurity = "security"
+
+	headerWebhookSignature = "X-Multy-Signature"
+	headerWebhookEvent     = "X-Multy-Event"
+	headerWebhookDelivery  = "X-Multy-Delivery"
+
+	msgErrWebhookURL    = "webhook url must be https"
+	msgErrWebhookHost   = "webhook host must be public"
+	msgErrWebhookEvents = "unknown webhook event"
+	msgErrNoWebhook     = "no such webhook"
+	msgErrNoDelivery    = "no such delivery"
+)
+
+// webhookNets are loopback, private, link-local and other ranges webhooks can't be sent to
+var webhookNets = parseCIDRs(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+	"192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/3",
+	"::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic("parseCIDRs: " + err.Error())
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// WebhookClient delivers wallet events to integrators' endpoints
+type WebhookClient struct {
+	userStore  store.UserStore
+	httpClient *http.Client
+
+	nsqConsumers []*nsq.Consumer
+
+	log slf.StructuredLogger
+}
+
+// WebhookPayload is the body of the delivery, data is the transaction notification or the security event
+type WebhookPayload struct {
+	DeliveryID string      `json:"deliveryid"`
+	Event      string      `json:"event"`
+	Time       int64       `json:"time"`
+	Data       interface{} `json:"data"`
+}
+
+func InitWebhooks(userStore store.UserStore, nsqAddr string) (*WebhookClient, error) {
+	wClient := &WebhookClient{
+		userStore: userStore,
+		httpClient: &http.Client{
+			Timeout:   webhookTimeout,
+			Transport: &http.Transport{DialContext: webhookDial, TLSHandshakeTimeout: webhookTimeout},
+			// redirects could lead anywhere, endpoints have to answer themselves
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		log: slf.WithContext("webhooks"),
+	}
+	wClient.log.Info("Webhooks initialization")
+
+	// NSQ topics delivered to webhooks
+	handlers := map[string]nsq.HandlerFunc{
+		store.TopicTransaction: func(message *nsq.Message) error {
+			msg := store.TransactionWithUserID{}
+			err := json.Unmarshal(message.Body, &msg)
+			if err != nil {
+				return err
+			}
+			if msg.NotificationMsg == nil {
+				return nil
+			}
+			wClient.newEvent(msg.UserID, notifyEvent(msg.NotificationMsg.TransactionType), msg.NotificationMsg, msg.NotificationMsg)
+			return nil
+		},
+		store.TopicSecurity: func(message *nsq.Message) error {
+			event := store.SecurityEvent{}
+			err := json.Unmarshal(message.Body, &event)
+			if err != nil {
+				return err
+			}
+			wClient.newEvent(event.UserID, webhookEventSecurity, nil, event)
+			return nil
+		},
+	}
+	for topic, handler := range handlers {
+		nsqConsumer, err := nsq.NewConsumer(topic, "webhooks", nsq.NewConfig())
+		if err != nil {
+			return nil, fmt.Errorf("new nsq consumer: %s", err.Error())
+		}
+		nsqConsumer.AddHandler(handler)
+		if err = nsqConsumer.ConnectToNSQD(nsqAddr); err != nil {
+			return nil, fmt.Errorf("connecting to nsq: %s", err.Error())
+		}
+		wClient.nsqConsumers = append(wClient.nsqConsumers, nsqConsumer)
+	}
+
+	go wClient.retry()
+
+	wClient.log.Debugf("Webhooks initialization done")
+	return wClient, nil
+}
+
+// newEvent logs delivery of the event for every matching webhook of the user and sends it,
+// msg is the transaction notification the webhook filters apply to, nil for other events
+func (wClient *WebhookClient) newEvent(userID, event string, msg *store.WsTxNotify, data interface{}) {
+	if event == "" {
+		return
+	}
+	webhooks, err := wClient.userStore.FindWebhooks(userID)
+	if err != nil {
+		wClient.log.Errorf("newEvent: userStore.FindWebhooks: %s", err.Error())
+		return
+	}
+
+	for _, webhook := range webhooks {
+		if !webhookMatches(webhook, event, msg) {
+			continue
+		}
+		deliveryID, err := randomID()
+		if err != nil {
+			wClient.log.Errorf("newEvent: randomID: %s", err.Error())
+			continue
+		}
+		payload, _ := json.Marshal(WebhookPayload{
+			DeliveryID: deliveryID,
+			Event:      event,
+			Time:       time.Now().Unix(),
+			Data:       data,
+		})
+
+		delivery := store.WebhookDelivery{
+			DeliveryID: deliveryID,
+			WebhookID:  webhook.WebhookID,
+			UserID:     webhook.UserID,
+			Event:      event,
+			Payload:    string(payload),
+			Status:     store.WebhookDeliveryPending,
+			// leased by the first attempt below, retry loop takes it if the attempt is lost
+			NextAttempt:    time.Now().Add(webhookLease).Unix(),
+			DateOfCreation: time.Now().Unix(),
+		}
+		err = wClient.userStore.InsertWebhookDelivery(delivery)
+		if err != nil {
+			wClient.log.Errorf("newEvent: userStore.InsertWebhookDelivery: %s", err.Error())
+			continue
+		}
+		go wClient.deliver(webhook, delivery)
+	}
+}
+
+func webhookMatches(webhook store.Webhook, event string, msg *store.WsTxNotify) bool {
+	// currency filters are of wallet events, account ones go to every webhook
+	if msg != nil && webhook.CurrencyID != nil && *webhook.CurrencyID != msg.CurrencyID {
+		return false
+	}
+	if msg != nil && webhook.NetworkID != nil && *webhook.NetworkID != msg.NetworkID {
+		return false
+	}
+	if len(webhook.Events) == 0 {
+		return true
+	}
+	for _, e := range webhook.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// deliver makes an attempt and reschedules the delivery with exponential backoff on failure
+func (wClient *WebhookClient) deliver(webhook store.Webhook, delivery store.WebhookDelivery) {
+	attempts := delivery.Attempts + 1
+	code, err := wClient.post(webhook, delivery)
+
+	set := bson.M{
+		"attempts":       attempts,
+		"laststatuscode": code,
+		"lasterror":      "",
+	}
+	switch {
+	case err == nil:
+		set["status"] = store.WebhookDeliveryDelivered
+		set["deliveredat"] = time.Now().Unix()
+	case attempts >= webhookMaxAttempts:
+		set["status"] = store.WebhookDeliveryFailed
+		set["lasterror"] = err.Error()
+	default:
+		set["lasterror"] = err.Error()
+		set["nextattempt"] = time.Now().Add(webhookRetryBase << uint(attempts-1)).Unix()
+	}
+
+	err = wClient.userStore.UpdateWebhookDelivery(delivery.DeliveryID, bson.M{"$set": set})
+	if err != nil {
+		wClient.log.Errorf("deliver: userStore.UpdateWebhookDelivery: %s", err.Error())
+	}
+}
+
+func (wClient *WebhookClient) post(webhook store.Webhook, delivery store.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(headerWebhookEvent, delivery.Event)
+	req.Header.Set(headerWebhookDelivery, delivery.DeliveryID)
+	req.Header.Set(headerWebhookSignature, "sha256="+ComputeHmac256([]byte(delivery.Payload), webhook.Secret))
+
+	resp, err := wClient.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, errors.New("post: " + resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// retry sends deliveries which time of the next attempt has come, replayed deliveries included
+func (wClient *WebhookClient) retry() {
+	for now := range time.Tick(webhookPoll) {
+		wClient.retryDue(now)
+	}
+}
+
+// retryDue claims due deliveries one by one and sends them, a claim leases the delivery
+// so instances polling at the same time don't send it twice
+func (wClient *WebhookClient) retryDue(now time.Time) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		delivery := store.WebhookDelivery{}
+		err := wClient.userStore.ClaimWebhookDelivery(now.Unix(), now.Add(webhookLease).Unix(), &delivery)
+		if err == mgo.ErrNotFound {
+			return
+		}
+		if err != nil {
+			wClient.log.Errorf("retryDue: userStore.ClaimWebhookDelivery: %s", err.Error())
+			return
+		}
+
+		webhooks, err := wClient.userStore.FindWebhooks(delivery.UserID)
+		if err != nil {
+			// taken again when the lease ends
+			wClient.log.Errorf("retryDue: userStore.FindWebhooks: %s", err.Error())
+			continue
+		}
+		webhook, ok := findWebhook(webhooks, delivery.WebhookID)
+		if !ok {
+			// webhook is deleted
+			wClient.userStore.UpdateWebhookDelivery(delivery.DeliveryID, bson.M{"$set": bson.M{"status": store.WebhookDeliveryFailed, "lasterror": msgErrNoWebhook}})
+			continue
+		}
+		wg.Add(1)
+		go func(delivery store.WebhookDelivery) {
+			defer wg.Done()
+			wClient.deliver(webhook, delivery)
+		}(delivery)
+	}
+}
+
+func findWebhook(webhooks []store.Webhook, webhookID string) (store.Webhook, bool) {
+	for _, webhook := range webhooks {
+		if webhook.WebhookID == webhookID {
+			return webhook, true
+		}
+	}
+	return store.Webhook{}, false
+}
+
+// checkWebhookHost resolves the host, webhooks are sent to public addresses only
+func checkWebhookHost(ctx context.Context, host string) ([]net.IPAddr, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("checkWebhookHost: %s", err.Error())
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("checkWebhookHost: no addresses of " + host)
+	}
+	for _, addr := range addrs {
+		for _, n := range webhookNets {
+			if n.Contains(addr.IP) {
+				return nil, fmt.Errorf("checkWebhookHost: %s is not public", addr.IP)
+			}
+		}
+	}
+	return addrs, nil
+}
+
+// webhookDial connects to the checked address, the host is resolved again on every connection
+// so it can't be pointed to a private address after the webhook is registered
+func webhookDial(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := checkWebhookHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	return dialer.DialContext(ctx, network, net.JoinHostPort(addrs[0].IP.String(), port))
+}
+
+func ComputeHmac256(message []byte, secret string) string {
+	key := []byte(secret)
+	h := hmac.New(sha256.New, key)
+	h.Write(message)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+type WebhookParams struct {
+	URL        string   `json:"url"`
+	Events     []string `json:"events"`
+	CurrencyID *int     `json:"currencyid"`
+	NetworkID  *int     `json:"networkid"`
+}
+
+func (restClient *RestClient) createWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+
+		var wp WebhookParams
+		err = decodeBody(c, &wp)
+		if err != nil {
+			restClient.log.Errorf("createWebhook: decodeBody: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrRequestBodyError,
+			})
+			return
+		}
+
+		u, err := url.Parse(wp.URL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrWebhookURL,
+			})
+			return
+		}
+		if _, err := checkWebhookHost(c.Request.Context(), u.Hostname()); err != nil {
+			restClient.log.Errorf("createWebhook: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrWebhookHost,
+			})
+			return
+		}
+		for _, event := range wp.Events {
+			if !knownEvent(event) && event != webhookEventSecurity {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"code":    http.StatusBadRequest,
+					"message": msgErrWebhookEvents,
+				})
+				return
+			}
+		}
+
+		webhookID, errID := randomID()
+		secret, errSecret := randomID()
+		if errID != nil || errSecret != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": msgErrServerError,
+			})
+			return
+		}
+		webhook := store.Webhook{
+			WebhookID:      webhookID,
+			UserID:         user.UserID,
+			URL:            wp.URL,
+			Secret:         secret,
+			Events:         wp.Events,
+			CurrencyID:     wp.CurrencyID,
+			NetworkID:      wp.NetworkID,
+			DateOfCreation: time.Now().Unix(),
+		}
+		err = restClient.userStore.InsertWebhook(webhook)
+		if err != nil {
+			restClient.log.Errorf("createWebhook: restClient.userStore.InsertWebhook: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": msgErrServerError,
+			})
+			return
+		}
+
+		// secret is shown only once
+		c.JSON(http.StatusCreated, gin.H{
+			"code":    http.StatusCreated,
+			"message": http.StatusText(http.StatusCreated),
+			"webhook": webhook,
+		})
+	}
+}
+
+func (restClient *RestClient) getWebhooks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+		webhooks, err := restClient.userStore.FindWebhooks(user.UserID)
+		if err != nil {
+			restClient.log.Errorf("getWebhooks: restClient.userStore.FindWebhooks: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+		}
+		for i := range webhooks {
+			webhooks[i].Secret = ""
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":     http.StatusOK,
+			"message":  http.StatusText(http.StatusOK),
+			"webhooks": webhooks,
+		})
+	}
+}
+
+func (restClient *RestClient) deleteWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+		err = restClient.userStore.DeleteWebhook(user.UserID, c.Param("webhookid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrNoWebhook,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": http.StatusText(http.StatusOK),
+		})
+	}
+}
+
+func (restClient *RestClient) getWebhookDeliveries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(webhookLogLimit)))
+		if err != nil || limit <= 0 || limit > webhookLogLimit {
+			limit = webhookLogLimit
+		}
+		deliveries, err := restClient.userStore.FindWebhookDeliveries(user.UserID, c.Param("webhookid"), limit)
+		if err != nil {
+			restClient.log.Errorf("getWebhookDeliveries: restClient.userStore.FindWebhookDeliveries: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":       http.StatusOK,
+			"message":    http.StatusText(http.StatusOK),
+			"deliveries": deliveries,
+		})
+	}
+}
+
+// replayWebhookDelivery puts the delivery back to the queue, it is sent again with the same id and payload
+func (restClient *RestClient) replayWebhookDelivery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := restClient.userByToken(c)
+		if err != nil {
+			return
+		}
+		delivery := store.WebhookDelivery{}
+		err = restClient.userStore.FindWebhookDelivery(user.UserID, c.Param("deliveryid"), &delivery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    http.StatusBadRequest,
+				"message": msgErrNoDelivery,
+			})
+			return
+		}
+
+		update := bson.M{"$set": bson.M{
+			"status":      store.WebhookDeliveryPending,
+			"attempts":    0,
+			"nextattempt": time.Now().Unix(),
+		}}
+		err = restClient.userStore.UpdateWebhookDelivery(delivery.DeliveryID, update)
+		if err != nil {
+			restClient.log.Errorf("replayWebhookDelivery: restClient.userStore.UpdateWebhookDelivery: %s\t[addr=%s]", err.Error(), c.Request.RemoteAddr)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    http.StatusInternalServerError,
+				"message": msgErrServerError,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"code":    http.StatusOK,
+			"message": http.StatusText(http.StatusOK),
+		})
+	}
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/jekabolt/slf"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// deliveriesStore keeps webhooks and deliveries in memory, claims are atomic as findAndModify is
+type deliveriesStore struct {
+	store.UserStore
+	m          sync.Mutex
+	webhooks   []store.Webhook
+	deliveries map[string]store.WebhookDelivery
+}
+
+func (ds *deliveriesStore) FindWebhooks(userID string) ([]store.Webhook, error) {
+	return ds.webhooks, nil
+}
+
+func (ds *deliveriesStore) ClaimWebhookDelivery(now, until int64, delivery *store.WebhookDelivery) error {
+	ds.m.Lock()
+	defer ds.m.Unlock()
+	for id, d := range ds.deliveries {
+		if d.Status == store.WebhookDeliveryPending && d.NextAttempt <= now {
+			d.NextAttempt = until
+			ds.deliveries[id] = d
+			*delivery = d
+			return nil
+		}
+	}
+	return mgo.ErrNotFound
+}
+
+func (ds *deliveriesStore) UpdateWebhookDelivery(deliveryID string, update bson.M) error {
+	ds.m.Lock()
+	defer ds.m.Unlock()
+	d := ds.deliveries[deliveryID]
+	for field, value := range update["$set"].(bson.M) {
+		switch field {
+		case "status":
+			d.Status = value.(string)
+		case "attempts":
+			d.Attempts = value.(int)
+		case "nextattempt":
+			d.NextAttempt = value.(int64)
+		case "laststatuscode":
+			d.LastStatusCode = value.(int)
+		case "lasterror":
+			d.LastError = value.(string)
+		}
+	}
+	ds.deliveries[deliveryID] = d
+	return nil
+}
+
+func TestWebhookRetry(t *testing.T) {
+	var m sync.Mutex
+	received := map[string]int{}
+	fail := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		h := hmac.New(sha256.New, []byte("secret"))
+		h.Write(body)
+		if r.Header.Get(headerWebhookSignature) != "sha256="+base64.StdEncoding.EncodeToString(h.Sum(nil)) {
+			t.Errorf("wrong signature %s", r.Header.Get(headerWebhookSignature))
+		}
+		m.Lock()
+		defer m.Unlock()
+		received[r.Header.Get(headerWebhookDelivery)]++
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	db := &deliveriesStore{
+		webhooks: []store.Webhook{{WebhookID: "w", URL: server.URL, Secret: "secret"}},
+		deliveries: map[string]store.WebhookDelivery{
+			"due":    {DeliveryID: "due", WebhookID: "w", Payload: `{"event":"incoming"}`, Status: store.WebhookDeliveryPending, Attempts: 2, NextAttempt: now.Unix()},
+			"later":  {DeliveryID: "later", WebhookID: "w", Payload: `{}`, Status: store.WebhookDeliveryPending, NextAttempt: now.Add(time.Minute).Unix()},
+			"orphan": {DeliveryID: "orphan", WebhookID: "deleted", Payload: `{}`, Status: store.WebhookDeliveryPending, NextAttempt: now.Unix()},
+		},
+	}
+	wClient := &WebhookClient{userStore: db, httpClient: server.Client(), log: slf.WithContext("test")}
+
+	sent := func(deliveryID string) int {
+		m.Lock()
+		defer m.Unlock()
+		return received[deliveryID]
+	}
+
+	// instances polling at the same time send the delivery once
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wClient.retryDue(now)
+		}()
+	}
+	wg.Wait()
+	if sent("due") != 1 || sent("later") != 0 {
+		t.Fatalf("wrong deliveries due=%d later=%d", sent("due"), sent("later"))
+	}
+	if db.deliveries["orphan"].Status != store.WebhookDeliveryFailed {
+		t.Errorf("delivery of deleted webhook is pending %+v", db.deliveries["orphan"])
+	}
+
+	// the third attempt failed, the next one is in four base intervals
+	due := db.deliveries["due"]
+	next := time.Unix(due.NextAttempt, 0).Sub(now)
+	if due.Attempts != 3 || due.LastStatusCode != http.StatusServiceUnavailable || next < 4*webhookRetryBase-time.Second || next > 4*webhookRetryBase+5*time.Second {
+		t.Errorf("wrong backoff %v %+v", next, due)
+	}
+	wClient.retryDue(now)
+	if sent("due") != 1 {
+		t.Errorf("delivery sent before backoff %d", sent("due"))
+	}
+
+	// the last attempt fails the delivery
+	due.Attempts = webhookMaxAttempts - 1
+	wClient.deliver(db.webhooks[0], due)
+	if db.deliveries["due"].Status != store.WebhookDeliveryFailed {
+		t.Errorf("delivery isn't failed %+v", db.deliveries["due"])
+	}
+
+	m.Lock()
+	fail = false
+	m.Unlock()
+	wClient.deliver(db.webhooks[0], db.deliveries["later"])
+	if db.deliveries["later"].Status != store.WebhookDeliveryDelivered || db.deliveries["later"].Attempts != 1 {
+		t.Errorf("wrong delivery %+v", db.deliveries["later"])
+	}
+}
+
+func TestCheckWebhookHost(t *testing.T) {
+	tests := []struct {
+		host   string
+		public bool
+	}{
+		{"8.8.8.8", true},
+		{"2001:4860:4860::8888", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.20.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fd00::1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, test := range tests {
+		_, err := checkWebhookHost(context.Background(), test.host)
+		if (err == nil) != test.public {
+			t.Errorf("%s: public=%v, err=%v", test.host, test.public, err)
+		}
+	}
+
+	// the guard is in the dialer too, a host can't be pointed to a private address later
+	if _, err := webhookDial(context.Background(), "tcp", "127.0.0.1:443"); err == nil {
+		t.Error("loopback dialed")
+	}
+}
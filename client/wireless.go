@@ -0,0 +1,543 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Multy-io/Multy-back/store"
+	"github.com/jekabolt/slf"
+)
+
+// wireless payment events
+const (
+	// PaymentStatus is emitted to sender and receiver when the payment intent changes its state
+	PaymentStatus = "event:payment:status"
+	// ReceiverExpired is emitted to the receiver when its advertisement expires
+	ReceiverExpired = "event:receiver:expired"
+
+	receiverTTL       = 2 * time.Minute
+	paymentTTL        = time.Hour
+	wirelessSweepTick = 10 * time.Second
+)
+
+// payment intent states, intent goes only forward through them
+const (
+	PaymentRequested = "requested"
+	PaymentBroadcast = "broadcast"
+	PaymentMempool   = "mempool"
+	PaymentConfirmed = "confirmed"
+	PaymentExpired   = "expired"
+)
+
+var paymentStateOrder = map[string]int{
+	PaymentRequested: 0,
+	PaymentBroadcast: 1,
+	PaymentMempool:   2,
+	PaymentConfirmed: 3,
+	PaymentExpired:   3,
+}
+
+// socketConn is the socket.io connection, it is *gosocketio.Channel out of tests
+type socketConn interface {
+	Id() string
+	Emit(method string, args interface{}) error
+}
+
+// PaymentRequest is sent by the sender to pay the nearby receiver
+type PaymentRequest struct {
+	UserCode string `json:"usercode"`
+	Amount   string `json:"amount"`
+}
+
+// PaymentIntent is the payment of the sender to the receiver through its states
+type PaymentIntent struct {
+	IntentID   string `json:"intentid"`
+	UserCode   string `json:"usercode"`
+	SenderID   string `json:"-"`
+	ReceiverID string `json:"-"`
+	CurrencyID int    `json:"currencyid"`
+	NetworkID  int    `json:"networkid"`
+	Address    string `json:"address"`
+	Amount     string `json:"amount"`
+	TxID       string `json:"txid,omitempty"`
+	State      string `json:"state"`
+	Updated    int64  `json:"updated"`
+	Expire     int64  `json:"expire"`
+
+	senderConn   socketConn
+	receiverConn socketConn
+}
+
+type receiverAd struct {
+	receiver store.Receiver
+	userID   string
+	conn     socketConn
+	expire   time.Time
+}
+
+// wirelessRegistry shares receiver advertisements between socket.io instances
+type wirelessRegistry interface {
+	// advertise stores the advertisement and announces it to senders of other instances
+	advertise(receiver store.WirelessReceiver) error
+	withdraw(userCodes []string)
+	find(userCodes []string) []store.WirelessReceiver
+}
+
+type wirelessSender struct {
+	userID  string
+	conn    socketConn
+	visible map[string]bool
+}
+
+// wirelessEmit is the emit collected under the lock, it's done after the lock is released
+type wirelessEmit struct {
+	conn  socketConn
+	event string
+	data  interface{}
+}
+
+// WirelessService keeps nearby payment sessions: receivers advertise user codes,
+// senders discover them by codes they see in proximity and pay with tracked intents
+type WirelessService struct {
+	m         sync.Mutex
+	receivers map[string]*receiverAd     // by user code
+	senders   map[string]*wirelessSender // by connection id
+	intents   map[string]*PaymentIntent  // by intent id
+	// emits and statuses are collected under the lock, unlock sends them
+	emits    []wirelessEmit
+	statuses []PaymentIntent
+
+	// registry is nil when the instance is alone
+	registry wirelessRegistry
+	now      func() time.Time
+	onStatus func(intent PaymentIntent)
+
+	log slf.StructuredLogger
+}
+
+func NewWirelessService() *WirelessService {
+	return &WirelessService{
+		receivers: map[string]*receiverAd{},
+		senders:   map[string]*wirelessSender{},
+		intents:   map[string]*PaymentIntent{},
+		now:       time.Now,
+		log:       slf.WithContext("wireless"),
+	}
+}
+
+// Run expires receivers and intents
+func (ws *WirelessService) Run() {
+	ticker := time.NewTicker(wirelessSweepTick)
+	for range ticker.C {
+		ws.Expire()
+	}
+}
+
+// publicReceiver is the receiver shown to senders
+func publicReceiver(receiver store.Receiver) store.Receiver {
+	receiver.Socket = nil
+	return receiver
+}
+
+// ReceiverOn advertises the receiver or refreshes its advertisement
+func (ws *WirelessService) ReceiverOn(conn socketConn, userID string, receiver store.Receiver) error {
+	if receiver.UserCode == "" || receiver.Address == "" {
+		return errors.New("ReceiverOn: user code and address are required")
+	}
+	receiver.ID = userID
+	receiver.Socket = nil
+	ad := &receiverAd{
+		receiver: receiver,
+		userID:   userID,
+		conn:     conn,
+		expire:   ws.now().Add(receiverTTL),
+	}
+
+	// the registry is asked without the lock, the code is checked again after it
+	ws.m.Lock()
+	taken := ws.codeTaken(receiver.UserCode, userID)
+	ws.unlock()
+	if taken {
+		return errors.New("ReceiverOn: user code is taken")
+	}
+	if ws.registry != nil {
+		err := ws.registry.advertise(ad.shared())
+		if err != nil {
+			return fmt.Errorf("ReceiverOn: %s", err.Error())
+		}
+	}
+
+	ws.m.Lock()
+	defer ws.unlock()
+	if ws.codeTaken(receiver.UserCode, userID) {
+		return errors.New("ReceiverOn: user code is taken")
+	}
+	ws.receivers[receiver.UserCode] = ad
+	ws.announce(receiver)
+	return nil
+}
+
+// codeTaken checks the user code is advertised here by another user, it's called under the lock
+func (ws *WirelessService) codeTaken(userCode, userID string) bool {
+	ad, ok := ws.receivers[userCode]
+	return ok && ad.userID != userID
+}
+
+// Announce tells local senders about the receiver advertised on another instance
+func (ws *WirelessService) Announce(receiver store.Receiver) {
+	ws.m.Lock()
+	defer ws.unlock()
+	ws.announce(receiver)
+}
+
+// announce emits the new receiver to senders which see its code, it's called under the lock
+func (ws *WirelessService) announce(receiver store.Receiver) {
+	for _, sender := range ws.senders {
+		if sender.visible[receiver.UserCode] && sender.userID != receiver.ID {
+			ws.emit(sender.conn, NewReceiver, publicReceiver(receiver))
+		}
+	}
+}
+
+// shared is the advertisement stored in the registry
+func (ad *receiverAd) shared() store.WirelessReceiver {
+	return store.WirelessReceiver{
+		UserCode:   ad.receiver.UserCode,
+		UserID:     ad.userID,
+		CurrencyID: ad.receiver.CurrencyID,
+		NetworkID:  ad.receiver.NetworkID,
+		Address:    ad.receiver.Address,
+		Amount:     ad.receiver.Amount,
+		Expire:     ad.expire.Unix(),
+	}
+}
+
+// remoteReceivers returns advertisements of the codes held by other instances, there is no connection to them
+func (ws *WirelessService) remoteReceivers(userCodes []string) map[string]*receiverAd {
+	remote := map[string]*receiverAd{}
+	if ws.registry == nil || len(userCodes) == 0 {
+		return remote
+	}
+	for _, shared := range ws.registry.find(userCodes) {
+		remote[shared.UserCode] = &receiverAd{
+			receiver: store.Receiver{
+				ID:         shared.UserID,
+				UserCode:   shared.UserCode,
+				CurrencyID: shared.CurrencyID,
+				NetworkID:  shared.NetworkID,
+				Address:    shared.Address,
+				Amount:     shared.Amount,
+			},
+			userID: shared.UserID,
+			expire: time.Unix(shared.Expire, 0),
+		}
+	}
+	return remote
+}
+
+// withdraw removes advertisements of the codes from the registry, it's called without the lock
+func (ws *WirelessService) withdraw(userCodes []string) {
+	if ws.registry != nil && len(userCodes) > 0 {
+		ws.registry.withdraw(userCodes)
+	}
+}
+
+// ReceiverOff stops advertisements of the connection
+func (ws *WirelessService) ReceiverOff(connID string) {
+	ws.m.Lock()
+	userCodes := ws.removeReceivers(connID)
+	ws.unlock()
+	ws.withdraw(userCodes)
+}
+
+// removeReceivers removes advertisements of the connection and returns their codes, it's called under the lock
+func (ws *WirelessService) removeReceivers(connID string) []string {
+	userCodes := []string{}
+	for userCode, ad := range ws.receivers {
+		if ad.conn.Id() == connID {
+			delete(ws.receivers, userCode)
+			userCodes = append(userCodes, userCode)
+		}
+	}
+	return userCodes
+}
+
+// SenderOn registers the sender with user codes visible nearby and returns advertised receivers of them
+func (ws *WirelessService) SenderOn(conn socketConn, userID string, userCodes []string) []store.Receiver {
+	visible := make(map[string]bool, len(userCodes))
+	for _, userCode := range userCodes {
+		visible[userCode] = true
+	}
+
+	remote := ws.remoteReceivers(userCodes)
+
+	ws.m.Lock()
+	defer ws.unlock()
+	ws.senders[conn.Id()] = &wirelessSender{userID: userID, conn: conn, visible: visible}
+
+	nearReceivers := []store.Receiver{}
+	for _, userCode := range userCodes {
+		ad, ok := ws.receivers[userCode]
+		if !ok {
+			ad, ok = remote[userCode]
+		}
+		if ok && ad.userID != userID {
+			nearReceivers = append(nearReceivers, publicReceiver(ad.receiver))
+		}
+	}
+	return nearReceivers
+}
+
+// SenderOff stops discovery of the connection
+func (ws *WirelessService) SenderOff(connID string) {
+	ws.m.Lock()
+	defer ws.unlock()
+	delete(ws.senders, connID)
+}
+
+// PaymentSend creates the payment intent of the sender to the advertised receiver
+func (ws *WirelessService) PaymentSend(conn socketConn, userID string, request PaymentRequest) (PaymentIntent, error) {
+	remote := ws.remoteReceivers([]string{request.UserCode})
+
+	ws.m.Lock()
+	defer ws.unlock()
+	intent, err := ws.newIntent(conn, userID, request, remote[request.UserCode])
+	if err != nil {
+		return PaymentIntent{}, err
+	}
+	return *intent, nil
+}
+
+// newIntent creates the intent in requested state, the receiver is looked up locally first
+// and then in remote one of other instance. It's called under the lock.
+func (ws *WirelessService) newIntent(conn socketConn, userID string, request PaymentRequest, remote *receiverAd) (*PaymentIntent, error) {
+	ad, ok := ws.receivers[request.UserCode]
+	if !ok {
+		ad, ok = remote, remote != nil
+	}
+	if !ok {
+		return nil, errors.New("newIntent: no such receiver " + request.UserCode)
+	}
+	intentID, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	amount := request.Amount
+	if amount == "" {
+		amount = ad.receiver.Amount
+	}
+	now := ws.now()
+	intent := &PaymentIntent{
+		IntentID:     intentID,
+		UserCode:     request.UserCode,
+		SenderID:     userID,
+		ReceiverID:   ad.userID,
+		CurrencyID:   ad.receiver.CurrencyID,
+		NetworkID:    ad.receiver.NetworkID,
+		Address:      ad.receiver.Address,
+		Amount:       amount,
+		State:        PaymentRequested,
+		Updated:      now.Unix(),
+		Expire:       now.Add(paymentTTL).Unix(),
+		senderConn:   conn,
+		receiverConn: ad.conn,
+	}
+	ws.intents[intentID] = intent
+	ws.emitStatus(intent)
+	return intent, nil
+}
+
+// Broadcast moves the intent of the sender to the user code to broadcast state.
+// Senders of old app versions broadcast without intent, it is created then.
+func (ws *WirelessService) Broadcast(conn socketConn, userID, userCode, txID string) (PaymentIntent, error) {
+	ws.m.Lock()
+	intent := ws.activeIntent(userID, userCode)
+	ws.unlock()
+	var remote map[string]*receiverAd
+	if intent == nil {
+		remote = ws.remoteReceivers([]string{userCode})
+	}
+
+	ws.m.Lock()
+	defer ws.unlock()
+	intent = ws.activeIntent(userID, userCode)
+	if intent == nil {
+		var err error
+		intent, err = ws.newIntent(conn, userID, PaymentRequest{UserCode: userCode}, remote[userCode])
+		if err != nil {
+			return PaymentIntent{}, err
+		}
+	}
+	if txID != "" {
+		intent.TxID = txID
+	}
+	ws.advance(intent, PaymentBroadcast)
+	return *intent, nil
+}
+
+// activeIntent returns the latest not finished intent of the sender to the user code
+func (ws *WirelessService) activeIntent(senderID, userCode string) *PaymentIntent {
+	var active *PaymentIntent
+	for _, intent := range ws.intents {
+		if intent.SenderID != senderID || intent.UserCode != userCode || paymentStateOrder[intent.State] >= paymentStateOrder[PaymentConfirmed] {
+			continue
+		}
+		if active == nil || intent.Updated > active.Updated {
+			active = intent
+		}
+	}
+	return active
+}
+
+// Transaction matches the receiver transaction notification with intents
+func (ws *WirelessService) Transaction(msg store.TransactionWithUserID) {
+	notify := msg.NotificationMsg
+	if notify == nil {
+		return
+	}
+	var state string
+	switch notify.TransactionType {
+	case store.TxStatusAppearedInMempoolIncoming:
+		state = PaymentMempool
+	case store.TxStatusAppearedInBlockIncoming, store.TxStatusInBlockConfirmedIncoming:
+		state = PaymentConfirmed
+	default:
+		return
+	}
+
+	ws.m.Lock()
+	defer ws.unlock()
+	for _, intent := range ws.intents {
+		if intentMatches(intent, msg.UserID, notify) {
+			intent.TxID = notify.TxID
+			ws.advance(intent, state)
+		}
+	}
+}
+
+// intentMatches checks the transaction pays the intent: by txid when it is known, by address and amount otherwise
+func intentMatches(intent *PaymentIntent, userID string, notify *store.WsTxNotify) bool {
+	if intent.ReceiverID != userID || intent.CurrencyID != notify.CurrencyID || intent.NetworkID != notify.NetworkID {
+		return false
+	}
+	if paymentStateOrder[intent.State] >= paymentStateOrder[PaymentConfirmed] {
+		return false
+	}
+	if intent.TxID != "" {
+		return intent.TxID == notify.TxID
+	}
+	if intent.Address != notify.Address {
+		return false
+	}
+	return intent.Amount == "" || notify.Amount == "" || intent.Amount == notify.Amount
+}
+
+// advance moves the intent forward to the state and emits it, it's called under the lock
+func (ws *WirelessService) advance(intent *PaymentIntent, state string) {
+	current := paymentStateOrder[intent.State]
+	if current >= paymentStateOrder[PaymentConfirmed] || paymentStateOrder[state] <= current {
+		return
+	}
+	intent.State = state
+	intent.Updated = ws.now().Unix()
+	ws.emitStatus(intent)
+	if paymentStateOrder[state] >= paymentStateOrder[PaymentConfirmed] {
+		delete(ws.intents, intent.IntentID)
+	}
+}
+
+// emitStatus collects the intent status for its connections and other instances, it's called under the lock
+func (ws *WirelessService) emitStatus(intent *PaymentIntent) {
+	if intent.senderConn != nil {
+		ws.emit(intent.senderConn, PaymentStatus, *intent)
+	}
+	if intent.receiverConn != nil {
+		ws.emit(intent.receiverConn, PaymentStatus, *intent)
+	}
+	if ws.onStatus != nil {
+		ws.statuses = append(ws.statuses, *intent)
+	}
+}
+
+// emit collects the emit to do after the lock is released, it's called under the lock
+func (ws *WirelessService) emit(conn socketConn, event string, data interface{}) {
+	ws.emits = append(ws.emits, wirelessEmit{conn: conn, event: event, data: data})
+}
+
+// unlock releases the lock and sends emits and statuses collected under it,
+// slow connections and cluster publishing don't hold other sessions
+func (ws *WirelessService) unlock() {
+	emits, statuses := ws.emits, ws.statuses
+	ws.emits, ws.statuses = nil, nil
+	ws.m.Unlock()
+	for _, e := range emits {
+		e.conn.Emit(e.event, e.data)
+	}
+	for _, intent := range statuses {
+		ws.onStatus(intent)
+	}
+}
+
+// Disconnect drops advertisement and discovery of the connection, intents keep going for other connections
+func (ws *WirelessService) Disconnect(connID string) {
+	ws.m.Lock()
+	userCodes := ws.removeReceivers(connID)
+	delete(ws.senders, connID)
+	for _, intent := range ws.intents {
+		if intent.senderConn != nil && intent.senderConn.Id() == connID {
+			intent.senderConn = nil
+		}
+		if intent.receiverConn != nil && intent.receiverConn.Id() == connID {
+			intent.receiverConn = nil
+		}
+	}
+	ws.unlock()
+	ws.withdraw(userCodes)
+}
+
+// Expire drops expired advertisements and intents
+func (ws *WirelessService) Expire() {
+	ws.m.Lock()
+	now := ws.now()
+	userCodes := []string{}
+	for userCode, ad := range ws.receivers {
+		if now.After(ad.expire) {
+			delete(ws.receivers, userCode)
+			userCodes = append(userCodes, userCode)
+			ws.emit(ad.conn, ReceiverExpired, publicReceiver(ad.receiver))
+		}
+	}
+	for _, intent := range ws.intents {
+		if now.Unix() > intent.Expire {
+			ws.advance(intent, PaymentExpired)
+		}
+	}
+	ws.unlock()
+	ws.withdraw(userCodes)
+}
+
+func connID(conn socketConn) string {
+	if conn == nil {
+		return ""
+	}
+	return conn.Id()
+}
+
+// txID returns the transaction hash of node reply, it's empty if the reply is not a hash
+func txID(reply string) string {
+	if len(reply) != 64 {
+		return ""
+	}
+	if _, err := hex.DecodeString(reply); err != nil {
+		return ""
+	}
+	return reply
+}
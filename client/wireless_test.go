@@ -0,0 +1,371 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package client
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+)
+
+// fakeConn records events emitted to the socket.io connection
+type fakeConn struct {
+	id     string
+	m      sync.Mutex
+	events []fakeEvent
+}
+
+type fakeEvent struct {
+	method string
+	args   interface{}
+}
+
+func (conn *fakeConn) Id() string { return conn.id }
+
+func (conn *fakeConn) Emit(method string, args interface{}) error {
+	conn.m.Lock()
+	conn.events = append(conn.events, fakeEvent{method, args})
+	conn.m.Unlock()
+	return nil
+}
+
+func (conn *fakeConn) emitted(method string) []interface{} {
+	conn.m.Lock()
+	defer conn.m.Unlock()
+	args := []interface{}{}
+	for _, event := range conn.events {
+		if event.method == method {
+			args = append(args, event.args)
+		}
+	}
+	return args
+}
+
+// states returns states of emitted payment statuses
+func (conn *fakeConn) states() []string {
+	states := []string{}
+	for _, args := range conn.emitted(PaymentStatus) {
+		states = append(states, args.(PaymentIntent).State)
+	}
+	return states
+}
+
+func testReceiver(userCode string) store.Receiver {
+	return store.Receiver{
+		UserCode:   userCode,
+		CurrencyID: currencies.Bitcoin,
+		NetworkID:  currencies.Main,
+		Address:    "1receiver" + userCode,
+		Amount:     "1000",
+	}
+}
+
+func incoming(userID, txType int, receiver store.Receiver, txID string) store.TransactionWithUserID {
+	return store.TransactionWithUserID{
+		UserID: fmt.Sprintf("receiver%d", userID),
+		NotificationMsg: &store.WsTxNotify{
+			CurrencyID:      receiver.CurrencyID,
+			NetworkID:       receiver.NetworkID,
+			Address:         receiver.Address,
+			Amount:          receiver.Amount,
+			TxID:            txID,
+			TransactionType: txType,
+		},
+	}
+}
+
+func TestWirelessDiscovery(t *testing.T) {
+	ws := NewWirelessService()
+	receiverConn := &fakeConn{id: "r"}
+	senderConn := &fakeConn{id: "s"}
+
+	if err := ws.ReceiverOn(receiverConn, "receiver1", testReceiver("1111")); err != nil {
+		t.Fatalf("ReceiverOn: %s", err.Error())
+	}
+	if err := ws.ReceiverOn(&fakeConn{id: "x"}, "other", testReceiver("1111")); err == nil {
+		t.Errorf("user code of another user taken")
+	}
+	if err := ws.ReceiverOn(receiverConn, "receiver1", store.Receiver{UserCode: "2222"}); err == nil {
+		t.Errorf("receiver without address accepted")
+	}
+
+	near := ws.SenderOn(senderConn, "sender1", []string{"1111", "3333"})
+	if len(near) != 1 || near[0].UserCode != "1111" || near[0].ID != "receiver1" {
+		t.Fatalf("wrong near receivers %v", near)
+	}
+	// own advertisements are not shown
+	if near := ws.SenderOn(&fakeConn{id: "r2"}, "receiver1", []string{"1111"}); len(near) != 0 {
+		t.Errorf("own receiver shown %v", near)
+	}
+
+	// receiver advertised after the sender looked around
+	ws.ReceiverOn(&fakeConn{id: "r3"}, "receiver3", testReceiver("3333"))
+	ws.ReceiverOn(&fakeConn{id: "r4"}, "receiver4", testReceiver("4444"))
+	newReceivers := senderConn.emitted(NewReceiver)
+	if len(newReceivers) != 1 || newReceivers[0].(store.Receiver).UserCode != "3333" {
+		t.Errorf("wrong new receivers %v", newReceivers)
+	}
+
+	ws.ReceiverOff("r")
+	if near := ws.SenderOn(senderConn, "sender1", []string{"1111"}); len(near) != 0 {
+		t.Errorf("receiver kept after off %v", near)
+	}
+	ws.SenderOff("s")
+	ws.ReceiverOn(receiverConn, "receiver1", testReceiver("1111"))
+	if got := len(senderConn.emitted(NewReceiver)); got != 1 {
+		t.Errorf("sender notified after off")
+	}
+}
+
+func TestWirelessIntentStates(t *testing.T) {
+	ws := NewWirelessService()
+	statuses := []string{}
+	ws.onStatus = func(intent PaymentIntent) {
+		statuses = append(statuses, intent.State)
+		// statuses are published without the lock
+		ws.SenderOff("nobody")
+	}
+	receiverConn := &fakeConn{id: "r"}
+	senderConn := &fakeConn{id: "s"}
+	receiver := testReceiver("1111")
+	ws.ReceiverOn(receiverConn, "receiver1", receiver)
+	ws.SenderOn(senderConn, "sender1", []string{"1111"})
+
+	if _, err := ws.PaymentSend(senderConn, "sender1", PaymentRequest{UserCode: "9999"}); err == nil {
+		t.Errorf("payment to unknown receiver accepted")
+	}
+	intent, err := ws.PaymentSend(senderConn, "sender1", PaymentRequest{UserCode: "1111"})
+	if err != nil {
+		t.Fatalf("PaymentSend: %s", err.Error())
+	}
+	if intent.State != PaymentRequested || intent.Amount != receiver.Amount || intent.Address != receiver.Address {
+		t.Errorf("wrong intent %+v", intent)
+	}
+
+	intent, err = ws.Broadcast(senderConn, "sender1", "1111", "")
+	if err != nil || intent.State != PaymentBroadcast {
+		t.Fatalf("Broadcast: %v %+v", err, intent)
+	}
+	// transactions of other users, currencies and outgoing ones don't match
+	ws.Transaction(incoming(2, store.TxStatusAppearedInMempoolIncoming, receiver, "tx"))
+	ws.Transaction(incoming(1, store.TxStatusAppearedInMempoolOutcoming, receiver, "tx"))
+	other := receiver
+	other.Address = "1other"
+	ws.Transaction(incoming(1, store.TxStatusAppearedInMempoolIncoming, other, "tx"))
+
+	ws.Transaction(incoming(1, store.TxStatusAppearedInMempoolIncoming, receiver, "tx"))
+	// block notification of another transaction to the same address
+	ws.Transaction(incoming(1, store.TxStatusAppearedInBlockIncoming, receiver, "another"))
+	ws.Transaction(incoming(1, store.TxStatusAppearedInBlockIncoming, receiver, "tx"))
+	ws.Transaction(incoming(1, store.TxStatusInBlockConfirmedIncoming, receiver, "tx"))
+
+	want := []string{PaymentRequested, PaymentBroadcast, PaymentMempool, PaymentConfirmed}
+	for _, conn := range []*fakeConn{senderConn, receiverConn} {
+		if got := conn.states(); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: states %v, want %v", conn.id, got, want)
+		}
+	}
+	if fmt.Sprint(statuses) != fmt.Sprint(want) {
+		t.Errorf("onStatus states %v, want %v", statuses, want)
+	}
+	if len(ws.intents) != 0 {
+		t.Errorf("confirmed intent kept")
+	}
+}
+
+func TestWirelessBroadcastByTxID(t *testing.T) {
+	ws := NewWirelessService()
+	receiverConn := &fakeConn{id: "r"}
+	senderConn := &fakeConn{id: "s"}
+	receiver := testReceiver("1111")
+	ws.ReceiverOn(receiverConn, "receiver1", receiver)
+
+	// old app versions send raw transaction without payment request
+	intent, err := ws.Broadcast(senderConn, "sender1", "1111", "tx")
+	if err != nil || intent.TxID != "tx" {
+		t.Fatalf("Broadcast: %v %+v", err, intent)
+	}
+	// amount differs but the txid is known
+	paid := receiver
+	paid.Amount = "999"
+	ws.Transaction(incoming(1, store.TxStatusAppearedInMempoolIncoming, paid, "other"))
+	ws.Transaction(incoming(1, store.TxStatusAppearedInBlockIncoming, paid, "tx"))
+
+	want := []string{PaymentRequested, PaymentBroadcast, PaymentConfirmed}
+	if got := senderConn.states(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("states %v, want %v", got, want)
+	}
+}
+
+func TestWirelessExpire(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	ws := NewWirelessService()
+	ws.now = func() time.Time { return now }
+	receiverConn := &fakeConn{id: "r"}
+	senderConn := &fakeConn{id: "s"}
+	ws.ReceiverOn(receiverConn, "receiver1", testReceiver("1111"))
+	ws.PaymentSend(senderConn, "sender1", PaymentRequest{UserCode: "1111"})
+
+	now = now.Add(receiverTTL / 2)
+	ws.ReceiverOn(receiverConn, "receiver1", testReceiver("1111"))
+	now = now.Add(receiverTTL * 3 / 4)
+	ws.Expire()
+	if len(receiverConn.emitted(ReceiverExpired)) != 0 {
+		t.Errorf("refreshed receiver expired")
+	}
+
+	now = now.Add(receiverTTL)
+	ws.Expire()
+	if len(receiverConn.emitted(ReceiverExpired)) != 1 {
+		t.Errorf("receiver not expired")
+	}
+	if near := ws.SenderOn(senderConn, "sender1", []string{"1111"}); len(near) != 0 {
+		t.Errorf("expired receiver shown %v", near)
+	}
+
+	now = now.Add(paymentTTL)
+	ws.Expire()
+	want := []string{PaymentRequested, PaymentExpired}
+	if got := senderConn.states(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("states %v, want %v", got, want)
+	}
+	if len(ws.intents) != 0 {
+		t.Errorf("expired intent kept")
+	}
+}
+
+func TestWirelessConcurrent(t *testing.T) {
+	ws := NewWirelessService()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			userCode := fmt.Sprintf("%04d", i)
+			receiver := testReceiver(userCode)
+			receiverConn := &fakeConn{id: "r" + userCode}
+			senderConn := &fakeConn{id: "s" + userCode}
+			ws.ReceiverOn(receiverConn, fmt.Sprintf("receiver%d", i), receiver)
+			ws.SenderOn(senderConn, "sender", []string{userCode, "0000"})
+			ws.Broadcast(senderConn, "sender", userCode, "")
+			ws.Transaction(incoming(i, store.TxStatusAppearedInBlockIncoming, receiver, "tx"+userCode))
+			ws.Expire()
+			ws.Disconnect(senderConn.id)
+			ws.Disconnect(receiverConn.id)
+		}(i)
+	}
+	wg.Wait()
+	if len(ws.receivers) != 0 || len(ws.senders) != 0 || len(ws.intents) != 0 {
+		t.Errorf("sessions kept: %d receivers, %d senders, %d intents", len(ws.receivers), len(ws.senders), len(ws.intents))
+	}
+}
+
+// clusterFake shares advertisements between services like the database and NSQ do for instances
+type clusterFake struct {
+	m        sync.Mutex
+	ads      map[string]store.WirelessReceiver
+	services map[string]*WirelessService
+}
+
+type registryFake struct {
+	instance string
+	cluster  *clusterFake
+}
+
+func (registry *registryFake) advertise(receiver store.WirelessReceiver) error {
+	cluster := registry.cluster
+	cluster.m.Lock()
+	if ad, ok := cluster.ads[receiver.UserCode]; ok && ad.UserID != receiver.UserID && ad.Expire >= time.Now().Unix() {
+		cluster.m.Unlock()
+		return store.ErrUserCodeTaken
+	}
+	receiver.Instance = registry.instance
+	cluster.ads[receiver.UserCode] = receiver
+	cluster.m.Unlock()
+
+	for instance, ws := range cluster.services {
+		if instance != registry.instance {
+			ws.Announce(store.Receiver{ID: receiver.UserID, UserCode: receiver.UserCode, Address: receiver.Address})
+		}
+	}
+	return nil
+}
+
+func (registry *registryFake) withdraw(userCodes []string) {
+	registry.cluster.m.Lock()
+	defer registry.cluster.m.Unlock()
+	for _, userCode := range userCodes {
+		if registry.cluster.ads[userCode].Instance == registry.instance {
+			delete(registry.cluster.ads, userCode)
+		}
+	}
+}
+
+func (registry *registryFake) find(userCodes []string) []store.WirelessReceiver {
+	registry.cluster.m.Lock()
+	defer registry.cluster.m.Unlock()
+	receivers := []store.WirelessReceiver{}
+	for _, userCode := range userCodes {
+		if ad, ok := registry.cluster.ads[userCode]; ok {
+			receivers = append(receivers, ad)
+		}
+	}
+	return receivers
+}
+
+func TestWirelessInstances(t *testing.T) {
+	cluster := &clusterFake{ads: map[string]store.WirelessReceiver{}, services: map[string]*WirelessService{}}
+	a, b := NewWirelessService(), NewWirelessService()
+	a.registry = &registryFake{instance: "a", cluster: cluster}
+	b.registry = &registryFake{instance: "b", cluster: cluster}
+	cluster.services["a"], cluster.services["b"] = a, b
+	receiverStatuses := []string{}
+	// receiver connection is on another instance, its statuses go through the relay
+	b.onStatus = func(intent PaymentIntent) {
+		if intent.receiverConn == nil {
+			receiverStatuses = append(receiverStatuses, intent.State)
+		}
+	}
+
+	senderConn := &fakeConn{id: "s"}
+	if near := b.SenderOn(senderConn, "sender1", []string{"1111"}); len(near) != 0 {
+		t.Errorf("wrong near receivers %v", near)
+	}
+	receiver := testReceiver("1111")
+	if err := a.ReceiverOn(&fakeConn{id: "r"}, "receiver1", receiver); err != nil {
+		t.Fatalf("ReceiverOn: %s", err.Error())
+	}
+	if got := len(senderConn.emitted(NewReceiver)); got != 1 {
+		t.Errorf("sender of another instance got %d new receivers", got)
+	}
+	if err := b.ReceiverOn(&fakeConn{id: "x"}, "other", testReceiver("1111")); err == nil {
+		t.Errorf("user code of another instance taken")
+	}
+	near := b.SenderOn(senderConn, "sender1", []string{"1111"})
+	if len(near) != 1 || near[0].ID != "receiver1" {
+		t.Fatalf("wrong near receivers %v", near)
+	}
+
+	intent, err := b.PaymentSend(senderConn, "sender1", PaymentRequest{UserCode: "1111"})
+	if err != nil || intent.Address != receiver.Address || intent.ReceiverID != "receiver1" {
+		t.Fatalf("PaymentSend: %v %+v", err, intent)
+	}
+	b.Broadcast(senderConn, "sender1", "1111", "tx")
+	b.Transaction(incoming(1, store.TxStatusAppearedInMempoolIncoming, receiver, "tx"))
+	want := []string{PaymentRequested, PaymentBroadcast, PaymentMempool}
+	if fmt.Sprint(receiverStatuses) != fmt.Sprint(want) {
+		t.Errorf("receiver states %v, want %v", receiverStatuses, want)
+	}
+
+	a.Disconnect("r")
+	if near := b.SenderOn(senderConn, "sender1", []string{"1111"}); len(near) != 0 {
+		t.Errorf("disconnected receiver shown %v", near)
+	}
+}
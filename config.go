@@ -18,6 +18,10 @@ type Configuration struct {
 	SocketioAddr      string
 	RestAddress       string
 	Firebase          client.FirebaseConf
+	Notifiers         client.NotifiersConf
+	Rates             client.RatesConf
+	Changelly         client.ChangellyConf
+	Streams           client.StreamConf
 	NSQAddress        string
 	BTCNodeAddress    string
 	DonationAddresses []store.DonationInfo
@@ -0,0 +1,312 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package eth
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/Multy-io/Multy-back/store"
+	"golang.org/x/crypto/sha3"
+)
+
+const abiWordSize = 32
+
+// abiArgument is a single typed argument of a contract method
+type abiArgument struct {
+	Name string
+	Type string
+}
+
+// abiMethod describes contract method, it's inputs and return values
+type abiMethod struct {
+	Contract  string
+	Name      string
+	Signature string
+	Inputs    []abiArgument
+	Outputs   []abiArgument
+}
+
+// newABIMethod builds method with canonical signature made of it's input types
+func newABIMethod(contract, name string, inputs, outputs []abiArgument) abiMethod {
+	types := make([]string, 0, len(inputs))
+	for _, in := range inputs {
+		types = append(types, in.Type)
+	}
+	return abiMethod{
+		Contract:  contract,
+		Name:      name,
+		Signature: name + "(" + strings.Join(types, ",") + ")",
+		Inputs:    inputs,
+		Outputs:   outputs,
+	}
+}
+
+// Selector returns first four bytes of keccak256 of the method signature e.g. 0xa9059cbb
+func (m abiMethod) Selector() string {
+	return methodSelector(m.Signature)
+}
+
+func methodSelector(signature string) string {
+	h := sha3.NewLegacyKeccak256()
+	h.Write([]byte(signature))
+	return "0x" + hex.EncodeToString(h.Sum(nil)[:4])
+}
+
+// DecodeCall decodes hex encoded contract call input and it's return data.
+// Methods are looked up in the registry of known contracts first and in the local 4-byte signature table after.
+// Return data is optional: it's empty for transactions which are not mined yet.
+// Calls of selectors shared by several known contracts are decoded as ambiguous.
+func DecodeCall(input, ret string) (*store.DecodedCall, error) {
+	return DecodeContractCall(input, ret, "")
+}
+
+// DecodeContractCall decodes call of the contract of known kind e.g. ContractERC721, the kind picks
+// the method of the selector shared by several contracts. Without the kind such calls are ambiguous:
+// arguments are decoded without names and the contract is empty.
+func DecodeContractCall(input, ret, contract string) (*store.DecodedCall, error) {
+	data, err := decodeHex(input)
+	if err != nil {
+		return nil, fmt.Errorf("DecodeContractCall: input: %s", err.Error())
+	}
+	if len(data) < 4 {
+		return nil, errors.New("DecodeContractCall: input is not a contract call")
+	}
+
+	selector := "0x" + hex.EncodeToString(data[:4])
+	methods := lookupMethods(selector)
+	if len(methods) == 0 {
+		return nil, errors.New("DecodeContractCall: unknown method " + selector)
+	}
+	method, ambiguous := methods[0], len(methods) > 1
+	for _, m := range methods {
+		if contract != "" && m.Contract == contract {
+			method, ambiguous = m, false
+		}
+	}
+	if ambiguous {
+		inputs := make([]abiArgument, 0, len(method.Inputs))
+		for _, in := range method.Inputs {
+			inputs = append(inputs, arg("", in.Type))
+		}
+		method = newABIMethod("", method.Name, inputs, nil)
+	}
+
+	params, err := decodeArguments(method.Inputs, data[4:])
+	if err != nil {
+		return nil, fmt.Errorf("DecodeContractCall: %s: %s", method.Signature, err.Error())
+	}
+
+	call := &store.DecodedCall{
+		Contract:  method.Contract,
+		Method:    method.Name,
+		Signature: method.Signature,
+		Selector:  selector,
+		Params:    params,
+		Ambiguous: ambiguous,
+	}
+
+	if len(method.Outputs) == 0 {
+		return call, nil
+	}
+	out, err := decodeHex(ret)
+	if err != nil || len(out) == 0 {
+		return call, nil
+	}
+	// node returns single word results without leading zeroes
+	if len(method.Outputs) == 1 && !isDynamicType(method.Outputs[0].Type) && len(out) < abiWordSize {
+		out = append(make([]byte, abiWordSize-len(out)), out...)
+	}
+	call.Return, err = decodeArguments(method.Outputs, out)
+	if err != nil {
+		return call, fmt.Errorf("DecodeContractCall: %s return: %s", method.Signature, err.Error())
+	}
+	return call, nil
+}
+
+func decodeHex(s string) ([]byte, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	if len(s)%2 == 1 {
+		s = "0" + s
+	}
+	return hex.DecodeString(s)
+}
+
+// decodeArguments decodes ABI encoded tuple of arguments
+func decodeArguments(args []abiArgument, data []byte) ([]store.DecodedParam, error) {
+	params := make([]store.DecodedParam, 0, len(args))
+	offset := 0
+	for _, arg := range args {
+		value, err := decodeValue(arg.Type, data, offset)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %s", arg.Type, arg.Name, err.Error())
+		}
+		params = append(params, store.DecodedParam{
+			Name:  arg.Name,
+			Type:  arg.Type,
+			Value: value,
+		})
+		offset += headSize(arg.Type)
+	}
+	return params, nil
+}
+
+// decodeValue decodes value which head is placed at offset of the enclosing tuple data.
+// Dynamic values store in the head an offset of their tail relative to the start of the tuple.
+func decodeValue(typ string, data []byte, offset int) (interface{}, error) {
+	if !isDynamicType(typ) {
+		return decodeStatic(typ, data, offset)
+	}
+
+	pos, err := readInt(data, offset)
+	if err != nil {
+		return nil, err
+	}
+	tail := data[pos:]
+
+	if typ == "bytes" || typ == "string" {
+		n, err := readInt(tail, 0)
+		if err != nil {
+			return nil, err
+		}
+		if abiWordSize+n > len(tail) {
+			return nil, errors.New("data too short")
+		}
+		b := tail[abiWordSize : abiWordSize+n]
+		if typ == "string" {
+			return string(b), nil
+		}
+		return "0x" + hex.EncodeToString(b), nil
+	}
+
+	elem, size, _ := splitArrayType(typ)
+	if size < 0 {
+		size, err = readInt(tail, 0)
+		if err != nil {
+			return nil, err
+		}
+		tail = tail[abiWordSize:]
+	}
+	return decodeList(elem, size, tail)
+}
+
+func decodeList(elem string, n int, data []byte) ([]interface{}, error) {
+	if n*headSize(elem) > len(data) {
+		return nil, errors.New("data too short")
+	}
+	values := make([]interface{}, 0, n)
+	offset := 0
+	for i := 0; i < n; i++ {
+		value, err := decodeValue(elem, data, offset)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		offset += headSize(elem)
+	}
+	return values, nil
+}
+
+func decodeStatic(typ string, data []byte, offset int) (interface{}, error) {
+	if elem, size, ok := splitArrayType(typ); ok {
+		if offset > len(data) {
+			return nil, errors.New("data too short")
+		}
+		return decodeList(elem, size, data[offset:])
+	}
+
+	word, err := readWord(data, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case typ == "address":
+		return "0x" + hex.EncodeToString(word[12:]), nil
+	case typ == "bool":
+		return word[abiWordSize-1] == 1, nil
+	case strings.HasPrefix(typ, "uint"):
+		return new(big.Int).SetBytes(word).String(), nil
+	case strings.HasPrefix(typ, "int"):
+		v := new(big.Int).SetBytes(word)
+		if word[0]&0x80 != 0 {
+			v.Sub(v, new(big.Int).Lsh(big.NewInt(1), abiWordSize*8))
+		}
+		return v.String(), nil
+	case strings.HasPrefix(typ, "bytes"):
+		n, err := strconv.Atoi(typ[len("bytes"):])
+		if err != nil || n < 1 || n > abiWordSize {
+			return nil, errors.New("unsupported type " + typ)
+		}
+		return "0x" + hex.EncodeToString(word[:n]), nil
+	}
+	return nil, errors.New("unsupported type " + typ)
+}
+
+func readWord(data []byte, offset int) ([]byte, error) {
+	if offset < 0 || offset+abiWordSize > len(data) {
+		return nil, errors.New("data too short")
+	}
+	return data[offset : offset+abiWordSize], nil
+}
+
+// readInt reads offset or length word, it never exceeds the size of data
+func readInt(data []byte, offset int) (int, error) {
+	word, err := readWord(data, offset)
+	if err != nil {
+		return 0, err
+	}
+	v := new(big.Int).SetBytes(word)
+	if !v.IsInt64() || v.Int64() > int64(len(data)) {
+		return 0, errors.New("offset out of range")
+	}
+	return int(v.Int64()), nil
+}
+
+// splitArrayType splits T[k] to T and k, size is -1 for dynamic T[]
+func splitArrayType(typ string) (elem string, size int, ok bool) {
+	if !strings.HasSuffix(typ, "]") {
+		return typ, 0, false
+	}
+	i := strings.LastIndex(typ, "[")
+	if i < 0 {
+		return typ, 0, false
+	}
+	elem = typ[:i]
+	if typ[i+1:len(typ)-1] == "" {
+		return elem, -1, true
+	}
+	size, err := strconv.Atoi(typ[i+1 : len(typ)-1])
+	if err != nil {
+		return typ, 0, false
+	}
+	return elem, size, true
+}
+
+func isDynamicType(typ string) bool {
+	if typ == "bytes" || typ == "string" {
+		return true
+	}
+	if elem, size, ok := splitArrayType(typ); ok {
+		return size < 0 || isDynamicType(elem)
+	}
+	return false
+}
+
+// headSize is a number of bytes value occupies in the head of the enclosing tuple
+func headSize(typ string) int {
+	if isDynamicType(typ) {
+		return abiWordSize
+	}
+	if elem, size, ok := splitArrayType(typ); ok {
+		return size * headSize(elem)
+	}
+	return abiWordSize
+}
@@ -0,0 +1,150 @@
+package eth
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Multy-io/Multy-back/store"
+)
+
+func word(hex string) string {
+	return strings.Repeat("0", 64-len(hex)) + hex
+}
+
+func TestSelectors(t *testing.T) {
+	known := map[string]string{
+		"create(address[],uint256)":                MultiSigFactory,
+		"submitTransaction(address,uint256,bytes)": submitTransaction,
+		"confirmTransaction(uint256)":              confirmTransaction,
+		"revokeConfirmation(uint256)":              revokeConfirmation,
+		"executeTransaction(uint256)":              executeTransaction,
+		"transfer(address,uint256)":                "0xa9059cbb",
+		"approve(address,uint256)":                 "0x095ea7b3",
+	}
+	for signature, selector := range known {
+		if got := methodSelector(signature); got != selector {
+			t.Errorf("methodSelector(%s) = %s, want %s", signature, got, selector)
+		}
+	}
+
+	// methods of the shared selector are picked by the contract kind
+	seen := map[string]string{}
+	for _, method := range knownMethods {
+		key := method.Contract + method.Selector()
+		if other, ok := seen[key]; ok {
+			t.Errorf("%s.%s has the selector of %s", method.Contract, method.Signature, other)
+		}
+		seen[key] = method.Contract + "." + method.Signature
+	}
+}
+
+func TestDecodeERC20Approve(t *testing.T) {
+	input := "0x095ea7b3" + word("dac17f958d2ee523a2206206994597c13d831ec7") + word("5f5e100")
+	call, err := DecodeContractCall(input, "1", ContractERC20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if call.Contract != ContractERC20 || call.Method != "approve" || call.Ambiguous {
+		t.Fatalf("unexpected method %s.%s", call.Contract, call.Method)
+	}
+	if v := decodedParam(call.Params, "spender"); v != "0xdac17f958d2ee523a2206206994597c13d831ec7" {
+		t.Errorf("spender = %v", v)
+	}
+	if v := decodedParam(call.Params, "value"); v != "100000000" {
+		t.Errorf("value = %v", v)
+	}
+	if v := decodedParam(call.Return, "success"); v != true {
+		t.Errorf("success = %v", v)
+	}
+}
+
+func TestDecodeSharedSelector(t *testing.T) {
+	from, to := "0x00000000000000000000000000000000000000a1", "0x00000000000000000000000000000000000000a2"
+	input := methodSelector("transferFrom(address,address,uint256)") + word(from[2:]) + word(to[2:]) + word("2a")
+
+	call, err := DecodeCall(input, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !call.Ambiguous || call.Contract != "" || call.Method != "transferFrom" || call.Params[2].Name != "" || call.Params[2].Value != "42" {
+		t.Errorf("unexpected ambiguous call %+v", call)
+	}
+
+	token := "0x00000000000000000000000000000000000000c1"
+	nft := []store.ETHLog{{
+		Address: "0x00000000000000000000000000000000000000C1",
+		Topics:  []string{eventTransfer, "0x" + word(from[2:]), "0x" + word(to[2:]), "0x" + word("2a")},
+	}}
+	if kind := tokenStandard(token, nft); kind != ContractERC721 {
+		t.Fatalf("tokenStandard = %q", kind)
+	}
+	call = decodeInput(input, "", tokenStandard(token, nft))
+	if call == nil || call.Ambiguous || call.Contract != ContractERC721 || decodedParam(call.Params, "tokenId") != "42" {
+		t.Errorf("unexpected erc721 call %+v", call)
+	}
+
+	erc20 := []store.ETHLog{{
+		Address: token,
+		Topics:  []string{eventTransfer, "0x" + word(from[2:]), "0x" + word(to[2:])},
+		Data:    "0x" + word("2a"),
+	}}
+	call = decodeInput(input, "0x1", tokenStandard(token, erc20))
+	if call == nil || call.Contract != ContractERC20 || decodedParam(call.Params, "value") != "42" || decodedParam(call.Return, "success") != true {
+		t.Errorf("unexpected erc20 call %+v", call)
+	}
+
+	// events of other contracts say nothing of the called one
+	if kind := tokenStandard("0x00000000000000000000000000000000000000c2", nft); kind != "" {
+		t.Errorf("tokenStandard of other contract = %q", kind)
+	}
+}
+
+func TestDecodeDynamicArguments(t *testing.T) {
+	submit := submitTransaction +
+		word("ab") + word("de0b6b3a7640000") + word("60") +
+		word("2") + "beef" + strings.Repeat("0", 60)
+	call, err := DecodeCall(submit, "0x07")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := decodedParam(call.Params, "data"); v != "0xbeef" {
+		t.Errorf("data = %v", v)
+	}
+	if index, _ := decodedInt(call.Return, "transactionId"); index != 7 {
+		t.Errorf("transactionId = %v", index)
+	}
+	if address, amount, data := multisigTransfer(submit); address != "0x00000000000000000000000000000000000000ab" || amount != "1000000000000000000" || data != "0xbeef" {
+		t.Errorf("multisigTransfer = %s %s %s", address, amount, data)
+	}
+
+	create := MultiSigFactory + word("40") + word("2") + word("2") + word("a1") + word("a2")
+	call, err = DecodeCall(create, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	owners := []interface{}{"0x00000000000000000000000000000000000000a1", "0x00000000000000000000000000000000000000a2"}
+	if v := decodedParam(call.Params, "owners"); !reflect.DeepEqual(v, owners) {
+		t.Errorf("owners = %v", v)
+	}
+}
+
+func TestDecodeFallbackAndErrors(t *testing.T) {
+	call, err := DecodeCall(methodSelector("burn(uint256)")+word("a"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if call.Contract != "" || call.Params[0].Value != "10" {
+		t.Errorf("unexpected fallback decoding %+v", call)
+	}
+
+	if _, err := DecodeCall("0xdeadbeef", ""); err == nil {
+		t.Error("unknown selector decoded")
+	}
+	if _, err := DecodeCall(submitTransaction+word("ab")+word("1")+word("ffff"), ""); err == nil {
+		t.Error("out of range offset decoded")
+	}
+	if call := decodeInput("0x", "", ""); call != nil {
+		t.Error("plain transfer decoded")
+	}
+}
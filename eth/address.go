@@ -0,0 +1,59 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package eth
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+	"golang.org/x/crypto/sha3"
+)
+
+// ValidateAddress checks length of the address and it's EIP-55 checksum if the address is mixed case.
+// Errors are store.AddressError.
+func ValidateAddress(address string, networkID int) error {
+	if networkID != currencies.ETHMain && networkID != currencies.ETHTest {
+		return store.ErrAddressNetwork
+	}
+	if len(address) != 42 || !strings.HasPrefix(address, "0x") {
+		return store.ErrAddressFormat
+	}
+	hexAddress := address[2:]
+	if _, err := hex.DecodeString(hexAddress); err != nil {
+		return store.ErrAddressFormat
+	}
+	if hexAddress == strings.ToLower(hexAddress) || hexAddress == strings.ToUpper(hexAddress) {
+		// no checksum
+		return nil
+	}
+	if checksumAddress(hexAddress) != address {
+		return store.ErrAddressChecksum
+	}
+	return nil
+}
+
+// checksumAddress encodes address as EIP-55 says: hex letter is upper case if the nibble of keccak256
+// of lower case address at the same position is 8 or more
+func checksumAddress(hexAddress string) string {
+	lower := strings.ToLower(hexAddress)
+	h := sha3.NewLegacyKeccak256()
+	h.Write([]byte(lower))
+	hash := h.Sum(nil)
+
+	result := []byte(lower)
+	for i, c := range result {
+		nibble := hash[i/2] >> 4
+		if i%2 == 1 {
+			nibble = hash[i/2] & 0x0f
+		}
+		if c >= 'a' && nibble >= 8 {
+			result[i] = c - 'a' + 'A'
+		}
+	}
+	return "0x" + string(result)
+}
@@ -0,0 +1,32 @@
+package eth
+
+import (
+	"testing"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	"github.com/Multy-io/Multy-back/store"
+)
+
+func TestValidateAddress(t *testing.T) {
+	for address, want := range map[string]error{
+		// EIP-55 test vectors
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed": nil,
+		"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359": nil,
+		"0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB": nil,
+		"0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb": nil,
+		"0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed": nil,
+		"0x5AAEB6053F3E94C9B9A09F33669435E7EF1BEAED": nil,
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD": store.ErrAddressChecksum,
+		"0x5aaeb6053f3e94c9b9a09f33669435e7ef1bea":   store.ErrAddressFormat,
+		"5aaeb6053f3e94c9b9a09f33669435e7ef1beaed00": store.ErrAddressFormat,
+		"0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaeg": store.ErrAddressFormat,
+	} {
+		if err := ValidateAddress(address, currencies.ETHMain); err != want {
+			t.Errorf("%s: err = %v, want %v", address, err, want)
+		}
+	}
+
+	if err := ValidateAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", 2); err != store.ErrAddressNetwork {
+		t.Errorf("unknown network err = %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package eth
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/Multy-io/Multy-back/store"
+)
+
+// Kinds of known contracts
+const (
+	ContractMultisig        = "multisig"
+	ContractMultisigFactory = "multisigfactory"
+	ContractERC20           = "erc20"
+	ContractERC721          = "erc721"
+)
+
+func arg(name, typ string) abiArgument {
+	return abiArgument{Name: name, Type: typ}
+}
+
+func args(a ...abiArgument) []abiArgument {
+	return a
+}
+
+// knownMethods is a registry of contract methods we are able to decode with argument names.
+// ERC-20 and ERC-721 share approve and transferFrom selectors, such calls are told apart
+// by the kind of the called contract only.
+var knownMethods = []abiMethod{
+	newABIMethod(ContractMultisigFactory, "create", args(arg("owners", "address[]"), arg("required", "uint256")), args(arg("wallet", "address"))),
+
+	newABIMethod(ContractMultisig, "submitTransaction", args(arg("destination", "address"), arg("value", "uint256"), arg("data", "bytes")), args(arg("transactionId", "uint256"))),
+	newABIMethod(ContractMultisig, "confirmTransaction", args(arg("transactionId", "uint256")), nil),
+	newABIMethod(ContractMultisig, "revokeConfirmation", args(arg("transactionId", "uint256")), nil),
+	newABIMethod(ContractMultisig, "executeTransaction", args(arg("transactionId", "uint256")), nil),
+	newABIMethod(ContractMultisig, "addOwner", args(arg("owner", "address")), nil),
+	newABIMethod(ContractMultisig, "removeOwner", args(arg("owner", "address")), nil),
+	newABIMethod(ContractMultisig, "replaceOwner", args(arg("owner", "address"), arg("newOwner", "address")), nil),
+	newABIMethod(ContractMultisig, "changeRequirement", args(arg("required", "uint256")), nil),
+
+	newABIMethod(ContractERC20, "transfer", args(arg("to", "address"), arg("value", "uint256")), args(arg("success", "bool"))),
+	newABIMethod(ContractERC20, "approve", args(arg("spender", "address"), arg("value", "uint256")), args(arg("success", "bool"))),
+	newABIMethod(ContractERC20, "transferFrom", args(arg("from", "address"), arg("to", "address"), arg("value", "uint256")), args(arg("success", "bool"))),
+
+	newABIMethod(ContractERC721, "safeTransferFrom", args(arg("from", "address"), arg("to", "address"), arg("tokenId", "uint256")), nil),
+	newABIMethod(ContractERC721, "safeTransferFrom", args(arg("from", "address"), arg("to", "address"), arg("tokenId", "uint256"), arg("data", "bytes")), nil),
+	newABIMethod(ContractERC721, "setApprovalForAll", args(arg("operator", "address"), arg("approved", "bool")), nil),
+	newABIMethod(ContractERC721, "transferFrom", args(arg("from", "address"), arg("to", "address"), arg("tokenId", "uint256")), nil),
+	newABIMethod(ContractERC721, "approve", args(arg("approved", "address"), arg("tokenId", "uint256")), nil),
+}
+
+// signatureTable is a local copy of widespread 4-byte signatures.
+// It's used for methods of contracts we don't know, arguments are decoded without names.
+var signatureTable = []string{
+	"balanceOf(address)",
+	"allowance(address,address)",
+	"increaseAllowance(address,uint256)",
+	"decreaseAllowance(address,uint256)",
+	"mint(address,uint256)",
+	"burn(uint256)",
+	"burnFrom(address,uint256)",
+	"deposit()",
+	"withdraw(uint256)",
+	"transferOwnership(address)",
+	"renounceOwnership()",
+	"pause()",
+	"unpause()",
+	"multiTransfer(address[],uint256[])",
+	"approveAndCall(address,uint256,bytes)",
+}
+
+// methodsBySelector keeps every known method of the selector, the signature table is used
+// for selectors of no known method
+var methodsBySelector = map[string][]abiMethod{}
+
+func init() {
+	for _, method := range knownMethods {
+		selector := method.Selector()
+		methodsBySelector[selector] = append(methodsBySelector[selector], method)
+	}
+	for _, signature := range signatureTable {
+		method, err := parseSignature(signature)
+		if err != nil {
+			panic("eth: bad signature table entry " + signature + ": " + err.Error())
+		}
+		if _, ok := methodsBySelector[method.Selector()]; !ok {
+			methodsBySelector[method.Selector()] = []abiMethod{method}
+		}
+	}
+}
+
+func lookupMethods(selector string) []abiMethod {
+	return methodsBySelector[strings.ToLower(selector)]
+}
+
+// parseSignature parses canonical signature like transfer(address,uint256). Tuples are not supported.
+func parseSignature(signature string) (abiMethod, error) {
+	open := strings.Index(signature, "(")
+	if open < 1 || !strings.HasSuffix(signature, ")") {
+		return abiMethod{}, errors.New("parseSignature: malformed signature")
+	}
+	inputs := []abiArgument{}
+	list := signature[open+1 : len(signature)-1]
+	if list != "" {
+		for _, typ := range strings.Split(list, ",") {
+			if typ == "" || strings.ContainsAny(typ, "() ") {
+				return abiMethod{}, errors.New("parseSignature: unsupported type " + typ)
+			}
+			inputs = append(inputs, arg("", typ))
+		}
+	}
+	return newABIMethod("", signature[:open], inputs, nil), nil
+}
+
+// decodeInput decodes contract call of the transaction, plain transfers and unknown methods give nil.
+// Contract is the kind of the called contract if it's known.
+func decodeInput(input, ret, contract string) *store.DecodedCall {
+	if fethMethod(input) == "0x" {
+		return nil
+	}
+	call, err := DecodeContractCall(input, ret, contract)
+	if err != nil {
+		log.Debugf("decodeInput: %s", err.Error())
+	}
+	return call
+}
+
+// ERC-20 and ERC-721 events have the same signatures, ERC-721 one has the indexed token id as the fourth topic
+var (
+	eventTransfer = eventTopic("Transfer(address,address,uint256)")
+	eventApproval = eventTopic("Approval(address,address,uint256)")
+)
+
+// tokenStandard tells ERC-20 token from ERC-721 one by Transfer and Approval events the contract emitted
+// within the mined transaction. It's empty when there are no such events e.g. for transactions in pool.
+func tokenStandard(contract string, logs []store.ETHLog) string {
+	for _, event := range logs {
+		if !strings.EqualFold(event.Address, contract) || len(event.Topics) == 0 {
+			continue
+		}
+		switch strings.ToLower(event.Topics[0]) {
+		case eventTransfer, eventApproval:
+			switch len(event.Topics) {
+			case 3:
+				return ContractERC20
+			case 4:
+				return ContractERC721
+			}
+		}
+	}
+	return ""
+}
+
+func decodedParam(params []store.DecodedParam, name string) interface{} {
+	for _, param := range params {
+		if param.Name == name {
+			return param.Value
+		}
+	}
+	return nil
+}
+
+func decodedInt(params []store.DecodedParam, name string) (int64, bool) {
+	s, _ := decodedParam(params, name).(string)
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return 0, false
+	}
+	return v.Int64(), true
+}
+
+// multisigTransfer returns destination, value and call data of the multisig submitTransaction call
+func multisigTransfer(input string) (address string, amount string, data string) {
+	call, err := DecodeCall(input, "")
+	if err != nil || call.Contract != ContractMultisig || call.Method != "submitTransaction" {
+		return "", "", ""
+	}
+	address, _ = decodedParam(call.Params, "destination").(string)
+	amount, _ = decodedParam(call.Params, "value").(string)
+	data, _ = decodedParam(call.Params, "data").(string)
+	return address, amount, data
+}
+
+// multisigTxIndex returns index of multisig transaction the confirm, revoke or execute call refers to
+func multisigTxIndex(input string) (int64, bool) {
+	call, err := DecodeCall(input, "")
+	if err != nil || call.Contract != ContractMultisig {
+		return 0, false
+	}
+	return decodedInt(call.Params, "transactionId")
+}
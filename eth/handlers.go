@@ -204,7 +204,7 @@ func setGRPCHandlers(cli pb.NodeCommuunicationsClient, nsqProducer *nsq.Producer
 				}
 			}
 
-			err = processMultisig(&tx, networtkID)
+			err = processMultisig(&tx, networtkID, cli, nsqProducer)
 			if err != nil {
 				log.Errorf("initGrpcClient: processMultisig: %s", err.Error())
 			}
@@ -266,8 +266,11 @@ func setGRPCHandlers(cli pb.NodeCommuunicationsClient, nsqProducer *nsq.Producer
 				})
 				if err != nil {
 					log.Errorf("EventResyncAddress: cli.EventResyncAddress %s\n", err.Error())
+					continue
 				}
 				log.Debugf("EventResyncAddress Reply %s", rp)
+				// the node replies when history of the address is sent
+				sendResyncCompleted(a, networtkID, nsqProducer)
 
 			}
 		}
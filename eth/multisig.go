@@ -0,0 +1,348 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package eth
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/Multy-io/Multy-back/currencies"
+	pb "github.com/Multy-io/Multy-back/node-streamer/eth"
+	"github.com/Multy-io/Multy-back/store"
+	nsq "github.com/bitly/go-nsq"
+	"golang.org/x/crypto/sha3"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// executedOwnerChange returns owner management call executed by the multisig contract within the transaction.
+// Contract allows such calls only from itself, so they come as data of the confirmed submitTransaction.
+func executedOwnerChange(tx *store.TransactionETH, multisigStore *mgo.Collection) *store.DecodedCall {
+	if tx.BlockTime == 0 || tx.Decoded == nil || tx.Decoded.Contract != ContractMultisig {
+		return nil
+	}
+
+	var destination, data string
+	switch tx.Decoded.Method {
+	case "submitTransaction":
+		if !tx.Confirmed {
+			return nil
+		}
+		destination, _, data = multisigTransfer(tx.Input)
+	case "confirmTransaction":
+		if !tx.Confirmed {
+			return nil
+		}
+		index, ok := multisigTxIndex(tx.Input)
+		if !ok {
+			return nil
+		}
+		originTx := store.TransactionETH{}
+		err := multisigStore.Find(bson.M{"index": index, "contract": tx.Contract}).One(&originTx)
+		if err != nil {
+			log.Errorf("executedOwnerChange:multisigStore.Find %v index:%v contract:%v", err.Error(), index, tx.Contract)
+			return nil
+		}
+		destination, _, data = multisigTransfer(originTx.Input)
+	default:
+		if !tx.InvocationStatus {
+			return nil
+		}
+		destination, data = tx.To, tx.Input
+	}
+
+	if !strings.EqualFold(destination, tx.Contract) {
+		return nil
+	}
+	call, err := DecodeCall(data, "")
+	if err != nil || call.Contract != ContractMultisig {
+		return nil
+	}
+	switch call.Method {
+	case "addOwner", "removeOwner", "replaceOwner", "changeRequirement":
+		return call
+	}
+	return nil
+}
+
+// MultiSigWallet events of owner management, replaceOwner emits removal and addition
+var (
+	eventOwnerAddition     = eventTopic("OwnerAddition(address)")
+	eventOwnerRemoval      = eventTopic("OwnerRemoval(address)")
+	eventRequirementChange = eventTopic("RequirementChange(uint256)")
+)
+
+// ownerChange is a single change of multisig owners or required confirmations
+type ownerChange struct {
+	event    string
+	owner    string
+	required int
+}
+
+// eventTopic returns keccak256 of the event signature, it is the first topic of the event log
+func eventTopic(signature string) string {
+	h := sha3.NewLegacyKeccak256()
+	h.Write([]byte(signature))
+	return "0x" + hex.EncodeToString(h.Sum(nil))
+}
+
+// logOwnerChanges returns owner changes of the multisig contract emitted within the mined transaction.
+// Events of executed transactions only are emitted, so changes failed on execution are never there.
+func logOwnerChanges(tx *store.TransactionETH) []ownerChange {
+	changes := []ownerChange{}
+	for _, event := range tx.Logs {
+		if !strings.EqualFold(event.Address, tx.Contract) || len(event.Topics) == 0 {
+			continue
+		}
+		switch strings.ToLower(event.Topics[0]) {
+		case eventOwnerAddition, eventOwnerRemoval:
+			// owner is indexed
+			if len(event.Topics) < 2 {
+				continue
+			}
+			topic, err := decodeHex(event.Topics[1])
+			if err != nil {
+				continue
+			}
+			owner, err := decodeStatic("address", topic, 0)
+			if err != nil {
+				continue
+			}
+			changes = append(changes, ownerChange{event: strings.ToLower(event.Topics[0]), owner: owner.(string)})
+		case eventRequirementChange:
+			data, err := decodeHex(event.Data)
+			if err != nil {
+				continue
+			}
+			word, err := readWord(data, 0)
+			if err != nil {
+				continue
+			}
+			changes = append(changes, ownerChange{event: eventRequirementChange, required: int(new(big.Int).SetBytes(word).Int64())})
+		}
+	}
+	return changes
+}
+
+// callOwnerChanges returns owner changes of the executed owner management call,
+// it's used for transactions streamed without logs
+func callOwnerChanges(call *store.DecodedCall) []ownerChange {
+	owner, _ := decodedParam(call.Params, "owner").(string)
+	switch call.Method {
+	case "addOwner":
+		return []ownerChange{{event: eventOwnerAddition, owner: owner}}
+	case "removeOwner":
+		return []ownerChange{{event: eventOwnerRemoval, owner: owner}}
+	case "replaceOwner":
+		newOwner, _ := decodedParam(call.Params, "newOwner").(string)
+		return []ownerChange{{event: eventOwnerRemoval, owner: owner}, {event: eventOwnerAddition, owner: newOwner}}
+	case "changeRequirement":
+		required, _ := decodedInt(call.Params, "required")
+		return []ownerChange{{event: eventRequirementChange, required: int(required)}}
+	}
+	return nil
+}
+
+// applyChanges returns owners and required confirmations after the changes and whether they differ from the given ones
+func applyChanges(owners []string, confirmations int, changes []ownerChange) ([]string, int, bool) {
+	result := append([]string{}, owners...)
+	required := confirmations
+	for _, change := range changes {
+		switch change.event {
+		case eventOwnerAddition:
+			if indexOfOwner(result, change.owner) < 0 {
+				result = append(result, strings.ToLower(change.owner))
+			}
+		case eventOwnerRemoval:
+			if i := indexOfOwner(result, change.owner); i >= 0 {
+				result = append(result[:i], result[i+1:]...)
+			}
+		case eventRequirementChange:
+			required = change.required
+		}
+	}
+	// removeOwner lowers requirement the same way, RequirementChange is in logs then
+	if required > len(result) {
+		required = len(result)
+	}
+	changed := required != confirmations || len(result) != len(owners)
+	for _, owner := range owners {
+		if indexOfOwner(result, owner) < 0 {
+			changed = true
+		}
+	}
+	return result, required, changed
+}
+
+// ownerMultisig is the multisig of the contract for its new owner, wallet name and status of other owners aren't copied
+func ownerMultisig(multisig store.Multisig, owners []store.AddressExtended, confirmations int, now int64) store.Multisig {
+	return store.Multisig{
+		CurrencyID:      multisig.CurrencyID,
+		NetworkID:       multisig.NetworkID,
+		Confirmations:   confirmations,
+		FactoryAddress:  multisig.FactoryAddress,
+		ContractAddress: multisig.ContractAddress,
+		TxOfCreation:    multisig.TxOfCreation,
+		LastActionTime:  now,
+		DateOfCreation:  now,
+		Owners:          owners,
+		DeployStatus:    multisig.DeployStatus,
+		Status:          store.WalletStatusOK,
+	}
+}
+
+// applyOwnerChange updates multisig of every owner user. Removed owners lose the wallet,
+// new owners get it and their addresses are registered on the node streamer together with the contract.
+func applyOwnerChange(changes []ownerChange, tx *store.TransactionETH, networkID int, cli pb.NodeCommuunicationsClient, nsqProducer *nsq.Producer) error {
+	contractAddress := strings.ToLower(tx.Contract)
+	holders := findContractOwners(contractAddress)
+	multisig, err := fethMultisig(holders, contractAddress)
+	if err != nil {
+		return fmt.Errorf("applyOwnerChange: %s", err.Error())
+	}
+
+	current := []string{}
+	for _, owner := range multisig.Owners {
+		current = append(current, strings.ToLower(owner.Address))
+	}
+	owners, confirmations, changed := applyChanges(current, multisig.Confirmations, changes)
+	if !changed {
+		// already applied, the same transaction comes on every status change
+		return nil
+	}
+
+	users := map[string]store.User{}
+	for _, address := range owners {
+		user := store.User{}
+		err := usersData.Find(bson.M{"wallets.addresses.address": address}).One(&user)
+		if err != nil {
+			continue
+		}
+		users[user.UserID] = user
+	}
+
+	wasHolder := map[string]bool{}
+	for _, holder := range holders {
+		wasHolder[holder.UserID] = true
+		if _, ok := users[holder.UserID]; ok {
+			continue
+		}
+		sel := bson.M{"userID": holder.UserID}
+		update := bson.M{"$pull": bson.M{"multisig": bson.M{"contractaddress": contractAddress}}}
+		err := usersData.Update(sel, update)
+		if err != nil {
+			log.Errorf("applyOwnerChange:usersData.Update: pull %s", err.Error())
+			continue
+		}
+		notifyMultisigOwner(holder.UserID, store.MultisigOwnerRemoved, tx, networkID, nsqProducer)
+	}
+
+	newAddresses := map[string]*pb.AddressExtended{}
+	for userID, user := range users {
+		addrs, err := FethUserAddresses(currencies.Ether, networkID, user, owners)
+		if err != nil {
+			log.Errorf("applyOwnerChange:FethUserAddresses: %v", err.Error())
+		}
+
+		if wasHolder[userID] {
+			sel := bson.M{"userID": userID, "multisig.contractaddress": contractAddress}
+			update := bson.M{
+				"$set": bson.M{
+					"multisig.$.owners":         addrs,
+					"multisig.$.confirmations":  confirmations,
+					"multisig.$.lastactiontime": time.Now().Unix(),
+				},
+			}
+			err = usersData.Update(sel, update)
+			if err != nil {
+				log.Errorf("applyOwnerChange:usersData.Update: set %s", err.Error())
+				continue
+			}
+			notifyMultisigOwner(userID, store.MultisigOwnersChanged, tx, networkID, nsqProducer)
+			continue
+		}
+
+		added := ownerMultisig(*multisig, addrs, confirmations, time.Now().Unix())
+		err = usersData.Update(bson.M{"userID": userID}, bson.M{"$push": bson.M{"multisig": added}})
+		if err != nil {
+			log.Errorf("applyOwnerChange:usersData.Update: push %s", err.Error())
+			continue
+		}
+		for _, addr := range addrs {
+			if addr.Associated && addr.UserID == userID {
+				newAddresses[addr.Address] = &pb.AddressExtended{
+					UserID:       addr.UserID,
+					WalletIndex:  int32(addr.WalletIndex),
+					AddressIndex: int32(addr.AddressIndex),
+				}
+			}
+		}
+		notifyMultisigOwner(userID, store.MultisigOwnerAdded, tx, networkID, nsqProducer)
+	}
+
+	if len(newAddresses) > 0 {
+		rp, err := cli.EventInitialAdd(context.Background(), &pb.UsersData{
+			Map: newAddresses,
+			UsersContracts: map[string]string{
+				contractAddress: multisig.FactoryAddress,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("applyOwnerChange: cli.EventInitialAdd: %s", err.Error())
+		}
+		log.Debugf("applyOwnerChange: EventInitialAdd Reply %s", rp)
+	}
+
+	return nil
+}
+
+func processOwnerChange(tx *store.TransactionETH, networkID int, multisigStore *mgo.Collection, cli pb.NodeCommuunicationsClient, nsqProducer *nsq.Producer) {
+	if tx.BlockTime == 0 {
+		return
+	}
+	changes := logOwnerChanges(tx)
+	// mined multisig transactions emit events, streamers which don't send logs have calls decoded
+	if len(tx.Logs) == 0 {
+		if call := executedOwnerChange(tx, multisigStore); call != nil {
+			changes = callOwnerChanges(call)
+		}
+	}
+	if len(changes) == 0 {
+		return
+	}
+	err := applyOwnerChange(changes, tx, networkID, cli, nsqProducer)
+	if err != nil {
+		log.Errorf("processOwnerChange:applyOwnerChange: %s", err.Error())
+	}
+}
+
+func indexOfOwner(owners []string, address string) int {
+	for i, owner := range owners {
+		if strings.EqualFold(owner, address) {
+			return i
+		}
+	}
+	return -1
+}
+
+func notifyMultisigOwner(userID string, notifyType int, tx *store.TransactionETH, networkID int, nsqProducer *nsq.Producer) {
+	sendNotify(&store.TransactionWithUserID{
+		UserID: userID,
+		NotificationMsg: &store.WsTxNotify{
+			CurrencyID:      currencies.Ether,
+			NetworkID:       networkID,
+			Address:         strings.ToLower(tx.Contract),
+			TxID:            tx.Hash,
+			TransactionType: notifyType,
+			From:            tx.From,
+			To:              tx.To,
+		},
+	}, nsqProducer)
+}
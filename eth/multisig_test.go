@@ -0,0 +1,126 @@
+package eth
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Multy-io/Multy-back/store"
+)
+
+const (
+	testMultisig = "0x5c6ba3cf53f62a8be6fd6dd1d1e1bd8fc2cb3e17"
+	ownerA       = "0x1111111111111111111111111111111111111111"
+	ownerB       = "0x2222222222222222222222222222222222222222"
+	ownerC       = "0x3333333333333333333333333333333333333333"
+)
+
+func TestEventTopics(t *testing.T) {
+	known := map[string]string{
+		eventOwnerAddition:     "0xf39e6e1eb0edcf53c221607b54b00cd28f3196fed0a24994dc308b8f611b682d",
+		eventOwnerRemoval:      "0x8001553a916ef2f495d26a907cc54d96ed840d7bda71e73194bf5a9df7a76b90",
+		eventRequirementChange: "0xa3f1ee9126a074d9326c682f561767f710e927faa811f7a99829d49dc421797a",
+	}
+	for got, topic := range known {
+		if got != topic {
+			t.Errorf("topic %s, want %s", got, topic)
+		}
+	}
+}
+
+func TestLogOwnerChanges(t *testing.T) {
+	tx := &store.TransactionETH{
+		Contract: testMultisig,
+		Logs: []store.ETHLog{
+			// replaceOwner(A, C)
+			{Address: testMultisig, Topics: []string{eventOwnerRemoval, "0x" + word(ownerA[2:])}},
+			{Address: testMultisig, Topics: []string{eventOwnerAddition, "0x" + word(ownerC[2:])}},
+			{Address: testMultisig, Topics: []string{eventRequirementChange}, Data: "0x" + word("3")},
+			// the same event of other contract
+			{Address: ownerB, Topics: []string{eventOwnerAddition, "0x" + word(ownerB[2:])}},
+			// Execution(transactionId)
+			{Address: testMultisig, Topics: []string{"0x33e13ecb54c3076d8e8bb8c2881800a4d972b792045ffae98fdf46df365fed75", "0x" + word("7")}},
+		},
+	}
+	expected := []ownerChange{
+		{event: eventOwnerRemoval, owner: ownerA},
+		{event: eventOwnerAddition, owner: ownerC},
+		{event: eventRequirementChange, required: 3},
+	}
+	if changes := logOwnerChanges(tx); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("changes %+v, want %+v", changes, expected)
+	}
+
+	call, err := DecodeCall("0xe20056e6"+word(ownerA[2:])+word(ownerC[2:]), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := callOwnerChanges(call); !reflect.DeepEqual(changes, expected[:2]) {
+		t.Errorf("call changes %+v, want %+v", changes, expected[:2])
+	}
+}
+
+func TestApplyChanges(t *testing.T) {
+	owners := []string{ownerA, ownerB}
+	tests := map[string]struct {
+		changes       []ownerChange
+		owners        []string
+		confirmations int
+		changed       bool
+	}{
+		"add": {
+			[]ownerChange{{event: eventOwnerAddition, owner: "0x3333333333333333333333333333333333333333"}},
+			[]string{ownerA, ownerB, ownerC}, 2, true,
+		},
+		"remove lowers requirement": {
+			[]ownerChange{{event: eventOwnerRemoval, owner: ownerB}},
+			[]string{ownerA}, 1, true,
+		},
+		"replace": {
+			[]ownerChange{{event: eventOwnerRemoval, owner: ownerA}, {event: eventOwnerAddition, owner: ownerC}},
+			[]string{ownerB, ownerC}, 2, true,
+		},
+		"requirement": {
+			[]ownerChange{{event: eventRequirementChange, required: 1}},
+			[]string{ownerA, ownerB}, 1, true,
+		},
+		"applied already": {
+			[]ownerChange{{event: eventOwnerAddition, owner: ownerB}, {event: eventRequirementChange, required: 2}},
+			[]string{ownerA, ownerB}, 2, false,
+		},
+	}
+	for name, test := range tests {
+		result, confirmations, changed := applyChanges(owners, 2, test.changes)
+		if !reflect.DeepEqual(result, test.owners) || confirmations != test.confirmations || changed != test.changed {
+			t.Errorf("%s: %v %d %v", name, result, confirmations, changed)
+		}
+	}
+	if !reflect.DeepEqual(owners, []string{ownerA, ownerB}) {
+		t.Errorf("owners modified %v", owners)
+	}
+}
+
+func TestOwnerMultisig(t *testing.T) {
+	holder := store.Multisig{
+		CurrencyID:      60,
+		NetworkID:       1,
+		Confirmations:   2,
+		WalletName:      "holder's wallet",
+		ContractAddress: testMultisig,
+		FactoryAddress:  ownerB,
+		DateOfCreation:  100,
+		Owners:          []store.AddressExtended{{UserID: "holder", Address: ownerA, Associated: true, WalletIndex: 4, AddressIndex: 2}},
+		DeployStatus:    true,
+		Status:          store.WalletStatusDeleted,
+	}
+	owners := []store.AddressExtended{{Address: ownerA}, {UserID: "new", Address: ownerC, Associated: true, WalletIndex: 1}}
+	added := ownerMultisig(holder, owners, 3, 200)
+	if added.WalletName != "" || added.Status != store.WalletStatusOK || added.DateOfCreation != 200 || added.Confirmations != 3 {
+		t.Errorf("holder state copied %+v", added)
+	}
+	if added.ContractAddress != testMultisig || added.FactoryAddress != ownerB || !added.DeployStatus || added.CurrencyID != 60 || added.NetworkID != 1 {
+		t.Errorf("contract isn't copied %+v", added)
+	}
+	if !reflect.DeepEqual(added.Owners, owners) {
+		t.Errorf("wrong owners %+v", added.Owners)
+	}
+}
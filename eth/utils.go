@@ -8,8 +8,6 @@ package eth
 import (
 	"encoding/json"
 	"errors"
-	"math/big"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -116,28 +114,24 @@ func GetReSyncExchangeRate(time int64) ([]store.ExchangeRatesRecord, error) {
 	}
 	stocksCCCAGG := store.ExchangeRatesRecord{}
 	err := exRate.Find(selCCCAGG).Sort("-timestamp").One(&stocksCCCAGG)
+	// records might be older than pairs
+	stocksCCCAGG.Exchanges.Normalize()
 	return []store.ExchangeRatesRecord{stocksCCCAGG}, err
 }
 
+// GetLatestExchangeRate returns the latest canonical rate aggregated from all rate providers
 func GetLatestExchangeRate() ([]store.ExchangeRatesRecord, error) {
-	selGdax := bson.M{
-		"stockexchange": "Gdax",
+	selCanonical := bson.M{
+		"stockexchange": store.StockExchangeCanonical,
 	}
-	selPoloniex := bson.M{
-		"stockexchange": "Poloniex",
-	}
-	stocksGdax := store.ExchangeRatesRecord{}
-	err := exRate.Find(selGdax).Sort("-timestamp").One(&stocksGdax)
-	if err != nil {
-		return nil, err
-	}
-
-	stocksPoloniex := store.ExchangeRatesRecord{}
-	err = exRate.Find(selPoloniex).Sort("-timestamp").One(&stocksPoloniex)
+	stocksCanonical := store.ExchangeRatesRecord{}
+	err := exRate.Find(selCanonical).Sort("-timestamp").One(&stocksCanonical)
 	if err != nil {
 		return nil, err
 	}
-	return []store.ExchangeRatesRecord{stocksPoloniex, stocksGdax}, nil
+	// sources stay in the rates collection, transactions keep rates only
+	stocksCanonical.Rates = nil
+	return []store.ExchangeRatesRecord{stocksCanonical}, nil
 }
 
 func setExchangeRates(tx *store.TransactionETH, isReSync bool, TxTime int64) {
@@ -199,6 +193,23 @@ func sendNotifyToClients(tx store.TransactionETH, nsqProducer *nsq.Producer, net
 	}
 }
 
+// sendResyncCompleted notifies the user that history of the watched address is synced
+func sendResyncCompleted(addr ethpb.WatchAddress, networkID int, nsqProducer *nsq.Producer) {
+	if addr.UserID == "" {
+		return
+	}
+	sendNotify(&store.TransactionWithUserID{
+		UserID: addr.UserID,
+		NotificationMsg: &store.WsTxNotify{
+			CurrencyID:      currencies.Ether,
+			NetworkID:       networkID,
+			Address:         addr.Address,
+			TransactionType: store.ResyncCompleted,
+			WalletIndex:     int(addr.WalletIndex),
+		},
+	}, nsqProducer)
+}
+
 func sendNotify(txMsq *store.TransactionWithUserID, nsqProducer *nsq.Producer) {
 	newTxJSON, err := json.Marshal(txMsq)
 	if err != nil {
@@ -217,7 +228,7 @@ func sendNotify(txMsq *store.TransactionWithUserID, nsqProducer *nsq.Producer) {
 }
 
 func generatedTxDataToStore(tx *ethpb.ETHTransaction) store.TransactionETH {
-	return store.TransactionETH{
+	stored := store.TransactionETH{
 		UserID:           tx.GetUserID(),
 		WalletIndex:      int(tx.GetWalletIndex()),
 		AddressIndex:     int(tx.GetAddressIndex()),
@@ -237,7 +248,23 @@ func generatedTxDataToStore(tx *ethpb.ETHTransaction) store.TransactionETH {
 		InvocationStatus: tx.GetInvocationStatus(),
 		Return:           tx.GetReturn(),
 		Input:            tx.GetInput(),
+		Logs:             generatedLogsToStore(tx.GetLogs()),
+	}
+	// events of the called token tell ERC-721 calls from ERC-20 ones
+	stored.Decoded = decodeInput(stored.Input, stored.Return, tokenStandard(stored.To, stored.Logs))
+	return stored
+}
+
+func generatedLogsToStore(logs []*ethpb.ETHLog) []store.ETHLog {
+	stored := []store.ETHLog{}
+	for _, event := range logs {
+		stored = append(stored, store.ETHLog{
+			Address: event.GetAddress(),
+			Topics:  event.GetTopics(),
+			Data:    event.GetData(),
+		})
 	}
+	return stored
 }
 
 func saveTransaction(tx store.TransactionETH, networtkID int, resync bool) error {
@@ -278,6 +305,7 @@ func saveTransaction(tx store.TransactionETH, networtkID int, resync bool) error
 				"txstatus":    tx.Status,
 				"blockheight": tx.BlockHeight,
 				"blocktime":   tx.BlockTime,
+				"decoded":     tx.Decoded,
 			},
 		}
 		err = txStore.Update(sel, update)
@@ -301,6 +329,7 @@ func saveTransaction(tx store.TransactionETH, networtkID int, resync bool) error
 				"txstatus":    tx.Status,
 				"blockheight": tx.BlockHeight,
 				"blocktime":   tx.BlockTime,
+				"decoded":     tx.Decoded,
 			},
 		}
 		err = txStore.Update(sel, update)
@@ -312,7 +341,7 @@ func saveTransaction(tx store.TransactionETH, networtkID int, resync bool) error
 	return nil
 }
 
-func processMultisig(tx *store.TransactionETH, networtkID int) error {
+func processMultisig(tx *store.TransactionETH, networtkID int, cli ethpb.NodeCommuunicationsClient, nsqProducer *nsq.Producer) error {
 
 	multisigStore := &mgo.Collection{}
 	txStore := &mgo.Collection{}
@@ -343,6 +372,7 @@ func processMultisig(tx *store.TransactionETH, networtkID int) error {
 		err := multisigStore.Find(sel).One(nil)
 		if err == mgo.ErrNotFound {
 			multyTX = ParseMultisigInput(tx, networtkID, multisigStore, txStore)
+			processOwnerChange(tx, networtkID, multisigStore, cli, nsqProducer)
 			log.Warnf("multyTX.amount %v", multyTX.Amount)
 			err := multisigStore.Insert(multyTX)
 			return err
@@ -350,6 +380,8 @@ func processMultisig(tx *store.TransactionETH, networtkID int) error {
 
 		multyTX = ParseMultisigInput(tx, networtkID, multisigStore, txStore)
 
+		processOwnerChange(tx, networtkID, multisigStore, cli, nsqProducer)
+
 		if err != nil && err != mgo.ErrNotFound {
 			// database error
 			return err
@@ -365,6 +397,7 @@ func processMultisig(tx *store.TransactionETH, networtkID int) error {
 				"invocationstatus": tx.InvocationStatus,
 				"confirmed":        tx.Confirmed,
 				"amount":           tx.Amount,
+				"decoded":          tx.Decoded,
 			},
 		}
 
@@ -394,23 +427,12 @@ func ParseMultisigInput(tx *store.TransactionETH, networtkID int, multisigStore,
 		// find in db if one one confirmation needed DONE internal transaction
 		log.Debugf("submitTransaction:  Input :%v Return :%v ", tx.Input, tx.Return)
 		if tx.BlockTime != 0 {
-			i, _ := new(big.Int).SetString(tx.Return, 16)
-			tx.Index = i.Int64()
-
-			var address string
-			var amount string
-			if len(tx.Input) >= 266 {
-				in := tx.Input[10:]
-				re := regexp.MustCompile(`.{64}`) // Every 64 chars
-				parts := re.FindAllString(in, -1) // Split the string into 64 chars blocks.
-
-				if len(parts) == 4 {
-					address = strings.ToLower("0x" + parts[0][24:])
-					a, _ := new(big.Int).SetString(parts[1], 16)
-					amount = a.String()
-				}
+			if tx.Decoded != nil {
+				tx.Index, _ = decodedInt(tx.Decoded.Return, "transactionId")
 			}
 
+			address, amount, _ := multisigTransfer(tx.Input)
+
 			tx.Amount = amount
 			log.Warnf("tx.Amount %v", tx.Amount)
 
@@ -513,13 +535,13 @@ func ParseMultisigInput(tx *store.TransactionETH, networtkID int, multisigStore,
 		//TODO: send notfy to owners about +1 confirmation. store confiramtions id db
 
 		log.Debugf("confirmTransaction: %v", tx.Input)
-		i, _ := new(big.Int).SetString(tx.Input[10:], 16)
-		sel := bson.M{"index": i.Int64(), "contract": tx.Contract}
+		index, _ := multisigTxIndex(tx.Input)
+		sel := bson.M{"index": index, "contract": tx.Contract}
 
 		originTx := store.TransactionETH{}
 		err := multisigStore.Find(sel).One(&originTx)
 		if err != nil {
-			log.Errorf("ParseMultisigInput:confirmTransaction:multisigStore.Find %v index:%v  contract:%v ", err.Error(), i.Int64(), contract.ContractAddress)
+			log.Errorf("ParseMultisigInput:confirmTransaction:multisigStore.Find %v index:%v  contract:%v ", err.Error(), index, contract.ContractAddress)
 		}
 
 		//todo update only on block and exec true
@@ -586,22 +608,8 @@ func ParseMultisigInput(tx *store.TransactionETH, networtkID int, multisigStore,
 				log.Debugf("Internal transaction:", MultiSigFactory)
 
 				isOurUser := false
-				var outputAddress string
-				var amount string
+				outputAddress, amount, _ := multisigTransfer(originTx.Input)
 				user := store.User{}
-				if len(originTx.Input) >= 266 {
-					in := originTx.Input[10:]
-					re := regexp.MustCompile(`.{64}`) // Every 64 chars
-					parts := re.FindAllString(in, -1) // Split the string into 64 chars blocks.
-
-					if len(parts) == 4 {
-						outputAddress = strings.ToLower("0x" + parts[0][24:])
-
-						a, _ := new(big.Int).SetString(parts[1], 16)
-						amount = a.String()
-
-					}
-				}
 
 				// internal transaction contract to addres
 				sel := bson.M{"wallets.addresses.address": outputAddress}
@@ -670,14 +678,14 @@ func ParseMultisigInput(tx *store.TransactionETH, networtkID int, multisigStore,
 		tx.Owners = []store.OwnerHistory{}
 
 		log.Debugf("revokeConfirmation: %v", tx.Input)
-		i, _ := new(big.Int).SetString(tx.Input, 16)
+		index, _ := multisigTxIndex(tx.Input)
 
-		sel := bson.M{"index": i.Int64(), "contract": contract.ContractAddress}
+		sel := bson.M{"index": index, "contract": contract.ContractAddress}
 
 		originTx := store.TransactionETH{}
 		err := multisigStore.Find(sel).One(&originTx)
 		if err != nil {
-			log.Errorf("ParseMultisigInput:revokeConfirmation:multisigStore.Find %v index:%v  contract:%v ", err.Error(), i.Int64(), contract.ContractAddress)
+			log.Errorf("ParseMultisigInput:revokeConfirmation:multisigStore.Find %v index:%v  contract:%v ", err.Error(), index, contract.ContractAddress)
 		}
 		ownerHistorys := []store.OwnerHistory{}
 		for _, ownerHistory := range tx.Owners {
@@ -701,7 +709,7 @@ func ParseMultisigInput(tx *store.TransactionETH, networtkID int, multisigStore,
 
 		err = multisigStore.Update(sel, update)
 		if err != nil {
-			log.Errorf("ParseMultisigInput:revokeConfirmation:multisigStore.Update %v index:%v  contract:%v ", err.Error(), i.Int64(), contract.ContractAddress)
+			log.Errorf("ParseMultisigInput:revokeConfirmation:multisigStore.Update %v index:%v  contract:%v ", err.Error(), index, contract.ContractAddress)
 		}
 
 		return tx
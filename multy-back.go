@@ -47,8 +47,9 @@ type Multy struct {
 
 	userStore store.UserStore
 
-	restClient     *client.RestClient
-	firebaseClient *client.FirebaseClient
+	restClient    *client.RestClient
+	notifyClient  *client.NotifyClient
+	webhookClient *client.WebhookClient
 
 	BTC *btc.BTCConn
 	ETH *eth.ETHConn
@@ -67,6 +68,25 @@ func Init(conf *Configuration) (*Multy, error) {
 	multy.userStore = userStore
 	log.Infof("UserStore initialization done on %s √", conf.Database)
 
+	// rates saved before pairs are migrated in the background, it's done once
+	go func() {
+		migrated, err := userStore.MigrateExchangeRates()
+		if err != nil {
+			log.Errorf("MigrateExchangeRates: %s", err.Error())
+			return
+		}
+		log.Infof("exchange rates migrated in %d documents", migrated)
+	}()
+
+	// devices logged in before tokens were hashed keep their sessions,
+	// tokens are hashed before REST API takes requests so they aren't rejected meanwhile
+	migrated, err := userStore.MigrateDeviceTokens()
+	if err != nil {
+		log.Errorf("MigrateDeviceTokens: %s", err.Error())
+	} else {
+		log.Infof("%d device tokens hashed", migrated)
+	}
+
 	// exchange rates
 	// exchange := &exchanger.Exchanger{}
 	// exchange.InitExchanger(conf.ExchangerConfiguration)
@@ -252,13 +272,18 @@ func (m *Multy) SetUserData(userStore store.UserStore, ct []store.CoinType) ([]s
 // initRoutes initialize client communication services
 // - http
 // - socketio
-// - firebase
+// - push notifications
 func (multy *Multy) initHttpRoutes(conf *Configuration) error {
 	router := gin.Default()
 	multy.route = router
 	//
 	gin.SetMode(gin.DebugMode)
 
+	changelly, err := client.InitChangelly(conf.Changelly, multy.userStore, conf.NSQAddress)
+	if err != nil {
+		return err
+	}
+
 	restClient, err := client.SetRestHandlers(
 		multy.userStore,
 		router,
@@ -267,6 +292,8 @@ func (multy *Multy) initHttpRoutes(conf *Configuration) error {
 		multy.ETH,
 		conf.MultyVerison,
 		conf.Secretkey,
+		changelly,
+		conf.Streams,
 	)
 	if err != nil {
 		return err
@@ -275,17 +302,23 @@ func (multy *Multy) initHttpRoutes(conf *Configuration) error {
 
 	// socketIO server initialization. server -> mobile client
 	socketIORoute := router.Group("/socketio")
-	socketIOPool, err := client.SetSocketIOHandlers(multy.restClient, multy.BTC, multy.ETH, socketIORoute, conf.SocketioAddr, conf.NSQAddress, multy.userStore)
+	socketIOPool, err := client.SetSocketIOHandlers(multy.restClient, multy.BTC, multy.ETH, socketIORoute, conf.SocketioAddr, conf.NSQAddress, multy.userStore, conf.Rates)
 	if err != nil {
 		return err
 	}
 	multy.clientPool = socketIOPool
 
-	firebaseClient, err := client.InitFirebaseConn(&conf.Firebase, multy.route, conf.NSQAddress)
+	notifyClient, err := client.InitNotifyClient(conf.Notifiers, conf.NSQAddress, multy.userStore)
+	if err != nil {
+		return err
+	}
+	multy.notifyClient = notifyClient
+
+	webhookClient, err := client.InitWebhooks(multy.userStore, conf.NSQAddress)
 	if err != nil {
 		return err
 	}
-	multy.firebaseClient = firebaseClient
+	multy.webhookClient = webhookClient
 
 	return nil
 }
@@ -24,6 +24,7 @@ It has these top-level messages:
 	AddressExtended
 	ReplyInfo
 	ServiceVersion
+	ETHLog
 */
 package eth
 
@@ -160,27 +161,28 @@ func (m *GasPrice) GetGas() string {
 }
 
 type ETHTransaction struct {
-	UserID           string `protobuf:"bytes,1,opt,name=UserID" json:"UserID,omitempty"`
-	WalletIndex      int32  `protobuf:"varint,2,opt,name=WalletIndex" json:"WalletIndex,omitempty"`
-	AddressIndex     int32  `protobuf:"varint,3,opt,name=AddressIndex" json:"AddressIndex,omitempty"`
-	Hash             string `protobuf:"bytes,4,opt,name=Hash" json:"Hash,omitempty"`
-	From             string `protobuf:"bytes,5,opt,name=From" json:"From,omitempty"`
-	To               string `protobuf:"bytes,6,opt,name=To" json:"To,omitempty"`
-	Amount           string `protobuf:"bytes,7,opt,name=Amount" json:"Amount,omitempty"`
-	Input            string `protobuf:"bytes,8,opt,name=input" json:"input,omitempty"`
-	GasPrice         int64  `protobuf:"varint,9,opt,name=GasPrice" json:"GasPrice,omitempty"`
-	GasLimit         int64  `protobuf:"varint,10,opt,name=GasLimit" json:"GasLimit,omitempty"`
-	Nonce            int32  `protobuf:"varint,11,opt,name=Nonce" json:"Nonce,omitempty"`
-	Status           int32  `protobuf:"varint,12,opt,name=Status" json:"Status,omitempty"`
-	BlockTime        int64  `protobuf:"varint,13,opt,name=BlockTime" json:"BlockTime,omitempty"`
-	TxpoolTime       int64  `protobuf:"varint,14,opt,name=TxpoolTime" json:"TxpoolTime,omitempty"`
-	BlockHeight      int64  `protobuf:"varint,15,opt,name=BlockHeight" json:"BlockHeight,omitempty"`
-	Resync           bool   `protobuf:"varint,16,opt,name=Resync" json:"Resync,omitempty"`
-	Multisig         bool   `protobuf:"varint,17,opt,name=Multisig" json:"Multisig,omitempty"`
-	Contract         string `protobuf:"bytes,18,opt,name=Contract" json:"Contract,omitempty"`
-	MethodInvoked    string `protobuf:"bytes,19,opt,name=MethodInvoked" json:"MethodInvoked,omitempty"`
-	Return           string `protobuf:"bytes,20,opt,name=return" json:"return,omitempty"`
-	InvocationStatus bool   `protobuf:"varint,21,opt,name=InvocationStatus" json:"InvocationStatus,omitempty"`
+	UserID           string    `protobuf:"bytes,1,opt,name=UserID" json:"UserID,omitempty"`
+	WalletIndex      int32     `protobuf:"varint,2,opt,name=WalletIndex" json:"WalletIndex,omitempty"`
+	AddressIndex     int32     `protobuf:"varint,3,opt,name=AddressIndex" json:"AddressIndex,omitempty"`
+	Hash             string    `protobuf:"bytes,4,opt,name=Hash" json:"Hash,omitempty"`
+	From             string    `protobuf:"bytes,5,opt,name=From" json:"From,omitempty"`
+	To               string    `protobuf:"bytes,6,opt,name=To" json:"To,omitempty"`
+	Amount           string    `protobuf:"bytes,7,opt,name=Amount" json:"Amount,omitempty"`
+	Input            string    `protobuf:"bytes,8,opt,name=input" json:"input,omitempty"`
+	GasPrice         int64     `protobuf:"varint,9,opt,name=GasPrice" json:"GasPrice,omitempty"`
+	GasLimit         int64     `protobuf:"varint,10,opt,name=GasLimit" json:"GasLimit,omitempty"`
+	Nonce            int32     `protobuf:"varint,11,opt,name=Nonce" json:"Nonce,omitempty"`
+	Status           int32     `protobuf:"varint,12,opt,name=Status" json:"Status,omitempty"`
+	BlockTime        int64     `protobuf:"varint,13,opt,name=BlockTime" json:"BlockTime,omitempty"`
+	TxpoolTime       int64     `protobuf:"varint,14,opt,name=TxpoolTime" json:"TxpoolTime,omitempty"`
+	BlockHeight      int64     `protobuf:"varint,15,opt,name=BlockHeight" json:"BlockHeight,omitempty"`
+	Resync           bool      `protobuf:"varint,16,opt,name=Resync" json:"Resync,omitempty"`
+	Multisig         bool      `protobuf:"varint,17,opt,name=Multisig" json:"Multisig,omitempty"`
+	Contract         string    `protobuf:"bytes,18,opt,name=Contract" json:"Contract,omitempty"`
+	MethodInvoked    string    `protobuf:"bytes,19,opt,name=MethodInvoked" json:"MethodInvoked,omitempty"`
+	Return           string    `protobuf:"bytes,20,opt,name=return" json:"return,omitempty"`
+	InvocationStatus bool      `protobuf:"varint,21,opt,name=InvocationStatus" json:"InvocationStatus,omitempty"`
+	Logs             []*ETHLog `protobuf:"bytes,22,rep,name=Logs" json:"Logs,omitempty"`
 }
 
 func (m *ETHTransaction) Reset()                    { *m = ETHTransaction{} }
@@ -335,6 +337,13 @@ func (m *ETHTransaction) GetInvocationStatus() bool {
 	return false
 }
 
+func (m *ETHTransaction) GetLogs() []*ETHLog {
+	if m != nil {
+		return m.Logs
+	}
+	return nil
+}
+
 type BlockHeight struct {
 	Height int64 `protobuf:"varint,1,opt,name=height" json:"height,omitempty"`
 }
@@ -583,6 +592,38 @@ func (m *ServiceVersion) GetLasttag() string {
 	return ""
 }
 
+type ETHLog struct {
+	Address string   `protobuf:"bytes,1,opt,name=Address" json:"Address,omitempty"`
+	Topics  []string `protobuf:"bytes,2,rep,name=Topics" json:"Topics,omitempty"`
+	Data    string   `protobuf:"bytes,3,opt,name=Data" json:"Data,omitempty"`
+}
+
+func (m *ETHLog) Reset()                    { *m = ETHLog{} }
+func (m *ETHLog) String() string            { return proto.CompactTextString(m) }
+func (*ETHLog) ProtoMessage()               {}
+func (*ETHLog) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{16} }
+
+func (m *ETHLog) GetAddress() string {
+	if m != nil {
+		return m.Address
+	}
+	return ""
+}
+
+func (m *ETHLog) GetTopics() []string {
+	if m != nil {
+		return m.Topics
+	}
+	return nil
+}
+
+func (m *ETHLog) GetData() string {
+	if m != nil {
+		return m.Data
+	}
+	return ""
+}
+
 func init() {
 	proto.RegisterType((*Multisig)(nil), "eth.Multisig")
 	proto.RegisterType((*Balance)(nil), "eth.Balance")
@@ -600,6 +641,7 @@ func init() {
 	proto.RegisterType((*AddressExtended)(nil), "eth.AddressExtended")
 	proto.RegisterType((*ReplyInfo)(nil), "eth.ReplyInfo")
 	proto.RegisterType((*ServiceVersion)(nil), "eth.ServiceVersion")
+	proto.RegisterType((*ETHLog)(nil), "eth.ETHLog")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -1337,76 +1379,79 @@ var _NodeCommuunications_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("streamer.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 1133 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x56, 0xdd, 0x52, 0xdb, 0x46,
-	0x14, 0xb6, 0x31, 0x36, 0xf6, 0x31, 0x18, 0xb2, 0x90, 0x54, 0xe3, 0x49, 0x3b, 0x1e, 0x4d, 0x92,
-	0x21, 0x69, 0x87, 0x50, 0x32, 0x9d, 0x49, 0xd3, 0xf6, 0xc2, 0x01, 0x02, 0xb4, 0x81, 0x66, 0x84,
-	0xdb, 0xf4, 0x76, 0x91, 0x0e, 0xb6, 0x06, 0x69, 0xd7, 0x23, 0xad, 0xc1, 0x7e, 0x81, 0xbe, 0x40,
-	0x5f, 0xad, 0x57, 0x7d, 0x94, 0x5e, 0x75, 0xf6, 0xec, 0x4a, 0x96, 0x8c, 0x3b, 0xe9, 0x4d, 0xef,
-	0xce, 0xff, 0xdf, 0x9e, 0xf3, 0x49, 0xd0, 0x49, 0x55, 0x82, 0x3c, 0xc6, 0x64, 0x6f, 0x9c, 0x48,
-	0x25, 0x59, 0x0d, 0xd5, 0xc8, 0xfd, 0xab, 0x0a, 0xcd, 0xf3, 0x49, 0xa4, 0xc2, 0x34, 0x1c, 0xb2,
-	0x27, 0xb0, 0x71, 0x28, 0xc5, 0x75, 0x98, 0xc4, 0x5c, 0x85, 0x52, 0xa4, 0x4e, 0xb5, 0x57, 0xdd,
-	0xad, 0x79, 0x65, 0x21, 0x7b, 0x06, 0x9d, 0x77, 0xdc, 0x57, 0x32, 0x99, 0xf5, 0x83, 0x20, 0xc1,
-	0x34, 0x75, 0x56, 0x7a, 0xd5, 0xdd, 0x96, 0xb7, 0x20, 0x65, 0x2e, 0xac, 0x0f, 0xa6, 0x3f, 0x5f,
-	0x1f, 0x26, 0x48, 0x8e, 0x4e, 0x8d, 0xac, 0x4a, 0x32, 0xd6, 0x85, 0xe6, 0xa1, 0x14, 0x2a, 0xe1,
-	0xbe, 0x72, 0x56, 0x49, 0x9f, 0xf3, 0xda, 0xff, 0x08, 0xc7, 0x91, 0x9c, 0x5d, 0x2a, 0xae, 0x26,
-	0xa9, 0x53, 0xef, 0x55, 0x77, 0x9b, 0x5e, 0x49, 0xc6, 0x1e, 0x43, 0xcb, 0xa6, 0xc3, 0xd4, 0x69,
-	0xf4, 0x6a, 0xbb, 0x2d, 0x6f, 0x2e, 0x70, 0x7f, 0x82, 0xb5, 0xb7, 0x3c, 0xe2, 0xc2, 0x47, 0xe6,
-	0xe4, 0x24, 0x35, 0xd5, 0xf2, 0x72, 0xcd, 0x33, 0xe8, 0x7c, 0x40, 0x11, 0x84, 0x62, 0x98, 0x19,
-	0xd8, 0x76, 0xca, 0x52, 0xf7, 0x73, 0xa8, 0x5f, 0x48, 0xed, 0xb0, 0x63, 0x09, 0x3b, 0x1d, 0xc3,
-	0xb8, 0x8f, 0xa1, 0x79, 0xc2, 0xd3, 0x0f, 0x49, 0xe8, 0x23, 0xdb, 0x82, 0xda, 0x09, 0x4f, 0x6d,
-	0x22, 0x4d, 0xba, 0x7f, 0xae, 0x42, 0xe7, 0x78, 0x70, 0x3a, 0x48, 0xb8, 0x48, 0xb9, 0x4f, 0xad,
-	0x3f, 0x82, 0xc6, 0x2f, 0x29, 0x26, 0x67, 0x47, 0xd6, 0xce, 0x72, 0xac, 0x07, 0xed, 0x8f, 0x3c,
-	0x8a, 0x50, 0x9d, 0x89, 0x00, 0xa7, 0x54, 0x4c, 0xdd, 0x2b, 0x8a, 0xf4, 0x60, 0x6c, 0x8f, 0xc6,
-	0xa4, 0x46, 0x26, 0x25, 0x19, 0x63, 0xb0, 0x7a, 0xca, 0xd3, 0x91, 0x1d, 0x2a, 0xd1, 0x5a, 0xf6,
-	0x2e, 0x91, 0x31, 0x0d, 0xb2, 0xe5, 0x11, 0xcd, 0x3a, 0xb0, 0x32, 0x90, 0x4e, 0x83, 0x24, 0x2b,
-	0x03, 0xa9, 0xab, 0xea, 0xc7, 0x72, 0x22, 0x94, 0xb3, 0x66, 0xaa, 0x32, 0x9c, 0x6e, 0x3a, 0x14,
-	0xe3, 0x89, 0x72, 0x9a, 0x24, 0x36, 0x8c, 0x7e, 0xbe, 0xac, 0x69, 0xa7, 0x45, 0xd3, 0x98, 0x0f,
-	0xc1, 0xe8, 0xde, 0x87, 0x71, 0xa8, 0x1c, 0xc8, 0x75, 0xc4, 0xcf, 0x47, 0xd8, 0xa6, 0xd2, 0xed,
-	0x60, 0x1f, 0x41, 0xc3, 0x3e, 0xf5, 0x3a, 0x89, 0x1b, 0xf3, 0x47, 0x7e, 0x1b, 0x49, 0xff, 0x66,
-	0x10, 0xc6, 0xe8, 0x6c, 0x50, 0xa8, 0xb9, 0x80, 0x7d, 0x01, 0x30, 0x98, 0x8e, 0xa5, 0x8c, 0x48,
-	0xdd, 0x21, 0x75, 0x41, 0xa2, 0xe7, 0x49, 0xc6, 0xa7, 0x18, 0x0e, 0x47, 0xca, 0xd9, 0x24, 0x83,
-	0xa2, 0x48, 0xe7, 0xf5, 0x30, 0x9d, 0x09, 0xdf, 0xd9, 0xa2, 0x15, 0xb3, 0x9c, 0xee, 0x20, 0x3b,
-	0x0d, 0xe7, 0x01, 0x69, 0xe6, 0xa7, 0x52, 0x5c, 0x5c, 0xb6, 0xb0, 0xb8, 0x4f, 0x60, 0xe3, 0x1c,
-	0xd5, 0x48, 0x06, 0x67, 0xe2, 0x56, 0xde, 0x60, 0xe0, 0x6c, 0x93, 0x41, 0x59, 0xa8, 0xb3, 0x26,
-	0xa8, 0x26, 0x89, 0x70, 0x76, 0xcc, 0xa4, 0x0d, 0xc7, 0x5e, 0xc0, 0x96, 0x36, 0xf1, 0xe9, 0x40,
-	0xec, 0x3c, 0x1e, 0x52, 0xf6, 0x7b, 0x72, 0xf7, 0x29, 0x2c, 0x36, 0x32, 0x32, 0x5d, 0x9a, 0xd5,
-	0xb4, 0x9c, 0xfb, 0x14, 0x36, 0xcf, 0x31, 0xa6, 0x89, 0xc8, 0x23, 0x8c, 0x50, 0xa1, 0xde, 0x85,
-	0x91, 0xde, 0x0f, 0xb3, 0x7b, 0x44, 0xbb, 0xbf, 0x57, 0x61, 0xfd, 0x23, 0x57, 0xfe, 0x28, 0xbb,
-	0x60, 0x07, 0xd6, 0xb8, 0x3d, 0x71, 0x7b, 0x34, 0x96, 0xd5, 0x99, 0x26, 0x66, 0x79, 0xcd, 0xb1,
-	0x58, 0x6e, 0x71, 0x79, 0x6b, 0x9f, 0x5e, 0xde, 0xd5, 0xfb, 0xcb, 0xeb, 0x1e, 0xea, 0x01, 0x52,
-	0xbd, 0x1e, 0xfa, 0x32, 0x09, 0xf4, 0xb4, 0x7d, 0xae, 0x70, 0x28, 0x93, 0x19, 0x55, 0x52, 0xf7,
-	0x72, 0x9e, 0x9a, 0xe6, 0xe9, 0x68, 0xf0, 0x5b, 0x56, 0x8a, 0xe1, 0xdc, 0x35, 0xa8, 0x1f, 0xc7,
-	0x63, 0x35, 0x73, 0x9f, 0x43, 0xdd, 0xe3, 0x77, 0x83, 0xa9, 0x2e, 0x4e, 0xcd, 0x0f, 0xd0, 0xb6,
-	0x54, 0x14, 0xb9, 0x5f, 0xc2, 0xa6, 0x2d, 0x64, 0x20, 0xed, 0x12, 0xfc, 0xeb, 0x0c, 0xdc, 0x3f,
-	0x56, 0xa0, 0xa5, 0x6f, 0x36, 0x3d, 0xe2, 0x8a, 0xb3, 0xe7, 0x50, 0x8b, 0xf9, 0xd8, 0xa9, 0xf6,
-	0x6a, 0xbb, 0xed, 0x83, 0xcf, 0xf6, 0x50, 0x8d, 0xf6, 0x72, 0xe5, 0xde, 0x39, 0x1f, 0x1f, 0x0b,
-	0x95, 0xcc, 0x3c, 0x6d, 0xc3, 0x7e, 0x84, 0x0e, 0xa9, 0xb2, 0x85, 0xd1, 0x00, 0xaa, 0xbd, 0xdc,
-	0x05, 0xaf, 0xb2, 0x91, 0x09, 0xb0, 0xe0, 0xd9, 0x7d, 0x0f, 0xcd, 0x2c, 0xb8, 0x86, 0x9d, 0x1b,
-	0x9c, 0x65, 0xb0, 0x73, 0x83, 0x33, 0xf6, 0x02, 0xea, 0xb7, 0x3c, 0x9a, 0x18, 0x48, 0x6b, 0x1f,
-	0xec, 0x50, 0x02, 0xdb, 0xe1, 0xf1, 0x54, 0xa1, 0x08, 0x30, 0xf0, 0x8c, 0xc9, 0x9b, 0x95, 0xd7,
-	0xd5, 0x6e, 0x1f, 0xb6, 0x97, 0x24, 0x5d, 0x12, 0x78, 0xa7, 0x18, 0xb8, 0x55, 0x08, 0xe1, 0xca,
-	0x7c, 0x84, 0x59, 0x82, 0xff, 0x17, 0xe9, 0xdc, 0xa7, 0xd0, 0xf2, 0x70, 0x1c, 0xcd, 0xce, 0xc4,
-	0xb5, 0xd4, 0xaf, 0x15, 0x63, 0x9a, 0xf2, 0x61, 0x0e, 0xf3, 0x96, 0x75, 0xa7, 0xd0, 0xb9, 0xc4,
-	0xe4, 0x36, 0xf4, 0xf1, 0x57, 0x4c, 0x52, 0x0b, 0xc0, 0x57, 0x09, 0x17, 0x7e, 0x76, 0x04, 0x96,
-	0xd3, 0x72, 0x5f, 0xc6, 0x1a, 0xb6, 0xec, 0x42, 0x19, 0x4e, 0xc3, 0xd0, 0xd5, 0x24, 0x8c, 0x02,
-	0xa5, 0x71, 0xc6, 0x7c, 0xcc, 0xe6, 0x02, 0x9d, 0x39, 0xe2, 0xa9, 0x52, 0x7c, 0x68, 0x31, 0x37,
-	0x63, 0x0f, 0xfe, 0x6e, 0xc0, 0xf6, 0x85, 0x0c, 0xf0, 0x50, 0xc6, 0xf1, 0x64, 0x22, 0x42, 0xdf,
-	0x7e, 0x47, 0xf7, 0xa1, 0x6d, 0x2b, 0xa2, 0xd2, 0x81, 0x1e, 0x87, 0x56, 0xb6, 0xbb, 0x4d, 0x74,
-	0xb9, 0x5e, 0xb7, 0xc2, 0x5e, 0xc2, 0xd6, 0xf1, 0x2d, 0x0a, 0x75, 0x82, 0x2a, 0x87, 0xd9, 0xa2,
-	0xdb, 0x06, 0xd1, 0x99, 0xca, 0xad, 0xb0, 0x57, 0xb0, 0x49, 0x0e, 0x67, 0x22, 0x54, 0x21, 0x8f,
-	0xfa, 0x41, 0xc0, 0x3a, 0xe5, 0x25, 0xeb, 0x1a, 0x3e, 0x9f, 0xa0, 0x5b, 0x61, 0xdf, 0x02, 0x23,
-	0xa7, 0x7e, 0x10, 0x5c, 0xe0, 0x5d, 0x86, 0x05, 0x0f, 0xc8, 0xae, 0x08, 0x0f, 0x4b, 0x5c, 0xbf,
-	0x81, 0xed, 0xac, 0xc0, 0x22, 0x2e, 0x15, 0x6b, 0xdc, 0x22, 0xba, 0xa0, 0xa5, 0x8c, 0xb9, 0x5b,
-	0x9f, 0x42, 0xdb, 0x0f, 0x6d, 0x71, 0x5d, 0xb3, 0x83, 0xec, 0x9a, 0x60, 0xe6, 0xa3, 0x5b, 0x61,
-	0x3f, 0xc0, 0xc3, 0xb2, 0x6b, 0xf6, 0x59, 0x5f, 0xee, 0xbc, 0x6e, 0xb2, 0xdb, 0x4f, 0x7a, 0x85,
-	0xbd, 0xb6, 0xbd, 0x6a, 0xf7, 0x28, 0xb2, 0xa0, 0x53, 0xaa, 0x97, 0x11, 0x5d, 0x82, 0x23, 0xb7,
-	0xb2, 0x5f, 0x65, 0xdf, 0xd9, 0xc4, 0xfd, 0x20, 0x28, 0x63, 0xd5, 0x7f, 0x71, 0x7e, 0x63, 0xd3,
-	0x1a, 0x30, 0x5e, 0x96, 0x76, 0xa7, 0xe8, 0x99, 0xa1, 0x36, 0xf9, 0x7e, 0x6f, 0x7d, 0x4d, 0x47,
-	0xd9, 0xf3, 0x2c, 0x6f, 0xf7, 0xfe, 0x0b, 0x7d, 0x0d, 0x1b, 0xe4, 0x7d, 0x81, 0x77, 0xf4, 0x06,
-	0x9f, 0x7a, 0x9b, 0xfd, 0x2a, 0xdb, 0x83, 0x0e, 0xb9, 0x5c, 0xa2, 0x08, 0x0c, 0x90, 0x1a, 0x1f,
-	0xa2, 0x97, 0xa4, 0xf8, 0x0a, 0xea, 0x17, 0x38, 0x37, 0x2b, 0x6e, 0x74, 0xf9, 0x17, 0x88, 0xa2,
-	0xbf, 0x84, 0xd6, 0xe5, 0x4c, 0xf8, 0xfa, 0x83, 0x86, 0xec, 0x5e, 0x01, 0x4b, 0xc3, 0xb7, 0xf5,
-	0xcc, 0xb3, 0x0f, 0xf1, 0xfd, 0xfd, 0xcf, 0x54, 0x3a, 0xfc, 0x55, 0x83, 0xfe, 0x75, 0x5f, 0xfd,
-	0x13, 0x00, 0x00, 0xff, 0xff, 0x23, 0x6b, 0xe8, 0x6e, 0xfd, 0x0a, 0x00, 0x00,
+	// 1173 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x16, 0xdd, 0x4e, 0x1b, 0x47,
+	0xd7, 0xc6, 0xd8, 0xd8, 0xc7, 0xd8, 0x90, 0x81, 0xe4, 0x5b, 0x59, 0xf9, 0x5a, 0x6b, 0x14, 0x22,
+	0x92, 0x56, 0x84, 0x12, 0x55, 0x4a, 0xd3, 0xf6, 0xc2, 0x01, 0x07, 0x68, 0xc1, 0x8d, 0x96, 0x6d,
+	0xd3, 0xdb, 0x61, 0x77, 0xb0, 0x57, 0xec, 0xee, 0x58, 0xbb, 0x63, 0xb0, 0x5f, 0xa0, 0x2f, 0xd0,
+	0xb7, 0xeb, 0x7b, 0xf4, 0xa6, 0x57, 0xd5, 0x9c, 0x99, 0xfd, 0x33, 0xae, 0xd2, 0x9b, 0xde, 0x9d,
+	0xff, 0xff, 0x73, 0x66, 0xa0, 0x9b, 0xc8, 0x98, 0xb3, 0x90, 0xc7, 0x07, 0xd3, 0x58, 0x48, 0x41,
+	0x6a, 0x5c, 0x4e, 0xe8, 0x1f, 0x55, 0x68, 0x5e, 0xce, 0x02, 0xe9, 0x27, 0xfe, 0x98, 0x3c, 0x83,
+	0xce, 0xb1, 0x88, 0x6e, 0xfc, 0x38, 0x64, 0xd2, 0x17, 0x51, 0x62, 0x55, 0xfb, 0xd5, 0xfd, 0x9a,
+	0x5d, 0x26, 0x92, 0xe7, 0xd0, 0x7d, 0xcf, 0x5c, 0x29, 0xe2, 0xc5, 0xc0, 0xf3, 0x62, 0x9e, 0x24,
+	0xd6, 0x5a, 0xbf, 0xba, 0xdf, 0xb2, 0x97, 0xa8, 0x84, 0xc2, 0xa6, 0x33, 0xff, 0xe9, 0xe6, 0x38,
+	0xe6, 0xa8, 0x68, 0xd5, 0x50, 0xaa, 0x44, 0x23, 0x3d, 0x68, 0x1e, 0x8b, 0x48, 0xc6, 0xcc, 0x95,
+	0xd6, 0x3a, 0xf2, 0x33, 0x5c, 0xe9, 0x9f, 0xf0, 0x69, 0x20, 0x16, 0x57, 0x92, 0xc9, 0x59, 0x62,
+	0xd5, 0xfb, 0xd5, 0xfd, 0xa6, 0x5d, 0xa2, 0x91, 0xa7, 0xd0, 0x32, 0xee, 0x78, 0x62, 0x35, 0xfa,
+	0xb5, 0xfd, 0x96, 0x9d, 0x13, 0xe8, 0x8f, 0xb0, 0xf1, 0x8e, 0x05, 0x2c, 0x72, 0x39, 0xb1, 0x32,
+	0x10, 0x93, 0x6a, 0xd9, 0x19, 0xe7, 0x39, 0x74, 0x3f, 0xf0, 0xc8, 0xf3, 0xa3, 0x71, 0x2a, 0x60,
+	0xd2, 0x29, 0x53, 0xe9, 0xff, 0xa1, 0x3e, 0x12, 0x4a, 0x61, 0xd7, 0x00, 0xa6, 0x3a, 0x1a, 0xa1,
+	0x4f, 0xa1, 0x79, 0xca, 0x92, 0x0f, 0xb1, 0xef, 0x72, 0xb2, 0x0d, 0xb5, 0x53, 0x96, 0x18, 0x47,
+	0x0a, 0xa4, 0x7f, 0xae, 0x43, 0x77, 0xe8, 0x9c, 0x39, 0x31, 0x8b, 0x12, 0xe6, 0x62, 0xea, 0x4f,
+	0xa0, 0xf1, 0x73, 0xc2, 0xe3, 0xf3, 0x13, 0x23, 0x67, 0x30, 0xd2, 0x87, 0xf6, 0x47, 0x16, 0x04,
+	0x5c, 0x9e, 0x47, 0x1e, 0x9f, 0x63, 0x30, 0x75, 0xbb, 0x48, 0x52, 0x85, 0x31, 0x39, 0x6a, 0x91,
+	0x1a, 0x8a, 0x94, 0x68, 0x84, 0xc0, 0xfa, 0x19, 0x4b, 0x26, 0xa6, 0xa8, 0x08, 0x2b, 0xda, 0xfb,
+	0x58, 0x84, 0x58, 0xc8, 0x96, 0x8d, 0x30, 0xe9, 0xc2, 0x9a, 0x23, 0xac, 0x06, 0x52, 0xd6, 0x1c,
+	0xa1, 0xa2, 0x1a, 0x84, 0x62, 0x16, 0x49, 0x6b, 0x43, 0x47, 0xa5, 0x31, 0x95, 0xb4, 0x1f, 0x4d,
+	0x67, 0xd2, 0x6a, 0x22, 0x59, 0x23, 0xa4, 0x97, 0x27, 0x6d, 0xb5, 0xb0, 0x1a, 0x79, 0x11, 0x34,
+	0xef, 0xc2, 0x0f, 0x7d, 0x69, 0x41, 0xc6, 0x43, 0x3c, 0x2f, 0x61, 0x1b, 0x43, 0xd7, 0x88, 0xf2,
+	0x6d, 0x5a, 0xbd, 0x89, 0xe4, 0x46, 0xde, 0xe4, 0x77, 0x81, 0x70, 0x6f, 0x1d, 0x3f, 0xe4, 0x56,
+	0x07, 0x4d, 0xe5, 0x04, 0xf2, 0x19, 0x80, 0x33, 0x9f, 0x0a, 0x11, 0x20, 0xbb, 0x8b, 0xec, 0x02,
+	0x45, 0xd5, 0x13, 0x85, 0xcf, 0xb8, 0x3f, 0x9e, 0x48, 0x6b, 0x0b, 0x05, 0x8a, 0x24, 0xe5, 0xd7,
+	0xe6, 0xc9, 0x22, 0x72, 0xad, 0x6d, 0x1c, 0x31, 0x83, 0x91, 0x5e, 0xbe, 0x1a, 0xd6, 0x23, 0xe4,
+	0x64, 0x78, 0x69, 0x70, 0xc9, 0xd2, 0xe0, 0x3e, 0x83, 0xce, 0x25, 0x97, 0x13, 0xe1, 0x9d, 0x47,
+	0x77, 0xe2, 0x96, 0x7b, 0xd6, 0x0e, 0x0a, 0x94, 0x89, 0xca, 0x6b, 0xcc, 0xe5, 0x2c, 0x8e, 0xac,
+	0x5d, 0x5d, 0x69, 0x8d, 0x91, 0x97, 0xb0, 0xad, 0x44, 0x5c, 0x5c, 0x10, 0x53, 0x8f, 0xc7, 0xe8,
+	0xfd, 0x01, 0x9d, 0x7c, 0x0e, 0xeb, 0x17, 0x62, 0x9c, 0x58, 0x4f, 0xfa, 0xb5, 0xfd, 0xf6, 0x51,
+	0xfb, 0x80, 0xcb, 0xc9, 0xc1, 0xd0, 0x39, 0xbb, 0x10, 0x63, 0x1b, 0x19, 0x74, 0x0f, 0x96, 0x33,
+	0x9d, 0x20, 0x64, 0x66, 0xd7, 0x60, 0x74, 0x0f, 0xb6, 0x2e, 0x79, 0x88, 0x25, 0x13, 0x27, 0x3c,
+	0xe0, 0x92, 0xab, 0x61, 0x99, 0xa8, 0x01, 0xd2, 0xc3, 0x89, 0x30, 0xfd, 0xad, 0x0a, 0x9b, 0x1f,
+	0x99, 0x74, 0x27, 0xe9, 0x8a, 0x5b, 0xb0, 0xc1, 0x34, 0x98, 0x6e, 0x95, 0x41, 0x95, 0xa7, 0x99,
+	0x9e, 0x6e, 0xbd, 0x4d, 0x06, 0x5b, 0x9e, 0xee, 0xda, 0xa7, 0xa7, 0x7b, 0xfd, 0xe1, 0x74, 0xd3,
+	0x63, 0xe8, 0x98, 0x78, 0x6d, 0xee, 0x8a, 0xd8, 0x53, 0xed, 0x70, 0x99, 0xe4, 0x63, 0x11, 0x2f,
+	0x30, 0x92, 0xba, 0x9d, 0xe1, 0x98, 0x34, 0x4b, 0x26, 0xce, 0xaf, 0x69, 0x28, 0x1a, 0xa3, 0x1b,
+	0x50, 0x1f, 0x86, 0x53, 0xb9, 0xa0, 0x2f, 0xa0, 0x6e, 0xb3, 0x7b, 0x67, 0xae, 0x82, 0x93, 0xf9,
+	0x86, 0x9a, 0x94, 0x8a, 0x24, 0xfa, 0x05, 0x6c, 0x99, 0x40, 0x1c, 0x61, 0xa6, 0xe4, 0x1f, 0x6b,
+	0x40, 0x7f, 0x5f, 0x83, 0x96, 0x5a, 0xea, 0xe4, 0x84, 0x49, 0x46, 0x5e, 0x40, 0x2d, 0x64, 0x53,
+	0xab, 0x8a, 0xad, 0xfa, 0x1f, 0xb6, 0x2a, 0x63, 0x1e, 0x5c, 0xb2, 0xe9, 0x30, 0x92, 0xf1, 0xc2,
+	0x56, 0x32, 0xe4, 0x07, 0xe8, 0x22, 0x2b, 0x9d, 0x28, 0x75, 0x61, 0x95, 0x16, 0x5d, 0xd2, 0x2a,
+	0x0b, 0x69, 0x03, 0x4b, 0x9a, 0xbd, 0x0b, 0x68, 0xa6, 0xc6, 0xd5, 0x5d, 0xba, 0xe5, 0x8b, 0xf4,
+	0x2e, 0xdd, 0xf2, 0x05, 0x79, 0x09, 0xf5, 0x3b, 0x16, 0xcc, 0xf4, 0xcd, 0x6b, 0x1f, 0xed, 0xa2,
+	0x03, 0x93, 0xe1, 0x70, 0x2e, 0x79, 0xe4, 0x71, 0xcf, 0xd6, 0x22, 0x6f, 0xd7, 0xde, 0x54, 0x7b,
+	0x03, 0xd8, 0x59, 0xe1, 0x74, 0x85, 0xe1, 0xdd, 0xa2, 0xe1, 0x56, 0xc1, 0x04, 0x15, 0xb0, 0xb5,
+	0xe4, 0xe0, 0xbf, 0x3d, 0x85, 0x74, 0x0f, 0x5a, 0x36, 0x9f, 0x06, 0x8b, 0xf3, 0xe8, 0x46, 0xa8,
+	0x6e, 0x85, 0x3c, 0x49, 0xd8, 0x38, 0x7b, 0x07, 0x0c, 0x4a, 0xe7, 0xd0, 0xbd, 0xe2, 0xf1, 0x9d,
+	0xef, 0xf2, 0x5f, 0x78, 0x9c, 0x98, 0x0b, 0x7d, 0x1d, 0xb3, 0xc8, 0x4d, 0x97, 0xc0, 0x60, 0x8a,
+	0xee, 0x8a, 0x50, 0xdd, 0x35, 0x33, 0x50, 0x1a, 0x53, 0x77, 0xea, 0x7a, 0xe6, 0x07, 0x9e, 0x54,
+	0x87, 0x48, 0xbf, 0x76, 0x39, 0x41, 0x79, 0x0e, 0x58, 0x22, 0x25, 0x1b, 0x9b, 0xa3, 0x9c, 0xa2,
+	0x74, 0x04, 0x0d, 0xbd, 0xb4, 0x4a, 0x66, 0x50, 0x9e, 0xa5, 0x41, 0xbe, 0x4f, 0x8e, 0x98, 0xfa,
+	0xae, 0x1e, 0x85, 0x96, 0x6d, 0x30, 0xb5, 0xa6, 0x6a, 0x14, 0x8c, 0x3b, 0x84, 0x8f, 0xfe, 0x6a,
+	0xc0, 0xce, 0x48, 0x78, 0xfc, 0x58, 0x84, 0xe1, 0x6c, 0x16, 0xf9, 0xae, 0x79, 0xb8, 0x0f, 0xa1,
+	0x6d, 0x32, 0xc4, 0x52, 0x80, 0x3e, 0x17, 0x6a, 0x05, 0x7a, 0x3b, 0x08, 0x97, 0xf3, 0xa7, 0x15,
+	0xf2, 0x0a, 0xb6, 0x87, 0x77, 0x3c, 0x92, 0xa7, 0x5c, 0x66, 0x77, 0xbd, 0xa8, 0xd6, 0x41, 0x38,
+	0x65, 0xd1, 0x0a, 0x79, 0x0d, 0x5b, 0xa8, 0x70, 0x1e, 0xf9, 0xd2, 0x67, 0xc1, 0xc0, 0xf3, 0x48,
+	0xb7, 0x3c, 0xb4, 0x3d, 0x8d, 0x67, 0x1d, 0xa1, 0x15, 0xf2, 0x0d, 0x10, 0x54, 0x1a, 0x78, 0xde,
+	0x88, 0xdf, 0xa7, 0x19, 0x3f, 0x42, 0xb9, 0xe2, 0xb9, 0x59, 0xa1, 0xfa, 0x35, 0xec, 0xa4, 0x01,
+	0x16, 0xef, 0x5c, 0x31, 0xc6, 0x6d, 0x84, 0x0b, 0x5c, 0xf4, 0x98, 0xa9, 0x0d, 0xd0, 0xb4, 0x79,
+	0xd9, 0x8b, 0xe3, 0x9f, 0x2e, 0x78, 0x4f, 0x1b, 0xd3, 0xaf, 0x7c, 0x85, 0x7c, 0x0f, 0x8f, 0xcb,
+	0xaa, 0xe9, 0x3f, 0x62, 0xb5, 0xf2, 0xa6, 0xf6, 0xae, 0x65, 0x68, 0x85, 0xbc, 0x01, 0x92, 0xa9,
+	0x07, 0x81, 0x39, 0x62, 0xa5, 0x78, 0x09, 0xc2, 0xa5, 0xf3, 0x46, 0x2b, 0x87, 0x55, 0xf2, 0xad,
+	0x71, 0x3c, 0xf0, 0xbc, 0x12, 0xf3, 0x5f, 0x29, 0xbf, 0x35, 0x6e, 0xf5, 0x71, 0x5f, 0xe5, 0x76,
+	0xb7, 0xa8, 0x99, 0xbe, 0x02, 0xa8, 0xfb, 0x9d, 0xd1, 0xd5, 0x19, 0xa5, 0xed, 0x59, 0x9d, 0xee,
+	0xc3, 0x0e, 0x7d, 0x05, 0x1d, 0xd4, 0x1e, 0xf1, 0x7b, 0xec, 0xc1, 0xa7, 0x7a, 0x73, 0x58, 0x25,
+	0x07, 0xd0, 0x45, 0x95, 0x2b, 0x1e, 0x79, 0xfa, 0x30, 0x6b, 0x1d, 0x84, 0x57, 0xb8, 0xf8, 0x12,
+	0xea, 0x23, 0x9e, 0x8b, 0x15, 0x27, 0xba, 0xfc, 0xe7, 0x42, 0xeb, 0xaf, 0xa0, 0x75, 0xb5, 0x88,
+	0x5c, 0xf5, 0x82, 0x72, 0xf2, 0x20, 0x80, 0x95, 0xe6, 0xdb, 0xaa, 0xe6, 0xe9, 0xcb, 0xff, 0x70,
+	0xfe, 0x53, 0x96, 0x32, 0x7f, 0xdd, 0xc0, 0xcf, 0xf5, 0xeb, 0xbf, 0x07, 0x00, 0xe9, 0x81, 0xca,
+	0xd9, 0x6e, 0x0b, 0x00, 0x00,
 }
@@ -0,0 +1,20 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package store
+
+// AddressError is returned by address validators of currencies
+type AddressError string
+
+func (e AddressError) Error() string {
+	return string(e)
+}
+
+const (
+	ErrAddressFormat      AddressError = "wrong address format"
+	ErrAddressChecksum    AddressError = "wrong address checksum"
+	ErrAddressNetwork     AddressError = "address is for another network"
+	ErrAddressUnsupported AddressError = "unsupported address type"
+)
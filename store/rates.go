@@ -0,0 +1,184 @@
+/*
+Copyright 2018 Idealnaya rabota LLC
+Licensed under Multy.io license.
+See LICENSE for details
+*/
+package store
+
+import (
+	"strings"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// PairRates are prices by pair like "BTC-USD", the price of the base currency in the quote one
+type PairRates map[string]float64
+
+// crossCurrencies are tried in the order when the pair has no direct or inverse rate
+var crossCurrencies = []string{"BTC", "USD"}
+
+func RatePair(base, quote string) string {
+	return base + "-" + quote
+}
+
+// SplitRatePair returns base and quote currencies of the pair
+func SplitRatePair(pair string) (string, string, bool) {
+	currencies := strings.Split(pair, "-")
+	if len(currencies) != 2 || currencies[0] == "" || currencies[1] == "" {
+		return "", "", false
+	}
+	return currencies[0], currencies[1], true
+}
+
+// Rate returns the price of base in quote from the direct or the inverse pair,
+// it's derived through BTC, USD or both when there are none
+func (rates PairRates) Rate(base, quote string) (float64, bool) {
+	return rates.rate(base, quote, crossCurrencies)
+}
+
+// rate tries the pair, then pairs through cross currencies not tried yet
+func (rates PairRates) rate(base, quote string, crosses []string) (float64, bool) {
+	if base == quote {
+		return 1, true
+	}
+	if price, ok := rates.direct(base, quote); ok {
+		return price, true
+	}
+	for i, cross := range crosses {
+		if cross == base || cross == quote {
+			continue
+		}
+		first, ok := rates.direct(base, cross)
+		if !ok {
+			continue
+		}
+		rest := append(append([]string{}, crosses[:i]...), crosses[i+1:]...)
+		if second, ok := rates.rate(cross, quote, rest); ok {
+			return first * second, true
+		}
+	}
+	return 0, false
+}
+
+func (rates PairRates) direct(base, quote string) (float64, bool) {
+	if price := rates[RatePair(base, quote)]; price > 0 {
+		return price, true
+	}
+	if price := rates[RatePair(quote, base)]; price > 0 {
+		return 1 / price, true
+	}
+	return 0, false
+}
+
+// Normalize makes pairs of records saved with fixed fields only, then fills fixed fields from pairs
+// for apps reading them
+func (rates *ExchangeRates) Normalize() {
+	if len(rates.Pairs) == 0 {
+		rates.Pairs = PairRates{}
+		// USDtoBTC and EURtoBTC are kept as BTC-USD and BTC-EUR, BTCtoUSD wins
+		legacy := []struct {
+			pair  string
+			price float64
+		}{
+			{"BTC-USD", rates.BTCtoUSD},
+			{"BTC-USD", inverse(rates.USDtoBTC)},
+			{"BTC-EUR", inverse(rates.EURtoBTC)},
+			{"ETH-BTC", rates.ETHtoBTC},
+			{"ETH-USD", rates.ETHtoUSD},
+			{"ETH-EUR", rates.ETHtoEUR},
+		}
+		for _, rate := range legacy {
+			if rate.price > 0 && rates.Pairs[rate.pair] == 0 {
+				rates.Pairs[rate.pair] = rate.price
+			}
+		}
+	}
+
+	rates.BTCtoUSD, _ = rates.Pairs.Rate("BTC", "USD")
+	rates.USDtoBTC, _ = rates.Pairs.Rate("USD", "BTC")
+	rates.EURtoBTC, _ = rates.Pairs.Rate("EUR", "BTC")
+	rates.ETHtoBTC, _ = rates.Pairs.Rate("ETH", "BTC")
+	rates.ETHtoUSD, _ = rates.Pairs.Rate("ETH", "USD")
+	rates.ETHtoEUR, _ = rates.Pairs.Rate("ETH", "EUR")
+}
+
+func inverse(price float64) float64 {
+	if price <= 0 {
+		return 0
+	}
+	return 1 / price
+}
+
+// MigrateExchangeRates adds pairs to rates records and to rates of stored transactions and outputs
+// saved with fixed fields only. It returns the number of updated documents and could be run again.
+func (mStore *MongoUserStore) MigrateExchangeRates() (int, error) {
+	updated := 0
+	iter := mStore.stockExchangeRate.Find(bson.M{"exchanges.pairs": bson.M{"$exists": false}}).Iter()
+	for {
+		doc := struct {
+			ID        bson.ObjectId `bson:"_id"`
+			Exchanges ExchangeRates `bson:"exchanges"`
+		}{}
+		if !iter.Next(&doc) {
+			break
+		}
+		doc.Exchanges.Normalize()
+		err := mStore.stockExchangeRate.UpdateId(doc.ID, bson.M{"$set": bson.M{"exchanges": doc.Exchanges}})
+		if err != nil {
+			iter.Close()
+			return updated, err
+		}
+		updated++
+	}
+	if err := iter.Close(); err != nil {
+		return updated, err
+	}
+
+	for _, collection := range []*mgo.Collection{
+		mStore.BTCMainTxsData,
+		mStore.BTCTestTxsData,
+		mStore.BTCMainSpendableOutputs,
+		mStore.BTCTestSpendableOutputs,
+		mStore.ETHMainTxsData,
+		mStore.ETHTestTxsData,
+		mStore.ETHMainMultisigTxsData,
+		mStore.ETHTestMultisigTxsData,
+	} {
+		n, err := migrateStockExchangeRate(collection)
+		updated += n
+		if err != nil {
+			return updated, err
+		}
+	}
+	return updated, nil
+}
+
+// migrateStockExchangeRate normalizes stockexchangerate arrays of the collection documents
+func migrateStockExchangeRate(collection *mgo.Collection) (int, error) {
+	updated := 0
+	query := bson.M{
+		"stockexchangerate.0":               bson.M{"$exists": true},
+		"stockexchangerate.exchanges.pairs": bson.M{"$exists": false},
+	}
+	iter := collection.Find(query).Select(bson.M{"stockexchangerate": 1}).Iter()
+	for {
+		doc := struct {
+			ID                bson.ObjectId         `bson:"_id"`
+			StockExchangeRate []ExchangeRatesRecord `bson:"stockexchangerate"`
+		}{}
+		if !iter.Next(&doc) {
+			break
+		}
+		for i := range doc.StockExchangeRate {
+			doc.StockExchangeRate[i].Exchanges.Normalize()
+		}
+		err := collection.UpdateId(doc.ID, bson.M{"$set": bson.M{"stockexchangerate": doc.StockExchangeRate}})
+		if err != nil {
+			iter.Close()
+			return updated, err
+		}
+		updated++
+	}
+	return updated, iter.Close()
+}
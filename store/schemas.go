@@ -21,17 +21,39 @@ const (
 	TxStatusInBlockConfirmedIncoming  = 5
 	TxStatusInBlockConfirmedOutcoming = 6
 
+	// multisig owner management notifications, sent as transactionType
+	MultisigOwnerAdded    = 7
+	MultisigOwnerRemoved  = 8
+	MultisigOwnersChanged = 9
+	MultisigSpendProposed = 10
+	MultisigSpendSigned   = 11
+
+	// price alert of the user fired, sent as transactionType
+	PriceAlertTriggered = 12
+
+	// history of the address is synced after it was added, sent as transactionType
+	ResyncCompleted = 13
+
+	// exchange of the user to own wallet changed its status, sent as transactionType
+	ExchangeStatusChanged = 14
+
 	// ws notification topic
 	TopicTransaction = "TransactionUpdate"
 	TopicNewIncoming = "NewIncoming"
+	TopicSecurity    = "SecurityEvent"
+	// socket.io events relayed between socket.io instances
+	TopicSocketIO = "SocketIOEvent"
 )
 
 // User represents a single app user
 type User struct {
-	UserID    string     `bson:"userID"`  // User uqnique identifier
-	Devices   []Device   `bson:"devices"` // All user devices
-	Wallets   []Wallet   `bson:"wallets"` // All user addresses in all chains
-	Multisigs []Multisig `bson:"multisig"`
+	UserID      string             `bson:"userID"`  // User uqnique identifier
+	Devices     []Device           `bson:"devices"` // All user devices
+	Wallets     []Wallet           `bson:"wallets"` // All user addresses in all chains
+	Multisigs   []Multisig         `bson:"multisig"`
+	Preferences *NotifyPreferences `bson:"preferences,omitempty"` // Push notifications settings of all devices
+	// PublicKey is hex secp256k1 key derived from the user seed, users with the key log in by signed challenges only
+	PublicKey string `bson:"publicKey,omitempty"`
 }
 
 type BTCTransaction struct {
@@ -59,16 +81,86 @@ type TxInfo struct {
 type Device struct {
 	DeviceID       string `bson:"deviceID"`       // Device uqnique identifier
 	PushToken      string `bson:"pushToken"`      // Firebase
-	JWT            string `bson:"JWT"`            // Device JSON Web Token
+	JWT            string `bson:"JWT"`            // Hash of device JSON Web Token, empty after logout
 	LastActionTime int64  `bson:"lastActionTime"` // Last action time from current device
 	LastActionIP   string `bson:"lastActionIP"`   // IP from last session
 	AppVersion     string `bson:"appVersion"`     // Mobile app verson
 	DeviceType     int    `bson:"deviceType"`     // 1 - IOS, 2 - Android
+	Locale         string `bson:"locale"`         // Device language like "en_US", push notifications are localized to it
+
+	Preferences *NotifyPreferences `bson:"preferences,omitempty"` // Overrides user push notifications settings on the device
+}
+
+// push notification event types
+const (
+	NotifyIncoming          = "incoming"
+	NotifyOutgoing          = "outgoing"
+	NotifyFirstConfirmation = "firstConfirmation"
+	NotifyFinalConfirmation = "finalConfirmation"
+	NotifyMultisigAction    = "multisigAction"
+	NotifyPriceAlert        = "priceAlert"
+	NotifyExchange          = "exchange"
+)
+
+// DefaultNotifyEvents are sent to users who haven't set preferences
+var DefaultNotifyEvents = map[string]bool{
+	NotifyIncoming:       true,
+	NotifyMultisigAction: true,
+	NotifyPriceAlert:     true,
+	NotifyExchange:       true,
+}
+
+// NotifyPreferences decides which push notifications user or device gets
+type NotifyPreferences struct {
+	// event type -> enabled, missing events are taken from DefaultNotifyEvents
+	Events map[string]bool `json:"events" bson:"events"`
+	// currency id -> minimal amount in the smallest units
+	MinAmounts   map[string]string `json:"minAmounts" bson:"minAmounts"`
+	MutedWallets []WalletMute      `json:"mutedWallets" bson:"mutedWallets"`
+	QuietHours   *QuietHours       `json:"quietHours,omitempty" bson:"quietHours,omitempty"`
+}
+
+// WalletMute mutes wallet by index or multisig by contract address
+type WalletMute struct {
+	CurrencyID  int    `json:"currencyID" bson:"currencyID"`
+	NetworkID   int    `json:"networkID" bson:"networkID"`
+	WalletIndex int    `json:"walletIndex" bson:"walletIndex"`
+	Address     string `json:"address,omitempty" bson:"address,omitempty"`
+}
+
+// QuietHours is "15:04" formatted time range in user's timezone, it may wrap midnight
+type QuietHours struct {
+	From     string `json:"from" bson:"from"`
+	To       string `json:"to" bson:"to"`
+	Timezone string `json:"timezone" bson:"timezone"`
 }
 
 const (
 	WalletStatusOK      = "ok"
 	WalletStatusDeleted = "deleted"
+
+	// bitcoin multisig address types
+	MultisigAddressP2SH  = "p2sh"
+	MultisigAddressP2WSH = "p2wsh"
+
+	// bitcoin multisig spend statuses
+	MultisigSpendPending = "pending"
+	MultisigSpendReady   = "ready"
+
+	// bitcoin multisig addresses are watched under the creator with this wallet index
+	MultisigWalletIndex = -1
+
+	// bitcoin address and output script types
+	AddressP2PKH      = "p2pkh"
+	AddressP2SH       = "p2sh"
+	AddressP2SHP2WPKH = "p2sh-p2wpkh"
+	AddressP2WPKH     = "p2wpkh"
+	AddressP2WSH      = "p2wsh"
+
+	// number of unused addresses kept watched after the last used one
+	DefaultGapLimit = 20
+	// MaxGapLimit bounds gap limit of xpub wallets, the window is derived and watched at once
+	MaxGapLimit = 100
 )
 
 // Wallet Specifies a concrete wallet of user.
@@ -92,6 +184,11 @@ type Wallet struct {
 	Adresses []Address `bson:"addresses"`
 
 	Status string `bson:"status"`
+
+	// Account extended public key of watch-only wallet, addresses are derived on the server
+	Xpub        string `bson:"xpub,omitempty"`
+	AddressType string `bson:"addressType,omitempty"`
+	GapLimit    int    `bson:"gapLimit,omitempty"`
 }
 
 type Multisig struct {
@@ -107,6 +204,33 @@ type Multisig struct {
 	Owners          []AddressExtended `bson:"owners"`
 	DeployStatus    bool
 	Status          string `bson:"status"`
+
+	// bitcoin multisig: owners' addresses are their xpubs, deployed when every owner joined
+	AddressType string            `bson:"addresstype,omitempty"`
+	OwnersCount int               `bson:"ownerscount,omitempty"`
+	InviteCode  string            `bson:"invitecode,omitempty"`
+	Addresses   []MultisigAddress `bson:"addresses,omitempty"`
+}
+
+// MultisigAddress is a derived address of the bitcoin multisig
+type MultisigAddress struct {
+	Address      string `json:"address"`
+	AddressIndex int    `json:"addressindex"`
+	Script       string `json:"script"` // redeem or witness script
+}
+
+// MultisigSpend is a PSBT spending bitcoin multisig outputs, co-owners sign it one by one
+type MultisigSpend struct {
+	SpendID        string         `json:"spendid"`
+	InviteCode     string         `json:"invitecode"`
+	NetworkID      int            `json:"networkid"`
+	Initiator      string         `json:"initiator"`
+	PSBT           string         `json:"psbt"`
+	Signed         []string       `json:"signed"`
+	Owners         []OwnerHistory `json:"owners"`
+	Required       int            `json:"required"`
+	Status         string         `json:"status"`
+	DateOfCreation int64          `json:"dateofcreation"`
 }
 
 type RatesRecord struct {
@@ -118,6 +242,7 @@ type Address struct {
 	AddressIndex   int    `json:"addressIndex" bson:"addressIndex"`
 	Address        string `json:"address" bson:"address"`
 	LastActionTime int64  `json:"lastActionTime" bson:"lastActionTime"`
+	IsChange       bool   `json:"isChange,omitempty" bson:"isChange,omitempty"`
 }
 
 type WalletsSelect struct {
@@ -162,6 +287,8 @@ type MultyTX struct {
 	TxOutputs         []AddresAmount        `json:"txoutputs"`
 	WalletsInput      []WalletForTx         `json:"walletsinput"`  //here we storing all wallets and addresses that took part in Inputs of the transaction
 	WalletsOutput     []WalletForTx         `json:"walletsoutput"` //here we storing all wallets and addresses that took part in Outputs of the transaction
+	// ExchangeID is the exchange the transaction is the payout of, it's set in history responses
+	ExchangeID string `json:"exchangeid,omitempty" bson:"-"`
 }
 
 type BTCResync struct {
@@ -183,11 +310,16 @@ type WsTxNotify struct {
 	WalletIndex     int    `json:"walletindex"`
 	From            string `json:"from"`
 	To              string `json:"to"`
+	AlertID         string `json:"alertid,omitempty"`
+	ExchangeID      string `json:"exchangeid,omitempty"`
+	ExchangeStatus  string `json:"exchangestatus,omitempty"`
 }
 
 type TransactionWithUserID struct {
 	NotificationMsg *WsTxNotify
 	UserID          string
+	// Seq is the sequence id of the stored user event
+	Seq int64 `json:"seq,omitempty"`
 }
 
 type AddresAmount struct {
@@ -206,10 +338,37 @@ type ExchangeRatesRecord struct {
 	Exchanges     ExchangeRates `json:"exchanges"`
 	Timestamp     int64         `json:"timestamp"`
 	StockExchange string        `json:"stock_exchange"`
+
+	// Rates are set for the canonical record, they tell which sources each rate is made of
+	Rates []CanonicalRate `json:"rates,omitempty" bson:"rates,omitempty"`
+}
+
+// StockExchangeCanonical is the stock exchange of records aggregated from all rate providers
+const StockExchangeCanonical = "Canonical"
+
+// CanonicalRate is the price of the pair like "BTC-USD" aggregated from several exchanges
+type CanonicalRate struct {
+	Pair    string       `json:"pair"`
+	Price   float64      `json:"price"`
+	Method  string       `json:"method"`
+	Updated int64        `json:"updated"`
+	Sources []RateSource `json:"sources"`
+}
+
+// RateSource is the last quote of the exchange, Dropped tells why it isn't in the price
+type RateSource struct {
+	Name    string  `json:"name"`
+	Price   float64 `json:"price"`
+	Volume  float64 `json:"volume,omitempty"`
+	Updated int64   `json:"updated"`
+	Dropped string  `json:"dropped,omitempty"`
 }
 
 // ExchangeRates stores exchange rates
 type ExchangeRates struct {
+	// Pairs are rates of any currencies, fixed fields are filled from them by Normalize for apps reading them
+	Pairs PairRates `json:"pairs,omitempty" bson:"pairs,omitempty"`
+
 	EURtoBTC float64 `json:"eur_btc"`
 	USDtoBTC float64 `json:"usd_btc"`
 	ETHtoBTC float64 `json:"eth_btc"`
@@ -220,15 +379,25 @@ type ExchangeRates struct {
 	BTCtoUSD float64 `json:"btc_usd"`
 }
 
-type RatesAPIBitstamp struct {
-	Date  string `json:"date"`
-	Price string `json:"price"`
+// Candle is OHLC of the pair rate in the interval like "1h" starting at Time
+type Candle struct {
+	Pair     string  `json:"-"`
+	Interval string  `json:"-"`
+	Time     int64   `json:"time"`
+	Open     float64 `json:"open"`
+	High     float64 `json:"high"`
+	Low      float64 `json:"low"`
+	Close    float64 `json:"close"`
+	// Samples is the number of rate snapshots in the candle, zero if it's backfilled
+	Samples int `json:"samples"`
 }
+
 type SpendableOutputs struct {
 	TxID              string                `json:"txid"`
 	TxOutID           int                   `json:"txoutid"`
 	TxOutAmount       int64                 `json:"txoutamount"`
 	TxOutScript       string                `json:"txoutscript"`
+	ScriptType        string                `json:"scripttype"`
 	Address           string                `json:"address"`
 	UserID            string                `json:"userid"`
 	WalletIndex       int                   `json:"walletindex"`
@@ -292,6 +461,38 @@ type TransactionETH struct {
 	Confirmed         bool                  `json:"confirmed,omitempty"`
 	IsInternal        bool                  `json:"isinternal,omitempty"`
 	StockExchangeRate []ExchangeRatesRecord `json:"stockexchangerate"`
+	Decoded           *DecodedCall          `json:"decoded,omitempty"`
+	// ExchangeID is the exchange the transaction is the payout of, it's set in history responses
+	ExchangeID string `json:"exchangeid,omitempty" bson:"-"`
+	// Logs are events of the mined transaction, they are handled as it comes and aren't stored
+	Logs []ETHLog `json:"-" bson:"-"`
+}
+
+// ETHLog is an event emitted by the contract, topics and data are hex encoded
+type ETHLog struct {
+	Address string
+	Topics  []string
+	Data    string
+}
+
+// DecodedCall is a typed form of contract call input and return data
+type DecodedCall struct {
+	Contract  string         `json:"contract,omitempty"`
+	Method    string         `json:"method"`
+	Signature string         `json:"signature"`
+	Selector  string         `json:"selector"`
+	Params    []DecodedParam `json:"params"`
+	Return    []DecodedParam `json:"return,omitempty"`
+	// Ambiguous call has the selector of methods of several contracts, it's params have no names
+	Ambiguous bool `json:"ambiguous,omitempty"`
+}
+
+// DecodedParam is a single decoded argument. Addresses, integers and bytes are hex or decimal strings,
+// arrays are lists of such values
+type DecodedParam struct {
+	Name  string      `json:"name,omitempty"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
 }
 
 type OwnerHistory struct {
@@ -421,3 +622,162 @@ type LastState struct {
 	CurrencyID  int   `bson:"currencyid"`
 	NetworkID   int   `bson:"networkid"`
 }
+
+// webhook delivery statuses
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// Webhook is HTTPS endpoint of integrator notified on user wallet events
+type Webhook struct {
+	WebhookID string `json:"webhookid"`
+	UserID    string `json:"-"`
+	URL       string `json:"url"`
+	// Secret signs deliveries with HMAC-SHA256
+	Secret string `json:"secret,omitempty"`
+	// Events are notification event types, empty means all
+	Events         []string `json:"events"`
+	CurrencyID     *int     `json:"currencyid,omitempty"`
+	NetworkID      *int     `json:"networkid,omitempty"`
+	DateOfCreation int64    `json:"dateofcreation"`
+}
+
+// WebhookDelivery is a log record of the event sent to the webhook
+type WebhookDelivery struct {
+	DeliveryID     string `json:"deliveryid"`
+	WebhookID      string `json:"webhookid"`
+	UserID         string `json:"-"`
+	Event          string `json:"event"`
+	Payload        string `json:"payload"`
+	Status         string `json:"status"`
+	Attempts       int    `json:"attempts"`
+	NextAttempt    int64  `json:"nextattempt"`
+	LastStatusCode int    `json:"laststatuscode"`
+	LastError      string `json:"lasterror,omitempty"`
+	DateOfCreation int64  `json:"dateofcreation"`
+	DeliveredAt    int64  `json:"deliveredat,omitempty"`
+}
+
+// price alert conditions
+const (
+	PriceAbove  = "above"
+	PriceBelow  = "below"
+	PriceChange = "change"
+)
+
+// PriceAlert notifies the user when fiat price of the currency reaches the level
+// or moves by the percent within the window
+type PriceAlert struct {
+	AlertID    string `json:"alertid"`
+	UserID     string `json:"-"`
+	CurrencyID int    `json:"currencyid"`
+	Fiat       string `json:"fiat"`
+	Condition  string `json:"condition"`
+	// Price is the level of above and below conditions
+	Price float64 `json:"price,omitempty"`
+	// Percent and Window in seconds are of change condition
+	Percent float64 `json:"percent,omitempty"`
+	Window  int64   `json:"window,omitempty"`
+	// Cooldown in seconds is the least time between two notifications of the alert
+	Cooldown int64 `json:"cooldown"`
+	// Triggered is set when the alert fires and reset when the condition is no more met,
+	// so the alert doesn't repeat while price stays beyond the level
+	Triggered      bool    `json:"triggered"`
+	LastTriggered  int64   `json:"lasttriggered"`
+	LastPrice      float64 `json:"lastprice,omitempty"`
+	DateOfCreation int64   `json:"dateofcreation"`
+}
+
+// Exchange is an exchange of the user through Changelly with the payout to own wallet.
+// Amounts are decimal strings in coins as Changelly takes them.
+type Exchange struct {
+	ExchangeID string `json:"exchangeid"`
+	UserID     string `json:"-"`
+	// From and To are Changelly currency codes like "ltc" and "btc"
+	From           string `json:"from"`
+	To             string `json:"to"`
+	CurrencyID     int    `json:"currencyid"`
+	NetworkID      int    `json:"networkid"`
+	WalletIndex    int    `json:"walletindex"`
+	Amount         string `json:"amount"`
+	AmountExpected string `json:"amountexpected"`
+	// PayinAddress is where the user sends From currency, some currencies need PayinExtraID too
+	PayinAddress  string `json:"payinaddress"`
+	PayinExtraID  string `json:"payinextraid,omitempty"`
+	PayoutAddress string `json:"payoutaddress"`
+	RefundAddress string `json:"refundaddress,omitempty"`
+	Status        string `json:"status"`
+	// TxHash is the payout transaction, it's set when the exchange is finished
+	TxHash string `json:"txhash,omitempty"`
+	// Done exchanges have final status and the payout hash if any, they aren't tracked
+	Done           bool  `json:"-"`
+	DateOfCreation int64 `json:"dateofcreation"`
+	LastUpdate     int64 `json:"lastupdate"`
+}
+
+// security event types
+const (
+	SecurityNewDevice        = "newDevice"
+	SecurityWalletDeleted    = "walletDeleted"
+	SecurityPushTokenChanged = "pushTokenChanged"
+	SecurityLargeOutgoing    = "largeOutgoingTx"
+	SecurityLoginKeyAdded    = "loginKeyAdded"
+	SecurityDevicesLoggedOut = "devicesLoggedOut"
+)
+
+// SecurityEvent is a sensitive change of the user account, it is pushed to all other user devices
+type SecurityEvent struct {
+	EventID string `json:"eventid"`
+	UserID  string `json:"userid"`
+	Type    string `json:"type"`
+	// DeviceID is the device caused the event, empty if it came from the chain
+	DeviceID       string            `json:"deviceid"`
+	DeviceType     int               `json:"devicetype"`
+	IP             string            `json:"ip,omitempty"`
+	Details        map[string]string `json:"details,omitempty"`
+	DateOfCreation int64             `json:"dateofcreation"`
+	// ExpireAt is when mongo removes the event
+	ExpireAt time.Time `json:"-"`
+}
+
+// AuthChallenge is the nonce the user signs to log in, it's used once
+type AuthChallenge struct {
+	UserID   string    `bson:"userid"`
+	Nonce    string    `bson:"nonce"`
+	ExpireAt time.Time `bson:"expireat"`
+}
+
+// RevokedToken is the hash of a token logged out or refreshed before its expiration
+type RevokedToken struct {
+	Hash     string    `bson:"hash"`
+	UserID   string    `bson:"userid"`
+	ExpireAt time.Time `bson:"expireat"`
+}
+
+// WirelessReceiver is the receiver advertisement shared by socket.io instances
+type WirelessReceiver struct {
+	UserCode   string `json:"usercode"`
+	UserID     string `json:"userid"`
+	CurrencyID int    `json:"currencyid"`
+	NetworkID  int    `json:"networkid"`
+	Address    string `json:"address"`
+	Amount     string `json:"amount"`
+	// Instance is the socket.io instance holding the receiver connection
+	Instance string `json:"instance"`
+	Expire   int64  `json:"expire"`
+}
+
+// UserEvent is the event emitted to the user, it's kept for the retention window
+// so clients replay what they missed while offline
+type UserEvent struct {
+	UserID string `json:"-"`
+	// Seq grows monotonically for every user
+	Seq     int64  `json:"seq"`
+	Event   string `json:"event"`
+	Channel string `json:"channel"`
+	// Data is emitted as the event data
+	Data           TransactionWithUserID `json:"data"`
+	DateOfCreation int64                 `json:"dateofcreation"`
+}
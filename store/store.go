@@ -6,8 +6,11 @@ See LICENSE for details
 package store
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Multy-io/Multy-back/currencies"
@@ -24,6 +27,19 @@ var (
 const (
 	TableUsers             = "UserCollection"
 	TableStockExchangeRate = "TableStockExchangeRate"
+	TableMultisigSpendsBTC = "BTCMultisigSpends"
+	TableWebhooks          = "Webhooks"
+	TableWebhookDeliveries = "WebhookDeliveries"
+	TablePriceAlerts       = "PriceAlerts"
+	TableSecurityEvents    = "SecurityEvents"
+	TableWirelessReceivers = "WirelessReceivers"
+	TableUserEvents        = "UserEvents"
+	TableUserEventSeqs     = "UserEventSeqs"
+	TableRateCandles       = "RateCandles"
+	TableExchanges         = "Exchanges"
+	TableAuthChallenges    = "AuthChallenges"
+	TableRevokedTokens     = "RevokedTokens"
+	TableLeases            = "Leases"
 )
 
 // Conf is a struct for database configuration
@@ -74,7 +90,13 @@ type UserStore interface {
 	FindUserErr(query bson.M) error
 	FindUserAddresses(query bson.M, sel bson.M, ws *WalletsSelect) error
 	InsertExchangeRate(ExchangeRates, string) error
-	GetExchangeRatesDay() ([]RatesAPIBitstamp, error)
+	InsertCanonicalRate(eRate ExchangeRates, rates []CanonicalRate) error
+	MigrateExchangeRates() (int, error)
+	RemoveExchangeRates(stockExchanges []string, before int64) error
+	AddCandleSample(pair, interval string, time int64, price float64) error
+	InsertCandles(candles []Candle) error
+	FindCandles(pair, interval string, from, to int64) ([]Candle, error)
+	RemoveCandles(interval string, before int64) error
 
 	//TODo update this method by eth
 	GetAllWalletTransactions(userid string, currencyID, networkID int, walletTxs *[]MultyTX) error
@@ -98,6 +120,58 @@ type UserStore interface {
 	FethLastSyncBlockState(networkid, currencyid int) (int64, error)
 
 	CheckTx(tx string) bool
+
+	// bitcoin multisig
+	UpdateMultisig(inviteCode string, update bson.M) error
+	InsertMultisigSpend(spend MultisigSpend) error
+	FindMultisigSpend(spendID string, spend *MultisigSpend) error
+	FindMultisigSpends(inviteCode string) ([]MultisigSpend, error)
+	UpdateMultisigSpend(spendID string, update bson.M) error
+	SignMultisigSpend(spendID, xpub, psbt string, now int64, spend *MultisigSpend) error
+
+	InsertWebhook(webhook Webhook) error
+	FindWebhooks(userID string) ([]Webhook, error)
+	DeleteWebhook(userID, webhookID string) error
+	InsertWebhookDelivery(delivery WebhookDelivery) error
+	FindWebhookDelivery(userID, deliveryID string, delivery *WebhookDelivery) error
+	FindWebhookDeliveries(userID, webhookID string, limit int) ([]WebhookDelivery, error)
+	ClaimWebhookDelivery(now, until int64, delivery *WebhookDelivery) error
+	UpdateWebhookDelivery(deliveryID string, update bson.M) error
+
+	InsertPriceAlert(alert PriceAlert) error
+	FindPriceAlerts(userID string) ([]PriceAlert, error)
+	FindPriceAlertsToEvaluate(currencyID int, fiat string, price float64) ([]PriceAlert, error)
+	RearmPriceAlerts(currencyID int, fiat string, price float64) error
+	DeletePriceAlert(userID, alertID string) error
+	UpdatePriceAlert(sel, update bson.M) error
+
+	InsertSecurityEvent(event SecurityEvent) error
+	FindSecurityEvents(userID string, limit int) ([]SecurityEvent, error)
+
+	AdvertiseWirelessReceiver(receiver WirelessReceiver) error
+	FindWirelessReceivers(userCodes []string) ([]WirelessReceiver, error)
+	RemoveWirelessReceivers(userCodes []string, instance string) error
+
+	NextUserEventSeq(userID string) (int64, error)
+	InsertUserEvent(event UserEvent) error
+	FindUserEvents(userID string, afterSeq int64, limit int) ([]UserEvent, error)
+	RemoveUserEvents(before int64) error
+
+	InsertExchange(exchange Exchange) error
+	FindExchanges(userID string) ([]Exchange, error)
+	FindActiveExchanges() ([]Exchange, error)
+	UpdateExchange(sel, update bson.M) error
+
+	SetUserPublicKey(userID, publicKey string) error
+	InsertAuthChallenge(challenge AuthChallenge) error
+	TrimAuthChallenges(userID string, keep int) error
+	ConsumeAuthChallenge(userID, nonce string, now time.Time) error
+
+	RevokeTokens(tokens []RevokedToken) error
+	IsTokenRevoked(hash string) (bool, error)
+	MigrateDeviceTokens() (int, error)
+
+	AcquireLease(name, holder string, now, until time.Time) (bool, error)
 }
 
 type MongoUserStore struct {
@@ -127,7 +201,23 @@ type MongoUserStore struct {
 	//eth multisig main
 	ETHMainMultisigTxsData *mgo.Collection
 
+	//btc multisig spends
+	BTCMultisigSpends *mgo.Collection
+
+	webhooks          *mgo.Collection
+	webhookDeliveries *mgo.Collection
+	priceAlerts       *mgo.Collection
+	securityEvents    *mgo.Collection
+	wirelessReceivers *mgo.Collection
+	userEvents        *mgo.Collection
+	userEventSeqs     *mgo.Collection
+	exchanges         *mgo.Collection
+	authChallenges    *mgo.Collection
+	revokedTokens     *mgo.Collection
+	leases            *mgo.Collection
+
 	stockExchangeRate *mgo.Collection
+	rateCandles       *mgo.Collection
 	ethTxHistory      *mgo.Collection
 	ETHTest           *mgo.Collection
 
@@ -155,6 +245,12 @@ func InitUserStore(conf Conf) (UserStore, error) {
 	uStore.session = session
 	uStore.usersData = uStore.session.DB(conf.DBUsers).C(TableUsers)
 	uStore.stockExchangeRate = uStore.session.DB(conf.DBStockExchangeRate).C(TableStockExchangeRate)
+	uStore.rateCandles = uStore.session.DB(conf.DBStockExchangeRate).C(TableRateCandles)
+	// every instance adds samples to the same candle
+	err = uStore.rateCandles.EnsureIndex(mgo.Index{Key: []string{"pair", "interval", "time"}, Unique: true})
+	if err != nil {
+		return nil, err
+	}
 
 	// BTC main
 	uStore.BTCMainTxsData = uStore.session.DB(conf.DBTx).C(conf.TableTxsDataBTCMain)
@@ -176,6 +272,70 @@ func InitUserStore(conf Conf) (UserStore, error) {
 	//eth multisig main
 	uStore.ETHMainMultisigTxsData = uStore.session.DB(conf.DBTx).C(conf.TableMultisigTxsMain)
 
+	//btc multisig spends
+	uStore.BTCMultisigSpends = uStore.session.DB(conf.DBTx).C(TableMultisigSpendsBTC)
+
+	uStore.webhooks = uStore.session.DB(conf.DBUsers).C(TableWebhooks)
+	uStore.webhookDeliveries = uStore.session.DB(conf.DBUsers).C(TableWebhookDeliveries)
+	err = uStore.webhookDeliveries.EnsureIndex(mgo.Index{Key: []string{"status", "nextattempt"}})
+	if err != nil {
+		return nil, err
+	}
+	uStore.priceAlerts = uStore.session.DB(conf.DBUsers).C(TablePriceAlerts)
+	err = uStore.priceAlerts.EnsureIndex(mgo.Index{Key: []string{"currencyid", "fiat", "triggered"}})
+	if err != nil {
+		return nil, err
+	}
+	uStore.securityEvents = uStore.session.DB(conf.DBUsers).C(TableSecurityEvents)
+	// events are listed by user from the latest and removed by mongo after the retention
+	err = uStore.securityEvents.EnsureIndex(mgo.Index{Key: []string{"userid", "-dateofcreation"}})
+	if err != nil {
+		return nil, err
+	}
+	err = uStore.securityEvents.EnsureIndex(mgo.Index{Key: []string{"expireat"}, ExpireAfter: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	uStore.wirelessReceivers = uStore.session.DB(conf.DBUsers).C(TableWirelessReceivers)
+	// user code is advertised by one user at a time across socket.io instances
+	err = uStore.wirelessReceivers.EnsureIndex(mgo.Index{Key: []string{"usercode"}, Unique: true})
+	if err != nil {
+		return nil, err
+	}
+	uStore.userEvents = uStore.session.DB(conf.DBUsers).C(TableUserEvents)
+	uStore.userEventSeqs = uStore.session.DB(conf.DBUsers).C(TableUserEventSeqs)
+	err = uStore.userEvents.EnsureIndex(mgo.Index{Key: []string{"userid", "seq"}})
+	if err != nil {
+		return nil, err
+	}
+	uStore.exchanges = uStore.session.DB(conf.DBUsers).C(TableExchanges)
+	uStore.authChallenges = uStore.session.DB(conf.DBUsers).C(TableAuthChallenges)
+	// expired challenges are removed by mongo
+	err = uStore.authChallenges.EnsureIndex(mgo.Index{Key: []string{"expireat"}, ExpireAfter: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = uStore.authChallenges.EnsureIndex(mgo.Index{Key: []string{"userid"}})
+	if err != nil {
+		return nil, err
+	}
+	uStore.revokedTokens = uStore.session.DB(conf.DBUsers).C(TableRevokedTokens)
+	err = uStore.revokedTokens.EnsureIndex(mgo.Index{Key: []string{"hash"}})
+	if err != nil {
+		return nil, err
+	}
+	// tokens are kept revoked until they expire
+	err = uStore.revokedTokens.EnsureIndex(mgo.Index{Key: []string{"expireat"}, ExpireAfter: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	uStore.leases = uStore.session.DB(conf.DBUsers).C(TableLeases)
+	err = uStore.leases.EnsureIndex(mgo.Index{Key: []string{"name"}, Unique: true})
+	if err != nil {
+		return nil, err
+	}
+
 	uStore.RestoreState = uStore.session.DB(conf.DBRestoreState).C(conf.TableState)
 
 	return uStore, nil
@@ -210,6 +370,18 @@ func (mStore *MongoUserStore) FindUserDataChain(CurrencyID, NetworkID int) (map[
 				}
 			}
 		}
+		// bitcoin multisig addresses are watched under the creator
+		for _, multisig := range user.Multisigs {
+			if multisig.CurrencyID == CurrencyID && multisig.NetworkID == NetworkID && len(multisig.Owners) > 0 && multisig.Owners[0].UserID == user.UserID {
+				for _, address := range multisig.Addresses {
+					usersData[address.Address] = AddressExtended{
+						UserID:       user.UserID,
+						WalletIndex:  MultisigWalletIndex,
+						AddressIndex: address.AddressIndex,
+					}
+				}
+			}
+		}
 	}
 	return usersData, nil
 }
@@ -422,10 +594,65 @@ func (mStore *MongoUserStore) InsertExchangeRate(eRate ExchangeRates, exchangeSt
 	return mStore.stockExchangeRate.Insert(eRateRecord)
 }
 
-// GetExchangeRatesDay returns exchange rates for last day with time interval equal to hour
-func (mStore *MongoUserStore) GetExchangeRatesDay() ([]RatesAPIBitstamp, error) {
-	// not implemented
-	return nil, nil
+// InsertCanonicalRate saves aggregated rates with their sources as StockExchangeCanonical record
+func (mStore *MongoUserStore) InsertCanonicalRate(eRate ExchangeRates, rates []CanonicalRate) error {
+	eRateRecord := &ExchangeRatesRecord{
+		Exchanges:     eRate,
+		Timestamp:     time.Now().Unix(),
+		StockExchange: StockExchangeCanonical,
+		Rates:         rates,
+	}
+
+	return mStore.stockExchangeRate.Insert(eRateRecord)
+}
+
+// RemoveExchangeRates removes rates snapshots of the stock exchanges made before the time
+func (mStore *MongoUserStore) RemoveExchangeRates(stockExchanges []string, before int64) error {
+	_, err := mStore.stockExchangeRate.RemoveAll(bson.M{
+		"stockexchange": bson.M{"$in": stockExchanges},
+		"timestamp":     bson.M{"$lt": before},
+	})
+	return err
+}
+
+// AddCandleSample adds the price to the candle starting at the time, the first price opens it
+func (mStore *MongoUserStore) AddCandleSample(pair, interval string, time int64, price float64) error {
+	sel := bson.M{"pair": pair, "interval": interval, "time": time}
+	update := bson.M{
+		"$setOnInsert": bson.M{"open": price},
+		"$max":         bson.M{"high": price},
+		"$min":         bson.M{"low": price},
+		"$set":         bson.M{"close": price},
+		"$inc":         bson.M{"samples": 1},
+	}
+	_, err := mStore.rateCandles.Upsert(sel, update)
+	return err
+}
+
+// InsertCandles saves backfilled candles, candles made of samples are kept
+func (mStore *MongoUserStore) InsertCandles(candles []Candle) error {
+	for _, candle := range candles {
+		sel := bson.M{"pair": candle.Pair, "interval": candle.Interval, "time": candle.Time}
+		_, err := mStore.rateCandles.Upsert(sel, bson.M{"$setOnInsert": candle})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindCandles returns candles of the pair starting within [from, to] sorted by time
+func (mStore *MongoUserStore) FindCandles(pair, interval string, from, to int64) ([]Candle, error) {
+	candles := []Candle{}
+	query := bson.M{"pair": pair, "interval": interval, "time": bson.M{"$gte": from, "$lte": to}}
+	err := mStore.rateCandles.Find(query).Sort("time").All(&candles)
+	return candles, err
+}
+
+// RemoveCandles removes candles of the interval starting before the time
+func (mStore *MongoUserStore) RemoveCandles(interval string, before int64) error {
+	_, err := mStore.rateCandles.RemoveAll(bson.M{"interval": interval, "time": bson.M{"$lt": before}})
+	return err
 }
 
 func (mStore *MongoUserStore) GetAllWalletTransactions(userid string, currencyID, networkID int, walletTxs *[]MultyTX) error {
@@ -479,3 +706,333 @@ func (mStore *MongoUserStore) Close() error {
 	mStore.session.Close()
 	return nil
 }
+
+// UpdateMultisig updates bitcoin multisig copies of every owner
+func (mStore *MongoUserStore) UpdateMultisig(inviteCode string, update bson.M) error {
+	_, err := mStore.usersData.UpdateAll(bson.M{"multisig.invitecode": inviteCode}, update)
+	return err
+}
+
+func (mStore *MongoUserStore) InsertMultisigSpend(spend MultisigSpend) error {
+	return mStore.BTCMultisigSpends.Insert(spend)
+}
+
+func (mStore *MongoUserStore) FindMultisigSpend(spendID string, spend *MultisigSpend) error {
+	return mStore.BTCMultisigSpends.Find(bson.M{"spendid": spendID}).One(spend)
+}
+
+func (mStore *MongoUserStore) FindMultisigSpends(inviteCode string) ([]MultisigSpend, error) {
+	spends := []MultisigSpend{}
+	err := mStore.BTCMultisigSpends.Find(bson.M{"invitecode": inviteCode}).Sort("-dateofcreation").All(&spends)
+	return spends, err
+}
+
+func (mStore *MongoUserStore) UpdateMultisigSpend(spendID string, update bson.M) error {
+	return mStore.BTCMultisigSpends.Update(bson.M{"spendid": spendID}, update)
+}
+
+// SignMultisigSpend confirms the owner of the xpub on the pending spend and returns the spend as updated,
+// mgo.ErrNotFound means the owner has already signed or the spend isn't pending
+func (mStore *MongoUserStore) SignMultisigSpend(spendID, xpub, psbt string, now int64, spend *MultisigSpend) error {
+	sel := bson.M{
+		"spendid": spendID,
+		"status":  MultisigSpendPending,
+		"owners":  bson.M{"$elemMatch": bson.M{"address": xpub, "confirmed": false}},
+	}
+	change := mgo.Change{
+		Update: bson.M{
+			"$set": bson.M{
+				"owners.$.confirmed":        true,
+				"owners.$.seen":             true,
+				"owners.$.confirmationtime": now,
+				"owners.$.seentime":         now,
+			},
+			"$push": bson.M{"signed": psbt},
+		},
+		ReturnNew: true,
+	}
+	_, err := mStore.BTCMultisigSpends.Find(sel).Apply(change, spend)
+	return err
+}
+
+func (mStore *MongoUserStore) InsertWebhook(webhook Webhook) error {
+	return mStore.webhooks.Insert(webhook)
+}
+
+func (mStore *MongoUserStore) FindWebhooks(userID string) ([]Webhook, error) {
+	webhooks := []Webhook{}
+	err := mStore.webhooks.Find(bson.M{"userid": userID}).All(&webhooks)
+	return webhooks, err
+}
+
+func (mStore *MongoUserStore) DeleteWebhook(userID, webhookID string) error {
+	return mStore.webhooks.Remove(bson.M{"userid": userID, "webhookid": webhookID})
+}
+
+func (mStore *MongoUserStore) InsertWebhookDelivery(delivery WebhookDelivery) error {
+	return mStore.webhookDeliveries.Insert(delivery)
+}
+
+func (mStore *MongoUserStore) FindWebhookDelivery(userID, deliveryID string, delivery *WebhookDelivery) error {
+	return mStore.webhookDeliveries.Find(bson.M{"userid": userID, "deliveryid": deliveryID}).One(delivery)
+}
+
+func (mStore *MongoUserStore) FindWebhookDeliveries(userID, webhookID string, limit int) ([]WebhookDelivery, error) {
+	deliveries := []WebhookDelivery{}
+	query := bson.M{"userid": userID, "webhookid": webhookID}
+	err := mStore.webhookDeliveries.Find(query).Sort("-dateofcreation").Limit(limit).All(&deliveries)
+	return deliveries, err
+}
+
+// ClaimWebhookDelivery leases a pending delivery which time of the next attempt has come until the time given,
+// mgo.ErrNotFound means there are no due deliveries
+func (mStore *MongoUserStore) ClaimWebhookDelivery(now, until int64, delivery *WebhookDelivery) error {
+	query := bson.M{"status": WebhookDeliveryPending, "nextattempt": bson.M{"$lte": now}}
+	change := mgo.Change{
+		Update:    bson.M{"$set": bson.M{"nextattempt": until}},
+		ReturnNew: true,
+	}
+	_, err := mStore.webhookDeliveries.Find(query).Apply(change, delivery)
+	return err
+}
+
+func (mStore *MongoUserStore) UpdateWebhookDelivery(deliveryID string, update bson.M) error {
+	return mStore.webhookDeliveries.Update(bson.M{"deliveryid": deliveryID}, update)
+}
+
+func (mStore *MongoUserStore) InsertPriceAlert(alert PriceAlert) error {
+	return mStore.priceAlerts.Insert(alert)
+}
+
+func (mStore *MongoUserStore) FindPriceAlerts(userID string) ([]PriceAlert, error) {
+	alerts := []PriceAlert{}
+	err := mStore.priceAlerts.Find(bson.M{"userid": userID}).Sort("-dateofcreation").All(&alerts)
+	return alerts, err
+}
+
+// FindPriceAlertsToEvaluate returns alerts of the pair that may fire at the price:
+// untriggered level alerts the price has crossed and change alerts, triggered ones to rearm
+func (mStore *MongoUserStore) FindPriceAlertsToEvaluate(currencyID int, fiat string, price float64) ([]PriceAlert, error) {
+	alerts := []PriceAlert{}
+	query := bson.M{
+		"currencyid": currencyID,
+		"fiat":       fiat,
+		"$or": []bson.M{
+			{"condition": PriceAbove, "triggered": false, "price": bson.M{"$lte": price}},
+			{"condition": PriceBelow, "triggered": false, "price": bson.M{"$gte": price}},
+			{"condition": PriceChange},
+		},
+	}
+	err := mStore.priceAlerts.Find(query).All(&alerts)
+	return alerts, err
+}
+
+// RearmPriceAlerts resets triggered level alerts of the pair the price is back from
+func (mStore *MongoUserStore) RearmPriceAlerts(currencyID int, fiat string, price float64) error {
+	sel := bson.M{
+		"currencyid": currencyID,
+		"fiat":       fiat,
+		"triggered":  true,
+		"$or": []bson.M{
+			{"condition": PriceAbove, "price": bson.M{"$gt": price}},
+			{"condition": PriceBelow, "price": bson.M{"$lt": price}},
+		},
+	}
+	_, err := mStore.priceAlerts.UpdateAll(sel, bson.M{"$set": bson.M{"triggered": false}})
+	return err
+}
+
+func (mStore *MongoUserStore) DeletePriceAlert(userID, alertID string) error {
+	return mStore.priceAlerts.Remove(bson.M{"userid": userID, "alertid": alertID})
+}
+
+// UpdatePriceAlert updates the alert matching sel, mgo.ErrNotFound means it has changed since read
+func (mStore *MongoUserStore) UpdatePriceAlert(sel, update bson.M) error {
+	return mStore.priceAlerts.Update(sel, update)
+}
+
+func (mStore *MongoUserStore) InsertSecurityEvent(event SecurityEvent) error {
+	return mStore.securityEvents.Insert(event)
+}
+
+func (mStore *MongoUserStore) FindSecurityEvents(userID string, limit int) ([]SecurityEvent, error) {
+	events := []SecurityEvent{}
+	err := mStore.securityEvents.Find(bson.M{"userid": userID}).Sort("-dateofcreation").Limit(limit).All(&events)
+	return events, err
+}
+
+// ErrUserCodeTaken is returned when the user code is advertised by another user
+var ErrUserCodeTaken = errors.New("user code is taken")
+
+// AdvertiseWirelessReceiver inserts or refreshes the advertisement unless the user code
+// is advertised by another user and not expired
+func (mStore *MongoUserStore) AdvertiseWirelessReceiver(receiver WirelessReceiver) error {
+	sel := bson.M{
+		"usercode": receiver.UserCode,
+		"$or": []bson.M{
+			{"userid": receiver.UserID},
+			{"expire": bson.M{"$lt": time.Now().Unix()}},
+		},
+	}
+	_, err := mStore.wirelessReceivers.Upsert(sel, receiver)
+	if mgo.IsDup(err) {
+		return ErrUserCodeTaken
+	}
+	return err
+}
+
+// FindWirelessReceivers returns not expired advertisements of the user codes
+func (mStore *MongoUserStore) FindWirelessReceivers(userCodes []string) ([]WirelessReceiver, error) {
+	receivers := []WirelessReceiver{}
+	query := bson.M{"usercode": bson.M{"$in": userCodes}, "expire": bson.M{"$gte": time.Now().Unix()}}
+	err := mStore.wirelessReceivers.Find(query).All(&receivers)
+	return receivers, err
+}
+
+// RemoveWirelessReceivers removes advertisements of the instance, the user code could be advertised again elsewhere
+func (mStore *MongoUserStore) RemoveWirelessReceivers(userCodes []string, instance string) error {
+	_, err := mStore.wirelessReceivers.RemoveAll(bson.M{"usercode": bson.M{"$in": userCodes}, "instance": instance})
+	return err
+}
+
+// NextUserEventSeq increments the event sequence of the user and returns it
+func (mStore *MongoUserStore) NextUserEventSeq(userID string) (int64, error) {
+	counter := struct {
+		Seq int64 `bson:"seq"`
+	}{}
+	change := mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"seq": 1}},
+		Upsert:    true,
+		ReturnNew: true,
+	}
+	_, err := mStore.userEventSeqs.Find(bson.M{"userid": userID}).Apply(change, &counter)
+	return counter.Seq, err
+}
+
+func (mStore *MongoUserStore) InsertUserEvent(event UserEvent) error {
+	return mStore.userEvents.Insert(event)
+}
+
+// FindUserEvents returns events of the user after the sequence id in order
+func (mStore *MongoUserStore) FindUserEvents(userID string, afterSeq int64, limit int) ([]UserEvent, error) {
+	events := []UserEvent{}
+	err := mStore.userEvents.Find(bson.M{"userid": userID, "seq": bson.M{"$gt": afterSeq}}).Sort("seq").Limit(limit).All(&events)
+	return events, err
+}
+
+// RemoveUserEvents removes events created before the time
+func (mStore *MongoUserStore) RemoveUserEvents(before int64) error {
+	_, err := mStore.userEvents.RemoveAll(bson.M{"dateofcreation": bson.M{"$lt": before}})
+	return err
+}
+
+func (mStore *MongoUserStore) InsertExchange(exchange Exchange) error {
+	return mStore.exchanges.Insert(exchange)
+}
+
+func (mStore *MongoUserStore) FindExchanges(userID string) ([]Exchange, error) {
+	exchanges := []Exchange{}
+	err := mStore.exchanges.Find(bson.M{"userid": userID}).Sort("-dateofcreation").All(&exchanges)
+	return exchanges, err
+}
+
+// FindActiveExchanges returns exchanges of all users not done yet to track them
+func (mStore *MongoUserStore) FindActiveExchanges() ([]Exchange, error) {
+	exchanges := []Exchange{}
+	err := mStore.exchanges.Find(bson.M{"done": false}).All(&exchanges)
+	return exchanges, err
+}
+
+// UpdateExchange updates the exchange matching sel, mgo.ErrNotFound means it has changed since read
+func (mStore *MongoUserStore) UpdateExchange(sel, update bson.M) error {
+	return mStore.exchanges.Update(sel, update)
+}
+
+// SetUserPublicKey registers the login key of the user, ErrNotFound is returned if the user has one
+func (mStore *MongoUserStore) SetUserPublicKey(userID, publicKey string) error {
+	sel := bson.M{"userID": userID, "publicKey": bson.M{"$exists": false}}
+	return mStore.usersData.Update(sel, bson.M{"$set": bson.M{"publicKey": publicKey}})
+}
+
+func (mStore *MongoUserStore) InsertAuthChallenge(challenge AuthChallenge) error {
+	return mStore.authChallenges.Insert(challenge)
+}
+
+// TrimAuthChallenges removes challenges of the user but the latest ones to keep
+func (mStore *MongoUserStore) TrimAuthChallenges(userID string, keep int) error {
+	old := []AuthChallenge{}
+	err := mStore.authChallenges.Find(bson.M{"userid": userID}).Sort("-expireat").Skip(keep).All(&old)
+	if err != nil || len(old) == 0 {
+		return err
+	}
+	nonces := make([]string, 0, len(old))
+	for _, challenge := range old {
+		nonces = append(nonces, challenge.Nonce)
+	}
+	_, err = mStore.authChallenges.RemoveAll(bson.M{"userid": userID, "nonce": bson.M{"$in": nonces}})
+	return err
+}
+
+// ConsumeAuthChallenge removes the challenge not expired yet, ErrNotFound is returned if there is none
+func (mStore *MongoUserStore) ConsumeAuthChallenge(userID, nonce string, now time.Time) error {
+	return mStore.authChallenges.Remove(bson.M{"userid": userID, "nonce": nonce, "expireat": bson.M{"$gt": now}})
+}
+
+// TokenHash is how device tokens are stored and revoked, tokens themselves aren't kept
+func TokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func (mStore *MongoUserStore) RevokeTokens(tokens []RevokedToken) error {
+	docs := make([]interface{}, 0, len(tokens))
+	for _, token := range tokens {
+		docs = append(docs, token)
+	}
+	return mStore.revokedTokens.Insert(docs...)
+}
+
+func (mStore *MongoUserStore) IsTokenRevoked(hash string) (bool, error) {
+	n, err := mStore.revokedTokens.Find(bson.M{"hash": hash}).Count()
+	return n > 0, err
+}
+
+// AcquireLease takes or renews the named lease for the holder until the time given,
+// false means another holder has it and it hasn't expired
+func (mStore *MongoUserStore) AcquireLease(name, holder string, now, until time.Time) (bool, error) {
+	sel := bson.M{"name": name, "$or": []bson.M{{"holder": holder}, {"expireat": bson.M{"$lt": now}}}}
+	_, err := mStore.leases.Upsert(sel, bson.M{"$set": bson.M{"holder": holder, "expireat": until}})
+	if mgo.IsDup(err) {
+		// the lease exists and is held, the upsert tried to insert a second one
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// MigrateDeviceTokens replaces tokens of user devices stored verbatim with their hashes
+func (mStore *MongoUserStore) MigrateDeviceTokens() (int, error) {
+	updated := 0
+	// hashes are hex, signed tokens have dots
+	iter := mStore.usersData.Find(bson.M{"devices.JWT": bson.RegEx{Pattern: `\.`}}).Select(bson.M{"userID": 1, "devices.JWT": 1}).Iter()
+	user := User{}
+	for iter.Next(&user) {
+		for _, device := range user.Devices {
+			if !strings.Contains(device.JWT, ".") {
+				continue
+			}
+			sel := bson.M{"userID": user.UserID, "devices.JWT": device.JWT}
+			err := mStore.usersData.Update(sel, bson.M{"$set": bson.M{"devices.$.JWT": TokenHash(device.JWT)}})
+			if err == mgo.ErrNotFound {
+				// relogged in meanwhile
+				continue
+			}
+			if err != nil {
+				iter.Close()
+				return updated, err
+			}
+			updated++
+		}
+		user = User{}
+	}
+	return updated, iter.Close()
+}